@@ -2,14 +2,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -21,16 +27,22 @@ import (
 	"github.com/xbslink/xbslink-ng/internal/events"
 	"github.com/xbslink/xbslink-ng/internal/logging"
 	"github.com/xbslink/xbslink-ng/internal/protocol"
+	"github.com/xbslink/xbslink-ng/internal/status"
 	"github.com/xbslink/xbslink-ng/internal/transport"
 )
 
-// Version is set at build time via -ldflags.
-var Version = "dev"
+// Version, Commit and BuildDate are set at build time via -ldflags.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
 
 const (
 	defaultPort          = 31415
 	defaultStatsInterval = 30
 	defaultLogLevel      = "info"
+	defaultStatsFormat   = "line"
 )
 
 func main() {
@@ -48,9 +60,15 @@ func main() {
 	case "connect":
 		runConnect(args)
 	case "interfaces":
-		runInterfaces()
+		runInterfaces(args)
+	case "discover":
+		runDiscover(args)
+	case "summarize":
+		runSummarize(args)
+	case "selftest":
+		runSelfTest(args)
 	case "version", "--version", "-v":
-		fmt.Printf("xbslink-ng %s (%s/%s)\n", Version, runtime.GOOS, runtime.GOARCH)
+		runVersion(args)
 	case "help", "--help", "-h":
 		printUsage()
 	default:
@@ -69,23 +87,67 @@ Usage:
 Commands:
   listen      Listen for incoming peer connection (requires port forwarding)
   connect     Connect to a listening peer
-  interfaces  List available network interfaces
-  version     Print version information
+  interfaces  List usable network interfaces (--all: every capture device)
+  discover    Watch all interfaces for an Xbox; prints its MAC and --interface
+  summarize   Print a session report from an --events-output file
+  selftest    Check that this machine can encode/decode frames fast enough
+  version     Print version information (--json: machine-readable)
 
 Flags for listen/connect:
-  --port            UDP port (listen: port to bind, connect: optional local port)
-  --address         Peer's IP:port (connect mode only, required)
+  --port            UDP port (listen: port(s) to bind, comma-separated; connect: optional local port)
+                    Names work too: dns, http, ntp, https, ike, ipsec-nat (e.g. --port https)
+  --address         Peer's IP:port (connect mode only, required; @file or - reads it from a file or stdin)
   --interface       Network interface name (required)
+  --inject-interface Inject received frames on this interface (default: --interface)
+  --exclude-dst     Don't capture frames sent to this MAC ("local": the inject NIC's own)
   --xbox-mac        Xbox MAC address (auto-detected if omitted)
   --key             Pre-shared key for authentication (strongly recommended)
+  --require-key     Refuse to run without --key (no silent insecure fallback)
+  --insecure        Run without --key on purpose (will be required to do so in a future release)
+  --key-format      How --key is written: raw (passphrase)|hex|base64 (default: raw)
+  --no-promisc      Open the interface without promiscuous mode (may miss frames)
   --log             Log level: error|warn|info|debug|trace (default: info)
+  --log-module      Per-module log levels, e.g. capture=warn,bridge=trace
+                    (modules: bridge, capture, discovery, transport)
+  --log-timeformat  Log timestamp format: default|rfc3339|rfc3339nano or a Go layout
+  --log-utc         Log timestamps in UTC instead of local time
+  --color           Color log output: auto|always|never (default: auto; honors NO_COLOR, FORCE_COLOR)
+  --trace-sample    At trace level, log 1 of every N frames (default: 1)
+  --dump-frames     Hex-dump the first N captured and N received frames (default: 0, off)
+  --batch-recv      Read several packets per syscall (recvmmsg on Linux)
+  --batch-send      Send queued packets with one syscall (sendmmsg on Linux)
+  --workers         Goroutines each for encoding and decoding frames (default: 1)
+  --socket-buffer   UDP socket buffer size in bytes (default: 65536)
+  --drop-congested  Drop packets instead of blocking when the send buffer is full
+  --on-oversize     Frames too big for one 1500-MTU packet: warn|fragment|drop (default: warn)
+  --max-frame       Largest Ethernet frame to forward, up to 9018 for jumbo frames (default: 1514)
+  --frame-crc       Check a CRC-32 on every frame before injecting it (when both sides set it)
+  --transport       Transport protocol: udp|tcp (default: udp; tcp adds latency)
+  --idle-timeout    Exit after no frames for this long, e.g. 30m (default: 0, off)
+  --max-duration    Exit once a session has run this long, e.g. 2h (default: 0, off)
+  --check-xbox      At startup, wait up to this long for a frame from --xbox-mac, e.g. 5s
+  --wait-progress   While listening, log how long we've waited for a peer this often (default: 30s, 0: off)
+  --watch-discovery Warn if another Xbox sends System Link traffic mid-session
+  --detect-loops    Drop and warn about injected frames that come back through capture
+  --ethernet-ii-only Drop captured frames that aren't Ethernet II (802.3/LLC, capture glitches)
+  --clock-skew      Exchange clocks in PONGs and warn if the peer's is off by over 1s (both sides)
+  --diag            Run one half only: capture (count Xbox frames, no peer) or transport (no pcap)
   --stats-interval  Seconds between stats output, 0 to disable (default: 30)
+  --stats-format    Stats output format: line|csv|table (default: line)
   --events-output   Write JSON Line events to: stdout, stderr, or a file path (disabled if empty)
+  --events-filter   Comma-separated event types to write, e.g. state_changed,error (default: all)
+  --events-sync     How often to fsync a file --events-output, 0 for every event (default: 1s)
+  --http-addr       Serve /healthz and /stats.json on this address, e.g. :8080 (localhost only) (default: off)
+  --http-token      Require "Authorization: Bearer <token>" on --http-addr requests
+  --allow-from      Comma-separated CIDRs/IPs allowed to connect (listen mode only, default: any)
 
 Examples:
   # List network interfaces
   xbslink-ng interfaces
 
+  # Find which interface the Xbox is on, and its MAC (start a System Link game)
+  xbslink-ng discover
+
   # Listen for incoming connection (port forward UDP 31415)
   xbslink-ng listen --port 31415 --interface "Ethernet" --xbox-mac 00:50:F2:1A:2B:3C
 
@@ -95,11 +157,191 @@ Examples:
   # With authentication (recommended)
   xbslink-ng listen --port 31415 --interface "Ethernet" --xbox-mac 00:50:F2:1A:2B:3C --key "mysecretkey"
 
+  # Review last night's session from its events file
+  xbslink-ng summarize events.jsonl
+
 Press Enter at any time to see current statistics.
+Type d and Enter to cycle the log level (info, debug, trace).
 `)
 }
 
-func runInterfaces() {
+// runSelfTest measures codec throughput in insecure and secure mode for
+// small and full-size frames, and fails if any falls short of --rate.
+func runSelfTest(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	rate := fs.Uint("rate", 10000, "Frames per second each way the machine must sustain")
+	duration := fs.Duration("duration", time.Second, "How long to run each measurement")
+	fs.Parse(args)
+
+	fmt.Printf("Codec self-test (%s/%s, %d CPUs), target %d frames/s each way\n",
+		runtime.GOOS, runtime.GOARCH, runtime.NumCPU(), *rate)
+	fmt.Printf("%s\n\n", protocol.AESCapability())
+	fmt.Printf("  %-8s  %6s  %12s  %10s\n", "Mode", "Frame", "Frames/s", "Per frame")
+
+	key := make([]byte, 32) // any key; only the HMAC cost matters
+	var slowest protocol.SelfTestResult
+	for _, secure := range []bool{false, true} {
+		for _, size := range []int{64, protocol.MaxFrameSize} {
+			var k []byte
+			if secure {
+				k = key
+			}
+			result, err := protocol.SelfTest(k, size, *duration)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: self-test failed: %v\n", err)
+				os.Exit(1)
+			}
+			mode := "insecure"
+			if secure {
+				mode = "secure"
+			}
+			fmt.Printf("  %-8s  %5dB  %12.0f  %10s\n", mode, size, result.FramesPerSec(), result.PerFrame())
+			if slowest.Frames == 0 || result.FramesPerSec() < slowest.FramesPerSec() {
+				slowest = result
+			}
+		}
+	}
+
+	verdict := "PASS"
+	if slowest.FramesPerSec() < float64(*rate) {
+		verdict = "FAIL"
+	}
+	fmt.Printf("\n%s: slowest was %.0f frames/s (secure=%t, %dB frames), %.1fx the %d frames/s target\n",
+		verdict, slowest.FramesPerSec(), slowest.Secure, slowest.FrameSize, slowest.FramesPerSec()/float64(*rate), *rate)
+	if verdict == "FAIL" {
+		os.Exit(1)
+	}
+}
+
+// shutdownOnSignal returns a context cancelled by the first SIGINT or
+// SIGTERM. runBridge threads it through every phase (the Xbox check,
+// discovery, connecting, backoff and the session itself), each of which
+// returns as soon as it is cancelled. A second signal exits at once, in case
+// something is slow to stop.
+func shutdownOnSignal(logger *logging.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logger.Info("Received signal %v, shutting down... (again to force)", sig)
+		cancel()
+		<-sigCh
+		logger.Warn("Received second signal, exiting now")
+		os.Exit(130)
+	}()
+	return ctx, cancel
+}
+
+// checkXboxTransmitting waits up to timeout for a frame from the configured
+// Xbox MAC and warns if none arrives. It is advisory: the bridge starts either
+// way.
+func checkXboxTransmitting(ctx context.Context, cap *capture.Capture, timeout time.Duration, logger *logging.Logger) {
+	logger.Info("Checking that %s is transmitting (up to %v)...", cap.XboxMAC(), timeout)
+	seen, err := cap.WaitForXbox(ctx, timeout)
+	switch {
+	case ctx.Err() != nil:
+		// Interrupted; runBridge notices ctx and shuts down
+	case err != nil:
+		logger.Warn("Could not check the Xbox: %v", err)
+	case seen:
+		logger.Info("Xbox is transmitting")
+	default:
+		logger.Warn("Configured Xbox MAC %s isn't transmitting - is the console on and in a System Link game?", cap.XboxMAC())
+	}
+}
+
+// runSummarize prints a session report for an events file ("-" for stdin).
+func runSummarize(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: xbslink-ng summarize <events-file|->")
+		os.Exit(1)
+	}
+
+	in := os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	summary, err := events.Summarize(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading events: %v\n", err)
+		os.Exit(1)
+	}
+	summary.Print(os.Stdout)
+}
+
+// versionInfo is the output of "version --json".
+type versionInfo struct {
+	Version         string `json:"version"`
+	Commit          string `json:"commit"`
+	BuildDate       string `json:"build_date"`
+	GoVersion       string `json:"go_version"`
+	GoOS            string `json:"go_os"`
+	GoArch          string `json:"go_arch"`
+	ProtocolVersion uint16 `json:"protocol_version"`
+}
+
+func runVersion(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print version information as JSON")
+	fs.Parse(args)
+
+	if !*asJSON {
+		fmt.Printf("xbslink-ng %s (%s/%s)\n", Version, runtime.GOOS, runtime.GOARCH)
+		fmt.Printf("Commit: %s, built %s with %s\n", buildCommit(), BuildDate, runtime.Version())
+		fmt.Println(protocol.AESCapability())
+		return
+	}
+	if err := writeVersionJSON(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// writeVersionJSON writes the output of "version --json" to w.
+func writeVersionJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(versionInfo{
+		Version:         Version,
+		Commit:          buildCommit(),
+		BuildDate:       BuildDate,
+		GoVersion:       runtime.Version(),
+		GoOS:            runtime.GOOS,
+		GoArch:          runtime.GOARCH,
+		ProtocolVersion: protocol.ProtocolVersion,
+	})
+}
+
+// buildCommit returns Commit, or the VCS revision go build stamped into the
+// binary when built from a checkout without -ldflags.
+func buildCommit() string {
+	if Commit != "unknown" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return Commit
+}
+
+// runInterfaces lists the interfaces that look usable, or all with --all.
+func runInterfaces(args []string) {
+	fs := flag.NewFlagSet("interfaces", flag.ExitOnError)
+	all := fs.Bool("all", false, "Show every capture device, including down, virtual and address-less ones")
+	fs.Parse(args)
+
 	// Check for Npcap on Windows before listing
 	if err := capture.CheckNpcapInstalled(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n\n%s\n", err, capture.NpcapInstallHelp())
@@ -119,68 +361,128 @@ func runInterfaces() {
 		os.Exit(1)
 	}
 
-	fmt.Print(capture.FormatInterfaceList(interfaces))
-}
-
-func runListen(args []string) {
-	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	shown := interfaces
+	if !*all {
+		shown = capture.UsableInterfaces(interfaces)
+	}
+	if len(shown) == 0 {
+		fmt.Println("No interfaces are up with an IPv4 address.")
+		fmt.Println("Run 'xbslink-ng interfaces --all' to see all of them.")
+		return
+	}
 
-	port := fs.Uint("port", defaultPort, "UDP port to listen on")
-	ifaceName := fs.String("interface", "", "Network interface name (required)")
-	xboxMAC := fs.String("xbox-mac", "", "Xbox MAC address (auto-detected if omitted)")
-	key := fs.String("key", "", "Pre-shared key for authentication")
-	logLevel := fs.String("log", defaultLogLevel, "Log level: error|warn|info|debug|trace")
-	statsInterval := fs.Uint("stats-interval", defaultStatsInterval, "Seconds between stats output (0 to disable)")
-	eventsOutput := fs.String("events-output", "", "Write JSON Line events to: stdout, stderr, or a file path")
+	fmt.Print(capture.FormatInterfaceList(shown))
+	if hidden := len(interfaces) - len(shown); hidden > 0 {
+		fmt.Printf("%d more (down, loopback or without IPv4) hidden; use --all to show them.\n", hidden)
+	}
+}
 
+// runDiscover listens on every interface that is up until an Xbox sends
+// System Link traffic, then prints the flags to use for it.
+func runDiscover(args []string) {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	noPromisc := fs.Bool("no-promisc", false, "Open the interfaces without promiscuous mode")
+	timeout := fs.Duration("timeout", 0, "Give up after this long, e.g. 2m (default: 0, wait until Ctrl+C)")
+	logLevel := fs.String("log", "info", "Log level: error|warn|info|debug|trace")
 	fs.Parse(args)
 
-	// Validate required flags
-	if *ifaceName == "" {
-		fmt.Fprintln(os.Stderr, "Error: --interface is required")
-		fmt.Fprintln(os.Stderr, "\nRun 'xbslink-ng interfaces' to list available interfaces.")
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-	if *port == 0 || *port > 65535 {
-		fmt.Fprintln(os.Stderr, "Error: --port must be between 1 and 65535")
+	logger := logging.NewLogger(level)
+
+	if err := capture.CheckNpcapInstalled(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n%s\n", err, capture.NpcapInstallHelp())
 		os.Exit(1)
 	}
 
-	runBridge(transport.ModeListen, uint16(*port), "", *ifaceName, *xboxMAC, *key, *logLevel, time.Duration(*statsInterval)*time.Second, *eventsOutput)
-}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
 
-func runConnect(args []string) {
-	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	promisc := !*noPromisc
+	logger.Info("Listening on all interfaces for System Link traffic; start a System Link game on the Xbox (Ctrl+C to stop)")
+	result, err := discovery.DiscoverAny(ctx, discovery.Config{Logger: logger, Promiscuous: &promisc, Timeout: *timeout})
+	if err != nil {
+		switch err {
+		case discovery.ErrDiscoveryTimeout:
+			fmt.Fprintf(os.Stderr, "No Xbox found in %v. Is it on and in a System Link game?\n", *timeout)
+		case discovery.ErrDiscoveryCancelled:
+			fmt.Fprintln(os.Stderr, "No System Link traffic seen.")
+		default:
+			fmt.Fprintf(os.Stderr, "Error: discovery failed: %v\n", err)
+		}
+		os.Exit(1)
+	}
 
-	address := fs.String("address", "", "Peer address in IP:port format (required)")
-	port := fs.Uint("port", 0, "Local UDP port (0 = auto-assign)")
-	ifaceName := fs.String("interface", "", "Network interface name (required)")
-	xboxMAC := fs.String("xbox-mac", "", "Xbox MAC address (auto-detected if omitted)")
-	key := fs.String("key", "", "Pre-shared key for authentication")
-	logLevel := fs.String("log", defaultLogLevel, "Log level: error|warn|info|debug|trace")
-	statsInterval := fs.Uint("stats-interval", defaultStatsInterval, "Seconds between stats output (0 to disable)")
-	eventsOutput := fs.String("events-output", "", "Write JSON Line events to: stdout, stderr, or a file path")
+	// On Windows, suggest the Network Connections name over the device GUID
+	iface := result.Interface
+	if info, err := capture.FindInterface(iface); err == nil && info.FriendlyName != "" {
+		iface = info.FriendlyName
+	}
+	fmt.Printf("Found Xbox %s on %s\n\n", result.MAC, iface)
+	fmt.Printf("Use: --interface %q --xbox-mac %s\n", iface, result.MAC)
+}
 
-	fs.Parse(args)
+func runListen(args []string) {
+	runBridge(parseSettings(transport.ModeListen, args))
+}
 
-	// Validate required flags
-	if *address == "" {
-		fmt.Fprintln(os.Stderr, "Error: --address is required")
-		os.Exit(1)
+func runConnect(args []string) {
+	runBridge(parseSettings(transport.ModeConnect, args))
+}
+
+// parseSettings resolves the settings for mode from args, the saved config
+// and the environment, exiting with a message if any is invalid.
+func parseSettings(mode transport.Mode, args []string) *Settings {
+	s := newSettings(mode)
+	saved, err := config.Load()
+	if err != nil && !errors.Is(err, config.ErrNewerVersion) {
+		saved = nil
 	}
-	if *ifaceName == "" {
-		fmt.Fprintln(os.Stderr, "Error: --interface is required")
-		fmt.Fprintln(os.Stderr, "\nRun 'xbslink-ng interfaces' to list available interfaces.")
+	s.SavedErr = err
+	if err := s.Resolve(args, saved, os.Getenv, os.Stdin); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, errInterfaceRequired) {
+			fmt.Fprintln(os.Stderr, "\nRun 'xbslink-ng interfaces' to list available interfaces.")
+		}
 		os.Exit(1)
 	}
+	return s
+}
 
-	// Validate address format
-	if !strings.Contains(*address, ":") {
-		fmt.Fprintln(os.Stderr, "Error: --address must be in IP:port format (e.g., 192.168.1.100:31415)")
-		os.Exit(1)
+// portAliases are names accepted in place of a port number in --port and
+// --address, for networks that only let well-known UDP ports through.
+var portAliases = map[string]uint16{
+	"dns":       53,
+	"http":      80,
+	"ntp":       123,
+	"https":     443,
+	"ike":       500,
+	"ipsec-nat": 4500,
+}
+
+// resolvePortAliases replaces port names in a comma-separated port list with
+// their numbers, leaving anything else for the caller to validate.
+func resolvePortAliases(s string) string {
+	parts := strings.Split(s, ",")
+	for i, part := range parts {
+		if port, ok := portAliases[strings.ToLower(strings.TrimSpace(part))]; ok {
+			parts[i] = strconv.Itoa(int(port))
+		}
 	}
+	return strings.Join(parts, ",")
+}
 
-	runBridge(transport.ModeConnect, uint16(*port), *address, *ifaceName, *xboxMAC, *key, *logLevel, time.Duration(*statsInterval)*time.Second, *eventsOutput)
+// resolveAddressPortAlias replaces a port name in a host:port address with
+// its number.
+func resolveAddressPortAlias(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort(host, resolvePortAliases(port))
 }
 
 // getBackoffDelay returns the backoff delay for a given reconnection attempt.
@@ -197,19 +499,43 @@ func getBackoffDelay(attempt int) time.Duration {
 	return 10 * time.Second // Cap at 10s
 }
 
-func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr, key, logLevelStr string, statsInterval time.Duration, eventsOutput string) {
+// parseExcludeDst parses --exclude-dst: a MAC address, or "local" for the
+// MAC of the interface frames are injected on.
+func parseExcludeDst(s, ifaceName, injectIfaceName string) (net.HardwareAddr, error) {
+	if !strings.EqualFold(s, "local") {
+		return capture.ParseMAC(s)
+	}
+	if injectIfaceName != "" {
+		ifaceName = injectIfaceName
+	}
+	return capture.InterfaceMAC(ifaceName)
+}
+
+func runBridge(s *Settings) {
 	// Parse log level
-	level, err := logging.ParseLevel(logLevelStr)
+	level, err := logging.ParseLevel(s.LogLevel)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
+	timeLayout, err := logging.ParseTimestampFormat(s.LogTimeFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --log-timeformat: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Create logger
 	logger := logging.NewLogger(level)
+	logger.SetTimestampFormat(timeLayout)
+	logger.SetUTC(s.LogUTC)
+	logger.SetColorMode(s.ColorMode)
+	for module, level := range s.ModuleLevels {
+		logger.SetModuleLevel(module, level)
+	}
 
 	// Create event emitter
-	emitter, err := createEmitter(eventsOutput)
+	emitter, err := createEmitter(s.EventsOutput, s.EventsSync, s.EventTypes, logger.Warn)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating event emitter: %v\n", err)
 		os.Exit(1)
@@ -218,8 +544,45 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 
 	// Print banner
 	logger.Info("xbslink-ng %s starting", Version)
-	if eventsOutput != "" {
-		logger.Info("Events output: %s", eventsOutput)
+	logger.Debug("Build: commit %s, built %s with %s", buildCommit(), BuildDate, runtime.Version())
+	logger.Info("%s", protocol.AESCapability())
+	if s.EventsOutput != "" {
+		logger.Info("Events output: %s", s.EventsOutput)
+	}
+	if s.Backend == transport.BackendTCP {
+		logger.Warn("Using TCP transport: expect higher latency from head-of-line blocking; prefer UDP when it gets through")
+		// A stream has no datagrams to fragment
+		s.Oversize = bridge.OversizeFragment
+	}
+	if s.BatchRecv && !transport.BatchSupported() {
+		logger.Warn("--batch-recv is not supported on %s, using single reads", runtime.GOOS)
+	}
+	if s.BatchSend && !transport.BatchSupported() {
+		logger.Warn("--batch-send is not supported on %s, using single writes", runtime.GOOS)
+	}
+	statusHandler := status.NewHandler()
+	if s.HTTPAddr != "" {
+		var h http.Handler = statusHandler
+		if s.HTTPToken != "" {
+			h = status.RequireToken(s.HTTPToken, h)
+		}
+		srv, err := status.Start(s.HTTPAddr, h)
+		if err != nil {
+			logger.Error("Failed to start --http-addr server: %v", err)
+			os.Exit(1)
+		}
+		defer srv.Close()
+		logger.Info("Serving /healthz and /stats.json on http://%s", srv.Addr())
+		if !srv.Local() && s.HTTPToken == "" {
+			logger.Warn("--http-addr %s is reachable from other machines without --http-token; anyone who can reach it can read the bridge's state", s.HTTPAddr)
+		}
+	}
+	if len(s.AllowFrom) > 0 {
+		ranges := make([]string, len(s.AllowFrom))
+		for i, n := range s.AllowFrom {
+			ranges[i] = n.String()
+		}
+		logger.Info("Accepting peers from: %s", strings.Join(ranges, ", "))
 	}
 
 	// Check Npcap on Windows
@@ -233,45 +596,74 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 	}
 
 	// Warn about insecure mode
-	var keyBytes []byte
-	if key == "" {
+	if s.Key == "" && s.RequireKey {
+		logger.Error("--require-key is set but no --key was given; refusing to run in insecure mode")
+		os.Exit(1)
+	}
+	if s.Key == "" && s.Insecure {
+		logger.Warn("Running without --key (insecure mode, acknowledged with --insecure): anyone who discovers your port can inject traffic into your LAN")
+	} else if s.Key == "" {
 		logger.Warn("*************************************************************")
 		logger.Warn("* WARNING: Running without --key (insecure mode)            *")
 		logger.Warn("* Anyone who discovers your port can inject traffic into    *")
 		logger.Warn("* your LAN. Use --key with a shared secret for security.    *")
+		logger.Warn("* Pass --insecure to run without a key on purpose; a        *")
+		logger.Warn("* future release will refuse to start without one of them.  *")
 		logger.Warn("*************************************************************")
 	} else {
-		keyBytes = []byte(key)
-		logger.Info("Authentication enabled (HMAC-SHA256)")
+		if s.KeyFormat == protocol.KeyFormatRaw {
+			logger.Info("Authentication enabled (HMAC-SHA256)")
+		} else {
+			logger.Info("Authentication enabled (HMAC-SHA256, %d-byte %s key)", len(s.KeyBytes), s.KeyFormat)
+		}
 	}
 
-	// Load saved config
-	cfg, err := config.Load()
-	if err != nil {
-		logger.Warn("Failed to load config: %v", err)
-		cfg = &config.Config{} // Use empty config
+	// Saved config, loaded by parseSettings
+	cfg := s.Saved
+	if errors.Is(s.SavedErr, config.ErrNewerVersion) {
+		logger.Warn("Config: %v; using the settings this version understands", s.SavedErr)
+	} else if s.SavedErr != nil {
+		logger.Warn("Failed to load config: %v", s.SavedErr)
+	}
+	for _, warning := range cfg.Warnings {
+		logger.Warn("Config: %s", warning)
+	}
+	if logger.GetLevel() >= logging.LevelDebug {
+		logger.Debug("Effective configuration:")
+		for _, setting := range s.Effective {
+			logger.Debug("  %s", setting)
+		}
 	}
 
 	// Determine Xbox MAC address
 	var mac net.HardwareAddr
 	var needsDiscovery bool
 
-	if xboxMACStr != "" {
-		// Use provided MAC address (overrides saved config)
-		mac, err = capture.ParseMAC(xboxMACStr)
+	if s.Diag == bridge.DiagTransport {
+		// No capture: frames from the peer are counted and dropped
+		logger.Info("Transport diagnostics: not opening pcap, received frames are counted as RX and dropped")
+	} else if s.XboxMAC != "" {
+		// --xbox-mac, or the saved MAC from config if not given
+		mac, err = capture.ParseMAC(s.XboxMAC)
 		if err != nil {
 			logger.Error("Invalid Xbox MAC address: %v", err)
 			os.Exit(1)
 		}
-		logger.Info("Using Xbox MAC from --xbox-mac: %s", mac)
-	} else if savedMAC := cfg.GetXboxMAC(); savedMAC != nil {
-		// Use saved MAC from config
-		mac = savedMAC
-		logger.Info("Using saved Xbox MAC from config: %s", mac)
+		if s.Source("xbox-mac") == config.SourceFlag {
+			logger.Info("Using Xbox MAC from --xbox-mac: %s", mac)
+		} else {
+			logger.Info("Using saved Xbox MAC from config: %s", mac)
+			if len(cfg.RecentXboxMACs) > 1 {
+				logger.Info("Recently seen Xboxes (choose one with --xbox-mac):")
+				for _, entry := range cfg.RecentXboxMACs {
+					logger.Info("  %s  last seen %s", entry.MAC, entry.LastSeen.Local().Format("2006-01-02 15:04"))
+				}
+			}
+		}
 	} else {
 		// No MAC available, will need discovery
 		needsDiscovery = true
-		if mode == transport.ModeListen {
+		if s.Mode == transport.ModeListen {
 			logger.Info("No Xbox MAC available, will auto-discover in background")
 			logger.Info("Start a System Link game on your Xbox to detect it automatically")
 		} else {
@@ -281,57 +673,104 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 	}
 
 	// Find and display interface info
-	iface, err := capture.FindInterface(ifaceName)
-	if err != nil {
-		logger.Error("Interface not found: %v", err)
-		fmt.Fprintln(os.Stderr, "\nRun 'xbslink-ng interfaces' to list available interfaces.")
-		os.Exit(1)
+	if s.Diag != bridge.DiagTransport {
+		iface, err := capture.FindInterface(s.Interface)
+		if err != nil {
+			logger.Error("Interface not found: %v", err)
+			fmt.Fprintln(os.Stderr, "\nRun 'xbslink-ng interfaces' to list available interfaces.")
+			os.Exit(1)
+		}
+
+		addrStr := "no IP"
+		if len(iface.Addresses) > 0 {
+			addrStr = iface.Addresses[0]
+		}
+		logger.Info("Interface: %s (%s)", iface.Name, addrStr)
+	}
+	if s.InjectInterface != "" && s.Diag != bridge.DiagTransport {
+		injectIface, err := capture.FindInterface(s.InjectInterface)
+		if err != nil {
+			logger.Error("Inject interface not found: %v", err)
+			fmt.Fprintln(os.Stderr, "\nRun 'xbslink-ng interfaces' to list available interfaces.")
+			os.Exit(1)
+		}
+		logger.Info("Injecting on: %s", injectIface.Name)
 	}
 
-	addrStr := "no IP"
-	if len(iface.Addresses) > 0 {
-		addrStr = iface.Addresses[0]
+	// Capture settings; XboxMAC is filled in once known
+	promisc := !s.NoPromisc
+	capCfg := capture.Config{
+		Interface:       s.Interface,
+		InjectInterface: s.InjectInterface,
+		Logger:          logger.Module(logging.ModuleCapture),
+		Promiscuous:     &promisc,
+	}
+	if s.ExcludeDst != "" {
+		capCfg.ExcludeDst, err = parseExcludeDst(s.ExcludeDst, s.Interface, s.InjectInterface)
+		if err != nil {
+			logger.Error("Invalid --exclude-dst: %v", err)
+			os.Exit(1)
+		}
+		logger.Info("Not capturing frames sent to: %s", capCfg.ExcludeDst)
 	}
-	logger.Info("Interface: %s (%s)", iface.Name, addrStr)
 
 	// Create protocol codec
-	codec := protocol.NewCodec(keyBytes)
+	var codec *protocol.Codec
+	if s.KeyBytes != nil && s.KeyFormat != protocol.KeyFormatRaw {
+		codec = protocol.NewCodecFromKey(s.KeyBytes)
+	} else if codec, err = protocol.NewCodec(s.KeyBytes); err != nil {
+		// Only a FIPS 140-only Go runtime refuses a passphrase, if it is short
+		logger.Error("Can't use this --key: %v. Use a passphrase of at least 14 bytes, or a random key with --key-format hex or base64", err)
+		os.Exit(1)
+	}
+	codec.SetFrameCRC(s.FrameCRC)
+	if err := codec.SetMaxFrameSize(s.MaxFrame); err != nil {
+		logger.Error("Invalid --max-frame: %v", err)
+		os.Exit(1)
+	}
+	if size := codec.EncodedSize(s.MaxFrame); size > transport.MaxMessageSize(s.Backend) {
+		logger.Error("Invalid --max-frame: %d-byte messages don't fit the %s transport (max %d)", size, s.Backend, transport.MaxMessageSize(s.Backend))
+		os.Exit(1)
+	}
+	if s.MaxFrame > protocol.MaxFrameSize {
+		logger.Info("Forwarding frames up to %d bytes; the peer must use the same --max-frame", s.MaxFrame)
+	}
+	if s.FrameCRC {
+		logger.Info("Offering frame CRCs (--frame-crc); used if the peer offers them too")
+	}
+
+	// Create application-level context (cancelled only by user signals)
+	appCtx, appCancel := shutdownOnSignal(logger)
+	defer appCancel()
 
 	// Create capture if we have a MAC, otherwise nil
 	var cap *capture.Capture
 	if mac != nil {
 		logger.Info("Xbox MAC: %s", mac)
-		cap, err = capture.New(capture.Config{
-			Interface: ifaceName,
-			XboxMAC:   mac,
-			Logger:    logger,
-		})
+		capCfg.XboxMAC = mac
+		cap, err = capture.New(capCfg)
 		if err != nil {
 			logger.Error("Failed to open capture: %v", err)
 			os.Exit(1)
 		}
+		if s.CheckXbox > 0 {
+			checkXboxTransmitting(appCtx, cap, s.CheckXbox, logger)
+		}
 	}
 
-	// Create application-level context (cancelled only by user signals)
-	appCtx, appCancel := context.WithCancel(context.Background())
-	defer appCancel()
-
-	// Set up signal handler for graceful application shutdown
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		sig := <-sigCh
-		logger.Info("Received signal %v, shutting down...", sig)
-		appCancel()
-	}()
+	// Stats formatter is shared across reconnects so CSV/table headers print once
+	statsFormatter := bridge.NewStatsFormatter(s.StatsFormat)
 
-	// If discovery is needed in connect mode, run it once before reconnection loop
-	if needsDiscovery && mode == transport.ModeConnect {
+	// If discovery is needed in connect mode (or with no peer at all under
+	// --diag capture), run it once before reconnection loop
+	if needsDiscovery && (s.Mode == transport.ModeConnect || s.Diag == bridge.DiagCapture) {
 		// Run discovery in foreground for connect mode (blocking)
-		mac = runForegroundDiscovery(appCtx, ifaceName, logger, emitter)
+		mac = runForegroundDiscovery(appCtx, capCfg, logger, emitter)
 		if mac == nil {
-			// Discovery was cancelled or failed
-			os.Exit(1)
+			if appCtx.Err() != nil {
+				return // Interrupted
+			}
+			os.Exit(1) // Discovery failed
 		}
 
 		// Save discovered MAC
@@ -344,11 +783,8 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 
 		// Create capture with discovered MAC
 		logger.Info("Xbox MAC: %s", mac)
-		cap, err = capture.New(capture.Config{
-			Interface: ifaceName,
-			XboxMAC:   mac,
-			Logger:    logger,
-		})
+		capCfg.XboxMAC = mac
+		cap, err = capture.New(capCfg)
 		if err != nil {
 			logger.Error("Failed to open capture: %v", err)
 			os.Exit(1)
@@ -356,6 +792,39 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 		needsDiscovery = false // Discovery complete
 	}
 
+	// Capture diagnostics never connect: one bridge with no transport, run
+	// until interrupted
+	if s.Diag == bridge.DiagCapture {
+		br, err := bridge.New(bridge.Config{
+			Capture:        cap,
+			Codec:          codec,
+			Logger:         logger.Module(logging.ModuleBridge),
+			Emitter:        emitter,
+			Mode:           s.Mode,
+			StatsInterval:  s.StatsInterval,
+			StatsFormatter: statsFormatter,
+			TraceSample:    s.TraceSample,
+			DumpFrames:     s.DumpFrames,
+			EthernetIIOnly: s.EthernetIIOnly,
+			IdleTimeout:    s.IdleTimeout,
+			MaxDuration:    s.MaxDuration,
+			Diag:           s.Diag,
+		})
+		if err != nil {
+			logger.Error("Failed to create bridge: %v", err)
+			cap.Close()
+			os.Exit(1)
+		}
+		statusHandler.Set(br)
+		err = br.Run(appCtx)
+		cap.Close()
+		if err != nil && !errors.Is(err, bridge.ErrIdleTimeout) && !errors.Is(err, bridge.ErrMaxDuration) {
+			logger.Error("Bridge error: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Reconnection loop
 	attempt := 0
 	for {
@@ -370,7 +839,7 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 
 		// Log connection attempt
 		if attempt > 0 {
-			if mode == transport.ModeListen {
+			if s.Mode == transport.ModeListen {
 				logger.Info("Waiting for new peer connection...")
 			} else {
 				logger.Info("Reconnection attempt %d...", attempt)
@@ -381,12 +850,20 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 		connCtx, connCancel := context.WithCancel(appCtx)
 
 		// Create fresh transport for this connection
-		trans, err := transport.New(transport.Config{
-			Mode:      mode,
-			LocalPort: port,
-			PeerAddr:  peerAddr,
-			Codec:     codec,
-			Logger:    logger,
+		trans, err := transport.Open(s.Backend, transport.Config{
+			Mode:         s.Mode,
+			LocalPort:    s.Ports[0],
+			ListenPorts:  s.Ports,
+			PeerAddr:     s.PeerAddr,
+			AllowFrom:    s.AllowFrom,
+			Codec:        codec,
+			Logger:       logger.Module(logging.ModuleTransport),
+			Emitter:      emitter,
+			SocketBuffer: int(s.SocketBuffer),
+
+			DropOnCongestion: s.DropOnCongestion,
+			RequireSecure:    s.RequireKey,
+			WaitProgress:     s.WaitProgress,
 		})
 		if err != nil {
 			logger.Error("Failed to create transport: %v", err)
@@ -396,16 +873,32 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 			}
 			os.Exit(1) // Fatal error, can't continue
 		}
+		if s.Mode == transport.ModeListen && attempt == 0 {
+			announceListening(s.Interface, s.Ports[0], s.Backend, s.Key != "", logger, emitter)
+		}
 
 		// Create fresh bridge for this connection (reuse capture if available)
 		br, err := bridge.New(bridge.Config{
-			Capture:       cap,
-			Transport:     trans,
-			Codec:         codec,
-			Logger:        logger,
-			Emitter:       emitter,
-			Mode:          mode,
-			StatsInterval: statsInterval,
+			Capture:        cap,
+			Transport:      trans,
+			Codec:          codec,
+			Logger:         logger.Module(logging.ModuleBridge),
+			Emitter:        emitter,
+			Mode:           s.Mode,
+			StatsInterval:  s.StatsInterval,
+			StatsFormatter: statsFormatter,
+			TraceSample:    s.TraceSample,
+			DumpFrames:     s.DumpFrames,
+			DetectLoops:    s.DetectLoops,
+			EthernetIIOnly: s.EthernetIIOnly,
+			ClockSkew:      s.ClockSkew,
+			Workers:        s.Workers,
+			OversizePolicy: s.Oversize,
+			BatchRecv:      s.BatchRecv,
+			BatchSend:      s.BatchSend,
+			IdleTimeout:    s.IdleTimeout,
+			MaxDuration:    s.MaxDuration,
+			Diag:           s.Diag,
 		})
 		if err != nil {
 			logger.Error("Failed to create bridge: %v", err)
@@ -416,10 +909,15 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 			}
 			os.Exit(1) // Fatal error
 		}
+		statusHandler.Set(br)
 
 		// If discovery is needed in listen mode, run it in background for this connection
-		if needsDiscovery && mode == transport.ModeListen {
-			go runBackgroundDiscovery(connCtx, ifaceName, br, cfg, logger, emitter)
+		if needsDiscovery && s.Mode == transport.ModeListen {
+			go runBackgroundDiscovery(connCtx, capCfg, br, cfg, logger, emitter)
+		}
+
+		if s.WatchDiscovery && s.Diag != bridge.DiagTransport {
+			go runDiscoveryWatch(connCtx, capCfg, br, logger, emitter)
 		}
 
 		// Run the bridge (blocks until disconnect or error)
@@ -439,7 +937,19 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 		}
 
 		// Decide whether to reconnect
-		if errors.Is(err, bridge.ErrPeerDisconnected) {
+		if errors.Is(err, bridge.ErrIdleTimeout) {
+			logger.Info("Session idle for %v, exiting", s.IdleTimeout)
+			if cap != nil {
+				cap.Close()
+			}
+			return
+		} else if errors.Is(err, bridge.ErrMaxDuration) {
+			logger.Info("Session reached --max-duration %v, exiting", s.MaxDuration)
+			if cap != nil {
+				cap.Close()
+			}
+			return
+		} else if errors.Is(err, bridge.ErrPeerDisconnected) {
 			// Peer disconnected, reconnect
 			logger.Info("Peer disconnected, preparing to reconnect...")
 
@@ -447,7 +957,7 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 			codec.ResetRecvNonce()
 
 			// Apply backoff for connect mode
-			if mode == transport.ModeConnect {
+			if s.Mode == transport.ModeConnect {
 				delay := getBackoffDelay(attempt)
 				logger.Info("Waiting %v before reconnect...", delay)
 
@@ -484,10 +994,12 @@ func runBridge(mode transport.Mode, port uint16, peerAddr, ifaceName, xboxMACStr
 }
 
 // runBackgroundDiscovery runs Xbox discovery in the background and sets capture when found.
-func runBackgroundDiscovery(ctx context.Context, ifaceName string, br *bridge.Bridge, cfg *config.Config, logger *logging.Logger, emitter events.Emitter) {
+// capCfg is the capture configuration without the Xbox MAC.
+func runBackgroundDiscovery(ctx context.Context, capCfg capture.Config, br *bridge.Bridge, cfg *config.Config, logger *logging.Logger, emitter events.Emitter) {
 	result, err := discovery.Discover(ctx, discovery.Config{
-		Interface: ifaceName,
-		Logger:    logger,
+		Interface:   capCfg.Interface,
+		Logger:      logger.Module(logging.ModuleDiscovery),
+		Promiscuous: capCfg.Promiscuous,
 	})
 
 	if err != nil {
@@ -512,11 +1024,8 @@ func runBackgroundDiscovery(ctx context.Context, ifaceName string, br *bridge.Br
 	}
 
 	// Create capture with discovered MAC
-	cap, err := capture.New(capture.Config{
-		Interface: ifaceName,
-		XboxMAC:   mac,
-		Logger:    logger,
-	})
+	capCfg.XboxMAC = mac
+	cap, err := capture.New(capCfg)
 	if err != nil {
 		logger.Error("Failed to open capture after discovery: %v", err)
 		return
@@ -530,25 +1039,73 @@ func runBackgroundDiscovery(ctx context.Context, ifaceName string, br *bridge.Br
 	}
 }
 
-// runForegroundDiscovery runs Xbox discovery in the foreground (blocking).
-// Returns nil if discovery was cancelled or failed.
-func runForegroundDiscovery(ctx context.Context, ifaceName string, logger *logging.Logger, emitter events.Emitter) net.HardwareAddr {
-	// Create a cancellable context for discovery
-	discoveryCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// runDiscoveryWatch reports System Link traffic from devices other than the
+// Xbox being bridged (or the remote consoles) for the lifetime of ctx.
+func runDiscoveryWatch(ctx context.Context, capCfg capture.Config, br *bridge.Bridge, logger *logging.Logger, emitter events.Emitter) {
+	ignore := func(mac net.HardwareAddr) bool {
+		// Until the bridged Xbox is known there is nothing to compare against
+		bridged := br.XboxMAC()
+		return bridged == nil || bytes.Equal(mac, bridged) || br.IsRemoteMAC(mac)
+	}
+	found := func(result discovery.Result) {
+		bridged := br.XboxMAC()
+		logger.Warn("System Link traffic from %s, but bridging %s. If that is the console you meant, restart with --xbox-mac %s",
+			result.MAC, bridged, result.MAC)
+		emitter.Emit(events.EventDiscovery, events.DiscoveryData{
+			MAC:        result.MAC.String(),
+			Unexpected: true,
+			BridgedMAC: bridged.String(),
+		})
+	}
 
-	// Handle Ctrl+C during discovery
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-sigCh
-		cancel()
-	}()
-	defer signal.Stop(sigCh)
+	err := discovery.Watch(ctx, discovery.Config{Interface: capCfg.Interface, Logger: logger.Module(logging.ModuleDiscovery), Promiscuous: capCfg.Promiscuous}, ignore, found)
+	if err != nil {
+		logger.Warn("Discovery watch failed: %v", err)
+	}
+}
+
+// announceListening logs, and emits as a listening event, the address to give
+// the peer: the first IPv4 address of the --interface with the listen port.
+func announceListening(ifaceName string, port uint16, backend transport.Backend, keySet bool, logger *logging.Logger, emitter events.Emitter) {
+	var ip net.IP
+	if info, err := capture.FindInterface(ifaceName); err == nil {
+		for _, a := range info.Addresses {
+			if parsed := net.ParseIP(a); parsed.To4() != nil && !parsed.IsLoopback() {
+				ip = parsed
+				break
+			}
+		}
+	}
+	if ip == nil {
+		logger.Info("Share this with your peer: <your-public-ip>:%d (key: %s)", port, keyState(keySet))
+		return
+	}
+
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	logger.Info("Share this with your peer: %s (key: %s)", addr, keyState(keySet))
+	if ip.IsPrivate() {
+		logger.Info("%s is a LAN address: a peer over the internet needs your public IP instead, with %s port %d forwarded here",
+			ip, strings.ToUpper(string(backend)), port)
+	}
+	emitter.Emit(events.EventListening, events.ListeningData{Address: addr, Private: ip.IsPrivate(), KeySet: keySet})
+}
+
+// keyState describes whether --key is set, for announceListening.
+func keyState(set bool) string {
+	if set {
+		return "set"
+	}
+	return "unset"
+}
 
-	result, err := discovery.Discover(discoveryCtx, discovery.Config{
-		Interface: ifaceName,
-		Logger:    logger,
+// runForegroundDiscovery runs Xbox discovery in the foreground (blocking)
+// until it finds an Xbox or ctx is cancelled.
+// Returns nil if discovery was cancelled or failed.
+func runForegroundDiscovery(ctx context.Context, capCfg capture.Config, logger *logging.Logger, emitter events.Emitter) net.HardwareAddr {
+	result, err := discovery.Discover(ctx, discovery.Config{
+		Interface:   capCfg.Interface,
+		Logger:      logger.Module(logging.ModuleDiscovery),
+		Promiscuous: capCfg.Promiscuous,
 	})
 
 	if err != nil {
@@ -565,25 +1122,38 @@ func runForegroundDiscovery(ctx context.Context, ifaceName string, logger *loggi
 	return result.MAC
 }
 
-// createEmitter creates an Emitter based on the --events-output flag value.
+// createEmitter creates an Emitter based on the --events-output flag value,
+// restricted to the given event types if any (--events-filter). File outputs
+// are synced every syncInterval (--events-sync). Write failures are reported
+// through warn.
 // Returns a NopEmitter if the value is empty.
-func createEmitter(output string) (events.Emitter, error) {
+func createEmitter(output string, syncInterval time.Duration, types []events.EventType, warn events.WarnFunc) (events.Emitter, error) {
+	emitter, err := openEmitter(output, syncInterval, warn)
+	if err != nil || len(types) == 0 {
+		return emitter, err
+	}
+	return events.NewFilterEmitter(emitter, types...), nil
+}
+
+// openEmitter opens the JSON Lines emitter for an --events-output value.
+func openEmitter(output string, syncInterval time.Duration, warn events.WarnFunc) (events.Emitter, error) {
+	var w *events.AsyncJSONLineWriter
 	switch output {
 	case "":
 		return events.NopEmitter{}, nil
 	case "stdout":
-		return events.NewAsyncJSONLineWriter(os.Stdout), nil
+		// Terminals and pipes can't be fsynced.
+		w = events.NewAsyncJSONLineWriterSync(os.Stdout, -1)
 	case "stderr":
-		return events.NewAsyncJSONLineWriter(os.Stderr), nil
+		w = events.NewAsyncJSONLineWriterSync(os.Stderr, -1)
 	default:
-		flags := os.O_WRONLY | os.O_APPEND
-		if _, err := os.Stat(output); os.IsNotExist(err) {
-			flags |= os.O_CREATE
-		}
-		f, err := os.OpenFile(output, flags, 0644)
+		// Reopened if writes keep failing or the file is rotated away
+		var err error
+		w, err = events.OpenAsyncJSONLineFile(output, syncInterval)
 		if err != nil {
 			return nil, fmt.Errorf("open events output %q: %w", output, err)
 		}
-		return events.NewAsyncJSONLineWriter(f), nil
 	}
+	w.SetWarnFunc(warn)
+	return w, nil
 }
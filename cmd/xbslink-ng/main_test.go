@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/xbslink/xbslink-ng/internal/protocol"
+)
+
+func TestWriteVersionJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeVersionJSON(&buf); err != nil {
+		t.Fatalf("writeVersionJSON() failed: %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if got["version"] != Version {
+		t.Errorf("version = %v, want %q", got["version"], Version)
+	}
+	if commit, ok := got["commit"].(string); !ok || commit == "" {
+		t.Errorf("commit = %v, want a non-empty string", got["commit"])
+	}
+	if got["protocol_version"] != float64(protocol.ProtocolVersion) {
+		t.Errorf("protocol_version = %v, want %d", got["protocol_version"], protocol.ProtocolVersion)
+	}
+	for _, field := range []string{"build_date", "go_version", "go_os", "go_arch"} {
+		if _, ok := got[field]; !ok {
+			t.Errorf("missing %q", field)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/bridge"
+	"github.com/xbslink/xbslink-ng/internal/config"
+	"github.com/xbslink/xbslink-ng/internal/events"
+	"github.com/xbslink/xbslink-ng/internal/logging"
+	"github.com/xbslink/xbslink-ng/internal/protocol"
+	"github.com/xbslink/xbslink-ng/internal/transport"
+)
+
+// errInterfaceRequired is returned by Resolve when --interface is missing.
+var errInterfaceRequired = errors.New("--interface is required")
+
+// Settings is everything runBridge needs. The flags of listen and connect
+// bind to its fields; Resolve layers the saved config and the environment
+// under the command line (see config.Resolve) and parses the values that
+// need it. Resolve is given the config, environment and stdin rather than
+// reading them itself, so it can be tested without touching any of them.
+type Settings struct {
+	Mode transport.Mode
+
+	Interface        string
+	InjectInterface  string
+	ExcludeDst       string
+	XboxMAC          string
+	Key              string
+	RequireKey       bool
+	Insecure         bool
+	NoPromisc        bool
+	LogLevel         string
+	LogTimeFormat    string
+	LogUTC           bool
+	TraceSample      uint
+	DumpFrames       uint
+	BatchRecv        bool
+	BatchSend        bool
+	Workers          int
+	MaxFrame         int
+	FrameCRC         bool
+	SocketBuffer     uint
+	DropOnCongestion bool
+	IdleTimeout      time.Duration
+	MaxDuration      time.Duration
+	CheckXbox        time.Duration
+	WaitProgress     time.Duration
+	WatchDiscovery   bool
+	DetectLoops      bool
+	EthernetIIOnly   bool
+	ClockSkew        bool
+	EventsOutput     string
+	EventsSync       time.Duration
+	HTTPAddr         string
+	HTTPToken        string
+
+	// Set by Resolve from the flags below
+	KeyFormat     protocol.KeyFormat
+	KeyBytes      []byte // Key decoded per KeyFormat; nil for insecure mode
+	Ports         []uint16
+	PeerAddr      string // connect mode
+	AllowFrom     []*net.IPNet
+	Backend       transport.Backend
+	ColorMode     logging.ColorMode
+	ModuleLevels  map[string]logging.Level
+	Oversize      bridge.OversizePolicy
+	StatsInterval time.Duration
+	StatsFormat   bridge.StatsFormat
+	EventTypes    []events.EventType
+	Diag          bridge.DiagMode
+
+	// Saved is the saved config, empty if there is none; SavedErr is the
+	// error loading it, set by parseSettings for runBridge to log.
+	Saved    *config.Config
+	SavedErr error
+
+	// Effective records every setting and where it came from.
+	Effective config.Effective
+
+	flags         *flag.FlagSet
+	port          string
+	address       string
+	allowFrom     string
+	transport     string
+	color         string
+	logModule     string
+	onOversize    string
+	statsInterval uint
+	statsFormat   string
+	eventsFilter  string
+	diag          string
+	keyFormat     string
+}
+
+// newSettings creates Settings for mode with its flags registered.
+func newSettings(mode transport.Mode) *Settings {
+	s := &Settings{Mode: mode}
+	name := "connect"
+	if mode == transport.ModeListen {
+		name = "listen"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	s.flags = fs
+
+	if mode == transport.ModeListen {
+		fs.StringVar(&s.port, "port", strconv.Itoa(defaultPort), "UDP port(s) to listen on, comma-separated; names like https work (e.g. 31415,3074,https)")
+	} else {
+		fs.StringVar(&s.address, "address", "", "Peer address in IP:port format, @file to read it from a file, or - for stdin (required)")
+		fs.StringVar(&s.port, "port", "0", "Local UDP port or name, e.g. dns (0 = auto-assign)")
+	}
+	fs.StringVar(&s.Interface, "interface", "", "Network interface name (required)")
+	fs.StringVar(&s.InjectInterface, "inject-interface", "", "Inject received frames on this interface instead of --interface")
+	fs.StringVar(&s.ExcludeDst, "exclude-dst", "", "Don't capture frames addressed to this MAC, or \"local\" for the inject interface's own")
+	fs.StringVar(&s.XboxMAC, "xbox-mac", "", "Xbox MAC address (auto-detected if omitted)")
+	fs.StringVar(&s.Key, "key", "", "Pre-shared key for authentication")
+	fs.StringVar(&s.keyFormat, "key-format", string(protocol.KeyFormatRaw), "How --key is written: raw (a passphrase) or hex|base64 (a random 16-64 byte key)")
+	fs.BoolVar(&s.RequireKey, "require-key", false, "Refuse to run without --key (never fall back to insecure mode)")
+	fs.BoolVar(&s.Insecure, "insecure", false, "Run without --key on purpose (will be required to do so in a future release)")
+	fs.BoolVar(&s.NoPromisc, "no-promisc", false, "Open the interface without promiscuous mode")
+	fs.StringVar(&s.LogLevel, "log", defaultLogLevel, "Log level: error|warn|info|debug|trace")
+	fs.StringVar(&s.logModule, "log-module", "", "Per-module log levels, e.g. capture=warn,bridge=trace (modules: "+strings.Join(logging.Modules, ", ")+")")
+	fs.StringVar(&s.LogTimeFormat, "log-timeformat", "default", "Log timestamp format: default|rfc3339|rfc3339nano or a Go time layout")
+	fs.BoolVar(&s.LogUTC, "log-utc", false, "Log timestamps in UTC instead of local time")
+	fs.StringVar(&s.color, "color", string(logging.ColorAuto), "Color log output: auto|always|never (auto honors NO_COLOR and FORCE_COLOR)")
+	fs.UintVar(&s.TraceSample, "trace-sample", 1, "At trace level, log 1 of every N frames")
+	fs.UintVar(&s.DumpFrames, "dump-frames", 0, "Hex-dump the first N captured and N received frames of each session")
+	fs.BoolVar(&s.BatchRecv, "batch-recv", false, "Read several packets per syscall (recvmmsg, Linux)")
+	fs.BoolVar(&s.BatchSend, "batch-send", false, "Send queued packets with one syscall (sendmmsg, Linux)")
+	fs.IntVar(&s.Workers, "workers", 1, "Goroutines each for encoding and decoding frames; frames stay in order")
+	fs.StringVar(&s.onOversize, "on-oversize", string(bridge.OversizeWarn), "Frames too big to send unfragmented: warn|fragment|drop")
+	fs.IntVar(&s.MaxFrame, "max-frame", protocol.MaxFrameSize, "Largest Ethernet frame to forward; both peers must match (jumbo frames: up to 9018)")
+	fs.BoolVar(&s.FrameCRC, "frame-crc", false, "Check a CRC-32 on every frame before injecting it, if the peer also sets --frame-crc")
+	fs.UintVar(&s.SocketBuffer, "socket-buffer", transport.DefaultReadBuffer, "UDP socket read/write buffer size in bytes")
+	fs.BoolVar(&s.DropOnCongestion, "drop-congested", false, "Drop packets instead of blocking when the send buffer is full")
+	fs.StringVar(&s.transport, "transport", "udp", "Transport protocol: udp|tcp (tcp for networks that block UDP)")
+	fs.DurationVar(&s.IdleTimeout, "idle-timeout", 0, "Shut down after no frames for this long, e.g. 30m (0 to disable)")
+	fs.DurationVar(&s.MaxDuration, "max-duration", 0, "Shut down once a session has run this long, e.g. 2h (0 to disable)")
+	fs.DurationVar(&s.CheckXbox, "check-xbox", 0, "At startup, warn if no frame arrives from the known Xbox MAC within this long (0 to skip)")
+	fs.DurationVar(&s.WaitProgress, "wait-progress", transport.DefaultWaitProgress, "While listening, log how long we've waited for a peer this often (0 to disable)")
+	fs.BoolVar(&s.WatchDiscovery, "watch-discovery", false, "Warn if another device sends System Link traffic during a session")
+	fs.BoolVar(&s.DetectLoops, "detect-loops", false, "Drop and warn about injected frames that come back through capture (network loop)")
+	fs.BoolVar(&s.EthernetIIOnly, "ethernet-ii-only", false, "Drop captured frames that aren't Ethernet II (802.3/LLC frames, capture glitches)")
+	fs.BoolVar(&s.ClockSkew, "clock-skew", false, "Exchange clocks in PONGs and warn if the peer's clock is off by more than a second")
+	fs.StringVar(&s.diag, "diag", string(bridge.DiagOff), "Run only one half of the bridge: off|capture|transport")
+	fs.UintVar(&s.statsInterval, "stats-interval", defaultStatsInterval, "Seconds between stats output (0 to disable)")
+	fs.StringVar(&s.statsFormat, "stats-format", defaultStatsFormat, "Stats output format: line|csv|table")
+	fs.StringVar(&s.EventsOutput, "events-output", "", "Write JSON Line events to: stdout, stderr, or a file path")
+	fs.StringVar(&s.eventsFilter, "events-filter", "", "Comma-separated event types to write, e.g. state_changed,error (default: all)")
+	fs.DurationVar(&s.EventsSync, "events-sync", events.DefaultSyncInterval, "How often to fsync a file --events-output (0 to sync every event)")
+	fs.StringVar(&s.HTTPAddr, "http-addr", "", "Serve /healthz and /stats.json on this address; a bare port like :8080 is localhost only (default: off)")
+	fs.StringVar(&s.HTTPToken, "http-token", "", "Require \"Authorization: Bearer <token>\" on --http-addr requests")
+	if mode == transport.ModeListen {
+		fs.StringVar(&s.allowFrom, "allow-from", "", "Comma-separated CIDRs/IPs allowed to connect (default: any)")
+	}
+	return s
+}
+
+// Resolve parses args, layers saved (which may be nil) and the environment
+// read through getenv under them and checks and parses every value. stdin
+// is where --address - reads the peer address. Errors name the flag at
+// fault.
+func (s *Settings) Resolve(args []string, saved *config.Config, getenv func(string) string, stdin io.Reader) error {
+	s.flags.Parse(args)
+
+	if saved == nil {
+		saved = &config.Config{}
+	}
+	s.Saved = saved
+	s.Effective = config.Resolve(s.flags, s.Saved, getenv)
+
+	var err error
+	if s.Diag, err = bridge.ParseDiagMode(s.diag); err != nil {
+		return fmt.Errorf("--diag: %w", err)
+	}
+
+	// Required flags
+	if s.Mode == transport.ModeConnect && s.address == "" {
+		return errors.New("--address is required")
+	}
+	if s.Interface == "" && s.Diag != bridge.DiagTransport {
+		return errInterfaceRequired
+	}
+	if s.Insecure && s.Key != "" {
+		return errors.New("--insecure and --key can't be used together")
+	}
+	if s.Insecure && s.RequireKey {
+		return errors.New("--insecure and --require-key can't be used together")
+	}
+	if s.HTTPToken != "" && s.HTTPAddr == "" {
+		return errors.New("--http-token needs --http-addr")
+	}
+	if s.KeyFormat, err = protocol.ParseKeyFormat(s.keyFormat); err != nil {
+		return fmt.Errorf("--key-format: %w", err)
+	}
+	if s.Key != "" {
+		if s.KeyBytes, err = protocol.DecodeKey(s.Key, s.KeyFormat); err != nil {
+			return fmt.Errorf("--key: %w", err)
+		}
+	}
+
+	if s.Mode == transport.ModeListen {
+		if s.Ports, err = transport.ParsePortList(resolvePortAliases(s.port)); err != nil {
+			return fmt.Errorf("--port: %w", err)
+		}
+		if s.AllowFrom, err = transport.ParseAllowList(s.allowFrom); err != nil {
+			return fmt.Errorf("--allow-from: %w", err)
+		}
+	} else {
+		// --address @file or - (stdin) names where to read the address
+		addr, err := transport.ReadPeerAddress(s.address, stdin)
+		if err != nil {
+			return fmt.Errorf("--address: %w", err)
+		}
+		if !strings.Contains(addr, ":") {
+			return errors.New("--address must be in IP:port format (e.g., 192.168.1.100:31415)")
+		}
+		s.PeerAddr = resolveAddressPortAlias(addr)
+		localPort, err := strconv.ParseUint(resolvePortAliases(s.port), 10, 16)
+		if err != nil {
+			return fmt.Errorf("--port: invalid port %q (must be 0-65535 or a name like https)", s.port)
+		}
+		s.Ports = []uint16{uint16(localPort)}
+	}
+
+	if s.StatsFormat, err = bridge.ParseStatsFormat(s.statsFormat); err != nil {
+		return fmt.Errorf("--stats-format: %w", err)
+	}
+	s.StatsInterval = time.Duration(s.statsInterval) * time.Second
+	if s.Oversize, err = bridge.ParseOversizePolicy(s.onOversize); err != nil {
+		return fmt.Errorf("--on-oversize: %w", err)
+	}
+	if s.Backend, err = transport.ParseBackend(s.transport); err != nil {
+		return fmt.Errorf("--transport: %w", err)
+	}
+	if s.EventTypes, err = events.ParseEventTypes(s.eventsFilter); err != nil {
+		return fmt.Errorf("--events-filter: %w", err)
+	}
+	if s.ColorMode, err = logging.ParseColorMode(s.color); err != nil {
+		return fmt.Errorf("--color: %w", err)
+	}
+	if s.ModuleLevels, err = logging.ParseModuleLevels(s.logModule); err != nil {
+		return fmt.Errorf("--log-module: %w", err)
+	}
+	return nil
+}
+
+// Source reports where the value of the flag called name came from.
+func (s *Settings) Source(name string) config.Source {
+	setting, _ := s.Effective.Get(name)
+	return setting.Source
+}
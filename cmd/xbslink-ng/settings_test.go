@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/xbslink/xbslink-ng/internal/config"
+	"github.com/xbslink/xbslink-ng/internal/logging"
+	"github.com/xbslink/xbslink-ng/internal/transport"
+)
+
+// resolve resolves args for mode with saved, env as the environment and
+// stdin as stdin.
+func resolve(mode transport.Mode, args []string, saved *config.Config, env map[string]string, stdin string) (*Settings, error) {
+	s := newSettings(mode)
+	err := s.Resolve(args, saved, func(name string) string { return env[name] }, strings.NewReader(stdin))
+	return s, err
+}
+
+func TestSettingsResolve_Precedence(t *testing.T) {
+	saved := &config.Config{LastXboxMAC: "00:50:F2:1A:2B:3C"}
+	noColor := map[string]string{"NO_COLOR": "1"}
+
+	tests := []struct {
+		name      string
+		args      []string
+		saved     *config.Config
+		env       map[string]string
+		wantMAC   string
+		wantColor logging.ColorMode
+		macFrom   config.Source
+		colorFrom config.Source
+	}{
+		{
+			name:      "defaults",
+			wantMAC:   "",
+			wantColor: logging.ColorAuto,
+			macFrom:   config.SourceDefault,
+			colorFrom: config.SourceDefault,
+		},
+		{
+			name:      "config over default",
+			saved:     saved,
+			wantMAC:   "00:50:f2:1a:2b:3c",
+			wantColor: logging.ColorAuto,
+			macFrom:   config.SourceConfig,
+			colorFrom: config.SourceDefault,
+		},
+		{
+			name:      "env over default",
+			saved:     saved,
+			env:       noColor,
+			wantMAC:   "00:50:f2:1a:2b:3c",
+			wantColor: logging.ColorNever,
+			macFrom:   config.SourceConfig,
+			colorFrom: config.SourceEnv,
+		},
+		{
+			name:      "flag over config and env",
+			args:      []string{"--xbox-mac", "00:50:F2:00:00:01", "--color", "always"},
+			saved:     saved,
+			env:       noColor,
+			wantMAC:   "00:50:F2:00:00:01",
+			wantColor: logging.ColorAlways,
+			macFrom:   config.SourceFlag,
+			colorFrom: config.SourceFlag,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--interface", "eth0", "--insecure"}, tt.args...)
+			s, err := resolve(transport.ModeListen, args, tt.saved, tt.env, "")
+			if err != nil {
+				t.Fatalf("Resolve() failed: %v", err)
+			}
+			if s.XboxMAC != tt.wantMAC || s.Source("xbox-mac") != tt.macFrom {
+				t.Errorf("xbox-mac = %q (%s), want %q (%s)", s.XboxMAC, s.Source("xbox-mac"), tt.wantMAC, tt.macFrom)
+			}
+			if s.ColorMode != tt.wantColor || s.Source("color") != tt.colorFrom {
+				t.Errorf("color = %q (%s), want %q (%s)", s.ColorMode, s.Source("color"), tt.wantColor, tt.colorFrom)
+			}
+			if s.Saved == nil {
+				t.Error("Saved is nil")
+			}
+		})
+	}
+}
+
+func TestSettingsResolve_Parses(t *testing.T) {
+	s, err := resolve(transport.ModeListen, []string{"--interface", "eth0", "--insecure", "--port", "31415,https,dns"}, nil, nil, "")
+	if err != nil {
+		t.Fatalf("listen: Resolve() failed: %v", err)
+	}
+	if got := s.Ports; len(got) != 3 || got[0] != 31415 || got[1] != 443 || got[2] != 53 {
+		t.Errorf("listen: Ports = %v, want [31415 443 53]", got)
+	}
+
+	s, err = resolve(transport.ModeConnect, []string{"--interface", "eth0", "--insecure", "--address", "192.0.2.1:https", "--port", "ntp"}, nil, nil, "")
+	if err != nil {
+		t.Fatalf("connect: Resolve() failed: %v", err)
+	}
+	if s.PeerAddr != "192.0.2.1:443" {
+		t.Errorf("connect: PeerAddr = %q, want 192.0.2.1:443", s.PeerAddr)
+	}
+	if len(s.Ports) != 1 || s.Ports[0] != 123 {
+		t.Errorf("connect: Ports = %v, want [123]", s.Ports)
+	}
+
+	// --address - reads the address from stdin
+	s, err = resolve(transport.ModeConnect, []string{"--interface", "eth0", "--insecure", "--address", "-"}, nil, nil, "# peer\n192.0.2.7:31415\n")
+	if err != nil {
+		t.Fatalf("--address -: Resolve() failed: %v", err)
+	}
+	if s.PeerAddr != "192.0.2.7:31415" {
+		t.Errorf("--address -: PeerAddr = %q, want 192.0.2.7:31415", s.PeerAddr)
+	}
+
+	s, err = resolve(transport.ModeListen, []string{"--interface", "eth0", "--key", "00112233445566778899aabbccddeeff", "--key-format", "hex"}, nil, nil, "")
+	if err != nil {
+		t.Fatalf("hex key: Resolve() failed: %v", err)
+	}
+	if len(s.KeyBytes) != 16 {
+		t.Errorf("hex key: KeyBytes has %d bytes, want 16", len(s.KeyBytes))
+	}
+}
+
+func TestSettingsResolve_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    transport.Mode
+		args    []string
+		wantErr string
+	}{
+		{"no interface", transport.ModeListen, nil, "--interface is required"},
+		{"no address", transport.ModeConnect, []string{"--interface", "eth0"}, "--address is required"},
+		{"insecure with key", transport.ModeListen, []string{"--interface", "eth0", "--insecure", "--key", "secret"}, "--insecure and --key"},
+		{"insecure with require-key", transport.ModeListen, []string{"--interface", "eth0", "--insecure", "--require-key"}, "--insecure and --require-key"},
+		{"http-token without http-addr", transport.ModeListen, []string{"--interface", "eth0", "--http-token", "t"}, "--http-token needs --http-addr"},
+		{"unknown key format", transport.ModeListen, []string{"--interface", "eth0", "--key-format", "b64"}, "--key-format"},
+		{"bad hex key", transport.ModeListen, []string{"--interface", "eth0", "--key", "not hex", "--key-format", "hex"}, "--key: "},
+		{"short hex key", transport.ModeListen, []string{"--interface", "eth0", "--key", "0011", "--key-format", "hex"}, "--key: "},
+		{"listen port out of range", transport.ModeListen, []string{"--interface", "eth0", "--port", "70000"}, "--port"},
+		{"unknown port alias", transport.ModeListen, []string{"--interface", "eth0", "--port", "gopher"}, "--port"},
+		{"connect port out of range", transport.ModeConnect, []string{"--interface", "eth0", "--address", "192.0.2.1:31415", "--port", "70000"}, "--port"},
+		{"connect unknown port alias", transport.ModeConnect, []string{"--interface", "eth0", "--address", "192.0.2.1:31415", "--port", "gopher"}, "--port"},
+		{"address without port", transport.ModeConnect, []string{"--interface", "eth0", "--address", "192.0.2.1"}, "IP:port"},
+		{"empty stdin address", transport.ModeConnect, []string{"--interface", "eth0", "--address", "-"}, "--address"},
+		{"bad allow-from", transport.ModeListen, []string{"--interface", "eth0", "--allow-from", "not-a-cidr"}, "--allow-from"},
+		{"bad diag", transport.ModeListen, []string{"--interface", "eth0", "--diag", "both"}, "--diag"},
+		{"bad stats-format", transport.ModeListen, []string{"--interface", "eth0", "--stats-format", "xml"}, "--stats-format"},
+		{"bad on-oversize", transport.ModeListen, []string{"--interface", "eth0", "--on-oversize", "truncate"}, "--on-oversize"},
+		{"bad transport", transport.ModeListen, []string{"--interface", "eth0", "--transport", "sctp"}, "--transport"},
+		{"bad color", transport.ModeListen, []string{"--interface", "eth0", "--color", "sometimes"}, "--color"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolve(tt.mode, tt.args, nil, nil, "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Resolve() = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A missing --interface is recognizable, for the hint to run interfaces
+	if _, err := resolve(transport.ModeListen, nil, nil, nil, ""); !errors.Is(err, errInterfaceRequired) {
+		t.Errorf("Resolve() without --interface = %v, want errInterfaceRequired", err)
+	}
+	// --diag transport needs no interface
+	if _, err := resolve(transport.ModeListen, []string{"--diag", "transport", "--insecure"}, nil, nil, ""); err != nil {
+		t.Errorf("Resolve() with --diag transport and no --interface = %v", err)
+	}
+}
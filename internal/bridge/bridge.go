@@ -2,15 +2,20 @@
 package bridge
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"golang.org/x/term"
+
 	"github.com/xbslink/xbslink-ng/internal/capture"
 	"github.com/xbslink/xbslink-ng/internal/events"
 	"github.com/xbslink/xbslink-ng/internal/logging"
@@ -22,6 +27,27 @@ import (
 // This error signals that reconnection should be attempted.
 var ErrPeerDisconnected = errors.New("peer disconnected")
 
+// ErrIdleTimeout indicates the session was shut down because no frames
+// crossed for Config.IdleTimeout. It should not trigger a reconnect.
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// ErrMaxDuration indicates the session was shut down because it reached
+// Config.MaxDuration. It should not trigger a reconnect.
+var ErrMaxDuration = errors.New("maximum session duration reached")
+
+// ErrCaptureFailed indicates the session was shut down because packet
+// capture failed CaptureErrorLimit times in a row (e.g. the interface went
+// away). It should not trigger a reconnect.
+var ErrCaptureFailed = errors.New("packet capture failed repeatedly")
+
+// ErrCaptureNotReady is returned by InjectFrame before the bridge has a
+// capture to inject on (see SetCapture).
+var ErrCaptureNotReady = errors.New("capture not ready")
+
+// ErrInjectQueueFull is returned by InjectFrame when the inject queue has no
+// room for the frame.
+var ErrInjectQueueFull = errors.New("inject queue full")
+
 // Configuration constants.
 const (
 	// PingInterval is how often to send ping messages.
@@ -30,12 +56,33 @@ const (
 	PongTimeout = 2 * time.Second
 	// MaxMissedPongs is the number of missed pongs before disconnect.
 	MaxMissedPongs = 3
+	// RTTGoodThreshold is the RTT up to which latency is shown as good (green).
+	// Between this and RTTAlertThreshold it is shown as marginal (yellow).
+	RTTGoodThreshold = 20 * time.Millisecond
 	// RTTAlertThreshold is the RTT above which we warn users.
 	RTTAlertThreshold = 30 * time.Millisecond
 	// RTTSpikeThreshold is the percentage increase to trigger a spike warning.
 	RTTSpikeThreshold = 0.5 // 50%
 	// ChannelBufferSize is the buffer size for internal channels.
 	ChannelBufferSize = 256
+	// IdleCheckInterval is how often the idle timeout and maximum session
+	// duration are checked (or the limit itself, if shorter).
+	IdleCheckInterval = 5 * time.Second
+	// CaptureRetryMin and CaptureRetryMax bound the exponential backoff
+	// between reads after consecutive capture errors.
+	CaptureRetryMin = 10 * time.Millisecond
+	CaptureRetryMax = 2 * time.Second
+	// CaptureErrorSummaryInterval is how often capture errors are summarized
+	// when periodic stats are disabled; otherwise the stats interval is used.
+	CaptureErrorSummaryInterval = 30 * time.Second
+	// CaptureErrorLimit is the number of consecutive capture errors after
+	// which the session is shut down with ErrCaptureFailed.
+	CaptureErrorLimit = 10
+	// InjectRetryLimit is how many times an injection that failed with a
+	// transient error (see capture.IsTransientWriteError) is retried before
+	// the frame is dropped, waiting InjectRetryDelay, then twice that, ...
+	InjectRetryLimit = 2
+	InjectRetryDelay = 200 * time.Microsecond
 )
 
 // State represents the bridge connection state.
@@ -62,18 +109,98 @@ func (s State) String() string {
 
 // Stats holds bridge statistics.
 type Stats struct {
-	TxPackets  uint64
-	TxBytes    uint64
-	RxPackets  uint64
-	RxBytes    uint64
+	TxPackets    uint64
+	TxBytes      uint64
+	RxPackets    uint64
+	RxBytes      uint64
+	TxDropped    uint64 // Captured frames dropped because the send queue was full
+	RxDropped    uint64 // Received frames dropped because the inject queue was full
+	TxCongested  uint64 // Frames not sent because the socket send buffer was full
+	LoopedFrames uint64 // Captured frames dropped as copies of frames we just injected
+
+	TxOversizeDropped uint64 // Frames dropped by OversizeDrop as too large to send unfragmented
+	NonEthernetII     uint64 // Captured frames dropped by EthernetIIOnly as not Ethernet II
+	InjectRetries     uint64 // Injections retried after a transient error
+	InjectDrops       uint64 // Received frames dropped because injecting them failed
+	PingsSent         uint64 // PINGs sent this session
+	PongsLost         uint64 // PINGs never answered before the next one was due
+	Filtered          uint64 // Frames dropped by middleware (Bridge.Use), either way
+
+	// When a frame was last sent / received, in Unix nanoseconds (0 if
+	// never). Accessed atomically, so kept with the counters for 64-bit
+	// alignment; use LastTx and LastRx.
+	LastTxUnixNano int64
+	LastRxUnixNano int64
+
 	RTTCurrent time.Duration
 	RTTAvg     time.Duration
+	RTTMin     time.Duration
+	RTTMax     time.Duration
+	Jitter     time.Duration // Smoothed RTT variation between samples (RFC 3550 style)
+	StartTime  time.Time     // When the session reached StateConnected (zero if never)
+
+	// Latest one-way delays to and from the peer (Config.ClockSkew; zero if
+	// unknown), guarded by rttMu like the RTT figures
+	UpDelay   time.Duration
+	DownDelay time.Duration
+
+	// Frames sent / received by EtherType; see EtherTypes
+	txEtherTypes etherTypeCounters
+	rxEtherTypes etherTypeCounters
 
 	// Internal tracking
 	rttSamples []time.Duration
 	rttSum     time.Duration
+	rttTotal   time.Duration // Sum of all samples this session
+	rttCount   uint64        // Number of samples this session
 	lastRTT    time.Duration
 	rttMu      sync.RWMutex
+	startMu    sync.RWMutex // protects StartTime
+}
+
+// Snapshot returns a copy of the counters, RTT figures and StartTime, safe
+// to keep and read without synchronization. EtherType counts are left out.
+func (s *Stats) Snapshot() *Stats {
+	s.rttMu.RLock()
+	rttCurrent, rttAvg, rttMin, rttMax, jitter := s.RTTCurrent, s.RTTAvg, s.RTTMin, s.RTTMax, s.Jitter
+	up, down := s.UpDelay, s.DownDelay
+	s.rttMu.RUnlock()
+	s.startMu.RLock()
+	start := s.StartTime
+	s.startMu.RUnlock()
+
+	return &Stats{
+		TxPackets:         atomic.LoadUint64(&s.TxPackets),
+		TxBytes:           atomic.LoadUint64(&s.TxBytes),
+		RxPackets:         atomic.LoadUint64(&s.RxPackets),
+		RxBytes:           atomic.LoadUint64(&s.RxBytes),
+		TxDropped:         atomic.LoadUint64(&s.TxDropped),
+		RxDropped:         atomic.LoadUint64(&s.RxDropped),
+		TxCongested:       atomic.LoadUint64(&s.TxCongested),
+		LoopedFrames:      atomic.LoadUint64(&s.LoopedFrames),
+		TxOversizeDropped: atomic.LoadUint64(&s.TxOversizeDropped),
+		NonEthernetII:     atomic.LoadUint64(&s.NonEthernetII),
+		InjectRetries:     atomic.LoadUint64(&s.InjectRetries),
+		InjectDrops:       atomic.LoadUint64(&s.InjectDrops),
+		PingsSent:         atomic.LoadUint64(&s.PingsSent),
+		PongsLost:         atomic.LoadUint64(&s.PongsLost),
+		Filtered:          atomic.LoadUint64(&s.Filtered),
+		LastTxUnixNano:    atomic.LoadInt64(&s.LastTxUnixNano),
+		LastRxUnixNano:    atomic.LoadInt64(&s.LastRxUnixNano),
+		RTTCurrent:        rttCurrent,
+		RTTAvg:            rttAvg,
+		RTTMin:            rttMin,
+		RTTMax:            rttMax,
+		Jitter:            jitter,
+		UpDelay:           up,
+		DownDelay:         down,
+		StartTime:         start,
+	}
+}
+
+// EtherTypes returns the sent and received frame counts by EtherType.
+func (s *Stats) EtherTypes() (tx, rx EtherTypeCounts) {
+	return s.txEtherTypes.load(), s.rxEtherTypes.load()
 }
 
 // AddRTTSample adds a new RTT sample.
@@ -81,10 +208,26 @@ func (s *Stats) AddRTTSample(rtt time.Duration) {
 	s.rttMu.Lock()
 	defer s.rttMu.Unlock()
 
+	if s.rttCount > 0 {
+		d := rtt - s.RTTCurrent
+		if d < 0 {
+			d = -d
+		}
+		s.Jitter += (d - s.Jitter) / 16
+	}
 	s.RTTCurrent = rtt
 	s.rttSamples = append(s.rttSamples, rtt)
 	s.rttSum += rtt
 
+	s.rttTotal += rtt
+	s.rttCount++
+	if s.RTTMin == 0 || rtt < s.RTTMin {
+		s.RTTMin = rtt
+	}
+	if rtt > s.RTTMax {
+		s.RTTMax = rtt
+	}
+
 	// Keep only last 20 samples for averaging
 	if len(s.rttSamples) > 20 {
 		s.rttSum -= s.rttSamples[0]
@@ -120,6 +263,28 @@ func (s *Stats) SetLastRTT(rtt time.Duration) {
 	s.lastRTT = rtt
 }
 
+// SetOneWayDelays records the latest one-way delays to (up) and from (down)
+// the peer.
+func (s *Stats) SetOneWayDelays(up, down time.Duration) {
+	s.rttMu.Lock()
+	defer s.rttMu.Unlock()
+	s.UpDelay, s.DownDelay = up, down
+}
+
+// GetOneWayDelays returns the latest one-way delays, zero if unknown.
+func (s *Stats) GetOneWayDelays() (up, down time.Duration) {
+	s.rttMu.RLock()
+	defer s.rttMu.RUnlock()
+	return s.UpDelay, s.DownDelay
+}
+
+// GetJitter returns the smoothed RTT variation.
+func (s *Stats) GetJitter() time.Duration {
+	s.rttMu.RLock()
+	defer s.rttMu.RUnlock()
+	return s.Jitter
+}
+
 // GetRTTCurrent returns the current RTT.
 func (s *Stats) GetRTTCurrent() time.Duration {
 	s.rttMu.RLock()
@@ -127,25 +292,165 @@ func (s *Stats) GetRTTCurrent() time.Duration {
 	return s.RTTCurrent
 }
 
+// SetStartTime marks the start of the session.
+func (s *Stats) SetStartTime(t time.Time) {
+	s.startMu.Lock()
+	defer s.startMu.Unlock()
+	s.StartTime = t
+}
+
+// Uptime returns how long the session has been connected, or 0 if it never was.
+func (s *Stats) Uptime() time.Duration {
+	s.startMu.RLock()
+	defer s.startMu.RUnlock()
+	if s.StartTime.IsZero() {
+		return 0
+	}
+	return time.Since(s.StartTime)
+}
+
+// MarkTx records that a frame was sent at t.
+func (s *Stats) MarkTx(t time.Time) {
+	atomic.StoreInt64(&s.LastTxUnixNano, t.UnixNano())
+}
+
+// MarkRx records that a frame was received at t.
+func (s *Stats) MarkRx(t time.Time) {
+	atomic.StoreInt64(&s.LastRxUnixNano, t.UnixNano())
+}
+
+// LastTx returns when a frame was last sent, or the zero time if none was.
+func (s *Stats) LastTx() time.Time {
+	return unixNanoTime(atomic.LoadInt64(&s.LastTxUnixNano))
+}
+
+// LastRx returns when a frame was last received, or the zero time if none was.
+func (s *Stats) LastRx() time.Time {
+	return unixNanoTime(atomic.LoadInt64(&s.LastRxUnixNano))
+}
+
+// LastFrameActivity returns when a frame last crossed in either direction,
+// or the zero time if none did.
+func (s *Stats) LastFrameActivity() time.Time {
+	tx, rx := s.LastTx(), s.LastRx()
+	if rx.After(tx) {
+		return rx
+	}
+	return tx
+}
+
+// unixNanoTime converts a Unix nanosecond timestamp to a time.Time, mapping
+// 0 to the zero time.
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// RTTSummary returns the average, minimum, and maximum RTT over the whole
+// session (RTTAvg only covers the recent sliding window).
+func (s *Stats) RTTSummary() (avg, min, max time.Duration) {
+	s.rttMu.RLock()
+	defer s.rttMu.RUnlock()
+
+	if s.rttCount > 0 {
+		avg = s.rttTotal / time.Duration(s.rttCount)
+	}
+	return avg, s.RTTMin, s.RTTMax
+}
+
 // Bridge coordinates all components for the xbslink-ng tunnel.
 type Bridge struct {
 	capture   *capture.Capture
 	captureMu sync.RWMutex // protects capture field
-	transport *transport.Transport
+	transport transport.Conn
 	codec     *protocol.Codec
 	logger    *logging.Logger
 	emitter   events.Emitter
 	stats     *Stats
 
-	mode          transport.Mode
-	statsInterval time.Duration
+	// Config callbacks; no-ops if not set
+	onConnected    func(peer net.Addr)
+	onDisconnected func(reason string)
+	onStats        func(*Stats)
+
+	mode           transport.Mode
+	statsInterval  time.Duration
+	statsFormatter *StatsFormatter
+
+	batchRecv bool // read several datagrams per syscall in recvLoop
+	batchSend bool // send queued frames with one syscall in sendLoop
+
+	idleTimeout time.Duration    // 0 = never shut down for inactivity
+	maxDuration time.Duration    // 0 = no limit on session length
+	now         func() time.Time // clock for frame activity
+	connectedAt time.Time        // per now, when the session connected
+
+	// Closed by stopSession when the bridge ends the session itself (idle
+	// timeout, maximum duration, capture failure); Run then returns stopErr.
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopErr  error // guarded by stateMu
+
+	// Backoff bounds after capture errors (CaptureRetryMin/Max; tests shorten them)
+	captureRetryMin time.Duration
+	captureRetryMax time.Duration
+
+	// First wait before retrying an injection (InjectRetryDelay; tests shorten it)
+	injectRetryDelay time.Duration
+
+	// Capture errors pending the next summary line
+	captureErrors *errorAggregator
+
+	// Trace logging samplers for captured and received frames
+	traceCaptured *traceSampler
+	traceReceived *traceSampler
+
+	// Hex dumps left for captured and received frames (--dump-frames)
+	dumpCaptured *frameDumper
+	dumpReceived *frameDumper
+
+	// Goroutines encoding/decoding frames (Config.Workers; 1 = in the loops)
+	workers int
 
-	state   State
-	stateMu sync.RWMutex
+	// Injected-frame fingerprints for loop detection (nil = disabled)
+	loops       *loopDetector
+	loopWarning sync.Once
 
-	// Channels for goroutine communication
-	framesToSend   chan []byte
-	framesToInject chan []byte
+	ethernetIIOnly bool // drop captured frames that aren't Ethernet II
+
+	diag DiagMode // run only one half of the bridge (Config.Diag)
+
+	// Clock skew estimation (Config.ClockSkew)
+	clockSkewCheck   bool
+	clockSkew        atomic.Int64 // latest estimate, in nanoseconds
+	clockSkewKnown   atomic.Bool
+	clockSkewWarning sync.Once
+
+	// What to do with frames too large to send unfragmented (Config.OversizePolicy)
+	oversizePolicy OversizePolicy
+	oversizeNotice sync.Once
+
+	// Frame middleware added with Use, fixed once Run starts
+	middleware []Middleware
+
+	// Source MACs of frames received from the peer (the remote consoles).
+	// lastRemoteMAC is only touched by the receive loop and skips the map
+	// lookup while the source doesn't change.
+	remoteMACs    sync.Map // [6]byte -> struct{}
+	lastRemoteMAC [6]byte
+
+	state      State
+	stopReason string // why the session ended, for the DISCONNECTED event
+	stateMu    sync.RWMutex
+
+	// Channels for goroutine communication.
+	// Both carry pooled buffers: the receiving loop returns each one to
+	// framePool once the frame has been sent/injected (or dropped).
+	framesToSend   chan *[]byte
+	framesToInject chan *[]byte
+	controlToSend  chan controlMsg // PINGs and PONGs for the send loop
 	done           chan struct{}
 	doneOnce       sync.Once // ensures done is closed only once
 
@@ -154,7 +459,8 @@ type Bridge struct {
 	missedPongs int32 // counter for missed pongs
 	pingMu      sync.Mutex
 
-	// For stdin monitoring
+	// For stdin monitoring; stdin is only read when it is a terminal
+	stdin   *os.File
 	stdinCh chan struct{}
 
 	// For capture lifecycle management
@@ -164,17 +470,89 @@ type Bridge struct {
 // Config holds bridge configuration.
 type Config struct {
 	Capture       *capture.Capture // Optional: can be nil and set later via SetCapture()
-	Transport     *transport.Transport
+	Transport     transport.Conn
 	Codec         *protocol.Codec
 	Logger        *logging.Logger
 	Emitter       events.Emitter // Optional: nil defaults to NopEmitter
 	Mode          transport.Mode
 	StatsInterval time.Duration // 0 to disable periodic stats
+	// StatsFormatter renders periodic stats. Optional: nil uses StatsFormatLine.
+	// Pass the same formatter to each reconnect's bridge so headers print once.
+	StatsFormatter *StatsFormatter
+	// TraceSample logs 1 of every N frames at trace level (0 or 1 = every frame).
+	TraceSample uint
+	// DumpFrames hex-dumps the first N captured and the first N received
+	// frames of the session at info level. 0 disables it.
+	DumpFrames uint
+	// DetectLoops remembers injected frames and drops (and warns about)
+	// identical frames captured within LoopWindow, which means the network
+	// is looping injected traffic back to the capture interface.
+	DetectLoops bool
+	// EthernetIIOnly drops captured frames whose EtherType field is below
+	// capture.MinEtherType (802.3 length-field frames such as LLC/STP, and
+	// capture glitches), counting them in Stats.NonEthernetII. System Link
+	// traffic is always Ethernet II.
+	EthernetIIOnly bool
+	// ClockSkew sends our clock in PONGs and, from PONGs carrying the
+	// peer's clock, estimates how far apart the two clocks are, warning if
+	// it is more than ClockSkewWarnThreshold. The peer needs it too for us
+	// to get estimates. Informational only; RTT never depends on it.
+	ClockSkew bool
+	// Workers spreads frame encoding and decoding (HMAC signing and
+	// verification in secure mode) over this many goroutines each way,
+	// keeping frames in order. 0 or 1 keeps it in the send and receive
+	// loops. Takes precedence over BatchSend and BatchRecv.
+	Workers int
+	// OversizePolicy handles captured frames whose encoded message exceeds
+	// MaxUnfragmentedDatagram. Optional: "" is OversizeWarn.
+	OversizePolicy OversizePolicy
+	// Diag runs only the capture or only the transport half of the bridge;
+	// see DiagMode. Transport may be nil under DiagCapture.
+	Diag DiagMode
+	// BatchRecv reads several datagrams per syscall (recvmmsg on Linux).
+	// Ignored where transport.BatchSupported reports false or the
+	// transport is not a transport.BatchConn.
+	BatchRecv bool
+	// BatchSend sends all queued frames in one syscall (sendmmsg on Linux).
+	// Ignored where transport.BatchSupported reports false or the
+	// transport is not a transport.BatchConn.
+	BatchSend bool
+	// IdleTimeout ends the session once no frames have been sent or
+	// received for this long; pings don't count. Run then sends BYE and
+	// returns ErrIdleTimeout. 0 disables it.
+	IdleTimeout time.Duration
+	// MaxDuration ends the session this long after it connected, however
+	// busy it is. Run then sends BYE and returns ErrMaxDuration. 0 disables it.
+	MaxDuration time.Duration
+	// Now is the clock used for idle tracking and MaxDuration. Optional: nil
+	// uses time.Now.
+	Now func() time.Time
+
+	// Optional callbacks for embedding the bridge, called alongside the
+	// matching events without going through an Emitter. They run on the
+	// bridge's goroutines, so they must not block.
+	//
+	// OnConnected is called when the session is established, with the
+	// peer's address.
+	OnConnected func(peer net.Addr)
+	// OnDisconnected is called when the session ends, with the reason from
+	// the DISCONNECTED event (e.g. events.ReasonIdleTimeout); it is empty
+	// when the peer left or Run's context was cancelled.
+	OnDisconnected func(reason string)
+	// OnStats is called with a Stats.Snapshot every StatsInterval (and when
+	// Enter is pressed), and once more with the totals when the session ends.
+	OnStats func(*Stats)
+}
+
+// supportsBatch reports whether conn can be used for batched socket I/O.
+func supportsBatch(conn transport.Conn) bool {
+	_, ok := conn.(transport.BatchConn)
+	return ok && transport.BatchSupported()
 }
 
 // New creates a new Bridge instance.
 func New(cfg Config) (*Bridge, error) {
-	if cfg.Transport == nil {
+	if cfg.Transport == nil && cfg.Diag != DiagCapture {
 		return nil, fmt.Errorf("transport is required")
 	}
 	if cfg.Codec == nil {
@@ -189,22 +567,79 @@ func New(cfg Config) (*Bridge, error) {
 		emitter = events.NopEmitter{}
 	}
 
+	statsFormatter := cfg.StatsFormatter
+	if statsFormatter == nil {
+		statsFormatter = NewStatsFormatter(StatsFormatLine)
+	}
+
+	now := cfg.Now
+	if now == nil {
+		now = time.Now
+	}
+
+	onConnected := cfg.OnConnected
+	if onConnected == nil {
+		onConnected = func(net.Addr) {}
+	}
+	onDisconnected := cfg.OnDisconnected
+	if onDisconnected == nil {
+		onDisconnected = func(string) {}
+	}
+	onStats := cfg.OnStats
+	if onStats == nil {
+		onStats = func(*Stats) {}
+	}
+
 	b := &Bridge{
-		capture:        cfg.Capture,
-		transport:      cfg.Transport,
-		codec:          cfg.Codec,
-		logger:         cfg.Logger,
-		emitter:        emitter,
-		stats:          &Stats{},
-		mode:           cfg.Mode,
-		statsInterval:  cfg.StatsInterval,
-		state:          StateDisconnected,
-		framesToSend:   make(chan []byte, ChannelBufferSize),
-		framesToInject: make(chan []byte, ChannelBufferSize),
-		done:           make(chan struct{}),
-		stdinCh:        make(chan struct{}),
-		captureReady:   make(chan struct{}),
+		capture:         cfg.Capture,
+		transport:       cfg.Transport,
+		codec:           cfg.Codec,
+		logger:          cfg.Logger,
+		emitter:         emitter,
+		stats:           &Stats{},
+		onConnected:     onConnected,
+		onDisconnected:  onDisconnected,
+		onStats:         onStats,
+		mode:            cfg.Mode,
+		statsInterval:   cfg.StatsInterval,
+		statsFormatter:  statsFormatter,
+		batchRecv:       cfg.BatchRecv && supportsBatch(cfg.Transport),
+		batchSend:       cfg.BatchSend && supportsBatch(cfg.Transport),
+		idleTimeout:     cfg.IdleTimeout,
+		maxDuration:     cfg.MaxDuration,
+		now:             now,
+		stop:            make(chan struct{}),
+		captureRetryMin: CaptureRetryMin,
+		captureRetryMax: CaptureRetryMax,
+		traceCaptured:   newTraceSampler(cfg.TraceSample),
+		traceReceived:   newTraceSampler(cfg.TraceSample),
+		dumpCaptured:    newFrameDumper(cfg.DumpFrames),
+		dumpReceived:    newFrameDumper(cfg.DumpFrames),
+		state:           StateDisconnected,
+		framesToSend:    make(chan *[]byte, ChannelBufferSize),
+		framesToInject:  make(chan *[]byte, ChannelBufferSize),
+		controlToSend:   make(chan controlMsg, controlQueueSize),
+		done:            make(chan struct{}),
+		stdin:           os.Stdin,
+		stdinCh:         make(chan struct{}),
+		captureReady:    make(chan struct{}),
+	}
+
+	if cfg.DetectLoops {
+		b.loops = newLoopDetector(LoopWindow)
+	}
+	b.ethernetIIOnly = cfg.EthernetIIOnly
+	b.clockSkewCheck = cfg.ClockSkew
+	b.diag = cfg.Diag
+	b.injectRetryDelay = InjectRetryDelay
+	b.workers = max(cfg.Workers, 1)
+	b.oversizePolicy = cfg.OversizePolicy
+
+	summaryInterval := CaptureErrorSummaryInterval
+	if cfg.StatsInterval > 0 {
+		summaryInterval = cfg.StatsInterval
 	}
+	b.captureErrors = newErrorAggregator("capture error", summaryInterval, now())
 
 	// If capture is provided initially, mark it as ready
 	if cfg.Capture != nil {
@@ -231,6 +666,28 @@ func (b *Bridge) SetCapture(cap *capture.Capture) error {
 	return nil
 }
 
+// XboxMAC returns the MAC of the local Xbox being bridged, or nil before
+// capture is set.
+func (b *Bridge) XboxMAC() net.HardwareAddr {
+	b.captureMu.RLock()
+	defer b.captureMu.RUnlock()
+	if b.capture == nil {
+		return nil
+	}
+	return b.capture.XboxMAC()
+}
+
+// IsRemoteMAC reports whether mac has been the source of a frame received
+// from the peer, i.e. belongs to a console on the far side of the bridge.
+func (b *Bridge) IsRemoteMAC(mac net.HardwareAddr) bool {
+	var key [6]byte
+	if copy(key[:], mac) != len(key) {
+		return false
+	}
+	_, ok := b.remoteMACs.Load(key)
+	return ok
+}
+
 // HasCapture returns true if capture is set.
 func (b *Bridge) HasCapture() bool {
 	b.captureMu.RLock()
@@ -238,9 +695,40 @@ func (b *Bridge) HasCapture() bool {
 	return b.capture != nil
 }
 
+// InjectFrame queues an Ethernet frame for injection on the capture
+// interface, as if it had been received from the peer, for tools and tests
+// that drive the bridge without one. The frame is copied, and must be
+// between protocol.MinEthernetFrame and the codec's MaxFrameSize bytes. It is
+// not counted in the RX stats. Returns ErrCaptureNotReady before SetCapture
+// and ErrInjectQueueFull if the frame can't be queued without blocking.
+func (b *Bridge) InjectFrame(frame []byte) error {
+	if len(frame) < protocol.MinEthernetFrame || len(frame) > b.codec.MaxFrameSize() {
+		return fmt.Errorf("frame size %d out of range [%d, %d]", len(frame), protocol.MinEthernetFrame, b.codec.MaxFrameSize())
+	}
+	select {
+	case <-b.captureReady:
+	default:
+		return ErrCaptureNotReady
+	}
+
+	bufp := getFrameBuf()
+	*bufp = (*bufp)[:copy(*bufp, frame)]
+	select {
+	case b.framesToInject <- bufp:
+		return nil
+	default:
+		putFrameBuf(bufp)
+		return ErrInjectQueueFull
+	}
+}
+
 // Run starts the bridge and blocks until shutdown.
 // The provided context controls the bridge lifetime - when cancelled, the bridge shuts down.
 func (b *Bridge) Run(ctx context.Context) error {
+	if b.diag == DiagCapture {
+		return b.runCaptureDiag(ctx)
+	}
+
 	// Establish connection based on mode
 	b.setState(StateConnecting)
 
@@ -259,44 +747,57 @@ func (b *Bridge) Run(ctx context.Context) error {
 	}
 
 	b.setState(StateConnected)
-	b.logger.Info("Bridge active! Forwarding packets...")
+	b.useSessionLogger()
+	if b.diag == DiagTransport {
+		b.logger.Info("Transport diagnostics: connected, exchanging PINGs; received frames are counted as RX and dropped")
+	} else {
+		b.logger.Info("Bridge active! Forwarding packets...")
+	}
+
+	// The loops also stop when the session ends without ctx being cancelled
+	loopCtx, stopLoops := context.WithCancel(ctx)
+	defer stopLoops()
 
 	// Start all goroutines
 	var wg sync.WaitGroup
 
-	// Goroutine 1: pcap capture -> channel
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		b.captureLoop(ctx)
-	}()
+	// Goroutine 1: pcap capture -> channel (never under DiagTransport)
+	if b.diag != DiagTransport {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.captureLoop(loopCtx)
+		}()
+	}
 
 	// Goroutine 2: channel -> UDP send
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		b.sendLoop(ctx)
+		b.sendLoop(loopCtx)
 	}()
 
 	// Goroutine 3: UDP recv -> parse -> dispatch
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		b.recvLoop(ctx)
+		b.recvLoop(loopCtx)
 	}()
 
-	// Goroutine 4: channel -> pcap inject
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		b.injectLoop(ctx)
-	}()
+	// Goroutine 4: channel -> pcap inject (never under DiagTransport)
+	if b.diag != DiagTransport {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.injectLoop(loopCtx)
+		}()
+	}
 
 	// Goroutine 5: Ping/pong loop
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		b.pingLoop(ctx)
+		b.pingLoop(loopCtx)
 	}()
 
 	// Goroutine 6: Stats output
@@ -304,7 +805,7 @@ func (b *Bridge) Run(ctx context.Context) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			b.statsLoop(ctx)
+			b.statsLoop(loopCtx)
 		}()
 	}
 
@@ -312,13 +813,33 @@ func (b *Bridge) Run(ctx context.Context) error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		b.stdinLoop(ctx)
+		b.stdinLoop(loopCtx)
 	}()
 
-	// Wait for context cancellation or done channel closure
+	// Goroutine 8: Idle timeout
+	if b.idleTimeout > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.idleLoop(loopCtx)
+		}()
+	}
+
+	// Goroutine 9: Maximum session duration
+	if b.maxDuration > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.durationLoop(loopCtx)
+		}()
+	}
+
+	// Wait for context cancellation, done channel closure or the bridge
+	// stopping the session itself
 	select {
 	case <-ctx.Done():
 	case <-b.done:
+	case <-b.stop:
 	}
 
 	// Determine if this was a peer disconnect or application shutdown
@@ -336,16 +857,18 @@ func (b *Bridge) Run(ctx context.Context) error {
 		b.captureMu.RUnlock()
 
 		// Wait for goroutines to finish
+		stopLoops()
 		wg.Wait()
 
 		b.setState(StateDisconnected)
 		b.logger.Info("Bridge stopped due to peer disconnect")
+		b.printSummary()
 
 		return ErrPeerDisconnected
 
 	default:
-		// Context was cancelled - application shutdown
-		// Send BYE and clean up normally
+		// Context was cancelled (application shutdown) or the bridge stopped
+		// the session - send BYE and clean up normally
 		b.logger.Debug("Sending BYE to peer")
 		if err := b.transport.SendBye(); err != nil {
 			b.logger.Debug("Failed to send BYE: %v", err)
@@ -362,12 +885,21 @@ func (b *Bridge) Run(ctx context.Context) error {
 		b.captureMu.RUnlock()
 
 		// Wait for goroutines to finish
+		stopLoops()
 		wg.Wait()
 
 		b.setState(StateDisconnected)
 		b.logger.Info("Bridge stopped")
+		b.printSummary()
 
-		return nil
+		select {
+		case <-b.stop:
+			b.stateMu.RLock()
+			defer b.stateMu.RUnlock()
+			return b.stopErr
+		default:
+			return nil
+		}
 	}
 }
 
@@ -376,17 +908,148 @@ func (b *Bridge) setState(state State) {
 	b.stateMu.Lock()
 	prev := b.state
 	b.state = state
+	reason := b.stopReason
 	b.stateMu.Unlock()
 
 	if prev != state {
+		if state == StateConnected {
+			b.stats.SetStartTime(time.Now())
+			b.connectedAt = b.now()
+		}
+
 		data := events.StateChangedData{State: state.String()}
+		if state == StateDisconnected {
+			data.Reason = reason
+		}
 		if state == StateConnected {
 			if addr := b.transport.PeerAddr(); addr != nil {
 				data.PeerAddr = addr.String()
 			}
+			info := b.transport.PeerInfo()
+			data.PeerVersion = info.Version
+			data.SecurityMode = info.Mode()
+			if !info.Secure {
+				insecureWarning.Do(b.warnInsecure)
+			}
 		}
 		b.emitter.Emit(events.EventStateChanged, data)
+
+		switch state {
+		case StateConnected:
+			b.onConnected(b.transport.PeerAddr())
+		case StateDisconnected:
+			b.onDisconnected(reason)
+		}
+	}
+}
+
+// sessions numbers this process's sessions for the session log field, since
+// a new Bridge is created for every reconnect.
+var sessions atomic.Uint64
+
+// useSessionLogger switches to a child logger that tags every line with the
+// session number and peer address, so lines from one session can be told
+// apart. Called before the loops start; SetCapture may log concurrently, so
+// the swap happens under captureMu.
+func (b *Bridge) useSessionLogger() {
+	fields := []interface{}{"session", sessions.Add(1)}
+	if addr := b.transport.PeerAddr(); addr != nil {
+		fields = append(fields, "peer", addr)
 	}
+	b.captureMu.Lock()
+	b.logger = b.logger.With(fields...)
+	b.captureMu.Unlock()
+}
+
+// idleLoop shuts the session down once it has been idle for idleTimeout.
+func (b *Bridge) idleLoop(ctx context.Context) {
+	ticker := time.NewTicker(min(IdleCheckInterval, b.idleTimeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if b.checkIdle() {
+				return
+			}
+		}
+	}
+}
+
+// checkIdle reports whether no frame has crossed for idleTimeout and, if so,
+// signals Run to shut down. Must not be called again once it returned true.
+func (b *Bridge) checkIdle() bool {
+	last := b.stats.LastFrameActivity()
+	if last.Before(b.connectedAt) {
+		last = b.connectedAt
+	}
+	idle := b.now().Sub(last)
+	if idle < b.idleTimeout {
+		return false
+	}
+
+	b.logger.Info("No frames for %v (--idle-timeout %v), shutting down", idle.Round(time.Second), b.idleTimeout)
+	b.stopSession(events.ReasonIdleTimeout, ErrIdleTimeout)
+	return true
+}
+
+// durationLoop shuts the session down once it has lasted maxDuration.
+func (b *Bridge) durationLoop(ctx context.Context) {
+	ticker := time.NewTicker(min(IdleCheckInterval, b.maxDuration))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if b.checkMaxDuration() {
+				return
+			}
+		}
+	}
+}
+
+// checkMaxDuration reports whether the session has lasted maxDuration and,
+// if so, signals Run to shut down. Must not be called again once it returned
+// true.
+func (b *Bridge) checkMaxDuration() bool {
+	elapsed := b.now().Sub(b.connectedAt)
+	if elapsed < b.maxDuration {
+		return false
+	}
+
+	b.logger.Info("Session has run for %v (--max-duration %v), shutting down", elapsed.Round(time.Second), b.maxDuration)
+	b.stopSession(events.ReasonMaxDuration, ErrMaxDuration)
+	return true
+}
+
+// stopSession ends the session from inside the bridge: Run sends BYE, cleans
+// up, and returns err; reason goes on the DISCONNECTED event. Only the first
+// call has any effect.
+func (b *Bridge) stopSession(reason string, err error) {
+	b.stopOnce.Do(func() {
+		b.stateMu.Lock()
+		b.stopReason = reason
+		b.stopErr = err
+		b.stateMu.Unlock()
+		close(b.stop)
+	})
+}
+
+// insecureWarning limits warnInsecure to once per process, since a new
+// Bridge is created for every reconnect.
+var insecureWarning sync.Once
+
+// warnInsecure reports that the session is running without authentication.
+func (b *Bridge) warnInsecure() {
+	b.logger.Warn("*************************************************************")
+	b.logger.Warn("* Connected WITHOUT authentication (neither side has --key) *")
+	b.logger.Warn("* Traffic can be spoofed or injected. Use the same --key on *")
+	b.logger.Warn("* both sides, and --require-key to refuse insecure sessions.*")
+	b.logger.Warn("*************************************************************")
 }
 
 // captureLoop reads packets from pcap and sends them to the send channel.
@@ -405,6 +1068,29 @@ func (b *Bridge) captureLoop(ctx context.Context) {
 
 	b.logger.Debug("Capture is ready, beginning packet capture")
 
+	b.captureMu.RLock()
+	cap := b.capture
+	b.captureMu.RUnlock()
+
+	if cap == nil {
+		// Capture was removed (shouldn't happen in normal flow)
+		b.logger.Warn("Capture is nil, stopping capture loop")
+		return
+	}
+
+	b.readFrames(ctx, cap)
+	b.flushCaptureErrors()
+}
+
+// frameReader is the part of *capture.Capture that readFrames uses.
+type frameReader interface {
+	ReadPacketInto(buf []byte) (int, error)
+}
+
+// readFrames reads frames from r into the send channel until ctx is done or
+// r fails CaptureErrorLimit times in a row.
+func (b *Bridge) readFrames(ctx context.Context, r frameReader) {
+	failures := 0
 	for {
 		select {
 		case <-ctx.Done():
@@ -412,78 +1098,321 @@ func (b *Bridge) captureLoop(ctx context.Context) {
 		default:
 		}
 
-		b.captureMu.RLock()
-		cap := b.capture
-		b.captureMu.RUnlock()
-
-		if cap == nil {
-			// Capture was removed (shouldn't happen in normal flow)
-			b.logger.Warn("Capture is nil, stopping capture loop")
-			return
-		}
-
-		frame, err := cap.ReadPacket()
+		bufp := getFrameBuf()
+		n, err := r.ReadPacketInto(*bufp)
 		if err != nil {
-			b.logger.Warn("Capture error: %v", err)
+			putFrameBuf(bufp)
+			if errors.Is(err, capture.ErrFrameTooLarge) {
+				b.logger.Debug("Dropping oversized frame: %v", err)
+				continue
+			}
+			if errors.Is(err, capture.ErrFrameTruncated) {
+				b.logger.Debug("Dropping truncated frame: %v", err)
+				continue
+			}
+			failures++
+			if !b.captureFailed(ctx, err, failures) {
+				return
+			}
 			continue
 		}
+		failures = 0
 
-		if frame == nil {
+		if n == 0 {
+			putFrameBuf(bufp)
 			continue // No packet available (timeout)
 		}
+		*bufp = (*bufp)[:n]
+		frame := *bufp
+
+		if b.ethernetIIOnly {
+			if _, _, etherType := capture.DecodeEthernetFrame(frame); !capture.IsEthernetII(etherType) {
+				atomic.AddUint64(&b.stats.NonEthernetII, 1)
+				b.logger.Trace("Dropping non-Ethernet II frame (EtherType/length 0x%04X, %d bytes)", etherType, len(frame))
+				putFrameBuf(bufp)
+				continue
+			}
+		}
+		if b.loops != nil && b.loops.looped(frame, b.now()) {
+			b.frameLooped(frame)
+			putFrameBuf(bufp)
+			continue
+		}
+		if !b.runMiddleware(DirTx, bufp) {
+			putFrameBuf(bufp)
+			continue
+		}
+		frame = *bufp
 
-		// Log at trace level
-		if b.logger.GetLevel() >= logging.LevelTrace {
+		// Log at trace level (sampled before the decode to keep it cheap)
+		if b.logger.GetLevel() >= logging.LevelTrace && b.traceCaptured.sample() {
 			srcMAC, dstMAC, etherType := capture.DecodeEthernetFrame(frame)
 			b.logger.Trace("Captured frame: %s -> %s (%s, %d bytes)",
 				srcMAC, dstMAC, capture.EtherTypeName(etherType), len(frame))
 		}
+		if b.dumpCaptured.take() {
+			b.logger.Info("Captured frame (%d bytes):\n%s", len(frame), capture.HexDump(frame))
+		}
 
 		// Send to channel (non-blocking with drop on full)
 		select {
-		case b.framesToSend <- frame:
+		case b.framesToSend <- bufp:
 		default:
+			putFrameBuf(bufp)
+			atomic.AddUint64(&b.stats.TxDropped, 1)
 			b.logger.Debug("Frame send channel full, dropping packet")
 		}
 	}
 }
 
-// sendLoop reads frames from channel and sends them over UDP.
+// frameLooped counts a captured frame that matches one we injected, warning
+// the first time.
+func (b *Bridge) frameLooped(frame []byte) {
+	atomic.AddUint64(&b.stats.LoopedFrames, 1)
+	b.loopWarning.Do(func() {
+		srcMAC, dstMAC, _ := capture.DecodeEthernetFrame(frame)
+		b.logger.Warn("Captured a frame we just injected (%s -> %s): the network is looping injected frames back; dropping such frames", srcMAC, dstMAC)
+		b.emitter.Emit(events.EventError, events.ErrorData{
+			Message: "injected frames are looping back to the capture interface",
+			Reason:  events.ReasonFrameLoop,
+		})
+	})
+}
+
+// captureFailed handles the failures-th consecutive capture error: it counts
+// it toward the next capture error summary, then waits out the backoff. It
+// reports false if readFrames should stop, because ctx is done or the error
+// limit was reached and the session is being shut down.
+func (b *Bridge) captureFailed(ctx context.Context, err error, failures int) bool {
+	b.captureErrors.add(err)
+	if failures >= CaptureErrorLimit {
+		b.flushCaptureErrors()
+		b.logger.Error("Capture failed %d times in a row, shutting down: %v", failures, err)
+		b.emitter.Emit(events.EventError, events.ErrorData{
+			Message: "capture failed: " + err.Error(),
+			Reason:  events.ReasonCaptureFailed,
+		})
+		b.stopSession(events.ReasonCaptureFailed, fmt.Errorf("%w: %v", ErrCaptureFailed, err))
+		return false
+	}
+	b.logger.Debug("Capture error (%d in a row): %v", failures, err)
+	if b.captureErrors.due(b.now()) {
+		b.flushCaptureErrors()
+	}
+
+	timer := time.NewTimer(b.captureRetryDelay(failures))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// flushCaptureErrors logs the capture errors since the last summary, if any.
+func (b *Bridge) flushCaptureErrors() {
+	if line := b.captureErrors.flush(b.now()); line != "" {
+		b.logger.Warn("%s", line)
+	}
+}
+
+// captureRetryDelay returns the backoff after the failures-th consecutive
+// capture error, doubling from captureRetryMin up to captureRetryMax.
+func (b *Bridge) captureRetryDelay(failures int) time.Duration {
+	delay := b.captureRetryMin
+	for i := 1; i < failures && delay < b.captureRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, b.captureRetryMax)
+}
+
+// sendLoop reads frames from channel and sends them over UDP, along with
+// the PINGs and PONGs queued by queueControl.
 func (b *Bridge) sendLoop(ctx context.Context) {
 	b.logger.Debug("Send loop started")
 	defer b.logger.Debug("Send loop stopped")
 
+	if b.workers > 1 {
+		b.sendParallelLoop(ctx)
+		return
+	}
+	if b.batchSend {
+		b.sendBatchLoop(ctx)
+		return
+	}
+
+	// Encode buffer owned by this goroutine, reused for every frame
+	out := make([]byte, 0, frameBufSize)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case frame := <-b.framesToSend:
-			encoded, err := b.codec.EncodeFrame(frame)
+		case ctl := <-b.controlToSend:
+			b.sendControl(ctl, b.encodeControl(ctl, b.codec.ReserveNonce()))
+		case bufp := <-b.framesToSend:
+			frame := *bufp
+			if b.dropOversize(len(frame)) {
+				putFrameBuf(bufp)
+				continue
+			}
+			_, _, etherType := capture.DecodeEthernetFrame(frame)
+			encoded, err := b.codec.EncodeFrameInto(out, frame)
+			putFrameBuf(bufp) // frame was copied into encoded
 			if err != nil {
 				b.logger.Debug("Failed to encode frame: %v", err)
 				continue
 			}
 
 			if err := b.transport.Send(encoded); err != nil {
-				b.logger.Warn("Failed to send frame: %v", err)
+				b.sendFailed(err, 1)
 				continue
 			}
 
 			// Update stats
 			atomic.AddUint64(&b.stats.TxPackets, 1)
 			atomic.AddUint64(&b.stats.TxBytes, uint64(len(frame)))
+			b.stats.txEtherTypes.count(etherType)
+			b.stats.MarkTx(b.now())
+		}
+	}
+}
+
+// sendBatchLoop is sendLoop flushing every frame already queued (up to
+// transport.DefaultBatchSize) in one syscall. A lone frame is sent at once,
+// so batching never adds latency; frames are sent in capture order.
+func (b *Bridge) sendBatchLoop(ctx context.Context) {
+	writer := b.transport.(transport.BatchConn).NewBatchWriter(transport.DefaultBatchSize)
+
+	// Encode buffers owned by this goroutine, one per batch slot
+	outs := make([][]byte, transport.DefaultBatchSize)
+	for i := range outs {
+		outs[i] = make([]byte, 0, frameBufSize)
+	}
+	encoded := make([][]byte, 0, transport.DefaultBatchSize)
+	sizes := make([]int, 0, transport.DefaultBatchSize)
+	etherTypes := make([]uint16, 0, transport.DefaultBatchSize)
+
+	// A PING or PONG in the batch has size -1, so it isn't counted as a frame
+	addControl := func(ctl controlMsg) {
+		encoded = append(encoded, b.encodeControl(ctl, b.codec.ReserveNonce()))
+		sizes = append(sizes, -1)
+		etherTypes = append(etherTypes, 0)
+	}
+
+	for {
+		encoded, sizes, etherTypes = encoded[:0], sizes[:0], etherTypes[:0]
+		var bufp *[]byte
+		select {
+		case <-ctx.Done():
+			return
+		case ctl := <-b.controlToSend:
+			addControl(ctl)
+		case bufp = <-b.framesToSend:
+		}
+
+		for {
+			if bufp != nil {
+				frame := *bufp
+				_, _, etherType := capture.DecodeEthernetFrame(frame)
+				if b.dropOversize(len(frame)) {
+					putFrameBuf(bufp)
+				} else {
+					out, err := b.codec.EncodeFrameInto(outs[len(encoded)], frame)
+					putFrameBuf(bufp) // frame was copied into out
+					if err != nil {
+						b.logger.Debug("Failed to encode frame: %v", err)
+					} else {
+						encoded = append(encoded, out)
+						sizes = append(sizes, len(frame))
+						etherTypes = append(etherTypes, etherType)
+					}
+				}
+				bufp = nil
+			}
+
+			if len(encoded) == len(outs) {
+				break
+			}
+			// Take whatever else is already queued, without waiting. PINGs
+			// and PONGs join the batch: their nonces are taken in sequence
+			// with its frames, so they can't overtake any of them.
+			select {
+			case bufp = <-b.framesToSend:
+				continue
+			case ctl := <-b.controlToSend:
+				addControl(ctl)
+				continue
+			default:
+			}
+			break
+		}
+
+		sent, err := writer.Write(encoded)
+		if err != nil {
+			b.sendFailed(err, countFrames(sizes[sent:]))
+		}
+
+		// Update stats
+		var sentFrames bool
+		for i, size := range sizes[:sent] {
+			if size < 0 {
+				continue // a PING or PONG
+			}
+			atomic.AddUint64(&b.stats.TxPackets, 1)
+			atomic.AddUint64(&b.stats.TxBytes, uint64(size))
+			b.stats.txEtherTypes.count(etherTypes[i])
+			sentFrames = true
+		}
+		if sentFrames {
+			b.stats.MarkTx(b.now())
 		}
 	}
 }
 
+// countFrames counts the frames among the sizes of a batch.
+func countFrames(sizes []int) int {
+	n := 0
+	for _, size := range sizes {
+		if size >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// sendFailed accounts for frames that could not be sent. Congestion is
+// expected under load, so it is counted rather than logged as a warning.
+func (b *Bridge) sendFailed(err error, frames int) {
+	if errors.Is(err, transport.ErrSendCongested) {
+		atomic.AddUint64(&b.stats.TxCongested, uint64(frames))
+		b.logger.Debug("Send buffer full, dropped %d frame(s)", frames)
+		return
+	}
+	b.logger.Warn("Failed to send frame: %v", err)
+}
+
 // recvLoop reads from UDP and dispatches messages.
 func (b *Bridge) recvLoop(ctx context.Context) {
 	b.logger.Debug("Recv loop started")
 	defer b.logger.Debug("Recv loop stopped")
 
+	if b.workers > 1 {
+		b.recvParallelLoop(ctx)
+		return
+	}
+	if b.batchRecv {
+		b.recvBatchLoop(ctx)
+		return
+	}
+
 	buf := make([]byte, 65536)
 	peerAddr := b.transport.PeerAddr()
 
+	// Reused for every packet; its slices alias buf until the next read
+	var msg protocol.Message
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -502,56 +1431,132 @@ func (b *Bridge) recvLoop(ctx context.Context) {
 			if ctx.Err() != nil {
 				return
 			}
+			if errors.Is(err, transport.ErrPeerClosed) {
+				b.handlePeerClosed()
+				return
+			}
 			b.logger.Warn("Recv error: %v", err)
 			continue
 		}
 
-		// Verify sender (ignore packets from unexpected sources)
-		if peerAddr != nil && !addrEqual(addr, peerAddr) {
-			b.logger.Debug("Ignoring packet from unexpected source: %s", addr)
-			continue
+		b.handlePacket(buf[:n], addr, peerAddr, &msg)
+	}
+}
+
+// recvBatchLoop is recvLoop reading several datagrams per syscall.
+func (b *Bridge) recvBatchLoop(ctx context.Context) {
+	reader := b.transport.(transport.BatchConn).NewBatchReader(transport.DefaultBatchSize, 65536)
+	peerAddr := b.transport.PeerAddr()
+
+	// Reused for every packet; its slices alias the reader's buffers
+	var msg protocol.Message
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
 		}
 
-		// Decode message
-		msg, err := b.codec.Decode(buf[:n])
+		// Set read deadline
+		b.transport.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+		count, err := reader.Read()
 		if err != nil {
-			b.logger.Debug("Failed to decode message: %v", err)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			b.logger.Warn("Recv error: %v", err)
 			continue
 		}
 
-		// Dispatch based on message type
-		switch msg.Type {
-		case protocol.MsgFrame:
-			b.handleFrame(msg.Frame)
-		case protocol.MsgPing:
-			b.handlePing(msg.Timestamp)
-		case protocol.MsgPong:
-			b.handlePong(msg.Timestamp)
-		case protocol.MsgBye:
-			b.handleBye()
-		default:
-			b.logger.Debug("Unexpected message type: %s", protocol.MessageTypeName(msg.Type))
+		for i := 0; i < count; i++ {
+			data, addr := reader.Datagram(i)
+			b.handlePacket(data, addr, peerAddr, &msg)
 		}
 	}
 }
 
+// handlePacket verifies, decodes and dispatches one received datagram.
+func (b *Bridge) handlePacket(data []byte, addr, peerAddr net.Addr, msg *protocol.Message) {
+	// Verify sender (ignore packets from unexpected sources)
+	if peerAddr != nil && !addrEqual(addr, peerAddr) {
+		b.logger.Debug("Ignoring packet from unexpected source: %s", addr)
+		return
+	}
+
+	// Decode message
+	if err := b.codec.DecodeInto(msg, data); err != nil {
+		b.logger.Debug("Failed to decode message: %v", err)
+		return
+	}
+	b.dispatchMessage(msg)
+}
+
+// dispatchMessage acts on a decoded message according to its type.
+func (b *Bridge) dispatchMessage(msg *protocol.Message) {
+	switch msg.Type {
+	case protocol.MsgFrame:
+		b.handleFrame(msg.Frame)
+	case protocol.MsgPing:
+		b.handlePing(msg.Timestamp)
+	case protocol.MsgPong:
+		b.handlePong(msg.Timestamp, msg.PeerClock)
+	case protocol.MsgBye:
+		b.handleBye()
+	case protocol.MsgError:
+		b.handlePeerError(msg)
+	default:
+		b.logger.Debug("Unexpected message type: %s", protocol.MessageTypeName(msg.Type))
+	}
+}
+
 // handleFrame processes a received frame.
+// frame aliases the receive buffer, so it is copied before being queued.
 func (b *Bridge) handleFrame(frame []byte) {
-	// Log at trace level
-	if b.logger.GetLevel() >= logging.LevelTrace {
+	// Log at trace level (sampled before the decode to keep it cheap)
+	if b.logger.GetLevel() >= logging.LevelTrace && b.traceReceived.sample() {
 		srcMAC, dstMAC, etherType := capture.DecodeEthernetFrame(frame)
 		b.logger.Trace("Received frame: %s -> %s (%s, %d bytes)",
 			srcMAC, dstMAC, capture.EtherTypeName(etherType), len(frame))
 	}
+	if b.dumpReceived.take() {
+		b.logger.Info("Received frame (%d bytes):\n%s", len(frame), capture.HexDump(frame))
+	}
 
 	// Update stats
 	atomic.AddUint64(&b.stats.RxPackets, 1)
 	atomic.AddUint64(&b.stats.RxBytes, uint64(len(frame)))
+	_, _, etherType := capture.DecodeEthernetFrame(frame)
+	b.stats.rxEtherTypes.count(etherType)
+	b.stats.MarkRx(b.now())
+
+	// Remember remote consoles so a local watcher can tell them from ours
+	if len(frame) >= 12 && [6]byte(frame[6:12]) != b.lastRemoteMAC {
+		b.lastRemoteMAC = [6]byte(frame[6:12])
+		b.remoteMACs.LoadOrStore(b.lastRemoteMAC, struct{}{})
+	}
+
+	if b.diag == DiagTransport {
+		return // nothing to inject on
+	}
+
+	bufp := getFrameBuf()
+	*bufp = (*bufp)[:copy(*bufp, frame)]
+	if !b.runMiddleware(DirRx, bufp) {
+		putFrameBuf(bufp)
+		return
+	}
 
 	// Send to inject channel (non-blocking)
 	select {
-	case b.framesToInject <- frame:
+	case b.framesToInject <- bufp:
 	default:
+		putFrameBuf(bufp)
+		atomic.AddUint64(&b.stats.RxDropped, 1)
 		b.logger.Debug("Frame inject channel full, dropping packet")
 	}
 }
@@ -560,14 +1565,16 @@ func (b *Bridge) handleFrame(frame []byte) {
 func (b *Bridge) handlePing(timestamp int64) {
 	b.logger.Trace("Received PING (ts=%d)", timestamp)
 
-	pong := b.codec.EncodePong(timestamp)
-	if err := b.transport.Send(pong); err != nil {
-		b.logger.Debug("Failed to send PONG: %v", err)
+	pong := controlMsg{msgType: protocol.MsgPong, timestamp: timestamp}
+	if b.clockSkewCheck {
+		pong.clock = time.Now().UnixNano()
 	}
+	b.queueControl(pong)
 }
 
-// handlePong processes a pong response.
-func (b *Bridge) handlePong(timestamp int64) {
+// handlePong processes a pong response. peerClock is the peer's clock when
+// it answered, or 0 if the PONG didn't carry it.
+func (b *Bridge) handlePong(timestamp, peerClock int64) {
 	b.pingMu.Lock()
 	defer b.pingMu.Unlock()
 
@@ -582,10 +1589,21 @@ func (b *Bridge) handlePong(timestamp int64) {
 	}
 
 	// Calculate RTT
-	rtt := time.Duration(time.Now().UnixNano() - timestamp)
+	received := time.Now().UnixNano()
+	rtt := time.Duration(received - timestamp)
 	b.pendingPing = 0
 	atomic.StoreInt32(&b.missedPongs, 0)
 
+	latency := events.LatencyData{RTTMs: float64(rtt) / float64(time.Millisecond)}
+	if b.clockSkewCheck && peerClock != 0 {
+		b.checkClockSkew(timestamp, peerClock, received)
+		if up, down, ok := oneWayDelays(timestamp, peerClock, received); ok {
+			b.stats.SetOneWayDelays(up, down)
+			latency.UpMs = float64(up) / float64(time.Millisecond)
+			latency.DownMs = float64(down) / float64(time.Millisecond)
+		}
+	}
+
 	// Check for spike before updating
 	previousRTT := b.stats.GetRTTCurrent()
 	b.stats.SetLastRTT(previousRTT)
@@ -605,11 +1623,9 @@ func (b *Bridge) handlePong(timestamp int64) {
 			rtt.Round(time.Millisecond), RTTAlertThreshold)
 	}
 
-	b.emitter.Emit(events.EventLatency, events.LatencyData{
-		RTTMs:            float64(rtt) / float64(time.Millisecond),
-		IsSpike:          isSpike,
-		ExceedsThreshold: exceedsThreshold,
-	})
+	latency.IsSpike = isSpike
+	latency.ExceedsThreshold = exceedsThreshold
+	b.emitter.Emit(events.EventLatency, latency)
 
 	b.logger.Trace("PONG received: RTT=%v", rtt.Round(time.Millisecond))
 }
@@ -624,6 +1640,31 @@ func (b *Bridge) handleBye() {
 	})
 }
 
+// handlePeerClosed handles a connection-oriented transport losing its peer.
+func (b *Bridge) handlePeerClosed() {
+	b.logger.Warn("Peer closed the connection")
+	b.emitter.Emit(events.EventError, events.ErrorData{Message: "peer closed the connection"})
+	b.setState(StateDisconnected)
+	// Signal goroutines to stop (Run() will detect this and return ErrPeerDisconnected)
+	b.doneOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+// handlePeerError surfaces an ERROR reported by the peer. Errors are
+// unauthenticated, so they are logged but never change bridge state.
+func (b *Bridge) handlePeerError(msg *protocol.Message) {
+	text := protocol.ErrorCodeName(msg.ErrorCode)
+	if msg.ErrorMsg != "" {
+		text += ": " + msg.ErrorMsg
+	}
+	b.logger.Warn("Peer reported error: %s", text)
+	b.emitter.Emit(events.EventError, events.ErrorData{
+		Message: "peer reported error: " + text,
+		Reason:  events.ReasonPeerError,
+	})
+}
+
 // injectLoop reads frames from channel and injects them to the network.
 func (b *Bridge) injectLoop(ctx context.Context) {
 	b.logger.Debug("Inject loop started")
@@ -640,22 +1681,39 @@ func (b *Bridge) injectLoop(ctx context.Context) {
 
 	b.logger.Debug("Capture is ready, beginning packet injection")
 
+	b.captureMu.RLock()
+	cap := b.capture
+	b.captureMu.RUnlock()
+
+	if cap == nil {
+		// Capture was removed (shouldn't happen in normal flow)
+		b.logger.Warn("Capture is nil, stopping inject loop")
+		return
+	}
+
+	b.writeFrames(ctx, cap)
+}
+
+// frameWriter is the part of *capture.Capture that writeFrames uses.
+type frameWriter interface {
+	WritePacket(frame []byte) error
+}
+
+// writeFrames injects frames from the inject channel through w until ctx is
+// done.
+func (b *Bridge) writeFrames(ctx context.Context, w frameWriter) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case frame := <-b.framesToInject:
-			b.captureMu.RLock()
-			cap := b.capture
-			b.captureMu.RUnlock()
-
-			if cap == nil {
-				// Capture was removed (shouldn't happen in normal flow)
-				b.logger.Warn("Capture is nil, dropping frame")
-				continue
+		case bufp := <-b.framesToInject:
+			err := b.writeFrame(w, *bufp)
+			if err == nil && b.loops != nil {
+				b.loops.injected(*bufp, b.now())
 			}
-
-			if err := cap.WritePacket(frame); err != nil {
+			putFrameBuf(bufp)
+			if err != nil {
+				atomic.AddUint64(&b.stats.InjectDrops, 1)
 				b.logger.Warn("Injection failed: %v", err)
 				continue
 			}
@@ -663,6 +1721,22 @@ func (b *Bridge) injectLoop(ctx context.Context) {
 	}
 }
 
+// writeFrame injects frame, retrying up to InjectRetryLimit times with
+// backoff while the error is transient.
+func (b *Bridge) writeFrame(w frameWriter, frame []byte) error {
+	delay := b.injectRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := w.WritePacket(frame)
+		if err == nil || attempt == InjectRetryLimit || !capture.IsTransientWriteError(err) {
+			return err
+		}
+		atomic.AddUint64(&b.stats.InjectRetries, 1)
+		b.logger.Trace("Injection failed (%v), retrying in %v", err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
 // pingLoop sends periodic ping messages.
 func (b *Bridge) pingLoop(ctx context.Context) {
 	b.logger.Debug("Ping loop started")
@@ -681,13 +1755,14 @@ func (b *Bridge) pingLoop(ctx context.Context) {
 	}
 }
 
-// sendPing sends a ping message and tracks it.
+// sendPing queues a ping message for the send loop and tracks it.
 func (b *Bridge) sendPing() {
 	b.pingMu.Lock()
 
 	// Check for missed pong
 	if b.pendingPing != 0 {
 		missed := atomic.AddInt32(&b.missedPongs, 1)
+		atomic.AddUint64(&b.stats.PongsLost, 1)
 		b.logger.Debug("Missed PONG response (count: %d)", missed)
 
 		if missed >= MaxMissedPongs {
@@ -709,10 +1784,8 @@ func (b *Bridge) sendPing() {
 	b.pendingPing = timestamp
 	b.pingMu.Unlock()
 
-	ping := b.codec.EncodePing(timestamp)
-	if err := b.transport.Send(ping); err != nil {
-		b.logger.Debug("Failed to send PING: %v", err)
-	}
+	atomic.AddUint64(&b.stats.PingsSent, 1)
+	b.queueControl(controlMsg{msgType: protocol.MsgPing, timestamp: timestamp})
 }
 
 // statsLoop outputs periodic statistics.
@@ -728,32 +1801,36 @@ func (b *Bridge) statsLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			b.flushCaptureErrors()
 			b.printStats()
 		case <-b.stdinCh:
 			b.printStats()
+			tx, rx := b.stats.EtherTypes()
+			b.logger.Stats("Traffic mix: TX %s; RX %s", tx, rx)
 		}
 	}
 }
 
-// stdinLoop monitors stdin for Enter key presses.
+// stdinLoop monitors stdin for commands: Enter alone for stats, "d" and
+// Enter to cycle the log level. It only does so when stdin is a terminal:
+// redirected from a pipe or file, stdin carries no key presses, and its
+// lines would be taken for commands.
 func (b *Bridge) stdinLoop(ctx context.Context) {
+	if !isTerminal(b.stdin) {
+		b.logger.Debug("Stdin is not a terminal, not monitoring it for commands")
+		return
+	}
 	b.logger.Debug("Stdin monitor started")
 	defer b.logger.Debug("Stdin monitor stopped")
 
 	// Read from stdin in a separate goroutine
-	inputCh := make(chan struct{})
+	inputCh := make(chan string)
 	go func() {
-		buf := make([]byte, 1)
-		for {
-			_, err := os.Stdin.Read(buf)
-			if err != nil {
-				return
-			}
-			if buf[0] == '\n' || buf[0] == '\r' {
-				select {
-				case inputCh <- struct{}{}:
-				default:
-				}
+		scanner := bufio.NewScanner(b.stdin)
+		for scanner.Scan() {
+			select {
+			case inputCh <- scanner.Text():
+			default:
 			}
 		}
 	}()
@@ -762,16 +1839,41 @@ func (b *Bridge) stdinLoop(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		case <-inputCh:
-			// Signal stats output
-			select {
-			case b.stdinCh <- struct{}{}:
-			default:
-			}
+		case line := <-inputCh:
+			b.handleStdinLine(line)
 		}
 	}
 }
 
+// isTerminal reports whether f is an interactive terminal. /dev/null is a
+// character device but not a terminal, so it is checked with an ioctl rather
+// than the file mode.
+func isTerminal(f *os.File) bool {
+	return f != nil && term.IsTerminal(int(f.Fd()))
+}
+
+// stdinLevels are the log levels the "d" command cycles through.
+var stdinLevels = []logging.Level{logging.LevelInfo, logging.LevelDebug, logging.LevelTrace}
+
+// handleStdinLine acts on one line typed on stdin.
+func (b *Bridge) handleStdinLine(line string) {
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "":
+		// Signal stats output
+		select {
+		case b.stdinCh <- struct{}{}:
+		default:
+		}
+	case "d":
+		next := stdinLevels[0]
+		if i := slices.Index(stdinLevels, b.logger.GetLevel()); i >= 0 {
+			next = stdinLevels[(i+1)%len(stdinLevels)]
+		}
+		b.logger.SetLevel(next)
+		b.logger.Info("Log level: %s (type d and Enter to change)", next)
+	}
+}
+
 // printStats outputs the current statistics.
 func (b *Bridge) printStats() {
 	txPkts := atomic.LoadUint64(&b.stats.TxPackets)
@@ -779,24 +1881,160 @@ func (b *Bridge) printStats() {
 	rxPkts := atomic.LoadUint64(&b.stats.RxPackets)
 	rxBytes := atomic.LoadUint64(&b.stats.RxBytes)
 	rtt := b.stats.GetRTTCurrent()
-
-	b.logger.Stats("TX: %s pkts (%s) | RX: %s pkts (%s) | RTT: %v",
-		formatNumber(txPkts), formatBytes(txBytes),
-		formatNumber(rxPkts), formatBytes(rxBytes),
-		rtt.Round(time.Millisecond))
+	up, down := b.stats.GetOneWayDelays()
+	quality := b.stats.QualityScore()
+	handshakeFailures := b.handshakeFailures()
+	codecStats := b.codec.Stats()
+	uptime := b.stats.Uptime()
+
+	b.statsFormatter.write(b.logger, statsSnapshot{
+		Time:              time.Now(),
+		TxPackets:         txPkts,
+		TxBytes:           txBytes,
+		RxPackets:         rxPkts,
+		RxBytes:           rxBytes,
+		RTT:               rtt,
+		UpDelay:           up,
+		DownDelay:         down,
+		Quality:           quality,
+		TxDropped:         atomic.LoadUint64(&b.stats.TxDropped),
+		RxDropped:         atomic.LoadUint64(&b.stats.RxDropped),
+		TxCongested:       atomic.LoadUint64(&b.stats.TxCongested),
+		LoopedFrames:      atomic.LoadUint64(&b.stats.LoopedFrames),
+		TxOversizeDropped: atomic.LoadUint64(&b.stats.TxOversizeDropped),
+		NonEthernetII:     atomic.LoadUint64(&b.stats.NonEthernetII),
+		Filtered:          atomic.LoadUint64(&b.stats.Filtered),
+		InjectDrops:       atomic.LoadUint64(&b.stats.InjectDrops),
+		HandshakeFailures: handshakeFailures,
+		Codec:             codecStats,
+		Uptime:            uptime,
+	})
+	if b.logger.GetLevel() >= logging.LevelDebug {
+		tx, rx := b.stats.EtherTypes()
+		b.logger.Debug("Traffic mix: TX %s; RX %s", tx, rx)
+	}
 
 	b.stats.rttMu.RLock()
 	rttAvg := b.stats.RTTAvg
 	b.stats.rttMu.RUnlock()
 
 	b.emitter.Emit(events.EventStats, events.StatsData{
-		TxPackets:    txPkts,
-		TxBytes:      txBytes,
-		RxPackets:    rxPkts,
-		RxBytes:      rxBytes,
-		RTTCurrentMs: float64(rtt) / float64(time.Millisecond),
-		RTTAvgMs:     float64(rttAvg) / float64(time.Millisecond),
+		TxPackets:         txPkts,
+		TxBytes:           txBytes,
+		RxPackets:         rxPkts,
+		RxBytes:           rxBytes,
+		RTTCurrentMs:      float64(rtt) / float64(time.Millisecond),
+		RTTAvgMs:          float64(rttAvg) / float64(time.Millisecond),
+		JitterMs:          float64(b.stats.GetJitter()) / float64(time.Millisecond),
+		PongsLost:         atomic.LoadUint64(&b.stats.PongsLost),
+		QualityScore:      quality.Score,
+		Quality:           quality.Label,
+		HandshakeFailures: handshakeFailures,
+		TxCongested:       atomic.LoadUint64(&b.stats.TxCongested),
+		LoopedFrames:      atomic.LoadUint64(&b.stats.LoopedFrames),
+		TxOversizeDropped: atomic.LoadUint64(&b.stats.TxOversizeDropped),
+		NonEthernetII:     atomic.LoadUint64(&b.stats.NonEthernetII),
+		Filtered:          atomic.LoadUint64(&b.stats.Filtered),
+		InjectRetries:     atomic.LoadUint64(&b.stats.InjectRetries),
+		InjectDrops:       atomic.LoadUint64(&b.stats.InjectDrops),
+		HMACFailures:      codecStats.HMACFailures,
+		Replays:           codecStats.Replays,
+		DecodeErrors:      codecStats.DecodeErrors,
+		RxCorrupt:         codecStats.RxCorrupt,
+		UptimeSec:         uptime.Seconds(),
 	})
+	b.onStats(b.stats.Snapshot())
+}
+
+// printSummary outputs totals for the session that just ended and emits them
+// as a final stats event.
+func (b *Bridge) printSummary() {
+	// Read once, so the log and the event report the same RTTs
+	avg, min, max := b.stats.RTTSummary()
+	data := b.sessionSummary(avg, min, max)
+
+	b.logger.Stats("Session summary (%s):", formatUptime(b.stats.Uptime()))
+	b.logger.Stats("  TX: %s pkts (%s) | RX: %s pkts (%s)",
+		formatNumber(data.TxPackets), formatBytes(data.TxBytes),
+		formatNumber(data.RxPackets), formatBytes(data.RxBytes))
+	b.logger.Stats("  RTT: avg %v | min %v | max %v",
+		avg.Round(time.Millisecond), min.Round(time.Millisecond), max.Round(time.Millisecond))
+	if quality := b.stats.QualityScore(); quality.Label != QualityUnknown {
+		b.logger.Stats("  Quality: %s | jitter %v | ping loss %.1f%%",
+			quality, b.stats.GetJitter().Round(time.Millisecond), b.stats.PingLoss()*100)
+	}
+	b.logger.Stats("  Drops: TX %s | RX %s | send congestion %s",
+		formatNumber(data.TxDropped), formatNumber(data.RxDropped), formatNumber(data.TxCongested))
+	if data.LoopedFrames > 0 {
+		b.logger.Stats("  Looped back: %s frames", formatNumber(data.LoopedFrames))
+	}
+	if data.TxOversizeDropped > 0 {
+		b.logger.Stats("  Oversize dropped: %s frames", formatNumber(data.TxOversizeDropped))
+	}
+	if data.NonEthernetII > 0 {
+		b.logger.Stats("  Non-Ethernet II dropped: %s frames", formatNumber(data.NonEthernetII))
+	}
+	if data.Filtered > 0 {
+		b.logger.Stats("  Filtered by middleware: %s frames", formatNumber(data.Filtered))
+	}
+	if skew, ok := b.ClockSkew(); ok {
+		b.logger.Stats("  Peer clock: %s", describeClockSkew(skew))
+	}
+	if data.InjectRetries+data.InjectDrops > 0 {
+		b.logger.Stats("  Injection: %s retries | %s frames dropped",
+			formatNumber(data.InjectRetries), formatNumber(data.InjectDrops))
+	}
+	if data.TxPackets+data.RxPackets > 0 {
+		tx, rx := b.stats.EtherTypes()
+		b.logger.Stats("  TX mix: %s", tx)
+		b.logger.Stats("  RX mix: %s", rx)
+	}
+	if data.HMACFailures+data.Replays+data.DecodeErrors+data.RxCorrupt > 0 {
+		b.logger.Stats("  Rejected: bad HMAC %s | replays %s | malformed %s | corrupt %s",
+			formatNumber(data.HMACFailures), formatNumber(data.Replays), formatNumber(data.DecodeErrors),
+			formatNumber(data.RxCorrupt))
+	}
+
+	b.emitter.Emit(events.EventStats, data)
+	b.onStats(b.stats.Snapshot())
+}
+
+// sessionSummary builds the final stats event for the session, given its
+// RTT summary (Stats.RTTSummary).
+func (b *Bridge) sessionSummary(avg, min, max time.Duration) events.StatsData {
+	codecStats := b.codec.Stats()
+	quality := b.stats.QualityScore()
+
+	return events.StatsData{
+		TxPackets:         atomic.LoadUint64(&b.stats.TxPackets),
+		TxBytes:           atomic.LoadUint64(&b.stats.TxBytes),
+		RxPackets:         atomic.LoadUint64(&b.stats.RxPackets),
+		RxBytes:           atomic.LoadUint64(&b.stats.RxBytes),
+		TxDropped:         atomic.LoadUint64(&b.stats.TxDropped),
+		RxDropped:         atomic.LoadUint64(&b.stats.RxDropped),
+		TxCongested:       atomic.LoadUint64(&b.stats.TxCongested),
+		LoopedFrames:      atomic.LoadUint64(&b.stats.LoopedFrames),
+		TxOversizeDropped: atomic.LoadUint64(&b.stats.TxOversizeDropped),
+		NonEthernetII:     atomic.LoadUint64(&b.stats.NonEthernetII),
+		Filtered:          atomic.LoadUint64(&b.stats.Filtered),
+		InjectRetries:     atomic.LoadUint64(&b.stats.InjectRetries),
+		InjectDrops:       atomic.LoadUint64(&b.stats.InjectDrops),
+		RTTCurrentMs:      float64(b.stats.GetRTTCurrent()) / float64(time.Millisecond),
+		RTTAvgMs:          float64(avg) / float64(time.Millisecond),
+		RTTMinMs:          float64(min) / float64(time.Millisecond),
+		RTTMaxMs:          float64(max) / float64(time.Millisecond),
+		JitterMs:          float64(b.stats.GetJitter()) / float64(time.Millisecond),
+		PongsLost:         atomic.LoadUint64(&b.stats.PongsLost),
+		QualityScore:      quality.Score,
+		Quality:           quality.Label,
+		HandshakeFailures: b.handshakeFailures(),
+		HMACFailures:      codecStats.HMACFailures,
+		Replays:           codecStats.Replays,
+		DecodeErrors:      codecStats.DecodeErrors,
+		RxCorrupt:         codecStats.RxCorrupt,
+		UptimeSec:         b.stats.Uptime().Seconds(),
+		Final:             true,
+	}
 }
 
 // GetStats returns the current statistics.
@@ -804,6 +2042,21 @@ func (b *Bridge) GetStats() *Stats {
 	return b.stats
 }
 
+// State returns the current connection state.
+func (b *Bridge) State() State {
+	b.stateMu.RLock()
+	defer b.stateMu.RUnlock()
+	return b.state
+}
+
+// PeerAddr returns the peer's address, or nil before it is known.
+func (b *Bridge) PeerAddr() net.Addr {
+	if b.transport == nil {
+		return nil
+	}
+	return b.transport.PeerAddr()
+}
+
 // formatNumber formats a number with comma separators.
 func formatNumber(n uint64) string {
 	if n < 1000 {
@@ -835,10 +2088,23 @@ func formatBytes(b uint64) string {
 	}
 }
 
-// addrEqual compares two UDP addresses.
-func addrEqual(a, b *net.UDPAddr) bool {
+// formatUptime formats a duration as HH:MM:SS (hours may exceed 24).
+func formatUptime(d time.Duration) string {
+	secs := int64(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", secs/3600, (secs/60)%60, secs%60)
+}
+
+// addrEqual compares two peer addresses.
+// UDP addresses are compared by IP and port without allocating; other
+// address types fall back to comparing their string forms.
+func addrEqual(a, b net.Addr) bool {
 	if a == nil || b == nil {
-		return a == b
+		return a == nil && b == nil
+	}
+	ua, okA := a.(*net.UDPAddr)
+	ub, okB := b.(*net.UDPAddr)
+	if okA && okB {
+		return ua.IP.Equal(ub.IP) && ua.Port == ub.Port
 	}
-	return a.IP.Equal(b.IP) && a.Port == b.Port
+	return a.Network() == b.Network() && a.String() == b.String()
 }
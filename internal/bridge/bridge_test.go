@@ -1,10 +1,26 @@
 package bridge
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"net"
+	"os"
+	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/events"
+	"github.com/xbslink/xbslink-ng/internal/logging"
+	"github.com/xbslink/xbslink-ng/internal/protocol"
+	"github.com/xbslink/xbslink-ng/internal/transport"
+	"github.com/xbslink/xbslink-ng/test/testutil"
+	"github.com/xbslink/xbslink-ng/test/testutil/transporttest"
 )
 
 func TestStats_IncrementTxPackets(t *testing.T) {
@@ -87,6 +103,138 @@ func TestStats_RTTAverage_SlidingWindow(t *testing.T) {
 	}
 }
 
+func TestStats_RTTSummary(t *testing.T) {
+	stats := &Stats{}
+
+	// Outlier falls out of the sliding window but not the session summary
+	stats.AddRTTSample(90 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		stats.AddRTTSample(10 * time.Millisecond)
+	}
+
+	avg, min, max := stats.RTTSummary()
+	if min != 10*time.Millisecond {
+		t.Errorf("min = %v, want 10ms", min)
+	}
+	if max != 90*time.Millisecond {
+		t.Errorf("max = %v, want 90ms", max)
+	}
+	// (90 + 20*10) / 21
+	if want := 290 * time.Millisecond / 21; avg != want {
+		t.Errorf("avg = %v, want %v", avg, want)
+	}
+	if stats.RTTAvg != 10*time.Millisecond {
+		t.Errorf("RTTAvg = %v, want 10ms (sliding window)", stats.RTTAvg)
+	}
+}
+
+func TestStats_RTTSummary_NoSamples(t *testing.T) {
+	avg, min, max := (&Stats{}).RTTSummary()
+	if avg != 0 || min != 0 || max != 0 {
+		t.Errorf("RTTSummary() = %v, %v, %v, want zeros", avg, min, max)
+	}
+}
+
+func TestStats_Jitter(t *testing.T) {
+	s := &Stats{}
+	s.AddRTTSample(20 * time.Millisecond)
+	if got := s.GetJitter(); got != 0 {
+		t.Errorf("jitter after one sample = %v, want 0", got)
+	}
+	// Alternating 20ms/36ms: every step is 16ms, so jitter climbs toward it
+	for i := range 200 {
+		s.AddRTTSample(time.Duration(20+16*(i%2)) * time.Millisecond)
+	}
+	if got := s.GetJitter(); got < 15*time.Millisecond || got > 16*time.Millisecond {
+		t.Errorf("jitter = %v, want about 16ms", got)
+	}
+}
+
+func TestQualityScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		rtt    time.Duration
+		jitter time.Duration
+		loss   float64
+		want   Quality
+	}{
+		{"LAN", 2 * time.Millisecond, 500 * time.Microsecond, 0, Quality{100, QualityGood}},
+		{"same city", 30 * time.Millisecond, 3 * time.Millisecond, 0, Quality{91, QualityGood}},
+		{"across the country", 60 * time.Millisecond, 8 * time.Millisecond, 0.01, Quality{57, QualityFair}},
+		{"steady but far", 90 * time.Millisecond, time.Millisecond, 0, Quality{56, QualityFair}},
+		{"jittery", 25 * time.Millisecond, 15 * time.Millisecond, 0, Quality{67, QualityFair}},
+		{"lossy", 25 * time.Millisecond, time.Millisecond, 0.05, Quality{77, QualityFair}},
+		{"overseas", 150 * time.Millisecond, 20 * time.Millisecond, 0.1, Quality{0, QualityPoor}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := qualityScore(tt.rtt, tt.jitter, tt.loss); got != tt.want {
+				t.Errorf("qualityScore(%v, %v, %v) = %+v, want %+v", tt.rtt, tt.jitter, tt.loss, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStats_QualityScore(t *testing.T) {
+	s := &Stats{}
+	if got := s.QualityScore(); got.Label != QualityUnknown || got.String() != "unknown" {
+		t.Errorf("QualityScore() with no samples = %+v", got)
+	}
+
+	s.AddRTTSample(60 * time.Millisecond)
+	s.PingsSent, s.PongsLost = 100, 1
+	if got := s.QualityScore(); got != (Quality{71, QualityFair}) || got.String() != "71 (fair)" {
+		t.Errorf("QualityScore() = %+v (%s), want 71 (fair)", got, got)
+	}
+	if got := formatLine(statsSnapshot{RTT: 60 * time.Millisecond, Quality: s.QualityScore()}, false); !strings.Contains(got, "RTT: 60ms | Quality: 71 (fair)") {
+		t.Errorf("stats line = %q", got)
+	}
+}
+
+func TestStats_Uptime_NotStarted(t *testing.T) {
+	if got := (&Stats{}).Uptime(); got != 0 {
+		t.Errorf("Uptime() = %v, want 0 before start", got)
+	}
+}
+
+func TestStats_Uptime_Monotonic(t *testing.T) {
+	stats := &Stats{}
+	stats.SetStartTime(time.Now())
+
+	prev := stats.Uptime()
+	for i := 0; i < 5; i++ {
+		time.Sleep(2 * time.Millisecond)
+		cur := stats.Uptime()
+		if cur <= prev {
+			t.Fatalf("Uptime() went from %v to %v, want increasing", prev, cur)
+		}
+		prev = cur
+	}
+}
+
+func TestBridge_StartTimeSetOnConnect(t *testing.T) {
+	b := newTestBridge(t, nil)
+
+	if !b.stats.StartTime.IsZero() {
+		t.Fatal("StartTime should be zero before connecting")
+	}
+
+	b.setState(StateConnected)
+	first := b.stats.StartTime
+	if first.IsZero() {
+		t.Fatal("StartTime not set on StateConnected")
+	}
+
+	// Reconnecting starts a new session
+	time.Sleep(2 * time.Millisecond)
+	b.setState(StateDisconnected)
+	b.setState(StateConnected)
+	if !b.stats.StartTime.After(first) {
+		t.Error("StartTime not reset on reconnect")
+	}
+}
+
 func TestStats_GetRTTCurrent(t *testing.T) {
 	stats := &Stats{}
 
@@ -266,6 +414,24 @@ func TestFormatNumber(t *testing.T) {
 	}
 }
 
+func TestFormatUptime(t *testing.T) {
+	tests := []struct {
+		d        time.Duration
+		expected string
+	}{
+		{0, "00:00:00"},
+		{59 * time.Second, "00:00:59"},
+		{12*time.Minute + 34*time.Second, "00:12:34"},
+		{26*time.Hour + 1500*time.Millisecond, "26:00:01"},
+	}
+
+	for _, tt := range tests {
+		if result := formatUptime(tt.d); result != tt.expected {
+			t.Errorf("formatUptime(%v) = %s, want %s", tt.d, result, tt.expected)
+		}
+	}
+}
+
 func TestFormatBytes(t *testing.T) {
 	tests := []struct {
 		input    uint64
@@ -312,3 +478,2037 @@ func TestAddrEqual_Bridge(t *testing.T) {
 
 // Note: Full integration testing of New() with valid components requires
 // actual pcap access and is covered in integration tests.
+
+func TestBridge_PrintSummary(t *testing.T) {
+	emitter := &testutil.MockEmitter{}
+	b := newTestBridge(t, emitter)
+
+	b.stats.TxPackets = 10
+	b.stats.TxBytes = 1000
+	b.stats.RxPackets = 20
+	b.stats.RxBytes = 2000
+	b.stats.TxDropped = 1
+	b.stats.RxDropped = 2
+	b.stats.AddRTTSample(10 * time.Millisecond)
+	b.stats.AddRTTSample(30 * time.Millisecond)
+	b.stats.SetStartTime(time.Now().Add(-90 * time.Second))
+
+	b.printSummary()
+
+	got := emitter.GetEvents(events.EventStats)
+	if len(got) != 1 {
+		t.Fatalf("got %d stats events, want 1", len(got))
+	}
+	data := got[0].Data.(events.StatsData)
+	if !data.Final {
+		t.Error("summary event should be marked final")
+	}
+	if data.UptimeSec < 90 || data.UptimeSec > 91 {
+		t.Errorf("UptimeSec = %v, want ~90", data.UptimeSec)
+	}
+	if data.TxPackets != 10 || data.RxPackets != 20 || data.TxBytes != 1000 || data.RxBytes != 2000 {
+		t.Errorf("unexpected totals: %+v", data)
+	}
+	if data.TxDropped != 1 || data.RxDropped != 2 {
+		t.Errorf("drops = %d/%d, want 1/2", data.TxDropped, data.RxDropped)
+	}
+	if data.RTTAvgMs != 20 || data.RTTMinMs != 10 || data.RTTMaxMs != 30 {
+		t.Errorf("RTT avg/min/max = %v/%v/%v, want 20/10/30", data.RTTAvgMs, data.RTTMinMs, data.RTTMaxMs)
+	}
+}
+
+// newTestBridge creates a listen-mode bridge on an ephemeral port with no capture.
+func newTestBridge(t *testing.T, emitter events.Emitter) *Bridge {
+	t.Helper()
+
+	logger := logging.NewLogger(logging.LevelError)
+	codec := newTestCodec(nil)
+
+	tr, err := transport.New(transport.Config{
+		Mode:   transport.ModeListen,
+		Codec:  codec,
+		Logger: logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	t.Cleanup(func() { tr.Close() })
+
+	b, err := New(Config{
+		Transport: tr,
+		Codec:     codec,
+		Logger:    logger,
+		Emitter:   emitter,
+		Mode:      transport.ModeListen,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+	return b
+}
+
+func TestParseStatsFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected StatsFormat
+	}{
+		{"line", StatsFormatLine},
+		{"CSV", StatsFormatCSV},
+		{" table ", StatsFormatTable},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseStatsFormat(tt.input)
+		if err != nil {
+			t.Errorf("ParseStatsFormat(%q) error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ParseStatsFormat(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+
+	if _, err := ParseStatsFormat("json"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestStatsFormatter_CSV(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.NewLogger(logging.LevelError)
+	logger.SetOutput(&buf)
+
+	f := NewStatsFormatter(StatsFormatCSV)
+	snap := statsSnapshot{
+		Time:      time.Date(2024, 1, 15, 14, 30, 35, 0, time.UTC),
+		TxPackets: 1247,
+		TxBytes:   335872,
+		RxPackets: 1302,
+		RxBytes:   359424,
+		RTT:       8500 * time.Microsecond,
+		RxDropped: 3,
+	}
+	f.write(logger, snap)
+	f.write(logger, snap)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want header + 2 rows:\n%s", len(lines), buf.String())
+	}
+	if want := "csv," + csvHeader; lines[0] != want {
+		t.Errorf("header = %q, want %q", lines[0], want)
+	}
+	want := "csv,2024-01-15T14:30:35Z,1247,335872,1302,359424,8.500,0,3,0"
+	if lines[1] != want || lines[2] != want {
+		t.Errorf("rows = %q, %q, want %q", lines[1], lines[2], want)
+	}
+}
+
+func TestStatsFormatter_Table(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.NewLogger(logging.LevelError)
+	logger.SetOutput(&buf)
+
+	f := NewStatsFormatter(StatsFormatTable)
+	f.write(logger, statsSnapshot{TxPackets: 1, RTT: 8 * time.Millisecond})
+	f.write(logger, statsSnapshot{TxPackets: 1247, RTT: 12 * time.Millisecond})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want header + 2 rows:\n%s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], "TX pkts") {
+		t.Errorf("header missing column names: %q", lines[0])
+	}
+	// Columns line up regardless of value width
+	if len(lines[1]) != len(lines[2]) || len(lines[0]) != len(lines[1]) {
+		t.Errorf("table rows not aligned:\n%s", buf.String())
+	}
+}
+
+func TestStatsFormatter_Line(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.NewLogger(logging.LevelError)
+	logger.SetOutput(&buf)
+
+	NewStatsFormatter(StatsFormatLine).write(logger, statsSnapshot{
+		TxPackets:         1247,
+		RTT:               8 * time.Millisecond,
+		HandshakeFailures: 2,
+	})
+
+	output := buf.String()
+	if !strings.Contains(output, "[STATS] TX: 1,247 pkts") {
+		t.Errorf("unexpected line output: %q", output)
+	}
+	if !strings.Contains(output, "Handshake failures: 2") {
+		t.Errorf("line output missing handshake failures: %q", output)
+	}
+}
+
+func TestBridge_SendFailed(t *testing.T) {
+	b := newTestBridge(t, nil)
+
+	congested := fmt.Errorf("%w: write: resource temporarily unavailable", transport.ErrSendCongested)
+	b.sendFailed(congested, 1)
+	b.sendFailed(congested, 3)
+	b.sendFailed(errors.New("network is unreachable"), 1)
+
+	if got := atomic.LoadUint64(&b.stats.TxCongested); got != 4 {
+		t.Errorf("TxCongested = %d, want 4", got)
+	}
+
+	var buf bytes.Buffer
+	logger := logging.NewLogger(logging.LevelError)
+	logger.SetOutput(&buf)
+	NewStatsFormatter(StatsFormatLine).write(logger, statsSnapshot{TxCongested: 4})
+	if !strings.Contains(buf.String(), "Send congestion: 4") {
+		t.Errorf("line output missing send congestion: %q", buf.String())
+	}
+}
+
+func TestFormatLine_CodecRejections(t *testing.T) {
+	line := formatLine(statsSnapshot{}, false)
+	if strings.Contains(line, "HMAC") {
+		t.Errorf("line mentions HMAC with no failures: %q", line)
+	}
+
+	line = formatLine(statsSnapshot{Codec: protocol.CodecStats{HMACFailures: 1200, Replays: 3, DecodeErrors: 1}}, false)
+	for _, want := range []string{"Bad HMAC: 1,200", "Replays: 3", "Malformed: 1"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line missing %q: %q", want, line)
+		}
+	}
+}
+
+func TestFormatLine_OneWayDelays(t *testing.T) {
+	if line := formatLine(statsSnapshot{RTT: 40 * time.Millisecond}, false); strings.Contains(line, " down") {
+		t.Errorf("line shows one-way delays without any: %q", line)
+	}
+	line := formatLine(statsSnapshot{RTT: 40 * time.Millisecond, UpDelay: 28 * time.Millisecond, DownDelay: 12 * time.Millisecond}, false)
+	if !strings.Contains(line, "RTT: 40ms (28ms up, 12ms down)") {
+		t.Errorf("line = %q, want the one-way delays after the RTT", line)
+	}
+}
+
+func TestRTTColor(t *testing.T) {
+	tests := []struct {
+		rtt      time.Duration
+		expected logging.Color
+	}{
+		{5 * time.Millisecond, logging.ColorGreen},
+		{RTTGoodThreshold, logging.ColorGreen},
+		{25 * time.Millisecond, logging.ColorYellow},
+		{RTTAlertThreshold, logging.ColorYellow},
+		{45 * time.Millisecond, logging.ColorRed},
+	}
+
+	for _, tt := range tests {
+		if got := rttColor(tt.rtt); got != tt.expected {
+			t.Errorf("rttColor(%v) = %q, want %q", tt.rtt, got, tt.expected)
+		}
+	}
+}
+
+func TestFormatLine_ColoredRTT(t *testing.T) {
+	snap := statsSnapshot{RTT: 45 * time.Millisecond}
+
+	colored := formatLine(snap, true)
+	if !strings.Contains(colored, "RTT: "+logging.Colorize(logging.ColorRed, "45ms")) {
+		t.Errorf("expected red RTT, got %q", colored)
+	}
+
+	plain := formatLine(snap, false)
+	if strings.Contains(plain, "\033[") {
+		t.Errorf("expected no ANSI codes without color, got %q", plain)
+	}
+	if !strings.Contains(plain, "RTT: 45ms |") {
+		t.Errorf("unexpected plain output: %q", plain)
+	}
+
+	// No RTT measured yet: nothing to grade
+	if strings.Contains(formatLine(statsSnapshot{}, true), "\033[") {
+		t.Error("expected zero RTT to be left uncolored")
+	}
+}
+
+func TestTraceSampler(t *testing.T) {
+	tests := []struct {
+		every  uint
+		frames int
+		logged int
+	}{
+		{0, 10, 10},
+		{1, 10, 10},
+		{3, 10, 4}, // frames 1, 4, 7, 10
+		{100, 250, 3},
+	}
+
+	for _, tt := range tests {
+		s := newTraceSampler(tt.every)
+		logged := 0
+		for i := 0; i < tt.frames; i++ {
+			if s.sample() {
+				logged++
+			}
+		}
+		if logged != tt.logged {
+			t.Errorf("every=%d: logged %d of %d frames, want %d", tt.every, logged, tt.frames, tt.logged)
+		}
+	}
+}
+
+func TestTraceSampler_FirstFrameLogged(t *testing.T) {
+	if !newTraceSampler(1000).sample() {
+		t.Error("first frame should always be logged")
+	}
+}
+
+func TestHandleFrame_TraceSampled(t *testing.T) {
+	var buf bytes.Buffer
+	b := newTestBridge(t, nil)
+	b.logger.SetLevel(logging.LevelTrace)
+	b.logger.SetOutput(&buf)
+	b.traceReceived = newTraceSampler(5)
+
+	frame := make([]byte, 64)
+	for i := 0; i < 10; i++ {
+		b.handleFrame(frame)
+	}
+
+	if got := strings.Count(buf.String(), "Received frame"); got != 2 {
+		t.Errorf("logged %d frames, want 2", got)
+	}
+	if b.stats.RxPackets != 10 {
+		t.Errorf("RxPackets = %d, want 10 (sampling must not affect stats)", b.stats.RxPackets)
+	}
+}
+
+func TestHandleFrame_DumpsFirstFrames(t *testing.T) {
+	var buf bytes.Buffer
+	b := newTestBridge(t, nil)
+	b.logger.SetLevel(logging.LevelInfo)
+	b.logger.SetOutput(&buf)
+	b.dumpReceived = newFrameDumper(2)
+
+	frame := make([]byte, 64)
+	for i := 0; i < 5; i++ {
+		b.handleFrame(frame)
+	}
+
+	if got := strings.Count(buf.String(), "Received frame (64 bytes)"); got != 2 {
+		t.Errorf("dumped %d frames, want 2", got)
+	}
+	if !strings.Contains(buf.String(), "0x0030:") {
+		t.Errorf("dump missing hex lines: %q", buf.String())
+	}
+}
+
+func TestHandleFrame_CopiesBeforeQueueing(t *testing.T) {
+	b := newTestBridge(t, nil)
+
+	frame := make([]byte, 64)
+	frame[0] = 0xAA
+	b.handleFrame(frame)
+
+	// The receive buffer is reused for the next read
+	frame[0] = 0xBB
+
+	queued := <-b.framesToInject
+	if (*queued)[0] != 0xAA {
+		t.Errorf("queued frame changed with receive buffer: got 0x%02x, want 0xaa", (*queued)[0])
+	}
+	if len(*queued) != len(frame) {
+		t.Errorf("queued frame length = %d, want %d", len(*queued), len(frame))
+	}
+}
+
+func TestBridge_WithMockConn(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415}
+	conn := newMockConn(peer)
+	codec := newTestCodec(nil)
+
+	b, err := New(Config{
+		Transport: conn,
+		Codec:     codec,
+		Logger:    logging.NewLogger(logging.LevelError),
+		Mode:      transport.ModeConnect,
+		BatchRecv: true,
+		BatchSend: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	// Batching needs a transport.BatchConn
+	if b.batchRecv || b.batchSend {
+		t.Error("batching should be disabled for a non-batch Conn")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.recvLoop(ctx)
+	go b.sendLoop(ctx) // sends the PONG
+
+	// A PING from the peer is answered with a PONG
+	conn.Deliver(codec.EncodePing(42))
+
+	deadline := time.Now().Add(time.Second)
+	for len(conn.Sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	sent := conn.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	msg, err := codec.Decode(sent[0])
+	if err != nil {
+		t.Fatalf("failed to decode reply: %v", err)
+	}
+	if msg.Type != protocol.MsgPong || msg.Timestamp != 42 {
+		t.Errorf("reply = %s ts=%d, want PONG ts=42", protocol.MessageTypeName(msg.Type), msg.Timestamp)
+	}
+}
+
+func TestBridge_ConnectedEventIncludesPeerInfo(t *testing.T) {
+	emitter := &testutil.MockEmitter{}
+	conn := newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415})
+	conn.info = transport.PeerInfo{Version: protocol.ProtocolVersion, Secure: true}
+
+	b, err := New(Config{
+		Transport: conn,
+		Codec:     newTestCodec(nil),
+		Logger:    logging.NewLogger(logging.LevelError),
+		Emitter:   emitter,
+		Mode:      transport.ModeConnect,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	b.setState(StateConnected)
+
+	got := emitter.GetEvents(events.EventStateChanged)
+	if len(got) != 1 {
+		t.Fatalf("got %d state events, want 1", len(got))
+	}
+	data := got[0].Data.(events.StateChangedData)
+	if data.PeerAddr != "192.0.2.1:31415" || data.PeerVersion != protocol.ProtocolVersion || data.SecurityMode != "secure" {
+		t.Errorf("unexpected connected event: %+v", data)
+	}
+}
+
+func TestStats_LastActivity(t *testing.T) {
+	stats := &Stats{}
+	if !stats.LastTx().IsZero() || !stats.LastRx().IsZero() || !stats.LastFrameActivity().IsZero() {
+		t.Fatal("activity timestamps should start at zero")
+	}
+
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	stats.MarkTx(t0)
+	stats.MarkRx(t0.Add(time.Second))
+	if !stats.LastTx().Equal(t0) || !stats.LastRx().Equal(t0.Add(time.Second)) {
+		t.Errorf("LastTx/LastRx = %v / %v", stats.LastTx(), stats.LastRx())
+	}
+	if !stats.LastFrameActivity().Equal(t0.Add(time.Second)) {
+		t.Errorf("LastFrameActivity() = %v, want the later of the two", stats.LastFrameActivity())
+	}
+}
+
+func TestBridge_FrameActivityUpdatesLastTxRx(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	conn := newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415})
+	b, err := New(Config{
+		Transport: conn,
+		Codec:     newTestCodec(nil),
+		Logger:    logging.NewLogger(logging.LevelError),
+		Mode:      transport.ModeConnect,
+		Now:       clock.Now,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	b.handleFrame(make([]byte, 64))
+	if !b.stats.LastRx().Equal(clock.Now()) || !b.stats.LastTx().IsZero() {
+		t.Errorf("after receive: LastRx = %v, LastTx = %v", b.stats.LastRx(), b.stats.LastTx())
+	}
+
+	clock.Advance(time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.sendLoop(ctx)
+
+	bufp := getFrameBuf()
+	*bufp = (*bufp)[:64]
+	b.framesToSend <- bufp
+
+	deadline := time.Now().Add(time.Second)
+	for b.stats.LastTx().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !b.stats.LastTx().Equal(clock.Now()) {
+		t.Errorf("after send: LastTx = %v, want %v", b.stats.LastTx(), clock.Now())
+	}
+}
+
+func TestBridge_IsRemoteMAC(t *testing.T) {
+	b := newTestBridge(t, nil)
+	first := net.HardwareAddr{0x00, 0x50, 0xf2, 0x00, 0x00, 0x01}
+	second := net.HardwareAddr{0x00, 0x50, 0xf2, 0x00, 0x00, 0x02}
+
+	if b.IsRemoteMAC(first) {
+		t.Fatal("MAC known before any frame was received")
+	}
+	for _, mac := range []net.HardwareAddr{first, second, first} {
+		frame := make([]byte, 64)
+		copy(frame[6:12], mac)
+		b.handleFrame(frame)
+		putFrameBuf(<-b.framesToInject)
+	}
+	if !b.IsRemoteMAC(first) || !b.IsRemoteMAC(second) {
+		t.Error("source MACs of received frames not recorded")
+	}
+	if b.IsRemoteMAC(net.HardwareAddr{0x00, 0x50, 0xf2, 0x00, 0x00, 0x03}) || b.IsRemoteMAC(nil) {
+		t.Error("unseen MAC reported as remote")
+	}
+	if b.XboxMAC() != nil {
+		t.Error("XboxMAC() should be nil without a capture")
+	}
+}
+
+func TestBridge_IdleTimeout(t *testing.T) {
+	emitter := &testutil.MockEmitter{}
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+
+	b, err := New(Config{
+		Transport:   newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415}),
+		Codec:       newTestCodec(nil),
+		Logger:      logging.NewLogger(logging.LevelError),
+		Emitter:     emitter,
+		Mode:        transport.ModeConnect,
+		IdleTimeout: time.Minute,
+		Now:         clock.Now,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	// The idle period starts when the session connects
+	b.setState(StateConnected)
+	clock.Advance(59 * time.Second)
+	if b.checkIdle() {
+		t.Fatal("idle after 59s with a 1m timeout")
+	}
+
+	// A received frame resets it
+	b.handleFrame(make([]byte, 64))
+	clock.Advance(59 * time.Second)
+	if b.checkIdle() {
+		t.Fatal("idle 59s after a frame")
+	}
+
+	// Pings don't count as activity
+	b.handlePing(1)
+	clock.Advance(2 * time.Second)
+	if !b.checkIdle() {
+		t.Fatal("not idle 61s after the last frame")
+	}
+	select {
+	case <-b.stop:
+	default:
+		t.Error("checkIdle did not signal Run to stop")
+	}
+
+	b.setState(StateDisconnected)
+	got := emitter.GetEvents(events.EventStateChanged)
+	if data := got[len(got)-1].Data.(events.StateChangedData); data.State != "DISCONNECTED" || data.Reason != events.ReasonIdleTimeout {
+		t.Errorf("disconnect event = %+v, want DISCONNECTED with reason %q", data, events.ReasonIdleTimeout)
+	}
+}
+
+func TestBridge_RunReturnsIdleTimeout(t *testing.T) {
+	b, err := New(Config{
+		Transport:   newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415}),
+		Codec:       newTestCodec(nil),
+		Logger:      logging.NewLogger(logging.LevelError),
+		Mode:        transport.ModeConnect,
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- b.Run(context.Background()) }()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrIdleTimeout) {
+			t.Errorf("Run() = %v, want ErrIdleTimeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not stop after the idle timeout")
+	}
+}
+
+func TestBridge_MaxDuration(t *testing.T) {
+	emitter := &testutil.MockEmitter{}
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+
+	b, err := New(Config{
+		Transport:   newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415}),
+		Codec:       newTestCodec(nil),
+		Logger:      logging.NewLogger(logging.LevelError),
+		Emitter:     emitter,
+		Mode:        transport.ModeConnect,
+		MaxDuration: time.Hour,
+		Now:         clock.Now,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	// Traffic doesn't extend the session
+	b.setState(StateConnected)
+	clock.Advance(59 * time.Minute)
+	b.handleFrame(make([]byte, 64))
+	if b.checkMaxDuration() {
+		t.Fatal("session ended after 59m with a 1h limit")
+	}
+	clock.Advance(time.Minute)
+	if !b.checkMaxDuration() {
+		t.Fatal("session not ended after 1h")
+	}
+	select {
+	case <-b.stop:
+	default:
+		t.Error("checkMaxDuration did not signal Run to stop")
+	}
+
+	b.setState(StateDisconnected)
+	got := emitter.GetEvents(events.EventStateChanged)
+	if data := got[len(got)-1].Data.(events.StateChangedData); data.State != "DISCONNECTED" || data.Reason != events.ReasonMaxDuration {
+		t.Errorf("disconnect event = %+v, want DISCONNECTED with reason %q", data, events.ReasonMaxDuration)
+	}
+}
+
+func TestBridge_RunReturnsMaxDuration(t *testing.T) {
+	conn := newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415})
+	b, err := New(Config{
+		Transport:   conn,
+		Codec:       newTestCodec(nil),
+		Logger:      logging.NewLogger(logging.LevelError),
+		Mode:        transport.ModeConnect,
+		MaxDuration: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- b.Run(context.Background()) }()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrMaxDuration) {
+			t.Errorf("Run() = %v, want ErrMaxDuration", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not stop after the maximum duration")
+	}
+	if !conn.byeSent() {
+		t.Error("Run did not send BYE")
+	}
+}
+
+func TestParseDiagMode(t *testing.T) {
+	tests := map[string]DiagMode{"": DiagOff, "off": DiagOff, "Capture": DiagCapture, " transport ": DiagTransport}
+	for s, want := range tests {
+		if got, err := ParseDiagMode(s); err != nil || got != want {
+			t.Errorf("ParseDiagMode(%q) = %q, %v, want %q", s, got, err, want)
+		}
+	}
+	if _, err := ParseDiagMode("inject"); err == nil {
+		t.Error("ParseDiagMode(\"inject\") succeeded")
+	}
+}
+
+func TestBridge_CaptureDiag(t *testing.T) {
+	if _, err := New(Config{Codec: newTestCodec(nil)}); err == nil {
+		t.Error("New() without a transport succeeded outside capture diagnostics")
+	}
+	b, err := New(Config{
+		Codec:       newTestCodec(nil),
+		Logger:      logging.NewLogger(logging.LevelError),
+		MaxDuration: 20 * time.Millisecond,
+		Diag:        DiagCapture,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	frame := makeTestFrame(0x01)
+	bufp := getFrameBuf()
+	*bufp = append((*bufp)[:0], frame...)
+	b.framesToSend <- bufp
+
+	if err := b.Run(context.Background()); !errors.Is(err, ErrMaxDuration) {
+		t.Fatalf("Run() = %v, want ErrMaxDuration", err)
+	}
+	if got := atomic.LoadUint64(&b.stats.TxPackets); got != 1 {
+		t.Errorf("TxPackets = %d, want 1", got)
+	}
+	if got := atomic.LoadUint64(&b.stats.TxBytes); got != uint64(len(frame)) {
+		t.Errorf("TxBytes = %d, want %d", got, len(frame))
+	}
+	if b.PeerAddr() != nil {
+		t.Errorf("PeerAddr() = %v, want nil", b.PeerAddr())
+	}
+}
+
+func TestBridge_TransportDiag(t *testing.T) {
+	conn := newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415})
+	codec := newTestCodec(nil)
+	b, err := New(Config{
+		Transport:   conn,
+		Codec:       codec,
+		Logger:      logging.NewLogger(logging.LevelError),
+		Mode:        transport.ModeConnect,
+		MaxDuration: 50 * time.Millisecond,
+		Diag:        DiagTransport,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	msg, err := codec.EncodeFrame(makeTestFrame(0x02))
+	if err != nil {
+		t.Fatalf("EncodeFrame() failed: %v", err)
+	}
+	conn.Deliver(msg)
+
+	if err := b.Run(context.Background()); !errors.Is(err, ErrMaxDuration) {
+		t.Fatalf("Run() = %v, want ErrMaxDuration", err)
+	}
+	if got := atomic.LoadUint64(&b.stats.RxPackets); got != 1 {
+		t.Errorf("RxPackets = %d, want 1", got)
+	}
+	if got := len(b.framesToInject); got != 0 {
+		t.Errorf("queued %d frames for injection, want 0", got)
+	}
+}
+
+func TestBridge_OverTransportPair(t *testing.T) {
+	const frames = 20
+	connA, connB := transporttest.Pair(transporttest.Options{})
+	newBridge := func(conn transport.Conn, mode transport.Mode) *Bridge {
+		b, err := New(Config{
+			Transport: conn,
+			Codec:     newTestCodec(nil),
+			Logger:    logging.NewLogger(logging.LevelError),
+			Mode:      mode,
+		})
+		if err != nil {
+			t.Fatalf("failed to create bridge: %v", err)
+		}
+		return b
+	}
+	a := newBridge(connA, transport.ModeConnect)
+	b := newBridge(connB, transport.ModeListen)
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	errA, errB := make(chan error, 1), make(chan error, 1)
+	go func() { errA <- a.Run(ctxA) }()
+	go func() { errB <- b.Run(context.Background()) }()
+
+	// Frames captured on A are injected on B, in order and intact
+	for i := 0; i < frames; i++ {
+		bufp := getFrameBuf()
+		*bufp = (*bufp)[:copy(*bufp, testutil.SequencedFrame(uint32(i)))]
+		a.framesToSend <- bufp
+	}
+	for i := 0; i < frames; i++ {
+		select {
+		case bufp := <-b.framesToInject:
+			if !bytes.Equal(*bufp, testutil.SequencedFrame(uint32(i))) {
+				got, _ := testutil.FrameSequence(*bufp)
+				t.Fatalf("injected frame %d at position %d", got, i)
+			}
+			putFrameBuf(bufp)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d frames reached B", i, frames)
+		}
+	}
+
+	// B answers A's PING
+	if !testutil.WaitFor(5*time.Second, func() bool { return a.State() == StateConnected }) {
+		t.Fatal("A never connected")
+	}
+	a.sendPing()
+	if !testutil.WaitFor(5*time.Second, func() bool { return a.stats.GetRTTCurrent() > 0 }) {
+		t.Error("no PONG from B")
+	}
+
+	// A shutting down hangs up on B
+	cancelA()
+	for _, side := range []struct {
+		name string
+		errc chan error
+		want error
+	}{{"A", errA, nil}, {"B", errB, ErrPeerDisconnected}} {
+		select {
+		case err := <-side.errc:
+			if !errors.Is(err, side.want) {
+				t.Errorf("%s: Run() = %v, want %v", side.name, err, side.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Run() did not return", side.name)
+		}
+	}
+}
+
+func TestBridge_SessionLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.NewLogger(logging.LevelInfo)
+	logger.SetOutput(&buf)
+
+	b, err := New(Config{
+		Transport:   newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415}),
+		Codec:       newTestCodec(nil),
+		Logger:      logger,
+		Mode:        transport.ModeConnect,
+		MaxDuration: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+	if err := b.Run(context.Background()); !errors.Is(err, ErrMaxDuration) {
+		t.Fatalf("Run() = %v, want ErrMaxDuration", err)
+	}
+
+	re := regexp.MustCompile(`\[INFO\]  session=\d+ peer=192\.0\.2\.1:31415 Bridge active!`)
+	if !re.MatchString(buf.String()) {
+		t.Errorf("session fields missing from connected lines:\n%s", buf.String())
+	}
+
+	// The caller's logger is not tagged
+	buf.Reset()
+	logger.Info("after")
+	if strings.Contains(buf.String(), "session=") {
+		t.Errorf("caller's logger picked up session fields: %q", buf.String())
+	}
+}
+
+func TestBridge_Callbacks(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415}
+	conn := newMockConn(peer)
+
+	var mu sync.Mutex
+	var connected []net.Addr
+	var reasons []string
+	var stats []*Stats
+	b, err := New(Config{
+		Transport:     conn,
+		Codec:         newTestCodec(nil),
+		Logger:        logging.NewLogger(logging.LevelError),
+		Mode:          transport.ModeConnect,
+		StatsInterval: 5 * time.Millisecond,
+		MaxDuration:   50 * time.Millisecond,
+		OnConnected: func(addr net.Addr) {
+			mu.Lock()
+			defer mu.Unlock()
+			connected = append(connected, addr)
+		},
+		OnDisconnected: func(reason string) {
+			mu.Lock()
+			defer mu.Unlock()
+			reasons = append(reasons, reason)
+		},
+		OnStats: func(s *Stats) {
+			mu.Lock()
+			defer mu.Unlock()
+			stats = append(stats, s)
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+	atomic.AddUint64(&b.stats.TxPackets, 3)
+
+	if err := b.Run(context.Background()); !errors.Is(err, ErrMaxDuration) {
+		t.Fatalf("Run() = %v, want ErrMaxDuration", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(connected) != 1 || !addrEqual(connected[0], peer) {
+		t.Errorf("OnConnected calls = %v, want [%v]", connected, peer)
+	}
+	if len(reasons) != 1 || reasons[0] != events.ReasonMaxDuration {
+		t.Errorf("OnDisconnected calls = %q, want [%q]", reasons, events.ReasonMaxDuration)
+	}
+	// At least one periodic snapshot, then the final totals
+	if len(stats) < 2 {
+		t.Fatalf("OnStats called %d times, want periodic and final calls", len(stats))
+	}
+	for i, s := range stats {
+		if s == b.stats || s.TxPackets != 3 || s.StartTime.IsZero() {
+			t.Errorf("OnStats call %d: got %+v, want a snapshot with TxPackets=3 and StartTime set", i, s)
+		}
+	}
+}
+
+func TestBridge_CallbacksOptional(t *testing.T) {
+	b := newTestBridge(t, nil)
+	b.setState(StateConnected)
+	b.printStats()
+	b.setState(StateDisconnected)
+	b.printSummary()
+}
+
+// recordingWriter is a frameWriter that records the frames written to it.
+type recordingWriter struct {
+	frames chan []byte
+}
+
+func (w *recordingWriter) WritePacket(frame []byte) error {
+	w.frames <- bytes.Clone(frame)
+	return nil
+}
+
+// flakyWriter is a frameWriter whose first len(errs) writes fail with errs
+// in order.
+type flakyWriter struct {
+	errs   []error
+	writes int
+}
+
+func (w *flakyWriter) WritePacket([]byte) error {
+	w.writes++
+	if len(w.errs) == 0 {
+		return nil
+	}
+	err := w.errs[0]
+	w.errs = w.errs[1:]
+	return err
+}
+
+func TestWriteFrame_Retries(t *testing.T) {
+	enobufs := fmt.Errorf("send: %w", syscall.ENOBUFS)
+	tests := []struct {
+		name        string
+		errs        []error
+		wantErr     bool
+		wantWrites  int
+		wantRetries uint64
+	}{
+		{"success", nil, false, 1, 0},
+		{"transient then success", []error{enobufs, enobufs}, false, 3, 2},
+		{"transient past the limit", []error{enobufs, enobufs, enobufs, enobufs}, true, InjectRetryLimit + 1, InjectRetryLimit},
+		{"permanent", []error{errors.New("device gone")}, true, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBridge(t, nil)
+			b.injectRetryDelay = time.Microsecond
+			w := &flakyWriter{errs: tt.errs}
+
+			err := b.writeFrame(w, makeTestFrame(0x01))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("writeFrame() = %v, want error %v", err, tt.wantErr)
+			}
+			if w.writes != tt.wantWrites {
+				t.Errorf("writes = %d, want %d", w.writes, tt.wantWrites)
+			}
+			if got := atomic.LoadUint64(&b.stats.InjectRetries); got != tt.wantRetries {
+				t.Errorf("InjectRetries = %d, want %d", got, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestWriteFrames_CountsDrops(t *testing.T) {
+	b := newTestBridge(t, nil)
+	b.injectRetryDelay = time.Microsecond
+	w := &flakyWriter{errs: []error{errors.New("device gone")}}
+
+	for range 2 {
+		bufp := getFrameBuf()
+		*bufp = append((*bufp)[:0], makeTestFrame(0x01)...)
+		b.framesToInject <- bufp
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.writeFrames(ctx, w)
+		close(done)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(b.framesToInject) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if got := atomic.LoadUint64(&b.stats.InjectDrops); got != 1 {
+		t.Errorf("InjectDrops = %d, want 1", got)
+	}
+}
+
+func TestBridge_InjectFrame(t *testing.T) {
+	b := newTestBridge(t, nil)
+	frame := makeTestFrame(0xAB)
+
+	if err := b.InjectFrame(frame); !errors.Is(err, ErrCaptureNotReady) {
+		t.Errorf("InjectFrame() before capture = %v, want ErrCaptureNotReady", err)
+	}
+
+	close(b.captureReady) // as SetCapture does
+	if err := b.InjectFrame(frame[:protocol.MinEthernetFrame-1]); err == nil {
+		t.Error("InjectFrame() accepted a runt frame")
+	}
+	if err := b.InjectFrame(make([]byte, protocol.MaxFrameSize+1)); err == nil {
+		t.Error("InjectFrame() accepted an oversized frame")
+	}
+
+	if err := b.InjectFrame(frame); err != nil {
+		t.Fatalf("InjectFrame() failed: %v", err)
+	}
+	frame[0] = 0 // the bridge must have its own copy
+
+	w := &recordingWriter{frames: make(chan []byte, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.writeFrames(ctx, w)
+
+	select {
+	case got := <-w.frames:
+		if !bytes.Equal(got, makeTestFrame(0xAB)) {
+			t.Errorf("WritePacket got %x, want the injected frame", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("injected frame never reached WritePacket")
+	}
+}
+
+func TestBridge_InjectFrameQueueFull(t *testing.T) {
+	b := newTestBridge(t, nil)
+	close(b.captureReady)
+	for range ChannelBufferSize {
+		if err := b.InjectFrame(makeTestFrame(1)); err != nil {
+			t.Fatalf("InjectFrame() failed: %v", err)
+		}
+	}
+	if err := b.InjectFrame(makeTestFrame(1)); !errors.Is(err, ErrInjectQueueFull) {
+		t.Errorf("InjectFrame() on a full queue = %v, want ErrInjectQueueFull", err)
+	}
+}
+
+func TestHandleStdinLine(t *testing.T) {
+	var buf bytes.Buffer
+	b := newTestBridge(t, nil)
+	b.logger.SetOutput(&buf)
+	b.logger.SetLevel(logging.LevelInfo)
+
+	for _, want := range []logging.Level{logging.LevelDebug, logging.LevelTrace, logging.LevelInfo} {
+		buf.Reset()
+		b.handleStdinLine("d\r")
+		if got := b.logger.GetLevel(); got != want {
+			t.Fatalf("level after d = %s, want %s", got, want)
+		}
+		if !strings.Contains(buf.String(), "Log level: "+want.String()) {
+			t.Errorf("no echo of the new level in %q", buf.String())
+		}
+	}
+
+	b.logger.SetLevel(logging.LevelWarn)
+	b.handleStdinLine("D")
+	if got := b.logger.GetLevel(); got != logging.LevelInfo {
+		t.Errorf("level after d from WARN = %s, want INFO", got)
+	}
+
+	// Enter alone still asks for stats; d never does
+	b.handleStdinLine("d")
+	select {
+	case <-b.stdinCh:
+		t.Error("d requested stats")
+	default:
+	}
+	go b.handleStdinLine("")
+	select {
+	case <-b.stdinCh:
+	case <-time.After(2 * time.Second):
+		t.Error("Enter did not request stats")
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	file, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for name, f := range map[string]*os.File{"nil": nil, "pipe": pr, "/dev/null": devNull, "file": file} {
+		if isTerminal(f) {
+			t.Errorf("isTerminal(%s) = true", name)
+		}
+	}
+}
+
+func TestStdinLoop_IgnoresPipedInput(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	b := newTestBridge(t, nil)
+	b.stdin = pr
+	b.logger.SetLevel(logging.LevelInfo)
+
+	// Lines piped in are never taken for Enter or d
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		b.stdinLoop(ctx)
+		close(done)
+	}()
+	fmt.Fprint(pw, "\nd\n\n")
+	select {
+	case <-b.stdinCh:
+		t.Error("piped newline requested stats")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := b.logger.GetLevel(); got != logging.LevelInfo {
+		t.Errorf("level after piped d = %s, want INFO", got)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("stdinLoop still running with piped stdin")
+	}
+}
+
+// failingReader is a frameReader whose reads always fail.
+type failingReader struct {
+	reads atomic.Int32
+}
+
+func (r *failingReader) ReadPacketInto([]byte) (int, error) {
+	r.reads.Add(1)
+	return 0, errors.New("interface went away")
+}
+
+func TestReadFrames_BacksOffAndStopsOnPersistentErrors(t *testing.T) {
+	var buf bytes.Buffer
+	emitter := &testutil.MockEmitter{}
+	b := newTestBridge(t, emitter)
+	b.logger.SetLevel(logging.LevelWarn)
+	b.logger.SetOutput(&buf)
+	b.captureRetryMin = time.Microsecond
+	b.captureRetryMax = 50 * time.Microsecond
+
+	r := &failingReader{}
+	done := make(chan struct{})
+	go func() {
+		b.readFrames(context.Background(), r)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("readFrames kept retrying past CaptureErrorLimit")
+	}
+
+	if got := int(r.reads.Load()); got != CaptureErrorLimit {
+		t.Errorf("reads = %d, want %d", got, CaptureErrorLimit)
+	}
+	if got := strings.Count(buf.String(), "capture errors in last"); got != 1 {
+		t.Errorf("logged %d capture error summaries, want 1:\n%s", got, buf.String())
+	}
+	if !strings.Contains(buf.String(), "10 capture errors in last") {
+		t.Errorf("summary doesn't count all %d errors:\n%s", CaptureErrorLimit, buf.String())
+	}
+
+	select {
+	case <-b.stop:
+	default:
+		t.Fatal("persistent capture errors did not stop the session")
+	}
+	if !errors.Is(b.stopErr, ErrCaptureFailed) {
+		t.Errorf("stopErr = %v, want ErrCaptureFailed", b.stopErr)
+	}
+	errs := emitter.GetEvents(events.EventError)
+	if len(errs) != 1 || errs[0].Data.(events.ErrorData).Reason != events.ReasonCaptureFailed {
+		t.Errorf("error events = %+v, want one with reason %q", errs, events.ReasonCaptureFailed)
+	}
+}
+
+// scriptedReader is a frameReader that returns frames in order, then
+// cancels the read loop.
+type scriptedReader struct {
+	frames [][]byte
+	cancel context.CancelFunc
+}
+
+func (r *scriptedReader) ReadPacketInto(buf []byte) (int, error) {
+	if len(r.frames) == 0 {
+		r.cancel()
+		return 0, nil
+	}
+	n := copy(buf, r.frames[0])
+	r.frames = r.frames[1:]
+	return n, nil
+}
+
+func TestReadFrames_EthernetIIOnly(t *testing.T) {
+	ethernetII := makeTestFrame(0x01)
+	// 802.3 frame: length field 46, then an LLC header (STP)
+	llc := makeTestFrame(0x02)
+	llc[12], llc[13] = 0x00, 0x2E
+	copy(llc[14:], []byte{0x42, 0x42, 0x03})
+
+	for _, only := range []bool{false, true} {
+		b := newTestBridge(t, nil)
+		b.ethernetIIOnly = only
+
+		ctx, cancel := context.WithCancel(context.Background())
+		b.readFrames(ctx, &scriptedReader{
+			frames: [][]byte{llc, ethernetII, llc},
+			cancel: cancel,
+		})
+		cancel()
+
+		wantQueued, wantDropped := 3, uint64(0)
+		if only {
+			wantQueued, wantDropped = 1, 2
+		}
+		if got := len(b.framesToSend); got != wantQueued {
+			t.Errorf("EthernetIIOnly=%v: queued %d frames, want %d", only, got, wantQueued)
+		}
+		if got := atomic.LoadUint64(&b.stats.NonEthernetII); got != wantDropped {
+			t.Errorf("EthernetIIOnly=%v: NonEthernetII = %d, want %d", only, got, wantDropped)
+		}
+		if only {
+			if got := *<-b.framesToSend; !bytes.Equal(got, ethernetII) {
+				t.Errorf("queued frame = %x, want the Ethernet II one", got)
+			}
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	frames := [][]byte{
+		testutil.SequencedFrame(0), // passed through
+		testutil.SequencedFrame(1), // dropped
+		testutil.SequencedFrame(2), // rewritten in place
+		testutil.SequencedFrame(3), // replaced
+	}
+	rewritten := testutil.SequencedFrame(2)
+	rewritten[0] = 0x01 // broadcast -> multicast
+	replacement := testutil.SequencedFrame(30)
+	want := [][]byte{frames[0], rewritten, replacement}
+
+	for _, dir := range []Direction{DirTx, DirRx} {
+		t.Run(dir.String(), func(t *testing.T) {
+			b := newTestBridge(t, nil)
+			var seen []uint32
+			b.Use(func(d Direction, frame []byte) ([]byte, bool) {
+				if d != dir {
+					t.Errorf("middleware called with direction %s", d)
+				}
+				switch seq, _ := testutil.FrameSequence(frame); seq {
+				case 1:
+					return nil, false
+				case 2:
+					frame[0] = 0x01
+				case 3:
+					return testutil.SequencedFrame(30), true
+				}
+				return frame, true
+			})
+			// Later middleware sees what earlier middleware forwarded
+			b.Use(func(_ Direction, frame []byte) ([]byte, bool) {
+				seq, _ := testutil.FrameSequence(frame)
+				seen = append(seen, seq)
+				return frame, true
+			})
+
+			var queue chan *[]byte
+			if dir == DirTx {
+				ctx, cancel := context.WithCancel(context.Background())
+				b.readFrames(ctx, &scriptedReader{frames: frames, cancel: cancel})
+				cancel()
+				queue = b.framesToSend
+			} else {
+				for _, frame := range frames {
+					b.handleFrame(frame)
+				}
+				queue = b.framesToInject
+			}
+
+			if len(queue) != len(want) {
+				t.Fatalf("queued %d frames, want %d", len(queue), len(want))
+			}
+			for i, w := range want {
+				if got := *<-queue; !bytes.Equal(got, w) {
+					t.Errorf("frame %d = %x, want %x", i, got, w)
+				}
+			}
+			if fmt.Sprint(seen) != "[0 2 30]" {
+				t.Errorf("second middleware saw sequences %v, want [0 2 30]", seen)
+			}
+			if got := atomic.LoadUint64(&b.stats.Filtered); got != 1 {
+				t.Errorf("Filtered = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestEstimateClockSkew(t *testing.T) {
+	const ms = int64(time.Millisecond)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	tests := []struct {
+		name            string
+		sent, received  int64
+		peerClock       int64
+		wantSkew        time.Duration
+		wantUncertainty time.Duration
+		significant     bool
+	}{
+		{"in sync", base, base + 20*ms, base + 10*ms, 0, 10 * time.Millisecond, false},
+		{"peer ahead", base, base + 20*ms, base + 2010*ms, 2 * time.Second, 10 * time.Millisecond, true},
+		{"peer behind", base, base + 40*ms, base - 1480*ms, -1500 * time.Millisecond, 20 * time.Millisecond, true},
+		{"within threshold", base, base + 20*ms, base + 1000*ms, 990 * time.Millisecond, 10 * time.Millisecond, false},
+		// 1.1s off, but a 600ms round trip could account for 300ms of it
+		{"within uncertainty", base, base + 600*ms, base + 1400*ms, 1100 * time.Millisecond, 300 * time.Millisecond, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skew, uncertainty := estimateClockSkew(tt.sent, tt.peerClock, tt.received)
+			if skew != tt.wantSkew || uncertainty != tt.wantUncertainty {
+				t.Errorf("estimateClockSkew() = %v ±%v, want %v ±%v", skew, uncertainty, tt.wantSkew, tt.wantUncertainty)
+			}
+			if got := clockSkewSignificant(skew, uncertainty); got != tt.significant {
+				t.Errorf("clockSkewSignificant(%v, %v) = %v, want %v", skew, uncertainty, got, tt.significant)
+			}
+		})
+	}
+}
+
+func TestOneWayDelays(t *testing.T) {
+	const ms = int64(time.Millisecond)
+	sent := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	tests := []struct {
+		name             string
+		upTrue, downTrue int64 // actual trip times
+		skew             int64 // how far the peer's clock is ahead
+		wantUp, wantDown time.Duration
+		wantOK           bool
+	}{
+		{"synchronized, symmetric", 10 * ms, 10 * ms, 0, 10 * time.Millisecond, 10 * time.Millisecond, true},
+		{"synchronized, slow uplink", 30 * ms, 8 * ms, 0, 30 * time.Millisecond, 8 * time.Millisecond, true},
+		// Skew shifts time from one direction to the other; RTT is unchanged
+		{"peer 5ms ahead", 30 * ms, 8 * ms, 5 * ms, 35 * time.Millisecond, 3 * time.Millisecond, true},
+		{"peer 5ms behind", 30 * ms, 8 * ms, -5 * ms, 25 * time.Millisecond, 13 * time.Millisecond, true},
+		{"peer far ahead", 10 * ms, 10 * ms, 500 * ms, 510 * time.Millisecond, -490 * time.Millisecond, false},
+		{"peer far behind", 10 * ms, 10 * ms, -500 * ms, -490 * time.Millisecond, 510 * time.Millisecond, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peerClock := sent + tt.upTrue + tt.skew
+			received := sent + tt.upTrue + tt.downTrue
+			up, down, ok := oneWayDelays(sent, peerClock, received)
+			if up != tt.wantUp || down != tt.wantDown || ok != tt.wantOK {
+				t.Errorf("oneWayDelays() = %v, %v, %v; want %v, %v, %v", up, down, ok, tt.wantUp, tt.wantDown, tt.wantOK)
+			}
+			if up+down != time.Duration(tt.upTrue+tt.downTrue) {
+				t.Errorf("up+down = %v, want the RTT %v", up+down, time.Duration(tt.upTrue+tt.downTrue))
+			}
+		})
+	}
+}
+
+func TestHandlePong_ClockSkew(t *testing.T) {
+	var buf bytes.Buffer
+	b := newTestBridge(t, nil)
+	b.logger.SetLevel(logging.LevelWarn)
+	b.logger.SetOutput(&buf)
+
+	// Off by default: a PONG carrying a clock is ignored
+	sent := time.Now().UnixNano()
+	b.pendingPing = sent
+	b.handlePong(sent, sent+int64(time.Hour))
+	if _, ok := b.ClockSkew(); ok {
+		t.Error("ClockSkew() known with Config.ClockSkew off")
+	}
+
+	b.clockSkewCheck = true
+	for range 2 {
+		sent = time.Now().UnixNano()
+		b.pendingPing = sent
+		b.handlePong(sent, sent+int64(time.Hour))
+	}
+	skew, ok := b.ClockSkew()
+	if !ok || skew < 59*time.Minute || skew > 61*time.Minute {
+		t.Errorf("ClockSkew() = %v, %v; want about 1h", skew, ok)
+	}
+	if got := strings.Count(buf.String(), "ahead of ours"); got != 1 {
+		t.Errorf("logged %d skew warnings, want 1:\n%s", got, buf.String())
+	}
+}
+
+// makeTestFrame returns a minimum-size Ethernet frame whose payload is
+// filled with fill.
+func makeTestFrame(fill byte) []byte {
+	frame := make([]byte, 60)
+	copy(frame, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x50, 0xf2, 0x00, 0x00, 0x01, 0x08, 0x00})
+	for i := 14; i < len(frame); i++ {
+		frame[i] = fill
+	}
+	return frame
+}
+
+func TestReadFrames_DropsLoopedInjectedFrames(t *testing.T) {
+	var buf bytes.Buffer
+	emitter := &testutil.MockEmitter{}
+	b := newTestBridge(t, emitter)
+	b.logger.SetLevel(logging.LevelWarn)
+	b.logger.SetOutput(&buf)
+	b.loops = newLoopDetector(LoopWindow)
+
+	injected := makeTestFrame(0x01)
+	other := makeTestFrame(0x02)
+	b.loops.injected(injected, b.now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.readFrames(ctx, &scriptedReader{
+		frames: [][]byte{injected, other, injected},
+		cancel: cancel,
+	})
+
+	if got := atomic.LoadUint64(&b.stats.LoopedFrames); got != 2 {
+		t.Errorf("LoopedFrames = %d, want 2", got)
+	}
+	if got := len(b.framesToSend); got != 1 {
+		t.Fatalf("queued %d frames, want only the non-looped one", got)
+	}
+	if got := *<-b.framesToSend; !bytes.Equal(got, other) {
+		t.Errorf("queued frame = %x, want %x", got, other)
+	}
+	if got := strings.Count(buf.String(), "looping injected frames"); got != 1 {
+		t.Errorf("logged %d loop warnings, want 1:\n%s", got, buf.String())
+	}
+	errs := emitter.GetEvents(events.EventError)
+	if len(errs) != 1 || errs[0].Data.(events.ErrorData).Reason != events.ReasonFrameLoop {
+		t.Errorf("error events = %+v, want one with reason %q", errs, events.ReasonFrameLoop)
+	}
+}
+
+func TestLoopDetector_Window(t *testing.T) {
+	d := newLoopDetector(time.Second)
+	start := time.Unix(1000, 0)
+	frame := makeTestFrame(0x01)
+
+	if d.looped(frame, start) {
+		t.Error("frame reported as looped before it was injected")
+	}
+	d.injected(frame, start)
+	if !d.looped(frame, start.Add(500*time.Millisecond)) {
+		t.Error("frame within the window not reported as looped")
+	}
+	if d.looped(makeTestFrame(0x02), start) {
+		t.Error("different frame reported as looped")
+	}
+	if d.looped(frame, start.Add(2*time.Second)) {
+		t.Error("frame outside the window reported as looped")
+	}
+}
+
+func TestCaptureRetryDelay(t *testing.T) {
+	b := newTestBridge(t, nil)
+
+	want := []time.Duration{10, 20, 40, 80, 160, 320, 640, 1280, 2000, 2000}
+	for i, w := range want {
+		if got := b.captureRetryDelay(i + 1); got != w*time.Millisecond {
+			t.Errorf("captureRetryDelay(%d) = %v, want %v", i+1, got, w*time.Millisecond)
+		}
+	}
+}
+
+// fakeClock is a manually advanced clock for Config.Now.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// Now returns the current fake time.
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestCodec is protocol.NewCodec for keys DeriveKey is known to accept.
+func newTestCodec(key []byte) *protocol.Codec {
+	codec, err := protocol.NewCodec(key)
+	if err != nil {
+		panic(err)
+	}
+	return codec
+}
+
+// mockConn is an in-memory transport.Conn for testing.
+// Messages queued with Deliver are returned by Recv; sent messages are recorded.
+type mockConn struct {
+	mu       sync.Mutex
+	peer     net.Addr
+	info     transport.PeerInfo
+	sent     [][]byte
+	deadline time.Time
+	incoming chan []byte
+	closed   bool
+	byes     int
+}
+
+// newMockConn creates a mock connection that appears connected to peer.
+func newMockConn(peer net.Addr) *mockConn {
+	return &mockConn{
+		peer:     peer,
+		incoming: make(chan []byte, 64),
+	}
+}
+
+// Deliver queues data to be returned by a later Recv.
+func (m *mockConn) Deliver(data []byte) {
+	m.incoming <- append([]byte(nil), data...)
+}
+
+// Sent returns copies of all messages passed to Send.
+func (m *mockConn) Sent() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([][]byte, len(m.sent))
+	copy(result, m.sent)
+	return result
+}
+
+// WaitForPeer returns immediately.
+func (m *mockConn) WaitForPeer(ctx context.Context) error { return nil }
+
+// Connect returns immediately.
+func (m *mockConn) Connect(ctx context.Context) error { return nil }
+
+// Send records a copy of data.
+func (m *mockConn) Send(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return net.ErrClosed
+	}
+	m.sent = append(m.sent, append([]byte(nil), data...))
+	return nil
+}
+
+// Recv returns the next delivered message, or a timeout error once the
+// read deadline passes.
+func (m *mockConn) Recv(buf []byte) (int, net.Addr, error) {
+	m.mu.Lock()
+	deadline := m.deadline
+	m.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case data := <-m.incoming:
+		return copy(buf, data), m.peer, nil
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// SetReadDeadline sets the deadline for Recv.
+func (m *mockConn) SetReadDeadline(deadline time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deadline = deadline
+	return nil
+}
+
+// SendBye records that a BYE was sent and returns nil.
+func (m *mockConn) SendBye() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.byes++
+	return nil
+}
+
+// byeSent reports whether SendBye was called.
+func (m *mockConn) byeSent() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.byes > 0
+}
+
+// PeerAddr returns the peer passed to newMockConn.
+func (m *mockConn) PeerAddr() net.Addr { return m.peer }
+
+// PeerInfo returns the info set on the mock.
+func (m *mockConn) PeerInfo() transport.PeerInfo { return m.info }
+
+// HandshakeFailures always returns 0.
+func (m *mockConn) HandshakeFailures() uint64 { return 0 }
+
+// Close marks the connection closed; later sends fail.
+func (m *mockConn) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func TestEtherTypeCounters_Classify(t *testing.T) {
+	var c etherTypeCounters
+	for _, etherType := range []uint16{0x0800, 0x0800, 0x0806, 0x86DD, 0x8100, 0x0000} {
+		c.count(etherType)
+	}
+
+	want := EtherTypeCounts{IPv4: 2, ARP: 1, IPv6: 1, Other: 2}
+	if got := c.load(); got != want {
+		t.Errorf("counts = %+v, want %+v", got, want)
+	}
+	if got := want.String(); got != "IPv4 2 | ARP 1 | IPv6 1 | other 2" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestHandleFrame_CountsEtherTypes(t *testing.T) {
+	b := newTestBridge(t, nil)
+
+	arp := makeTestFrame(0x00)
+	arp[12], arp[13] = 0x08, 0x06
+	b.handleFrame(makeTestFrame(0x01))
+	b.handleFrame(arp)
+	b.handleFrame(arp)
+	b.handleFrame([]byte{0x01, 0x02}) // runt: no EtherType
+
+	tx, rx := b.stats.EtherTypes()
+	if want := (EtherTypeCounts{IPv4: 1, ARP: 2, Other: 1}); rx != want {
+		t.Errorf("rx = %+v, want %+v", rx, want)
+	}
+	if tx != (EtherTypeCounts{}) {
+		t.Errorf("tx = %+v, want zero", tx)
+	}
+}
+
+func TestErrorAggregator_Summarizes(t *testing.T) {
+	start := time.Unix(1000, 0)
+	a := newErrorAggregator("capture error", 30*time.Second, start)
+
+	for i := 0; i < 10; i++ {
+		a.add(errors.New("read: network is down"))
+	}
+	a.add(errors.New("timeout"))
+	a.add(errors.New("timeout"))
+
+	if a.due(start.Add(10 * time.Second)) {
+		t.Error("summary due before the interval")
+	}
+	if !a.due(start.Add(30 * time.Second)) {
+		t.Error("summary not due after the interval")
+	}
+	want := "12 capture errors in last 30s: read: network is down (10 times; 2 distinct errors)"
+	if got := a.flush(start.Add(30 * time.Second)); got != want {
+		t.Errorf("flush() = %q, want %q", got, want)
+	}
+	if got := a.flush(start.Add(60 * time.Second)); got != "" {
+		t.Errorf("second flush() = %q, want empty", got)
+	}
+
+	a.add(errors.New("timeout"))
+	if got, want := a.flush(start.Add(90*time.Second)), "1 capture error in last 30s: timeout"; got != want {
+		t.Errorf("flush() = %q, want %q", got, want)
+	}
+}
+
+func TestOrderedWorkers_DeliversInSubmissionOrder(t *testing.T) {
+	const jobs = 500
+	var delivered []int
+	done := make(chan struct{})
+
+	w := newOrderedWorkers(4, func(job *pipelineJob) {
+		// Finish out of order: later jobs are often faster
+		if job.n%3 == 0 {
+			time.Sleep(50 * time.Microsecond)
+		}
+	}, func(job *pipelineJob) {
+		delivered = append(delivered, job.n)
+		if len(delivered) == jobs {
+			close(done)
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx)
+
+	for i := 0; i < jobs; i++ {
+		if !w.submit(ctx, func(job *pipelineJob) { job.n = i }) {
+			t.Fatal("submit failed")
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("delivered %d of %d jobs", len(delivered), jobs)
+	}
+	for i, n := range delivered {
+		if n != i {
+			t.Fatalf("job %d delivered at position %d", n, i)
+		}
+	}
+}
+
+func TestBridge_WorkersKeepFrameOrder(t *testing.T) {
+	const frames = 200
+	key := []byte("parallel-test-key")
+	conn := newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415})
+	codec := newTestCodec(key)
+	b, err := New(Config{
+		Transport: conn,
+		Codec:     codec,
+		Logger:    logging.NewLogger(logging.LevelError),
+		Mode:      transport.ModeConnect,
+		Workers:   4,
+	})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.sendParallelLoop(ctx)
+	go b.recvParallelLoop(ctx)
+
+	// Send: captured frames go out in order with increasing nonces, so the
+	// peer's replay check accepts every one.
+	for i := 0; i < frames; i++ {
+		bufp := getFrameBuf()
+		*bufp = (*bufp)[:copy(*bufp, testutil.SequencedFrame(uint32(i)))]
+		b.framesToSend <- bufp
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(conn.Sent()) < frames && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	peer := newTestCodec(key)
+	for i, data := range conn.Sent() {
+		msg, err := peer.Decode(data)
+		if err != nil {
+			t.Fatalf("peer rejected sent message %d: %v", i, err)
+		}
+		if got, ok := testutil.FrameSequence(msg.Frame); !ok || got != uint32(i) {
+			t.Fatalf("sent frame %d at position %d", got, i)
+		}
+	}
+	if got := len(conn.Sent()); got != frames {
+		t.Fatalf("sent %d frames, want %d", got, frames)
+	}
+
+	// Receive: frames are queued for injection in the order they arrived.
+	go func() {
+		for i := 0; i < frames; i++ {
+			data, _ := peer.EncodeFrame(testutil.SequencedFrame(uint32(i)))
+			conn.Deliver(data)
+		}
+	}()
+	for i := 0; i < frames; i++ {
+		select {
+		case bufp := <-b.framesToInject:
+			if got, ok := testutil.FrameSequence(*bufp); !ok || got != uint32(i) {
+				t.Fatalf("injected frame %d at position %d", got, i)
+			}
+			putFrameBuf(bufp)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d frames queued for injection", i, frames)
+		}
+	}
+	if stats := codec.Stats(); stats != (protocol.CodecStats{}) {
+		t.Errorf("codec rejected messages: %+v", stats)
+	}
+}
+
+func TestBridge_WorkersKeepPingsInNonceOrder(t *testing.T) {
+	key := []byte("control-order-test-key")
+	conn := newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415})
+	sent := 0
+	recv := func() []byte {
+		if !testutil.WaitFor(5*time.Second, func() bool { return len(conn.Sent()) > sent }) {
+			return nil
+		}
+		sent++
+		return conn.Sent()[sent-1]
+	}
+	checkControlInNonceOrder(t, Config{Transport: conn, Codec: newTestCodec(key), Workers: 4}, newTestCodec(key), recv)
+}
+
+func TestBridge_BatchSendKeepsPingsInNonceOrder(t *testing.T) {
+	if !transport.BatchSupported() {
+		t.Skip("batched writes not supported on this platform")
+	}
+	key := []byte("control-order-test-key")
+	logger := logging.NewLogger(logging.LevelError)
+	listenCodec, connectCodec := newTestCodec(key), newTestCodec(key)
+	listener, err := transport.New(transport.Config{Mode: transport.ModeListen, Codec: listenCodec, Logger: logger})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+	connector, err := transport.New(transport.Config{Mode: transport.ModeConnect, PeerAddr: fmt.Sprintf("127.0.0.1:%d", listener.LocalAddr().(*net.UDPAddr).Port), Codec: connectCodec, Logger: logger})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	defer connector.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- listener.WaitForPeer(ctx) }()
+	if err := connector.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("WaitForPeer failed: %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	recv := func() []byte {
+		listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := listener.Recv(buf)
+		if err != nil {
+			return nil
+		}
+		return buf[:n]
+	}
+	checkControlInNonceOrder(t, Config{Transport: connector, Codec: connectCodec, BatchSend: true}, listenCodec, recv)
+}
+
+// checkControlInNonceOrder sends PINGs and PONGs from another goroutine while
+// frames are in flight on a bridge built from cfg, and checks that peer's
+// replay check accepts every message recv returns (nil when none came).
+func checkControlInNonceOrder(t *testing.T, cfg Config, peer *protocol.Codec, recv func() []byte) {
+	t.Helper()
+	const frames = 500
+	cfg.Logger = logging.NewLogger(logging.LevelError)
+	cfg.Mode = transport.ModeConnect
+	b, err := New(cfg)
+	if err != nil {
+		t.Fatalf("failed to create bridge: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A PING now and then (too many unanswered ones end the session) and a
+	// PONG more often, as if answering the peer's
+	ping := func(i int) bool { return i%200 == 100 }
+	pong := func(i int) bool { return i%50 == 25 }
+	want := frames
+	for i := 0; i < frames; i++ {
+		if ping(i) {
+			want++
+		}
+		if pong(i) {
+			want++
+		}
+	}
+	// At most inFlight messages are left unread, so a real socket's
+	// receive buffer doesn't overflow
+	const inFlight = 64
+	var received atomic.Int64
+	go func() {
+		for i := 0; i < frames; i++ {
+			if i == inFlight {
+				// Start with a backlog, so the first batch or pipeline
+				// round has a PONG among its frames
+				go b.sendLoop(ctx)
+			}
+			for int(received.Load()) < i-inFlight && ctx.Err() == nil {
+				time.Sleep(100 * time.Microsecond)
+			}
+			bufp := getFrameBuf()
+			*bufp = (*bufp)[:copy(*bufp, testutil.SequencedFrame(uint32(i)))]
+			b.framesToSend <- bufp
+			if ping(i) {
+				b.sendPing()
+			}
+			if pong(i) {
+				b.handlePing(int64(i))
+			}
+		}
+	}()
+
+	next := uint32(0)
+	for i := 0; i < want; i++ {
+		data := recv()
+		if data == nil {
+			t.Fatalf("received %d messages, want %d", i, want)
+		}
+		received.Add(1)
+		msg, err := peer.Decode(data)
+		if err != nil {
+			t.Fatalf("peer rejected sent message %d: %v", i, err)
+		}
+		if msg.Type != protocol.MsgFrame {
+			continue
+		}
+		if got, ok := testutil.FrameSequence(msg.Frame); !ok || got != next {
+			t.Fatalf("sent frame %d, want %d", got, next)
+		}
+		next++
+	}
+	if next != frames {
+		t.Errorf("sent %d frames, want %d", next, frames)
+	}
+}
+
+func BenchmarkEncodeWorkers_Secure_1500(b *testing.B) {
+	codec := newTestCodec([]byte("benchmark-key"))
+	frame := make([]byte, 1500)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			var delivered int
+			done := make(chan struct{})
+			w := newOrderedWorkers(workers, func(job *pipelineJob) {
+				job.out, job.err = codec.EncodeFrameIntoNonce(job.out, frame, job.nonce)
+			}, func(job *pipelineJob) {
+				if delivered++; delivered == b.N {
+					close(done)
+				}
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go w.run(ctx)
+
+			b.SetBytes(int64(len(frame)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.submit(ctx, func(job *pipelineJob) { job.nonce = codec.ReserveNonce() })
+			}
+			<-done
+		})
+	}
+}
+
+func TestSendLoop_OversizePolicy(t *testing.T) {
+	big := make([]byte, protocol.MaxFrameSize)
+	copy(big, makeTestFrame(0xAB))
+	small := makeTestFrame(0xCD)
+
+	tests := []struct {
+		policy      OversizePolicy
+		wantSent    int
+		wantDropped uint64
+		wantLog     string
+	}{
+		{"", 3, 0, "IP will fragment it"},
+		{OversizeWarn, 3, 0, "IP will fragment it"},
+		{OversizeFragment, 3, 0, ""},
+		{OversizeDrop, 1, 2, "--on-oversize drop"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := logging.NewLogger(logging.LevelWarn)
+			logger.SetOutput(&buf)
+			conn := newMockConn(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415})
+			b, err := New(Config{
+				Transport:      conn,
+				Codec:          newTestCodec([]byte("oversize-key")),
+				Logger:         logger,
+				Mode:           transport.ModeConnect,
+				OversizePolicy: tt.policy,
+			})
+			if err != nil {
+				t.Fatalf("failed to create bridge: %v", err)
+			}
+
+			for _, frame := range [][]byte{big, small, big} {
+				bufp := getFrameBuf()
+				*bufp = (*bufp)[:copy(*bufp, frame)]
+				b.framesToSend <- bufp
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				b.sendLoop(ctx)
+				close(done)
+			}()
+			deadline := time.Now().Add(5 * time.Second)
+			for len(b.framesToSend) > 0 || len(conn.Sent()) < tt.wantSent {
+				if time.Now().After(deadline) {
+					t.Fatalf("sent %d frames, want %d", len(conn.Sent()), tt.wantSent)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			if got := len(conn.Sent()); got != tt.wantSent {
+				t.Errorf("sent %d frames, want %d", got, tt.wantSent)
+			}
+			if got := atomic.LoadUint64(&b.stats.TxOversizeDropped); got != tt.wantDropped {
+				t.Errorf("TxOversizeDropped = %d, want %d", got, tt.wantDropped)
+			}
+			if tt.wantLog == "" {
+				if buf.Len() > 0 {
+					t.Errorf("unexpected log output:\n%s", buf.String())
+				}
+			} else if got := strings.Count(buf.String(), tt.wantLog); got != 1 {
+				t.Errorf("logged %q %d times, want once:\n%s", tt.wantLog, got, buf.String())
+			}
+		})
+	}
+}
+
+func TestParseOversizePolicy(t *testing.T) {
+	for _, s := range []string{"drop", "Fragment", " warn "} {
+		if _, err := ParseOversizePolicy(s); err != nil {
+			t.Errorf("ParseOversizePolicy(%q) failed: %v", s, err)
+		}
+	}
+	if _, err := ParseOversizePolicy("truncate"); err == nil {
+		t.Error("ParseOversizePolicy(\"truncate\") succeeded")
+	}
+}
@@ -0,0 +1,74 @@
+package bridge
+
+import "time"
+
+// ClockSkewWarnThreshold is how far the peer's clock may be from ours, beyond
+// the uncertainty of the estimate, before Config.ClockSkew warns about it.
+const ClockSkewWarnThreshold = time.Second
+
+// estimateClockSkew estimates how far the peer's clock is ahead of ours
+// (negative if behind) from one PING/PONG exchange, all in Unix nanoseconds:
+// we sent the PING at sent and got the PONG at received by our clock, and
+// the peer read peerClock while answering. Assuming it answered halfway
+// through the round trip, the estimate is off by at most uncertainty, half
+// the RTT.
+func estimateClockSkew(sent, peerClock, received int64) (skew, uncertainty time.Duration) {
+	half := (received - sent) / 2
+	return time.Duration(peerClock - (sent + half)), time.Duration(half)
+}
+
+// clockSkewSignificant reports whether skew is beyond ClockSkewWarnThreshold
+// even if it is off by the full uncertainty.
+func clockSkewSignificant(skew, uncertainty time.Duration) bool {
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew-uncertainty > ClockSkewWarnThreshold
+}
+
+// describeClockSkew renders skew as e.g. "1.5s ahead of ours".
+func describeClockSkew(skew time.Duration) string {
+	if skew < 0 {
+		return (-skew).Round(time.Millisecond).String() + " behind ours"
+	}
+	return skew.Round(time.Millisecond).String() + " ahead of ours"
+}
+
+// oneWayDelays splits a round trip into the PING's trip to the peer (up)
+// and the PONG's trip back (down), taking the peer's clock at face value.
+// Any skew between the clocks moves from one figure to the other (a peer
+// clock ahead by 5ms adds 5ms to up and takes it from down), so the split is
+// only as good as the clocks' synchronization, e.g. by NTP. ok is false if
+// either delay comes out negative: the clocks are further apart than the
+// delays, and the split means nothing.
+func oneWayDelays(sent, peerClock, received int64) (up, down time.Duration, ok bool) {
+	up = time.Duration(peerClock - sent)
+	down = time.Duration(received - peerClock)
+	return up, down, up >= 0 && down >= 0
+}
+
+// checkClockSkew records the skew estimated from one PONG and warns, once
+// per session, if the peer's clock is far off.
+func (b *Bridge) checkClockSkew(sent, peerClock, received int64) {
+	skew, uncertainty := estimateClockSkew(sent, peerClock, received)
+	b.clockSkew.Store(int64(skew))
+	b.clockSkewKnown.Store(true)
+	b.logger.Debug("Peer clock is %s (±%v)", describeClockSkew(skew), uncertainty.Round(time.Millisecond))
+
+	if clockSkewSignificant(skew, uncertainty) {
+		b.clockSkewWarning.Do(func() {
+			b.logger.Warn("Peer's clock is %s (±%v): timestamps in its logs and events won't line up with ours",
+				describeClockSkew(skew), uncertainty.Round(time.Millisecond))
+		})
+	}
+}
+
+// ClockSkew returns the latest estimate of how far the peer's clock is ahead
+// of ours, or false if there is none: Config.ClockSkew is off, no PONG has
+// arrived yet, or the peer doesn't send its clock.
+func (b *Bridge) ClockSkew() (time.Duration, bool) {
+	if !b.clockSkewKnown.Load() {
+		return 0, false
+	}
+	return time.Duration(b.clockSkew.Load()), true
+}
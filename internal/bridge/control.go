@@ -0,0 +1,47 @@
+package bridge
+
+import "github.com/xbslink/xbslink-ng/internal/protocol"
+
+// controlQueueSize is how many PINGs and PONGs can wait for the send loop
+// before more are dropped.
+const controlQueueSize = 16
+
+// controlMsg is a PING or PONG waiting for the send loop.
+//
+// The peer drops any message whose nonce isn't higher than the last one it
+// accepted, and the send loop encodes a frame some time before it sends it
+// (a batch, or the worker pipeline, later). A PING or PONG encoded and sent
+// from another goroutine in between would overtake frames with lower
+// nonces and get them dropped as replays, so the send loop takes its nonce
+// in sequence with the frames around it.
+type controlMsg struct {
+	msgType   byte  // protocol.MsgPing or protocol.MsgPong
+	timestamp int64 // PING: when it was sent; PONG: the PING's, echoed
+	clock     int64 // PONG: our clock when answering, with ClockSkew (else 0)
+}
+
+// queueControl hands ctl to the send loop. If the queue is full ctl is
+// dropped, as the network might have dropped it; a lost PING or PONG only
+// counts as a missed PONG.
+func (b *Bridge) queueControl(ctl controlMsg) {
+	select {
+	case b.controlToSend <- ctl:
+	default:
+		b.logger.Debug("Control queue full, dropping %s", protocol.MessageTypeName(ctl.msgType))
+	}
+}
+
+// encodeControl encodes ctl with nonce, from protocol.Codec.ReserveNonce.
+func (b *Bridge) encodeControl(ctl controlMsg, nonce uint64) []byte {
+	if ctl.msgType == protocol.MsgPing {
+		return b.codec.EncodePingNonce(ctl.timestamp, nonce)
+	}
+	return b.codec.EncodePongNonce(ctl.timestamp, ctl.clock, nonce)
+}
+
+// sendControl sends an encoded ctl.
+func (b *Bridge) sendControl(ctl controlMsg, msg []byte) {
+	if err := b.transport.Send(msg); err != nil {
+		b.logger.Debug("Failed to send %s: %v", protocol.MessageTypeName(ctl.msgType), err)
+	}
+}
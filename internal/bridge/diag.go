@@ -0,0 +1,123 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/capture"
+)
+
+// DiagMode runs one half of the bridge on its own (--diag), to tell capture
+// problems from network problems.
+type DiagMode string
+
+const (
+	// DiagOff runs the whole bridge. The default; "" means the same.
+	DiagOff DiagMode = "off"
+	// DiagCapture captures frames from the Xbox and counts them as TX
+	// without sending them anywhere. No transport is needed.
+	DiagCapture DiagMode = "capture"
+	// DiagTransport connects to the peer and exchanges PINGs without
+	// touching pcap: received frames are counted as RX and dropped.
+	DiagTransport DiagMode = "transport"
+)
+
+// ParseDiagMode parses a --diag value.
+// Valid values: off, capture, transport (case-insensitive).
+func ParseDiagMode(s string) (DiagMode, error) {
+	switch m := DiagMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "", DiagOff:
+		return DiagOff, nil
+	case DiagCapture, DiagTransport:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid diagnostic mode %q (valid: off, capture, transport)", s)
+	}
+}
+
+// runCaptureDiag is Run for DiagCapture: the capture loop feeds discardLoop
+// instead of the send loop, and there is no peer to connect to.
+func (b *Bridge) runCaptureDiag(ctx context.Context) error {
+	b.stats.SetStartTime(time.Now())
+	b.connectedAt = b.now()
+	b.logger.Info("Capture diagnostics: counting captured Xbox frames as TX without sending them (Ctrl+C to stop)")
+
+	loopCtx, stopLoops := context.WithCancel(ctx)
+	defer stopLoops()
+
+	var wg sync.WaitGroup
+	loops := []func(context.Context){b.captureLoop, b.discardLoop, b.stdinLoop}
+	if b.statsInterval > 0 {
+		loops = append(loops, b.statsLoop)
+	}
+	if b.idleTimeout > 0 {
+		loops = append(loops, b.idleLoop)
+	}
+	if b.maxDuration > 0 {
+		loops = append(loops, b.durationLoop)
+	}
+	for _, loop := range loops {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loop(loopCtx)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-b.stop:
+	}
+
+	b.captureMu.RLock()
+	if b.capture != nil {
+		b.capture.Close()
+	}
+	b.captureMu.RUnlock()
+	stopLoops()
+	wg.Wait()
+
+	b.logger.Info("Capture diagnostics stopped")
+	b.printSummary()
+
+	select {
+	case <-b.stop:
+		b.stateMu.RLock()
+		defer b.stateMu.RUnlock()
+		return b.stopErr
+	default:
+		return nil
+	}
+}
+
+// discardLoop stands in for the send loop under DiagCapture, counting
+// captured frames as if they had been sent.
+func (b *Bridge) discardLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case bufp := <-b.framesToSend:
+			frame := *bufp
+			atomic.AddUint64(&b.stats.TxPackets, 1)
+			atomic.AddUint64(&b.stats.TxBytes, uint64(len(frame)))
+			_, _, etherType := capture.DecodeEthernetFrame(frame)
+			b.stats.txEtherTypes.count(etherType)
+			b.stats.MarkTx(b.now())
+			putFrameBuf(bufp)
+		}
+	}
+}
+
+// handshakeFailures returns the transport's rejected handshakes, 0 without
+// a transport (DiagCapture).
+func (b *Bridge) handshakeFailures() uint64 {
+	if b.transport == nil {
+		return 0
+	}
+	return b.transport.HandshakeFailures()
+}
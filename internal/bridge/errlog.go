@@ -0,0 +1,92 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxErrorKinds bounds the distinct messages an errorAggregator tracks;
+// further kinds still count toward the total.
+const maxErrorKinds = 16
+
+// errorAggregator counts errors between flushes so a burst is logged as one
+// summary line instead of one line per error. It is safe for concurrent use.
+type errorAggregator struct {
+	what     string        // singular noun for the summary, e.g. "capture error"
+	interval time.Duration // how often a summary is due
+
+	mu     sync.Mutex
+	since  time.Time      // start of the current window
+	total  int            // errors in the current window
+	counts map[string]int // errors by message in the current window
+}
+
+// newErrorAggregator creates an aggregator whose window starts at now.
+func newErrorAggregator(what string, interval time.Duration, now time.Time) *errorAggregator {
+	return &errorAggregator{
+		what:     what,
+		interval: interval,
+		since:    now,
+		counts:   make(map[string]int),
+	}
+}
+
+// add records one error.
+func (a *errorAggregator) add(err error) {
+	msg := err.Error()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.total++
+	if _, ok := a.counts[msg]; ok || len(a.counts) < maxErrorKinds {
+		a.counts[msg]++
+	}
+}
+
+// due reports whether errors are pending and the window has lasted at least
+// the interval.
+func (a *errorAggregator) due(now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.total > 0 && now.Sub(a.since) >= a.interval
+}
+
+// flush returns a summary of the errors since the last flush and starts a
+// new window, or returns "" if there were none.
+func (a *errorAggregator) flush(now time.Time) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	total, window := a.total, now.Sub(a.since)
+	a.since = now
+	if total == 0 {
+		return ""
+	}
+
+	var common string
+	var commonCount int
+	for msg, n := range a.counts {
+		if n > commonCount || (n == commonCount && msg < common) {
+			common, commonCount = msg, n
+		}
+	}
+	kinds := len(a.counts)
+	a.total = 0
+	clear(a.counts)
+
+	noun := a.what
+	if total != 1 {
+		noun += "s"
+	}
+	if window >= time.Second {
+		window = window.Round(time.Second)
+	} else {
+		window = window.Round(time.Millisecond)
+	}
+	line := fmt.Sprintf("%d %s in last %s: %s", total, noun, window, common)
+	if commonCount < total {
+		line += fmt.Sprintf(" (%d times; %d distinct errors)", commonCount, kinds)
+	}
+	return line
+}
@@ -0,0 +1,60 @@
+package bridge
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// EtherTypes counted separately in the traffic breakdown; everything else
+// (including VLAN-tagged frames) is Other.
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+	etherTypeIPv6 = 0x86DD
+)
+
+// EtherTypeCounts is the number of frames of each EtherType class.
+type EtherTypeCounts struct {
+	IPv4  uint64
+	ARP   uint64
+	IPv6  uint64
+	Other uint64
+}
+
+// String formats the counts for the stats output.
+func (c EtherTypeCounts) String() string {
+	return fmt.Sprintf("IPv4 %s | ARP %s | IPv6 %s | other %s",
+		formatNumber(c.IPv4), formatNumber(c.ARP), formatNumber(c.IPv6), formatNumber(c.Other))
+}
+
+// etherTypeCounters is EtherTypeCounts updated atomically from the hot path.
+type etherTypeCounters struct {
+	ipv4  atomic.Uint64
+	arp   atomic.Uint64
+	ipv6  atomic.Uint64
+	other atomic.Uint64
+}
+
+// count records one frame of the given EtherType.
+func (c *etherTypeCounters) count(etherType uint16) {
+	switch etherType {
+	case etherTypeIPv4:
+		c.ipv4.Add(1)
+	case etherTypeARP:
+		c.arp.Add(1)
+	case etherTypeIPv6:
+		c.ipv6.Add(1)
+	default:
+		c.other.Add(1)
+	}
+}
+
+// load returns a snapshot of the counters.
+func (c *etherTypeCounters) load() EtherTypeCounts {
+	return EtherTypeCounts{
+		IPv4:  c.ipv4.Load(),
+		ARP:   c.arp.Load(),
+		IPv6:  c.ipv6.Load(),
+		Other: c.other.Load(),
+	}
+}
@@ -0,0 +1,76 @@
+package bridge
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// LoopWindow is how long after injecting a frame an identical captured frame
+// is treated as our own injection coming back (a network loop).
+const LoopWindow = 2 * time.Second
+
+// maxLoopFingerprints bounds the fingerprints a loopDetector remembers.
+const maxLoopFingerprints = 4096
+
+// loopDetector remembers fingerprints of recently injected frames so that
+// capturing one of them again can be reported as a loop. Frames themselves
+// are never modified. It is safe for concurrent use.
+type loopDetector struct {
+	mu     sync.Mutex
+	seed   maphash.Seed
+	window time.Duration
+	recent map[uint64]time.Time // fingerprint -> when injected
+}
+
+// newLoopDetector creates a detector that matches within window.
+func newLoopDetector(window time.Duration) *loopDetector {
+	return &loopDetector{
+		seed:   maphash.MakeSeed(),
+		window: window,
+		recent: make(map[uint64]time.Time),
+	}
+}
+
+// injected records that frame was injected at now.
+func (d *loopDetector) injected(frame []byte, now time.Time) {
+	h := maphash.Bytes(d.seed, frame)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.recent) >= maxLoopFingerprints {
+		d.pruneLocked(now)
+	}
+	d.recent[h] = now
+}
+
+// looped reports whether frame matches one injected within the window
+// before now.
+func (d *loopDetector) looped(frame []byte, now time.Time) bool {
+	h := maphash.Bytes(d.seed, frame)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	at, ok := d.recent[h]
+	if !ok {
+		return false
+	}
+	if now.Sub(at) > d.window {
+		delete(d.recent, h)
+		return false
+	}
+	return true
+}
+
+// pruneLocked drops expired fingerprints, or all of them if every one is
+// still within the window (a burst larger than maxLoopFingerprints).
+func (d *loopDetector) pruneLocked(now time.Time) {
+	for h, at := range d.recent {
+		if now.Sub(at) > d.window {
+			delete(d.recent, h)
+		}
+	}
+	if len(d.recent) >= maxLoopFingerprints {
+		clear(d.recent)
+	}
+}
@@ -0,0 +1,61 @@
+package bridge
+
+import "sync/atomic"
+
+// Direction is which way a frame is flowing through the bridge.
+type Direction int
+
+const (
+	// DirTx is a frame captured locally, on its way to the peer.
+	DirTx Direction = iota
+	// DirRx is a frame received from the peer, on its way to be injected.
+	DirRx
+)
+
+// String returns "tx" or "rx".
+func (d Direction) String() string {
+	if d == DirRx {
+		return "rx"
+	}
+	return "tx"
+}
+
+// Middleware inspects or transforms a frame flowing through the bridge. It
+// returns the frame to forward and true, or false to drop it. The frame may
+// be modified in place or replaced; it is only valid during the call, so a
+// middleware that keeps it must copy it. Middleware runs on the capture and
+// receive goroutines, so it must not block.
+type Middleware func(dir Direction, frame []byte) ([]byte, bool)
+
+// Use appends mw to the middleware chain, which runs in the order added on
+// every captured frame (after the EthernetIIOnly and DetectLoops checks)
+// and every received frame (before it is queued for injection). Frames it
+// drops are counted in Stats.Filtered. Call Use before Run; it is not safe
+// to call while the bridge is running.
+func (b *Bridge) Use(mw Middleware) {
+	b.middleware = append(b.middleware, mw)
+}
+
+// runMiddleware passes the frame in *bufp through the middleware chain,
+// leaving the result in *bufp. It reports false if the frame was dropped.
+func (b *Bridge) runMiddleware(dir Direction, bufp *[]byte) bool {
+	if len(b.middleware) == 0 {
+		return true
+	}
+	frame := *bufp
+	for _, mw := range b.middleware {
+		var ok bool
+		if frame, ok = mw(dir, frame); !ok {
+			atomic.AddUint64(&b.stats.Filtered, 1)
+			b.logger.Trace("Middleware dropped %s frame (%d bytes)", dir, len(*bufp))
+			return false
+		}
+	}
+	// A replacement frame is copied back so the pooled buffer still owns it
+	if len(frame) == 0 || &frame[0] != &(*bufp)[0] {
+		*bufp = append((*bufp)[:0], frame...)
+	} else {
+		*bufp = frame
+	}
+	return true
+}
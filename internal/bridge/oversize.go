@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// MaxUnfragmentedDatagram is the largest UDP payload that crosses a standard
+// 1500-byte Ethernet MTU without IP fragmentation (1500 minus 20 bytes of
+// IPv4 header and 8 of UDP header). A full-size 1514-byte frame plus the
+// protocol header is larger, so the kernel fragments it.
+const MaxUnfragmentedDatagram = 1472
+
+// OversizePolicy selects what the send path does with a frame whose encoded
+// message exceeds MaxUnfragmentedDatagram.
+type OversizePolicy string
+
+const (
+	// OversizeWarn sends the frame (letting IP fragment it) and logs an
+	// explanation the first time. The default; "" means the same.
+	OversizeWarn OversizePolicy = "warn"
+	// OversizeFragment sends the frame silently.
+	OversizeFragment OversizePolicy = "fragment"
+	// OversizeDrop drops the frame and counts it in Stats.TxOversizeDropped,
+	// trading a rare loss for never relying on fragment reassembly.
+	OversizeDrop OversizePolicy = "drop"
+)
+
+// ParseOversizePolicy parses an --on-oversize value.
+// Valid values: drop, fragment, warn (case-insensitive).
+func ParseOversizePolicy(s string) (OversizePolicy, error) {
+	switch p := OversizePolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case OversizeWarn, OversizeFragment, OversizeDrop:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid oversize policy %q (valid: drop, fragment, warn)", s)
+	}
+}
+
+// dropOversize applies the oversize policy to a captured frame of frameLen
+// bytes about to be sent, reporting true if it must be dropped instead.
+func (b *Bridge) dropOversize(frameLen int) bool {
+	if b.oversizePolicy == OversizeFragment {
+		return false
+	}
+	size := b.codec.EncodedSize(frameLen)
+	if size <= MaxUnfragmentedDatagram {
+		return false
+	}
+
+	if b.oversizePolicy == OversizeDrop {
+		atomic.AddUint64(&b.stats.TxOversizeDropped, 1)
+		b.oversizeNotice.Do(func() {
+			b.logger.Warn("Dropping a %d-byte frame: as a %d-byte datagram it exceeds the %d bytes that fit a 1500-byte MTU unfragmented; "+
+				"such frames are dropped and counted because of --on-oversize drop", frameLen, size, MaxUnfragmentedDatagram)
+		})
+		return true
+	}
+	b.oversizeNotice.Do(func() {
+		b.logger.Warn("Sending a %d-byte frame as a %d-byte datagram, over the %d bytes that fit a 1500-byte MTU, so IP will fragment it; "+
+			"losing any fragment loses the frame. Use --on-oversize drop to drop such frames or fragment to silence this", frameLen, size, MaxUnfragmentedDatagram)
+	})
+	return false
+}
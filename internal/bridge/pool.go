@@ -0,0 +1,39 @@
+package bridge
+
+import (
+	"sync"
+
+	"github.com/xbslink/xbslink-ng/internal/protocol"
+)
+
+// frameBufSize fits the largest frame any codec forwards (see
+// protocol.Codec.SetMaxFrameSize), plus the secure-mode header and HMAC so the
+// same size also works as an encode buffer.
+const frameBufSize = protocol.MaxMessageSize
+
+// framePool recycles frame buffers between the bridge loops.
+//
+// Ownership: captureLoop takes a buffer with getFrameBuf and reads a frame
+// into it. If the frame is handed to framesToSend, sendLoop owns it and must
+// call putFrameBuf once it no longer needs the frame (after encoding, since
+// encoding copies it). Otherwise captureLoop returns it immediately.
+// handleFrame likewise copies each received frame into a pooled buffer for
+// framesToInject, and injectLoop returns it after WritePacket.
+var framePool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, frameBufSize)
+		return &buf
+	},
+}
+
+// getFrameBuf returns a full-length buffer from framePool.
+func getFrameBuf() *[]byte {
+	bufp := framePool.Get().(*[]byte)
+	*bufp = (*bufp)[:cap(*bufp)]
+	return bufp
+}
+
+// putFrameBuf returns a buffer to framePool. The caller must not use it afterwards.
+func putFrameBuf(bufp *[]byte) {
+	framePool.Put(bufp)
+}
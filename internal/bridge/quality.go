@@ -0,0 +1,105 @@
+package bridge
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/logging"
+)
+
+// Quality score weights and limits. System Link games are far more
+// sensitive to latency and its variation than to the odd lost packet, so
+// RTT and jitter make up most of the score. Each part costs nothing up to
+// its good limit and its full weight at its worst limit, linearly between.
+const (
+	qualityRTTWeight    = 50
+	qualityJitterWeight = 30
+	qualityLossWeight   = 20
+
+	qualityRTTWorst    = 100 * time.Millisecond // good up to RTTGoodThreshold
+	qualityJitterGood  = 2 * time.Millisecond
+	qualityJitterWorst = 15 * time.Millisecond
+	qualityLossWorst   = 0.05 // 5% of pings unanswered
+)
+
+// Quality labels, from best to worst.
+const (
+	QualityGood    = "good"    // score 80 and up: play
+	QualityFair    = "fair"    // 50 to 79: playable, expect some lag
+	QualityPoor    = "poor"    // below 50: expect desyncs and dropped games
+	QualityUnknown = "unknown" // no RTT samples yet
+)
+
+// Quality is a 0-100 connection quality score with its label.
+type Quality struct {
+	Score int
+	Label string
+}
+
+// String formats q as e.g. "91 (good)".
+func (q Quality) String() string {
+	if q.Label == QualityUnknown {
+		return q.Label
+	}
+	return fmt.Sprintf("%d (%s)", q.Score, q.Label)
+}
+
+// color returns the color q is shown in.
+func (q Quality) color() logging.Color {
+	switch q.Label {
+	case QualityGood:
+		return logging.ColorGreen
+	case QualityFair:
+		return logging.ColorYellow
+	default:
+		return logging.ColorRed
+	}
+}
+
+// qualityScore combines average RTT, jitter and ping loss (0 to 1) into a
+// score.
+func qualityScore(rtt, jitter time.Duration, loss float64) Quality {
+	penalty := qualityRTTWeight*ramp(float64(rtt), float64(RTTGoodThreshold), float64(qualityRTTWorst)) +
+		qualityJitterWeight*ramp(float64(jitter), float64(qualityJitterGood), float64(qualityJitterWorst)) +
+		qualityLossWeight*ramp(loss, 0, qualityLossWorst)
+	score := int(math.Round(100 - penalty))
+
+	label := QualityPoor
+	switch {
+	case score >= 80:
+		label = QualityGood
+	case score >= 50:
+		label = QualityFair
+	}
+	return Quality{Score: score, Label: label}
+}
+
+// ramp returns 0 for v up to good, 1 from worst on, and rises linearly
+// between.
+func ramp(v, good, worst float64) float64 {
+	return min(max((v-good)/(worst-good), 0), 1)
+}
+
+// PingLoss returns the fraction of pings sent this session that went
+// unanswered.
+func (s *Stats) PingLoss() float64 {
+	sent := atomic.LoadUint64(&s.PingsSent)
+	if sent == 0 {
+		return 0
+	}
+	return float64(atomic.LoadUint64(&s.PongsLost)) / float64(sent)
+}
+
+// QualityScore rates the connection from the average RTT, jitter and ping
+// loss. Before the first RTT sample it is QualityUnknown with score 0.
+func (s *Stats) QualityScore() Quality {
+	s.rttMu.RLock()
+	samples, rtt, jitter := len(s.rttSamples), s.RTTAvg, s.Jitter
+	s.rttMu.RUnlock()
+	if samples == 0 {
+		return Quality{Label: QualityUnknown}
+	}
+	return qualityScore(rtt, jitter, s.PingLoss())
+}
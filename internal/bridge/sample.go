@@ -0,0 +1,46 @@
+package bridge
+
+import "sync/atomic"
+
+// traceSampler decides which frames get a trace log line, so trace level stays
+// usable at gaming packet rates. It is safe for concurrent use.
+type traceSampler struct {
+	every uint64 // log 1 of every N frames (0 or 1 = every frame)
+	count atomic.Uint64
+}
+
+// newTraceSampler creates a sampler that admits 1 of every n frames.
+func newTraceSampler(n uint) *traceSampler {
+	return &traceSampler{every: uint64(n)}
+}
+
+// sample reports whether the current frame should be logged.
+// The first frame is always logged.
+func (s *traceSampler) sample() bool {
+	if s.every <= 1 {
+		return true
+	}
+	return (s.count.Add(1)-1)%s.every == 0
+}
+
+// frameDumper admits a fixed number of frames for a hex dump, then none.
+// It is safe for concurrent use.
+type frameDumper struct {
+	remaining atomic.Int64
+}
+
+// newFrameDumper creates a dumper that admits the next n frames.
+func newFrameDumper(n uint) *frameDumper {
+	d := &frameDumper{}
+	d.remaining.Store(int64(n))
+	return d
+}
+
+// take reports whether the current frame should be dumped.
+func (d *frameDumper) take() bool {
+	// Cheap check first so the steady state (nothing left) never writes.
+	if d.remaining.Load() <= 0 {
+		return false
+	}
+	return d.remaining.Add(-1) >= 0
+}
@@ -0,0 +1,188 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/logging"
+	"github.com/xbslink/xbslink-ng/internal/protocol"
+)
+
+// StatsFormat selects how periodic statistics are printed.
+type StatsFormat string
+
+const (
+	// StatsFormatLine prints a single human-readable line per interval (default).
+	StatsFormatLine StatsFormat = "line"
+	// StatsFormatCSV prints a header once, then one comma-separated row per
+	// interval with csvPrefix instead of the log prefix, suitable for
+	// spreadsheets and plotting.
+	StatsFormatCSV StatsFormat = "csv"
+	// StatsFormatTable prints a header once, then aligned columns.
+	StatsFormatTable StatsFormat = "table"
+)
+
+// csvHeader names the columns written in StatsFormatCSV.
+const csvHeader = "timestamp,tx_packets,tx_bytes,rx_packets,rx_bytes,rtt_ms,tx_dropped,rx_dropped,tx_congested"
+
+// csvPrefix starts the StatsFormatCSV header and rows. They share stdout
+// with the log, whose lines start with a timestamp in any --log-timeformat,
+// so this picks them out: grep '^csv,' | cut -d, -f2-.
+const csvPrefix = "csv,"
+
+// tableRowFormat lays out StatsFormatTable columns.
+const tableRowFormat = "%12s %10s %12s %10s %8s %7s %9s"
+
+// ParseStatsFormat parses a --stats-format value.
+// Valid values: line, csv, table (case-insensitive).
+func ParseStatsFormat(s string) (StatsFormat, error) {
+	switch f := StatsFormat(strings.ToLower(strings.TrimSpace(s))); f {
+	case StatsFormatLine, StatsFormatCSV, StatsFormatTable:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid stats format %q (valid: line, csv, table)", s)
+	}
+}
+
+// statsSnapshot is a point-in-time copy of the values printed per interval.
+type statsSnapshot struct {
+	Time              time.Time
+	TxPackets         uint64
+	TxBytes           uint64
+	RxPackets         uint64
+	RxBytes           uint64
+	RTT               time.Duration
+	UpDelay           time.Duration // zero if unknown
+	DownDelay         time.Duration
+	Quality           Quality
+	TxDropped         uint64
+	RxDropped         uint64
+	TxCongested       uint64
+	LoopedFrames      uint64
+	TxOversizeDropped uint64
+	NonEthernetII     uint64
+	Filtered          uint64
+	InjectDrops       uint64
+	HandshakeFailures uint64
+	Codec             protocol.CodecStats
+	Uptime            time.Duration
+}
+
+// StatsFormatter renders periodic stats in the chosen format.
+// Share one formatter across reconnects so CSV and table headers are written
+// only once per run.
+type StatsFormatter struct {
+	format     StatsFormat
+	headerOnce sync.Once
+}
+
+// NewStatsFormatter creates a formatter for the given format.
+func NewStatsFormatter(format StatsFormat) *StatsFormatter {
+	return &StatsFormatter{format: format}
+}
+
+// write outputs s to logger, preceded by a header on first use when the
+// format has one.
+func (f *StatsFormatter) write(logger *logging.Logger, s statsSnapshot) {
+	switch f.format {
+	case StatsFormatCSV:
+		f.headerOnce.Do(func() { logger.Raw(csvPrefix + csvHeader) })
+		logger.Raw(csvPrefix + formatCSVRow(s))
+	case StatsFormatTable:
+		f.headerOnce.Do(func() {
+			logger.Stats(tableRowFormat, "TX pkts", "TX bytes", "RX pkts", "RX bytes", "RTT", "Drops", "Uptime")
+		})
+		logger.Stats("%s", formatTableRow(s))
+	default:
+		logger.Stats("%s", formatLine(s, logger.ColorEnabled()))
+	}
+}
+
+// formatLine renders the default single-line format.
+// With color enabled the RTT is colored by rttColor.
+func formatLine(s statsSnapshot, color bool) string {
+	rtt := s.RTT.Round(time.Millisecond).String()
+	if color && s.RTT > 0 {
+		rtt = logging.Colorize(rttColor(s.RTT), rtt)
+	}
+	if s.UpDelay > 0 || s.DownDelay > 0 {
+		rtt += fmt.Sprintf(" (%s up, %s down)", s.UpDelay.Round(time.Millisecond), s.DownDelay.Round(time.Millisecond))
+	}
+	if s.Quality.Label != "" && s.Quality.Label != QualityUnknown {
+		quality := s.Quality.String()
+		if color {
+			quality = logging.Colorize(s.Quality.color(), quality)
+		}
+		rtt += " | Quality: " + quality
+	}
+
+	line := fmt.Sprintf("TX: %s pkts (%s) | RX: %s pkts (%s) | RTT: %s | up %s",
+		formatNumber(s.TxPackets), formatBytes(s.TxBytes),
+		formatNumber(s.RxPackets), formatBytes(s.RxBytes),
+		rtt, formatUptime(s.Uptime))
+	if s.HandshakeFailures > 0 {
+		line += fmt.Sprintf(" | Handshake failures: %s", formatNumber(s.HandshakeFailures))
+	}
+	if s.TxCongested > 0 {
+		line += fmt.Sprintf(" | Send congestion: %s", formatNumber(s.TxCongested))
+	}
+	if s.LoopedFrames > 0 {
+		line += fmt.Sprintf(" | Looped: %s", formatNumber(s.LoopedFrames))
+	}
+	if s.TxOversizeDropped > 0 {
+		line += fmt.Sprintf(" | Oversize dropped: %s", formatNumber(s.TxOversizeDropped))
+	}
+	if s.NonEthernetII > 0 {
+		line += fmt.Sprintf(" | Non-Ethernet II: %s", formatNumber(s.NonEthernetII))
+	}
+	if s.Filtered > 0 {
+		line += fmt.Sprintf(" | Filtered: %s", formatNumber(s.Filtered))
+	}
+	if s.InjectDrops > 0 {
+		line += fmt.Sprintf(" | Inject failed: %s", formatNumber(s.InjectDrops))
+	}
+	if s.Codec.HMACFailures > 0 {
+		line += fmt.Sprintf(" | Bad HMAC: %s", formatNumber(s.Codec.HMACFailures))
+	}
+	if s.Codec.Replays > 0 {
+		line += fmt.Sprintf(" | Replays: %s", formatNumber(s.Codec.Replays))
+	}
+	if s.Codec.DecodeErrors > 0 {
+		line += fmt.Sprintf(" | Malformed: %s", formatNumber(s.Codec.DecodeErrors))
+	}
+	if s.Codec.RxCorrupt > 0 {
+		line += fmt.Sprintf(" | Corrupt: %s", formatNumber(s.Codec.RxCorrupt))
+	}
+	return line
+}
+
+// rttColor grades an RTT: green up to RTTGoodThreshold, yellow up to
+// RTTAlertThreshold, red beyond it.
+func rttColor(rtt time.Duration) logging.Color {
+	switch {
+	case rtt <= RTTGoodThreshold:
+		return logging.ColorGreen
+	case rtt <= RTTAlertThreshold:
+		return logging.ColorYellow
+	default:
+		return logging.ColorRed
+	}
+}
+
+// formatCSVRow renders one CSV row matching csvHeader.
+func formatCSVRow(s statsSnapshot) string {
+	return fmt.Sprintf("%s,%d,%d,%d,%d,%.3f,%d,%d,%d",
+		s.Time.Format(time.RFC3339), s.TxPackets, s.TxBytes, s.RxPackets, s.RxBytes,
+		float64(s.RTT)/float64(time.Millisecond), s.TxDropped, s.RxDropped, s.TxCongested)
+}
+
+// formatTableRow renders one row aligned under the table header.
+func formatTableRow(s statsSnapshot) string {
+	return fmt.Sprintf(tableRowFormat,
+		formatNumber(s.TxPackets), formatBytes(s.TxBytes),
+		formatNumber(s.RxPackets), formatBytes(s.RxBytes),
+		s.RTT.Round(time.Millisecond).String(), formatNumber(s.TxDropped+s.RxDropped),
+		formatUptime(s.Uptime))
+}
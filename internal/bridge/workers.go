@@ -0,0 +1,273 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/capture"
+	"github.com/xbslink/xbslink-ng/internal/protocol"
+	"github.com/xbslink/xbslink-ng/internal/transport"
+)
+
+// workerQueueDepth is how many jobs per worker an orderedWorkers keeps in
+// flight before submit blocks.
+const workerQueueDepth = 8
+
+// pipelineJob is one frame or datagram moving through an orderedWorkers.
+// Jobs are reused, so process and deliver must not keep pointers to them.
+type pipelineJob struct {
+	bufp  *[]byte          // pooled input buffer
+	n     int              // valid bytes in *bufp
+	nonce uint64           // send: reserved nonce; receive: decoded nonce
+	size  int              // send: frame length
+	etype uint16           // send: frame EtherType
+	out   []byte           // send: encoded message (buffer kept across uses)
+	ctl   controlMsg       // send: a PING or PONG instead of a frame, if msgType is set
+	msg   protocol.Message // receive: decoded message
+	err   error
+	done  chan struct{} // signalled once processed
+}
+
+// orderedWorkers runs process on jobs across several goroutines and hands
+// them to deliver one at a time, in the order they were submitted, however
+// the workers finish. This lets the send and receive paths spread HMAC work
+// over all cores while keeping the wire order of nonces and the inject order
+// of frames.
+type orderedWorkers struct {
+	workers int
+	process func(*pipelineJob) // called concurrently
+	deliver func(*pipelineJob) // called from a single goroutine, in order
+
+	free    chan *pipelineJob
+	work    chan *pipelineJob
+	pending chan *pipelineJob // jobs in submission order
+}
+
+// newOrderedWorkers creates a pipeline with the given number of workers.
+func newOrderedWorkers(workers int, process, deliver func(*pipelineJob)) *orderedWorkers {
+	depth := workers * workerQueueDepth
+	w := &orderedWorkers{
+		workers: workers,
+		process: process,
+		deliver: deliver,
+		free:    make(chan *pipelineJob, depth),
+		work:    make(chan *pipelineJob, depth),
+		pending: make(chan *pipelineJob, depth),
+	}
+	for i := 0; i < depth; i++ {
+		w.free <- &pipelineJob{done: make(chan struct{}, 1)}
+	}
+	return w
+}
+
+// run starts the workers and the deliverer, and blocks until ctx is done.
+// Jobs still in flight then are dropped.
+func (w *orderedWorkers) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < w.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-w.work:
+					w.process(job)
+					job.done <- struct{}{}
+				}
+			}
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case job := <-w.pending:
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return
+			case <-job.done:
+			}
+			w.deliver(job)
+			job.bufp, job.err, job.ctl = nil, nil, controlMsg{}
+			w.free <- job
+		}
+	}
+}
+
+// submit queues a job filled in by fill, waiting while the pipeline is full.
+// Jobs are delivered in the order of their fill calls, so fill is where
+// order-dependent state (such as a send nonce) is assigned. It reports false
+// if ctx was done first.
+func (w *orderedWorkers) submit(ctx context.Context, fill func(*pipelineJob)) bool {
+	var job *pipelineJob
+	select {
+	case <-ctx.Done():
+		return false
+	case job = <-w.free:
+	}
+	fill(job)
+	// Both have room for every job, so neither send blocks
+	w.pending <- job
+	w.work <- job
+	return true
+}
+
+// newEncodeWorkers returns the pipeline sendParallelLoop uses: workers
+// encode (and sign) frames, and frames are sent in capture order. PINGs and
+// PONGs go through it too, so they are sent in nonce order with the frames.
+func (b *Bridge) newEncodeWorkers() *orderedWorkers {
+	process := func(job *pipelineJob) {
+		if job.ctl.msgType != 0 {
+			job.out = append(job.out[:0], b.encodeControl(job.ctl, job.nonce)...)
+			return
+		}
+		frame := (*job.bufp)[:job.n]
+		job.size = len(frame)
+		_, _, job.etype = capture.DecodeEthernetFrame(frame)
+		if job.out == nil {
+			job.out = make([]byte, 0, frameBufSize)
+		}
+		job.out, job.err = b.codec.EncodeFrameIntoNonce(job.out, frame, job.nonce)
+		putFrameBuf(job.bufp) // frame was copied into out
+	}
+	deliver := func(job *pipelineJob) {
+		if job.ctl.msgType != 0 {
+			b.sendControl(job.ctl, job.out)
+			return
+		}
+		if job.err != nil {
+			b.logger.Debug("Failed to encode frame: %v", job.err)
+			return
+		}
+		if err := b.transport.Send(job.out); err != nil {
+			b.sendFailed(err, 1)
+			return
+		}
+		atomic.AddUint64(&b.stats.TxPackets, 1)
+		atomic.AddUint64(&b.stats.TxBytes, uint64(job.size))
+		b.stats.txEtherTypes.count(job.etype)
+		b.stats.MarkTx(b.now())
+	}
+	return newOrderedWorkers(b.workers, process, deliver)
+}
+
+// newDecodeWorkers returns the pipeline recvParallelLoop uses: workers
+// decode (and verify) datagrams, and the replay check and message handling
+// happen in arrival order.
+func (b *Bridge) newDecodeWorkers() *orderedWorkers {
+	process := func(job *pipelineJob) {
+		job.nonce, job.err = b.codec.DecodeUnordered(&job.msg, (*job.bufp)[:job.n])
+	}
+	deliver := func(job *pipelineJob) {
+		defer putFrameBuf(job.bufp)
+		if job.err == nil {
+			job.err = b.codec.AcceptNonce(job.msg.Type, job.nonce)
+		}
+		if job.err != nil {
+			b.logger.Debug("Failed to decode message: %v", job.err)
+			return
+		}
+		b.dispatchMessage(&job.msg)
+	}
+	return newOrderedWorkers(b.workers, process, deliver)
+}
+
+// sendParallelLoop is sendLoop spreading frame encoding over b.workers
+// goroutines.
+func (b *Bridge) sendParallelLoop(ctx context.Context) {
+	w := b.newEncodeWorkers()
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx)
+		close(done)
+	}()
+	defer func() { <-done }()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ctl := <-b.controlToSend:
+			ok := w.submit(ctx, func(job *pipelineJob) {
+				job.ctl = ctl
+				job.nonce = b.codec.ReserveNonce()
+			})
+			if !ok {
+				return
+			}
+		case bufp := <-b.framesToSend:
+			if b.dropOversize(len(*bufp)) {
+				putFrameBuf(bufp)
+				continue
+			}
+			ok := w.submit(ctx, func(job *pipelineJob) {
+				job.bufp, job.n = bufp, len(*bufp)
+				job.nonce = b.codec.ReserveNonce()
+			})
+			if !ok {
+				putFrameBuf(bufp)
+				return
+			}
+		}
+	}
+}
+
+// recvParallelLoop is recvLoop spreading message decoding over b.workers
+// goroutines.
+func (b *Bridge) recvParallelLoop(ctx context.Context) {
+	w := b.newDecodeWorkers()
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx)
+		close(done)
+	}()
+	defer func() { <-done }()
+	peerAddr := b.transport.PeerAddr()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		// Set read deadline
+		b.transport.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+		bufp := getFrameBuf()
+		n, addr, err := b.transport.Recv(*bufp)
+		if err != nil {
+			putFrameBuf(bufp)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, transport.ErrPeerClosed) {
+				b.handlePeerClosed()
+				return
+			}
+			b.logger.Warn("Recv error: %v", err)
+			continue
+		}
+		if peerAddr != nil && !addrEqual(addr, peerAddr) {
+			putFrameBuf(bufp)
+			b.logger.Debug("Ignoring packet from unexpected source: %s", addr)
+			continue
+		}
+		if !w.submit(ctx, func(job *pipelineJob) { job.bufp, job.n = bufp, n }) {
+			putFrameBuf(bufp)
+			return
+		}
+	}
+}
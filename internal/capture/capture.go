@@ -6,13 +6,18 @@ import (
 	"fmt"
 	"net"
 	"runtime"
+	"slices"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 
 	"github.com/xbslink/xbslink-ng/internal/logging"
+	"github.com/xbslink/xbslink-ng/internal/macaddr"
 )
 
 // Configuration constants.
@@ -29,30 +34,74 @@ const (
 var (
 	ErrNpcapNotInstalled = errors.New("npcap not installed")
 	ErrInterfaceNotFound = errors.New("interface not found")
-	ErrInvalidMAC        = errors.New("invalid MAC address format")
+	ErrInvalidMAC        = macaddr.ErrInvalid
+	ErrNotUnicastMAC     = errors.New("the Xbox MAC must be a unicast address")
+	ErrFrameTooLarge     = errors.New("captured frame larger than buffer")
+	ErrFrameTruncated    = errors.New("captured frame truncated by the snap length")
 )
 
 // InterfaceInfo contains information about a network interface.
 type InterfaceInfo struct {
-	Name        string   // System name (e.g., "eth0", "Ethernet")
+	Name        string   // System name (e.g., "eth0", "\Device\NPF_{GUID}")
 	Description string   // Human-readable description
 	Addresses   []string // IP addresses assigned to this interface
 	Flags       string   // Interface flags
+
+	Link      string // LinkUp, LinkDown, or "" if unknown
+	Wireless  bool   // Wi-Fi adapter, as reported by pcap
+	SpeedMbps int    // Negotiated link speed, 0 if unknown
+
+	// FriendlyName is the OS name of the interface where it differs from
+	// Name: on Windows, the "Ethernet"/"Wi-Fi" name from Network Connections.
+	FriendlyName string
 }
 
 // Capture handles pcap packet capture and injection.
 type Capture struct {
-	handle  *pcap.Handle
-	xboxMAC net.HardwareAddr
-	ifName  string
-	logger  *logging.Logger
+	handle       *pcap.Handle
+	source       packetSource // handle, or a fake in tests
+	injectHandle *pcap.Handle // nil when injecting on the capture interface
+	xboxMAC      net.HardwareAddr
+	ifName       string
+	injectIfName string
+	logger       *logging.Logger
+	truncated    atomic.Uint64
+}
+
+// packetSource is the part of *pcap.Handle that frames are read from.
+type packetSource interface {
+	ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error)
 }
 
 // Config holds capture configuration.
+//
+// By default frames are captured from and injected onto Interface. Setting
+// CaptureInterface or InjectInterface overrides one side, for setups where
+// the Xbox and the injection target are on different segments (e.g. a pair
+// of interfaces bridged by the host); a separate pcap handle is then opened
+// for injection.
 type Config struct {
-	Interface string           // Network interface name
-	XboxMAC   net.HardwareAddr // Xbox MAC address to filter
-	Logger    *logging.Logger
+	Interface        string           // Network interface name
+	CaptureInterface string           // Optional: capture from this interface instead
+	InjectInterface  string           // Optional: inject onto this interface instead
+	XboxMAC          net.HardwareAddr // Xbox MAC address to filter
+	Logger           *logging.Logger
+
+	// ExcludeDst, if set, also filters out captured frames addressed to this
+	// MAC, normally the injecting NIC's own. On hypervisors injected frames
+	// can bounce around a virtual switch and be captured again.
+	ExcludeDst net.HardwareAddr
+
+	// Promiscuous opens the capture interface in promiscuous mode
+	// (default: true). Without it the NIC only passes up frames addressed to
+	// this machine, broadcasts and multicasts, so unicast frames from the
+	// Xbox to other hosts may be missed.
+	Promiscuous *bool
+}
+
+// promiscuous reports whether the capture handle should be promiscuous.
+func (cfg Config) promiscuous() bool {
+	return cfg.Promiscuous == nil || *cfg.Promiscuous
 }
 
 // CheckNpcapInstalled checks if Npcap is installed on Windows.
@@ -142,11 +191,25 @@ func ListInterfaces() ([]InterfaceInfo, error) {
 		return nil, fmt.Errorf("failed to list interfaces: %w\n\n%s", err, NpcapInstallHelp())
 	}
 
+	// pcap only knows Windows interfaces by GUID; look up the names users know
+	var friendly map[string]string
+	if runtime.GOOS == "windows" {
+		if ifaces, err := localInterfaces(); err == nil {
+			friendly = friendlyNames(devices, ifaces)
+		}
+	}
+
 	var interfaces []InterfaceInfo
 	for _, dev := range devices {
 		info := InterfaceInfo{
-			Name:        dev.Name,
-			Description: dev.Description,
+			Name:         dev.Name,
+			Description:  dev.Description,
+			FriendlyName: friendly[dev.Name],
+			Link:         linkState(dev.Flags),
+			Wireless:     dev.Flags&pcapIfWireless != 0,
+		}
+		if info.Link != LinkDown {
+			info.SpeedMbps = linkSpeed(dev.Name)
 		}
 
 		// Collect IP addresses
@@ -156,12 +219,11 @@ func ListInterfaces() ([]InterfaceInfo, error) {
 			}
 		}
 
-		// Build flags string
-		var flags []string
-		if len(dev.Addresses) > 0 {
-			flags = append(flags, "UP")
+		// Build flags string; without pcap flags, an address suggests UP
+		info.Flags = pcapFlagNames(dev.Flags)
+		if info.Flags == "" && len(dev.Addresses) > 0 {
+			info.Flags = "UP"
 		}
-		info.Flags = strings.Join(flags, ",")
 
 		interfaces = append(interfaces, info)
 	}
@@ -169,13 +231,47 @@ func ListInterfaces() ([]InterfaceInfo, error) {
 	return interfaces, nil
 }
 
-// FindInterface finds an interface by name (exact or partial match).
+// Usable reports whether the interface looks like one an Xbox could be on:
+// up (or of unknown link state but flagged UP) with a non-loopback IPv4
+// address. Interfaces dedicated to the Xbox may have no address at all, so
+// this is for narrowing listings, not for rejecting --interface.
+func (i InterfaceInfo) Usable() bool {
+	up := i.Link == LinkUp || (i.Link == "" && slices.Contains(strings.Split(i.Flags, ","), "UP"))
+	if !up {
+		return false
+	}
+	for _, addr := range i.Addresses {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil && !ip.IsLoopback() {
+			return true
+		}
+	}
+	return false
+}
+
+// UsableInterfaces returns the interfaces for which Usable reports true.
+func UsableInterfaces(interfaces []InterfaceInfo) []InterfaceInfo {
+	var usable []InterfaceInfo
+	for _, iface := range interfaces {
+		if iface.Usable() {
+			usable = append(usable, iface)
+		}
+	}
+	return usable
+}
+
+// FindInterface finds an interface by name (exact or partial match). On
+// Windows the friendly name ("Ethernet", "Wi-Fi") also matches.
 func FindInterface(name string) (*InterfaceInfo, error) {
 	interfaces, err := ListInterfaces()
 	if err != nil {
 		return nil, err
 	}
+	return findInterface(interfaces, name)
+}
 
+// findInterface picks name from interfaces, preferring exact matches on the
+// device name, then on the friendly name, over description substrings.
+func findInterface(interfaces []InterfaceInfo, name string) (*InterfaceInfo, error) {
 	// Try exact match first
 	for _, iface := range interfaces {
 		if iface.Name == name {
@@ -191,6 +287,13 @@ func FindInterface(name string) (*InterfaceInfo, error) {
 		}
 	}
 
+	// Try the friendly name (Windows), which is exact, unlike descriptions
+	for _, iface := range interfaces {
+		if iface.FriendlyName != "" && strings.ToLower(iface.FriendlyName) == nameLower {
+			return &iface, nil
+		}
+	}
+
 	// Try partial match on description (useful on Windows)
 	for _, iface := range interfaces {
 		if strings.Contains(strings.ToLower(iface.Description), nameLower) {
@@ -203,16 +306,7 @@ func FindInterface(name string) (*InterfaceInfo, error) {
 
 // ParseMAC parses a MAC address in XX:XX:XX:XX:XX:XX or XX-XX-XX-XX-XX-XX format.
 func ParseMAC(s string) (net.HardwareAddr, error) {
-	// Normalize to colon separator
-	s = strings.ReplaceAll(s, "-", ":")
-	mac, err := net.ParseMAC(s)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrInvalidMAC, err)
-	}
-	if len(mac) != 6 {
-		return nil, fmt.Errorf("%w: expected 6 bytes, got %d", ErrInvalidMAC, len(mac))
-	}
-	return mac, nil
+	return macaddr.Parse(s)
 }
 
 // New creates a new Capture instance.
@@ -223,24 +317,147 @@ func New(cfg Config) (*Capture, error) {
 	if len(cfg.XboxMAC) != 6 {
 		return nil, ErrInvalidMAC
 	}
+	if !macaddr.IsUnicast(cfg.XboxMAC) {
+		// A broadcast or multicast address is never a frame's source, so
+		// the capture filter would match nothing
+		return nil, fmt.Errorf("%w, not %s", ErrNotUnicastMAC, cfg.XboxMAC)
+	}
+	if cfg.ExcludeDst != nil && len(cfg.ExcludeDst) != 6 {
+		return nil, fmt.Errorf("exclude destination: %w", ErrInvalidMAC)
+	}
 
 	// Check Npcap on Windows
 	if err := CheckNpcapInstalled(); err != nil {
 		return nil, fmt.Errorf("%w\n\n%s", err, NpcapInstallHelp())
 	}
 
-	// Find the interface
-	iface, err := FindInterface(cfg.Interface)
+	// Find the interfaces (both must exist before anything is opened)
+	captureName := cfg.Interface
+	if cfg.CaptureInterface != "" {
+		captureName = cfg.CaptureInterface
+	}
+	iface, err := FindInterface(captureName)
+	if err != nil {
+		return nil, err
+	}
+	injectIface := iface
+	if cfg.InjectInterface != "" {
+		injectIface, err = FindInterface(cfg.InjectInterface)
+		if err != nil {
+			return nil, fmt.Errorf("inject interface: %w", err)
+		}
+	}
+
+	cfg.Logger.Debug("Opening interface %s (%s, promiscuous: %t)", iface.Name, iface.Description, cfg.promiscuous())
+
+	handle, err := openHandle(iface.Name, cfg.promiscuous())
+	if err != nil {
+		return nil, err
+	}
+
+	// Set BPF filter to capture only packets from the Xbox MAC
+	// This significantly reduces CPU usage by filtering in the kernel
+	filter := captureFilter(cfg.XboxMAC, cfg.ExcludeDst)
+	if err := handle.SetBPFFilter(filter); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("failed to set BPF filter %q: %w", filter, err)
+	}
+
+	cfg.Logger.Debug("BPF filter set: %s", filter)
+
+	c := &Capture{
+		handle:       handle,
+		source:       handle,
+		xboxMAC:      cfg.XboxMAC,
+		ifName:       iface.Name,
+		injectIfName: injectIface.Name,
+		logger:       cfg.Logger,
+	}
+
+	if injectIface.Name != iface.Name {
+		cfg.Logger.Debug("Opening inject interface %s (%s)", injectIface.Name, injectIface.Description)
+		c.injectHandle, err = openHandle(injectIface.Name, false)
+		if err != nil {
+			handle.Close()
+			return nil, err
+		}
+		// Nothing is read from the inject handle; keep the kernel from
+		// queueing packets on it (best-effort).
+		_ = c.injectHandle.SetBPFFilter("less 1")
+	}
+
+	return c, nil
+}
+
+// inactiveHandle is the part of *pcap.InactiveHandle that openHandle uses.
+type inactiveHandle interface {
+	SetSnapLen(snaplen int) error
+	SetPromisc(promisc bool) error
+	SetTimeout(timeout time.Duration) error
+	SetBufferSize(bufferSize int) error
+	Activate() (*pcap.Handle, error)
+	CleanUp()
+}
+
+// newInactiveHandle creates the handle openHandle configures (tests replace it).
+var newInactiveHandle = func(name string) (inactiveHandle, error) {
+	return pcap.NewInactiveHandle(name)
+}
+
+// captureFilter returns the BPF filter for frames sent by xboxMAC, excluding
+// frames addressed to excludeDst if it is set.
+func captureFilter(xboxMAC, excludeDst net.HardwareAddr) string {
+	filter := fmt.Sprintf("ether src %s", xboxMAC)
+	if len(excludeDst) > 0 {
+		filter += fmt.Sprintf(" and not ether dst %s", excludeDst)
+	}
+	return filter
+}
+
+// InterfaceMAC returns the hardware address of the named interface. name is
+// resolved like FindInterface; pcap device names that the OS doesn't know
+// (e.g. \Device\NPF_{GUID} on Windows) are matched by IP address.
+func InterfaceMAC(name string) (net.HardwareAddr, error) {
+	info, err := FindInterface(name)
 	if err != nil {
 		return nil, err
 	}
+	if ifi, err := net.InterfaceByName(info.Name); err == nil && len(ifi.HardwareAddr) == 6 {
+		return ifi.HardwareAddr, nil
+	}
 
-	cfg.Logger.Debug("Opening interface %s (%s)", iface.Name, iface.Description)
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	for _, ifi := range ifaces {
+		if len(ifi.HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			for _, ip := range info.Addresses {
+				if ip == ipNet.IP.String() {
+					return ifi.HardwareAddr, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("no hardware address found for interface %q", name)
+}
 
-	// Open pcap handle
-	inactive, err := pcap.NewInactiveHandle(iface.Name)
+// openHandle opens and activates a pcap handle on the named interface.
+func openHandle(name string, promisc bool) (*pcap.Handle, error) {
+	inactive, err := newInactiveHandle(name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create handle for %s: %w\n\n%s", iface.Name, err, NpcapInstallHelp())
+		return nil, fmt.Errorf("failed to create handle for %s: %w\n\n%s", name, err, NpcapInstallHelp())
 	}
 	defer inactive.CleanUp()
 
@@ -248,7 +465,7 @@ func New(cfg Config) (*Capture, error) {
 	if err := inactive.SetSnapLen(SnapLen); err != nil {
 		return nil, fmt.Errorf("failed to set snap length: %w", err)
 	}
-	if err := inactive.SetPromisc(true); err != nil {
+	if err := inactive.SetPromisc(promisc); err != nil {
 		return nil, fmt.Errorf("failed to set promiscuous mode: %w", err)
 	}
 	if err := inactive.SetTimeout(ReadTimeout); err != nil {
@@ -261,34 +478,56 @@ func New(cfg Config) (*Capture, error) {
 	// Activate the handle
 	handle, err := inactive.Activate()
 	if err != nil {
-		return nil, fmt.Errorf("failed to activate capture on %s: %w\n\n%s", iface.Name, err, NpcapInstallHelp())
+		return nil, fmt.Errorf("failed to activate capture on %s: %w\n\n%s", name, err, NpcapInstallHelp())
 	}
+	return handle, nil
+}
 
-	// Set BPF filter to capture only packets from the Xbox MAC
-	// This significantly reduces CPU usage by filtering in the kernel
-	filter := fmt.Sprintf("ether src %s", cfg.XboxMAC.String())
-	if err := handle.SetBPFFilter(filter); err != nil {
-		handle.Close()
-		return nil, fmt.Errorf("failed to set BPF filter %q: %w", filter, err)
+// ReadPacket reads the next packet from the capture.
+// Returns the raw Ethernet frame bytes, or nil if no packet is available.
+// Returns ErrFrameTruncated for a packet the capture cut short, which must
+// not be forwarded.
+func (c *Capture) ReadPacket() ([]byte, error) {
+	data, err := c.read()
+	if err != nil || data == nil {
+		return nil, err
 	}
 
-	cfg.Logger.Debug("BPF filter set: %s", filter)
+	if len(data) == 0 {
+		return nil, nil
+	}
 
-	c := &Capture{
-		handle:  handle,
-		xboxMAC: cfg.XboxMAC,
-		ifName:  iface.Name,
-		logger:  cfg.Logger,
+	// Make a copy since ZeroCopy data is only valid until next read
+	frame := make([]byte, len(data))
+	copy(frame, data)
+
+	return frame, nil
+}
+
+// ReadPacketInto reads the next packet into buf and returns its length.
+// Unlike ReadPacket it does not allocate, so callers can recycle buffers.
+// Returns 0 and nil error on timeout (no packet available), and
+// ErrFrameTooLarge if the packet does not fit in buf, or ErrFrameTruncated
+// like ReadPacket.
+func (c *Capture) ReadPacketInto(buf []byte) (int, error) {
+	data, err := c.read()
+	if err != nil || data == nil {
+		return 0, err
 	}
 
-	return c, nil
+	if len(data) > len(buf) {
+		return 0, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(data))
+	}
+
+	// ZeroCopy data is only valid until the next read
+	return copy(buf, data), nil
 }
 
-// ReadPacket reads the next packet from the capture.
-// Returns the raw Ethernet frame bytes, or nil if no packet is available.
-func (c *Capture) ReadPacket() ([]byte, error) {
+// read reads the next packet without copying it (valid until the next read),
+// or nil if no packet is available.
+func (c *Capture) read() ([]byte, error) {
 	// Use ZeroCopyReadPacketData for efficiency
-	data, _, err := c.handle.ZeroCopyReadPacketData()
+	data, ci, err := c.source.ZeroCopyReadPacketData()
 	if err != nil {
 		if err == pcap.NextErrorTimeoutExpired {
 			return nil, nil // No packet available
@@ -296,31 +535,66 @@ func (c *Capture) ReadPacket() ([]byte, error) {
 		return nil, err
 	}
 
-	if len(data) == 0 {
-		return nil, nil
+	// A lowered or platform-capped snap length hands back only the start of
+	// the frame; forwarding it would inject a corrupt one.
+	if ci.CaptureLength < ci.Length {
+		if c.truncated.Add(1) == 1 {
+			c.logger.Warn("Capture on %s is truncating frames (captured %d of %d bytes); dropping them. Is the snap length limited?",
+				c.ifName, ci.CaptureLength, ci.Length)
+		}
+		return nil, fmt.Errorf("%w: captured %d of %d bytes", ErrFrameTruncated, ci.CaptureLength, ci.Length)
 	}
+	return data, nil
+}
 
-	// Make a copy since ZeroCopy data is only valid until next read
-	frame := make([]byte, len(data))
-	copy(frame, data)
-
-	return frame, nil
+// Truncated returns the number of packets dropped because the capture cut
+// them short.
+func (c *Capture) Truncated() uint64 {
+	return c.truncated.Load()
 }
 
-// WritePacket injects a raw Ethernet frame onto the network.
+// WritePacket injects a raw Ethernet frame onto the inject interface.
 func (c *Capture) WritePacket(frame []byte) error {
 	if len(frame) < 14 {
 		return fmt.Errorf("frame too small: %d bytes", len(frame))
 	}
 
+	if c.injectHandle != nil {
+		return c.injectHandle.WritePacketData(frame)
+	}
 	return c.handle.WritePacketData(frame)
 }
 
-// Close closes the capture handle.
+// transientWriteErrors are the errors of a momentarily full send queue,
+// after which the same write usually succeeds.
+var transientWriteErrors = []syscall.Errno{syscall.ENOBUFS, syscall.EAGAIN, syscall.EINTR}
+
+// IsTransientWriteError reports whether a WritePacket error is worth
+// retrying shortly, e.g. ENOBUFS while the send ring is full. Other errors
+// (interface gone, frame rejected) will fail again.
+func IsTransientWriteError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, errno := range transientWriteErrors {
+		// pcap reports send errors as text rather than wrapping the errno
+		if errors.Is(err, errno) || strings.Contains(err.Error(), errno.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes the capture (and inject) handles.
 func (c *Capture) Close() error {
 	if c.handle != nil {
 		c.handle.Close()
 		c.handle = nil
+		c.source = nil
+	}
+	if c.injectHandle != nil {
+		c.injectHandle.Close()
+		c.injectHandle = nil
 	}
 	return nil
 }
@@ -338,6 +612,12 @@ func (c *Capture) InterfaceName() string {
 	return c.ifName
 }
 
+// InjectInterfaceName returns the name of the interface frames are injected
+// onto, which is InterfaceName unless Config.InjectInterface was set.
+func (c *Capture) InjectInterfaceName() string {
+	return c.injectIfName
+}
+
 // XboxMAC returns the Xbox MAC address being filtered.
 func (c *Capture) XboxMAC() net.HardwareAddr {
 	return c.xboxMAC
@@ -350,18 +630,40 @@ func FormatInterfaceList(interfaces []InterfaceInfo) string {
 
 	for i, iface := range interfaces {
 		sb.WriteString(fmt.Sprintf("  %d. %s\n", i+1, iface.Name))
+		if iface.FriendlyName != "" {
+			sb.WriteString(fmt.Sprintf("     Name:        %s\n", iface.FriendlyName))
+		}
 		if iface.Description != "" {
 			sb.WriteString(fmt.Sprintf("     Description: %s\n", iface.Description))
 		}
 		if len(iface.Addresses) > 0 {
 			sb.WriteString(fmt.Sprintf("     Addresses:   %s\n", strings.Join(iface.Addresses, ", ")))
 		}
+		if link := formatLink(iface); link != "" {
+			sb.WriteString(fmt.Sprintf("     Link:        %s\n", link))
+		}
 		sb.WriteString("\n")
 	}
 
 	return sb.String()
 }
 
+// formatLink describes an interface's link, e.g. "up, 1 Gbps" or
+// "down, wireless", leaving out whatever is unknown.
+func formatLink(iface InterfaceInfo) string {
+	var parts []string
+	if iface.Link != "" {
+		parts = append(parts, iface.Link)
+	}
+	if iface.SpeedMbps > 0 {
+		parts = append(parts, formatSpeed(iface.SpeedMbps))
+	}
+	if iface.Wireless {
+		parts = append(parts, "wireless")
+	}
+	return strings.Join(parts, ", ")
+}
+
 // DecodeEthernetFrame extracts basic info from an Ethernet frame for logging.
 func DecodeEthernetFrame(frame []byte) (srcMAC, dstMAC net.HardwareAddr, etherType uint16) {
 	if len(frame) < 14 {
@@ -375,6 +677,17 @@ func DecodeEthernetFrame(frame []byte) (srcMAC, dstMAC net.HardwareAddr, etherTy
 	return srcMAC, dstMAC, etherType
 }
 
+// MinEtherType is the smallest EtherType of an Ethernet II frame. Smaller
+// values in the EtherType field are IEEE 802.3 payload lengths.
+const MinEtherType = 0x0600
+
+// IsEthernetII reports whether etherType, as returned by
+// DecodeEthernetFrame, marks an Ethernet II frame rather than an 802.3
+// length-field frame (or a frame too short to have the field).
+func IsEthernetII(etherType uint16) bool {
+	return etherType >= MinEtherType
+}
+
 // EtherTypeName returns a human-readable name for common EtherTypes.
 func EtherTypeName(etherType uint16) string {
 	switch layers.EthernetType(etherType) {
@@ -1,9 +1,22 @@
 package capture
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+
+	"github.com/xbslink/xbslink-ng/internal/logging"
 )
 
 func TestParseMAC_Colons(t *testing.T) {
@@ -130,6 +143,312 @@ func TestFindInterface_NotFound(t *testing.T) {
 	}
 }
 
+func TestNew_InjectInterfaceNotFound(t *testing.T) {
+	interfaces, err := ListInterfaces()
+	if err != nil || len(interfaces) == 0 {
+		t.Skipf("no interfaces available: %v", err)
+	}
+
+	mac, _ := ParseMAC("00:50:F2:1A:2B:3C")
+	_, err = New(Config{
+		Interface:       interfaces[0].Name,
+		InjectInterface: "definitely-not-a-real-interface-name-12345",
+		XboxMAC:         mac,
+		Logger:          logging.NewLogger(logging.LevelError),
+	})
+	if !errors.Is(err, ErrInterfaceNotFound) {
+		t.Fatalf("expected ErrInterfaceNotFound for the inject interface, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "inject interface") {
+		t.Errorf("error should name the inject interface: %v", err)
+	}
+}
+
+func TestCaptureFilter(t *testing.T) {
+	xbox, _ := ParseMAC("00:50:F2:1A:2B:3C")
+	local, _ := ParseMAC("52:54:00:12:34:56")
+
+	if got, want := captureFilter(xbox, nil), "ether src 00:50:f2:1a:2b:3c"; got != want {
+		t.Errorf("captureFilter() = %q, want %q", got, want)
+	}
+	want := "ether src 00:50:f2:1a:2b:3c and not ether dst 52:54:00:12:34:56"
+	if got := captureFilter(xbox, local); got != want {
+		t.Errorf("captureFilter() with exclude = %q, want %q", got, want)
+	}
+}
+
+func TestNew_InvalidExcludeDst(t *testing.T) {
+	mac, _ := ParseMAC("00:50:F2:1A:2B:3C")
+	_, err := New(Config{
+		Interface:  "eth0",
+		XboxMAC:    mac,
+		ExcludeDst: net.HardwareAddr{0x01, 0x02},
+		Logger:     logging.NewLogger(logging.LevelError),
+	})
+	if !errors.Is(err, ErrInvalidMAC) {
+		t.Errorf("expected ErrInvalidMAC, got %v", err)
+	}
+}
+
+func TestNew_NotUnicastXboxMAC(t *testing.T) {
+	for _, s := range []string{"FF:FF:FF:FF:FF:FF", "01:00:5E:00:00:01", "33:33:00:00:00:01"} {
+		mac, _ := ParseMAC(s)
+		_, err := New(Config{
+			Interface: "eth0",
+			XboxMAC:   mac,
+			Logger:    logging.NewLogger(logging.LevelError),
+		})
+		if !errors.Is(err, ErrNotUnicastMAC) {
+			t.Errorf("New(XboxMAC %s) error = %v, want ErrNotUnicastMAC", s, err)
+		}
+	}
+}
+
+// fakeInactiveHandle records how openHandle configures a handle and fails
+// to activate.
+type fakeInactiveHandle struct {
+	promisc   bool
+	snapLen   int
+	cleanedUp bool
+}
+
+func (h *fakeInactiveHandle) SetSnapLen(n int) error         { h.snapLen = n; return nil }
+func (h *fakeInactiveHandle) SetPromisc(p bool) error        { h.promisc = p; return nil }
+func (h *fakeInactiveHandle) SetTimeout(time.Duration) error { return nil }
+func (h *fakeInactiveHandle) SetBufferSize(int) error        { return nil }
+func (h *fakeInactiveHandle) Activate() (*pcap.Handle, error) {
+	return nil, errors.New("not activated in tests")
+}
+func (h *fakeInactiveHandle) CleanUp() { h.cleanedUp = true }
+
+func TestOpenHandle_Promiscuous(t *testing.T) {
+	off := false
+	on := true
+	tests := []struct {
+		name string
+		cfg  Config
+		want bool
+	}{
+		{"default", Config{}, true},
+		{"enabled", Config{Promiscuous: &on}, true},
+		{"disabled", Config{Promiscuous: &off}, false},
+	}
+
+	orig := newInactiveHandle
+	t.Cleanup(func() { newInactiveHandle = orig })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInactiveHandle{promisc: !tt.want}
+			newInactiveHandle = func(string) (inactiveHandle, error) { return fake, nil }
+
+			if _, err := openHandle("eth0", tt.cfg.promiscuous()); err == nil {
+				t.Fatal("expected the fake activation error")
+			}
+			if fake.promisc != tt.want {
+				t.Errorf("SetPromisc(%t), want %t", fake.promisc, tt.want)
+			}
+			if fake.snapLen != SnapLen {
+				t.Errorf("SetSnapLen(%d), want %d", fake.snapLen, SnapLen)
+			}
+			if !fake.cleanedUp {
+				t.Error("inactive handle not cleaned up")
+			}
+		})
+	}
+}
+
+// fakeReader is a packetReader returning frames in order, then timeouts.
+type fakeReader struct {
+	frames [][]byte
+	err    error
+}
+
+func (r *fakeReader) ReadPacketInto(buf []byte) (int, error) {
+	if len(r.frames) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		time.Sleep(time.Millisecond) // like the pcap read timeout
+		return 0, nil
+	}
+	frame := r.frames[0]
+	r.frames = r.frames[1:]
+	return copy(buf, frame), nil
+}
+
+func TestWaitForFrameFrom(t *testing.T) {
+	xbox := net.HardwareAddr{0x00, 0x50, 0xf2, 0x12, 0x34, 0x56}
+	frameFrom := func(src net.HardwareAddr) []byte {
+		frame := make([]byte, 60)
+		copy(frame[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+		copy(frame[6:12], src)
+		return frame
+	}
+	other := frameFrom(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	readErr := errors.New("interface went away")
+
+	tests := []struct {
+		name    string
+		reader  *fakeReader
+		want    bool
+		wantErr error
+	}{
+		{"transmitting", &fakeReader{frames: [][]byte{other, frameFrom(xbox)}}, true, nil},
+		{"silent", &fakeReader{frames: [][]byte{other}}, false, nil},
+		{"read error", &fakeReader{err: readErr}, false, readErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			got, err := waitForFrameFrom(context.Background(), tt.reader, xbox, 50*time.Millisecond)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("waitForFrameFrom() = %t, %v; want %t, %v", got, err, tt.want, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %v with a 50ms timeout", elapsed)
+			}
+		})
+	}
+}
+
+// fakeSource is a packetSource returning packets in order, then timeouts.
+type fakeSource struct {
+	packets []fakePacket
+}
+
+type fakePacket struct {
+	data   []byte
+	length int // Original length on the wire
+}
+
+func (s *fakeSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(s.packets) == 0 {
+		return nil, gopacket.CaptureInfo{}, pcap.NextErrorTimeoutExpired
+	}
+	p := s.packets[0]
+	s.packets = s.packets[1:]
+	return p.data, gopacket.CaptureInfo{CaptureLength: len(p.data), Length: p.length}, nil
+}
+
+func TestReadPacket_Truncated(t *testing.T) {
+	frame := make([]byte, 1514)
+	for i := range frame {
+		frame[i] = byte(i)
+	}
+	src := &fakeSource{packets: []fakePacket{
+		{frame[:96], len(frame)},
+		{frame, len(frame)},
+		{frame[:96], len(frame)},
+	}}
+	c := &Capture{source: src, ifName: "eth0", logger: logging.NewLogger(logging.LevelError)}
+
+	if got, err := c.ReadPacket(); !errors.Is(err, ErrFrameTruncated) || got != nil {
+		t.Errorf("ReadPacket() = %d bytes, %v; want ErrFrameTruncated", len(got), err)
+	}
+	buf := make([]byte, SnapLen)
+	if n, err := c.ReadPacketInto(buf); err != nil || n != len(frame) {
+		t.Errorf("ReadPacketInto() = %d, %v; want the whole %d-byte frame", n, err, len(frame))
+	}
+	if n, err := c.ReadPacketInto(buf); !errors.Is(err, ErrFrameTruncated) || n != 0 {
+		t.Errorf("ReadPacketInto() = %d, %v; want ErrFrameTruncated", n, err)
+	}
+	if n, err := c.ReadPacketInto(buf); err != nil || n != 0 {
+		t.Errorf("ReadPacketInto() after the last packet = %d, %v; want a timeout", n, err)
+	}
+	if got := c.Truncated(); got != 2 {
+		t.Errorf("Truncated() = %d, want 2", got)
+	}
+}
+
+// windowsDevices is a synthetic pcap device list as Npcap reports it.
+var windowsDevices = []pcap.Interface{
+	{
+		Name:        `\Device\NPF_{3A1B2C4D-0000-4000-8000-000000000001}`,
+		Description: "Hyper-V Virtual Ethernet Adapter",
+		Addresses:   []pcap.InterfaceAddress{{IP: net.ParseIP("172.20.80.1")}},
+	},
+	{
+		Name:        `\Device\NPF_{3A1B2C4D-0000-4000-8000-000000000002}`,
+		Description: "Intel(R) Ethernet Connection (7) I219-V",
+		Addresses: []pcap.InterfaceAddress{
+			{IP: net.ParseIP("fe80::1c2d:3e4f:5a6b:7c8d")},
+			{IP: net.ParseIP("192.168.1.20")},
+		},
+	},
+	{
+		Name:        `\Device\NPF_{3A1B2C4D-0000-4000-8000-000000000003}`,
+		Description: "Intel(R) Wi-Fi 6 AX201 160MHz",
+	},
+	{
+		Name:        `\Device\NPF_Loopback`,
+		Description: "Adapter for loopback traffic capture",
+	},
+}
+
+func TestFriendlyNames(t *testing.T) {
+	ifaces := []netIface{
+		{Name: "vEthernet (Default Switch)", IPs: []net.IP{net.ParseIP("172.20.80.1")}},
+		{Name: "Ethernet", IPs: []net.IP{net.ParseIP("192.168.1.20"), net.ParseIP("fe80::1c2d:3e4f:5a6b:7c8d")}},
+		{Name: "Wi-Fi"}, // disconnected: no address to match on
+		{Name: "Loopback Pseudo-Interface 1", IPs: []net.IP{net.ParseIP("127.0.0.1")}},
+	}
+
+	names := friendlyNames(windowsDevices, ifaces)
+	want := map[string]string{
+		windowsDevices[0].Name: "vEthernet (Default Switch)",
+		windowsDevices[1].Name: "Ethernet",
+	}
+	if len(names) != len(want) {
+		t.Errorf("friendlyNames() = %v, want %v", names, want)
+	}
+	for dev, name := range want {
+		if names[dev] != name {
+			t.Errorf("friendlyNames()[%s] = %q, want %q", dev, names[dev], name)
+		}
+	}
+
+	// An address on two OS interfaces doesn't identify either
+	ifaces = append(ifaces, netIface{Name: "Ethernet 2", IPs: []net.IP{net.ParseIP("192.168.1.20")}})
+	if name, ok := friendlyNames(windowsDevices, ifaces)[windowsDevices[1].Name]; ok {
+		t.Errorf("ambiguous address mapped to %q", name)
+	}
+}
+
+func TestFindInterface_FriendlyName(t *testing.T) {
+	interfaces := []InterfaceInfo{
+		{Name: windowsDevices[0].Name, Description: windowsDevices[0].Description, FriendlyName: "vEthernet (Default Switch)"},
+		{Name: windowsDevices[1].Name, Description: windowsDevices[1].Description, FriendlyName: "Ethernet"},
+		{Name: windowsDevices[2].Name, Description: windowsDevices[2].Description},
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		// "ethernet" is also in the Hyper-V description, listed first
+		{"Ethernet", windowsDevices[1].Name},
+		{"ethernet", windowsDevices[1].Name},
+		{"vEthernet (Default Switch)", windowsDevices[0].Name},
+		{windowsDevices[2].Name, windowsDevices[2].Name},
+		// Description substrings still work without a friendly name
+		{"Wi-Fi 6", windowsDevices[2].Name},
+	}
+	for _, tt := range tests {
+		got, err := findInterface(interfaces, tt.name)
+		if err != nil {
+			t.Errorf("findInterface(%q) failed: %v", tt.name, err)
+			continue
+		}
+		if got.Name != tt.want {
+			t.Errorf("findInterface(%q) = %s, want %s", tt.name, got.Name, tt.want)
+		}
+	}
+	if _, err := findInterface(interfaces, "Bluetooth"); !errors.Is(err, ErrInterfaceNotFound) {
+		t.Errorf("findInterface(\"Bluetooth\") = %v, want ErrInterfaceNotFound", err)
+	}
+}
+
 func TestFormatInterfaceList(t *testing.T) {
 	interfaces := []InterfaceInfo{
 		{
@@ -160,6 +479,104 @@ func TestFormatInterfaceList(t *testing.T) {
 	if !strings.Contains(output, "lo") {
 		t.Error("expected lo in output")
 	}
+
+	output = FormatInterfaceList([]InterfaceInfo{{Name: windowsDevices[1].Name, FriendlyName: "Ethernet"}})
+	if !strings.Contains(output, "Name:        Ethernet") {
+		t.Errorf("expected friendly name in output:\n%s", output)
+	}
+	if strings.Contains(output, "Link:") {
+		t.Errorf("expected no link line when nothing is known:\n%s", output)
+	}
+
+	output = FormatInterfaceList([]InterfaceInfo{
+		{Name: "eth0", Link: LinkUp, SpeedMbps: 2500},
+		{Name: "wlan0", Link: LinkDown, Wireless: true},
+	})
+	for _, want := range []string{"Link:        up, 2.5 Gbps\n", "Link:        down, wireless\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output:\n%s", want, output)
+		}
+	}
+}
+
+func TestInterfaceInfo_Usable(t *testing.T) {
+	tests := []struct {
+		name  string
+		iface InterfaceInfo
+		want  bool
+	}{
+		{"up with IPv4", InterfaceInfo{Link: LinkUp, Addresses: []string{"fe80::1", "192.168.1.20"}}, true},
+		{"unknown link, UP flag", InterfaceInfo{Flags: "UP,RUNNING", Addresses: []string{"10.0.0.5"}}, true},
+		{"link down", InterfaceInfo{Link: LinkDown, Flags: "UP", Addresses: []string{"192.168.1.20"}}, false},
+		{"not UP", InterfaceInfo{Addresses: []string{"192.168.1.20"}}, false},
+		{"IPv6 only", InterfaceInfo{Link: LinkUp, Addresses: []string{"fe80::1"}}, false},
+		{"no address", InterfaceInfo{Link: LinkUp}, false},
+		{"loopback", InterfaceInfo{Flags: "UP,RUNNING,LOOPBACK", Addresses: []string{"127.0.0.1"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.iface.Usable(); got != tt.want {
+			t.Errorf("%s: Usable() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+
+	all := []InterfaceInfo{tests[0].iface, tests[2].iface, tests[1].iface}
+	if got := UsableInterfaces(all); len(got) != 2 || got[0].Link != LinkUp || got[1].Flags != "UP,RUNNING" {
+		t.Errorf("UsableInterfaces() = %+v, want the two usable ones in order", got)
+	}
+}
+
+func TestLinkState(t *testing.T) {
+	tests := []struct {
+		flags uint32
+		want  string
+	}{
+		{pcapIfUp | pcapIfRunning | pcapIfConnected, LinkUp},
+		{pcapIfUp | pcapIfDisconnected, LinkDown},
+		{pcapIfUp | pcapIfRunning | pcapIfLoopback | pcapIfNotApplicable, ""},
+		// Older libpcap: no connection status, so go by the carrier
+		{pcapIfUp | pcapIfRunning, LinkUp},
+		{pcapIfUp, LinkDown},
+		{0, ""},
+	}
+	for _, tt := range tests {
+		if got := linkState(tt.flags); got != tt.want {
+			t.Errorf("linkState(%#x) = %q, want %q", tt.flags, got, tt.want)
+		}
+	}
+	if got := pcapFlagNames(pcapIfUp | pcapIfRunning | pcapIfWireless); got != "UP,RUNNING,WIRELESS" {
+		t.Errorf("pcapFlagNames() = %q", got)
+	}
+}
+
+func TestLinkSpeed(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("link speed is only read on Linux")
+	}
+	dir := t.TempDir()
+	orig := sysClassNet
+	sysClassNet = dir
+	t.Cleanup(func() { sysClassNet = orig })
+
+	for name, speed := range map[string]string{"eth0": "1000\n", "eth1": "-1\n"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "speed"), []byte(speed), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]int{
+		"eth0":      1000,
+		"eth1":      0, // link down: speed unknown
+		"wlan0":     0, // no speed file
+		"../escape": 0,
+	}
+	for name, want := range tests {
+		if got := linkSpeed(name); got != want {
+			t.Errorf("linkSpeed(%q) = %d, want %d", name, got, want)
+		}
+	}
 }
 
 func TestDecodeEthernetFrame_Valid(t *testing.T) {
@@ -231,6 +648,64 @@ func TestDecodeEthernetFrame_ARP(t *testing.T) {
 	}
 }
 
+func TestIsEthernetII(t *testing.T) {
+	tests := []struct {
+		name  string
+		field []byte // bytes 12-13 of the frame
+		short bool
+		want  bool
+	}{
+		{"IPv4", []byte{0x08, 0x00}, false, true},
+		{"ARP", []byte{0x08, 0x06}, false, true},
+		{"lowest EtherType", []byte{0x06, 0x00}, false, true},
+		{"802.3 length 46", []byte{0x00, 0x2E}, false, false},
+		{"802.3 length 1500", []byte{0x05, 0xDC}, false, false},
+		{"undefined 1536 gap", []byte{0x05, 0xFF}, false, false},
+		{"too short", nil, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := make([]byte, 60)
+			if tt.short {
+				frame = frame[:12]
+			} else {
+				copy(frame[12:], tt.field)
+				// An 802.3 frame starts its payload with an LLC header
+				copy(frame[14:], []byte{0x42, 0x42, 0x03})
+			}
+			_, _, etherType := DecodeEthernetFrame(frame)
+			if got := IsEthernetII(etherType); got != tt.want {
+				t.Errorf("IsEthernetII(0x%04X) = %v, want %v", etherType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTransientWriteError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"wrapped ENOBUFS", fmt.Errorf("inject: %w", syscall.ENOBUFS), true},
+		{"pcap ENOBUFS text", errors.New("send: " + syscall.ENOBUFS.Error()), true},
+		{"EAGAIN", syscall.EAGAIN, true},
+		{"EINTR", syscall.EINTR, true},
+		{"interface down", syscall.ENETDOWN, false},
+		{"frame too small", errors.New("frame too small: 10 bytes"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransientWriteError(tt.err); got != tt.want {
+				t.Errorf("IsTransientWriteError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestEtherTypeName(t *testing.T) {
 	tests := []struct {
 		etherType uint16
@@ -252,6 +727,20 @@ func TestEtherTypeName(t *testing.T) {
 	}
 }
 
+func TestHexDump(t *testing.T) {
+	data := []byte("\x00\x50\xf2\x1a\x2b\x3cHello, System Link!")
+
+	want := "  0x0000:  0050 f21a 2b3c 4865 6c6c 6f2c 2053 7973  .P..+<Hello, Sys\n" +
+		"  0x0010:  7465 6d20 4c69 6e6b 21                   tem Link!"
+	if got := HexDump(data); got != want {
+		t.Errorf("HexDump =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := HexDump(nil); got != "" {
+		t.Errorf("HexDump(nil) = %q, want empty", got)
+	}
+}
+
 func TestNpcapInstallHelp(t *testing.T) {
 	help := NpcapInstallHelp()
 	if help == "" {
@@ -0,0 +1,44 @@
+package capture
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hexDumpWidth is the number of bytes shown per HexDump line.
+const hexDumpWidth = 16
+
+// HexDump formats data like tcpdump -X: one line per 16 bytes with the
+// offset, the bytes as groups of 4 hex digits, and printable ASCII ('.' for
+// anything else). Lines are separated by newlines with no trailing newline.
+func HexDump(data []byte) string {
+	var sb strings.Builder
+	for off := 0; off < len(data); off += hexDumpWidth {
+		line := data[off:min(off+hexDumpWidth, len(data))]
+		if off > 0 {
+			sb.WriteByte('\n')
+		}
+		fmt.Fprintf(&sb, "  0x%04x:  ", off)
+
+		for i := 0; i < hexDumpWidth; i++ {
+			if i < len(line) {
+				fmt.Fprintf(&sb, "%02x", line[i])
+			} else {
+				sb.WriteString("  ")
+			}
+			if i%2 == 1 {
+				sb.WriteByte(' ')
+			}
+		}
+
+		sb.WriteByte(' ')
+		for _, c := range line {
+			if c >= 0x20 && c < 0x7f {
+				sb.WriteByte(c)
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,98 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// pcap_if_t flags (pcap/pcap.h).
+const (
+	pcapIfLoopback         = 0x00000001
+	pcapIfUp               = 0x00000002
+	pcapIfRunning          = 0x00000004
+	pcapIfWireless         = 0x00000008
+	pcapIfConnectionStatus = 0x00000030
+	pcapIfConnected        = 0x00000010
+	pcapIfDisconnected     = 0x00000020
+	pcapIfNotApplicable    = 0x00000030
+)
+
+// Link states reported in InterfaceInfo.Link.
+const (
+	LinkUp   = "up"
+	LinkDown = "down"
+)
+
+// sysClassNet is where Linux exposes per-interface link details.
+var sysClassNet = "/sys/class/net"
+
+// pcapFlagNames describes pcap device flags, e.g. "UP,RUNNING,WIRELESS".
+func pcapFlagNames(flags uint32) string {
+	var names []string
+	for _, f := range []struct {
+		bit  uint32
+		name string
+	}{
+		{pcapIfUp, "UP"},
+		{pcapIfRunning, "RUNNING"},
+		{pcapIfLoopback, "LOOPBACK"},
+		{pcapIfWireless, "WIRELESS"},
+	} {
+		if flags&f.bit != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// linkState derives LinkUp or LinkDown from pcap device flags, or "" if
+// they don't say (older libpcap, or loopback and other virtual devices).
+func linkState(flags uint32) string {
+	switch flags & pcapIfConnectionStatus {
+	case pcapIfConnected:
+		return LinkUp
+	case pcapIfDisconnected:
+		return LinkDown
+	case pcapIfNotApplicable:
+		return ""
+	}
+	// Connection status unknown: fall back to the carrier (RUNNING)
+	switch {
+	case flags&pcapIfRunning != 0:
+		return LinkUp
+	case flags&pcapIfUp != 0:
+		return LinkDown // administratively up, but no carrier
+	default:
+		return ""
+	}
+}
+
+// linkSpeed returns the negotiated link speed of the named interface in
+// Mbit/s, or 0 where it can't be determined. Only Linux reports it, for
+// wired interfaces with a link.
+func linkSpeed(name string) int {
+	if runtime.GOOS != "linux" || name == "" || strings.ContainsAny(name, `/\`) {
+		return 0
+	}
+	data, err := os.ReadFile(filepath.Join(sysClassNet, name, "speed"))
+	if err != nil {
+		return 0 // no link, or not an Ethernet device
+	}
+	speed, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || speed <= 0 {
+		return 0 // -1 while the speed is unknown
+	}
+	return speed
+}
+
+// formatSpeed renders a link speed in Mbit/s, e.g. "100 Mbps", "2.5 Gbps".
+func formatSpeed(mbps int) string {
+	if mbps >= 1000 {
+		return strconv.FormatFloat(float64(mbps)/1000, 'f', -1, 64) + " Gbps"
+	}
+	return fmt.Sprintf("%d Mbps", mbps)
+}
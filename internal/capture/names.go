@@ -0,0 +1,76 @@
+package capture
+
+import (
+	"net"
+
+	"github.com/google/gopacket/pcap"
+)
+
+// netIface is an OS network interface as net.Interfaces reports it: on
+// Windows, Name is the friendly name shown in Network Connections
+// ("Ethernet", "Wi-Fi").
+type netIface struct {
+	Name string
+	IPs  []net.IP
+}
+
+// localInterfaces returns the OS interfaces with their IP addresses.
+func localInterfaces() ([]netIface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var out []netIface
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		entry := netIface{Name: iface.Name}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				entry.IPs = append(entry.IPs, ipnet.IP)
+			}
+		}
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+// friendlyNames maps pcap device names to OS interface names by matching IP
+// addresses. On Windows pcap devices are named \Device\NPF_{GUID}, which
+// nobody can type, while net.Interfaces uses the friendly names; neither
+// side carries the other's name. Devices without an address that belongs to
+// exactly one OS interface are left out.
+func friendlyNames(devices []pcap.Interface, ifaces []netIface) map[string]string {
+	names := make(map[string]string)
+	for _, dev := range devices {
+		match := ""
+		for _, iface := range ifaces {
+			if !sharesIP(dev.Addresses, iface.IPs) {
+				continue
+			}
+			if match != "" && match != iface.Name {
+				match = "" // ambiguous
+				break
+			}
+			match = iface.Name
+		}
+		if match != "" {
+			names[dev.Name] = match
+		}
+	}
+	return names
+}
+
+// sharesIP reports whether any of a pcap device's addresses is in ips.
+func sharesIP(addrs []pcap.InterfaceAddress, ips []net.IP) bool {
+	for _, addr := range addrs {
+		for _, ip := range ips {
+			if addr.IP != nil && addr.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
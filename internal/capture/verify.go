@@ -0,0 +1,46 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// packetReader is the part of *Capture that waitForFrameFrom reads.
+type packetReader interface {
+	ReadPacketInto(buf []byte) (int, error)
+}
+
+// WaitForXbox passively reads captured frames for up to timeout and reports
+// whether one came from the Xbox MAC, i.e. whether the console is on and
+// transmitting. Nothing is injected. The frames read are discarded, so call
+// it before handing the capture to a bridge.
+func (c *Capture) WaitForXbox(ctx context.Context, timeout time.Duration) (bool, error) {
+	return waitForFrameFrom(ctx, c, c.xboxMAC, timeout)
+}
+
+// waitForFrameFrom reads from r until a frame with source mac arrives,
+// timeout elapses or ctx is done.
+func waitForFrameFrom(ctx context.Context, r packetReader, mac net.HardwareAddr, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, SnapLen)
+	for time.Now().Before(deadline) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		n, err := r.ReadPacketInto(buf)
+		if err != nil {
+			if errors.Is(err, ErrFrameTooLarge) || errors.Is(err, ErrFrameTruncated) {
+				continue
+			}
+			return false, err
+		}
+		// The capture filter already matches the source; check anyway
+		if n >= 12 && bytes.Equal(buf[6:12], mac) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -3,16 +3,52 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/macaddr"
 )
 
+// MaxRecentXboxMACs is how many Xbox MACs RecentXboxMACs keeps.
+const MaxRecentXboxMACs = 5
+
+// CurrentVersion is the config schema version written by this build.
+//
+// Version history:
+//   - 0: unversioned; only last_xbox_mac
+//   - 1: adds version and recent_xbox_macs
+const CurrentVersion = 1
+
+// ErrNewerVersion is returned when the config file was written by a newer
+// version of xbslink-ng. LoadFrom still returns the fields it understands;
+// SaveTo refuses to overwrite such a file so no settings are lost.
+var ErrNewerVersion = errors.New("config written by a newer version")
+
 // Config holds the persistent configuration.
 type Config struct {
+	// Version is the schema version of the file (see CurrentVersion).
+	Version int `json:"version"`
+
 	// LastXboxMAC is the MAC address of the last discovered Xbox.
+	// Kept in step with RecentXboxMACs for older versions reading the file.
 	LastXboxMAC string `json:"last_xbox_mac,omitempty"`
+
+	// RecentXboxMACs lists recently used Xboxes, most recent first.
+	RecentXboxMACs []MACEntry `json:"recent_xbox_macs,omitempty"`
+
+	// Warnings describes problems found and worked around while loading,
+	// such as invalid saved MACs. It is not saved.
+	Warnings []string `json:"-"`
+}
+
+// MACEntry is an Xbox MAC address and when it was last used.
+type MACEntry struct {
+	MAC      string    `json:"mac"`
+	LastSeen time.Time `json:"last_seen"`
 }
 
 // DefaultConfigDir returns the default configuration directory.
@@ -46,12 +82,16 @@ func Load() (*Config, error) {
 
 // LoadFrom reads the configuration from the specified file path.
 // Returns an empty Config if the file doesn't exist.
+//
+// Files from older schema versions are migrated and rewritten. Files from a
+// newer version are returned as parsed along with an error wrapping
+// ErrNewerVersion, which callers should treat as a warning.
 func LoadFrom(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// File doesn't exist yet, return empty config
-			return &Config{}, nil
+			return &Config{Version: CurrentVersion}, nil
 		}
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
@@ -61,9 +101,79 @@ func LoadFrom(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	if cfg.Version > CurrentVersion {
+		return &cfg, fmt.Errorf("%w: schema version %d, this build supports up to %d",
+			ErrNewerVersion, cfg.Version, CurrentVersion)
+	}
+
+	// Date migrated entries by when the file was last written.
+	seen := time.Now()
+	if info, err := os.Stat(path); err == nil {
+		seen = info.ModTime()
+	}
+	if cfg.migrate(seen) {
+		// Best-effort: the migrated config is usable in memory either way,
+		// and a failed rewrite is simply retried on the next load.
+		_ = cfg.SaveTo(path)
+	}
+	// Only after the rewrite, so an invalid MAC stays in the file for the
+	// user to fix rather than being silently erased
+	cfg.validateMACs(path)
+
 	return &cfg, nil
 }
 
+// validateMACs drops invalid saved MACs (typically from hand edits),
+// recording a warning for each so callers can explain why the MAC is not
+// used. Recent entries are normalized to lowercase colon form; LastXboxMAC
+// is left as written since GetXboxMAC normalizes it on use.
+func (c *Config) validateMACs(path string) {
+	if c.LastXboxMAC != "" {
+		if _, err := macaddr.Parse(c.LastXboxMAC); err != nil {
+			c.Warnings = append(c.Warnings, fmt.Sprintf(
+				"saved Xbox MAC %q in %s is invalid and will be ignored", c.LastXboxMAC, path))
+			c.LastXboxMAC = ""
+		}
+	}
+
+	recent := c.RecentXboxMACs[:0]
+	for _, entry := range c.RecentXboxMACs {
+		mac, err := macaddr.Parse(entry.MAC)
+		if err != nil {
+			c.Warnings = append(c.Warnings, fmt.Sprintf(
+				"recent Xbox MAC %q in %s is invalid and will be ignored", entry.MAC, path))
+			continue
+		}
+		entry.MAC = mac.String()
+		recent = append(recent, entry)
+	}
+	if len(recent) == 0 {
+		recent = nil
+	}
+	c.RecentXboxMACs = recent
+}
+
+// migrate upgrades c from an older schema version to CurrentVersion, using
+// seen as the last-seen time for entries that had none. It reports whether
+// anything changed. Configs at CurrentVersion or newer are left untouched.
+func (c *Config) migrate(seen time.Time) bool {
+	if c.Version >= CurrentVersion {
+		return false
+	}
+
+	// v0 -> v1: LastXboxMAC seeds the recent list.
+	if c.Version < 1 {
+		if c.LastXboxMAC != "" && len(c.RecentXboxMACs) == 0 {
+			if mac, err := macaddr.Parse(c.LastXboxMAC); err == nil {
+				c.RecentXboxMACs = []MACEntry{{MAC: mac.String(), LastSeen: seen}}
+			}
+		}
+	}
+
+	c.Version = CurrentVersion
+	return true
+}
+
 // Save writes the configuration to the default config file.
 func (c *Config) Save() error {
 	path, err := DefaultConfigPath()
@@ -74,7 +184,13 @@ func (c *Config) Save() error {
 }
 
 // SaveTo writes the configuration to the specified file path.
+// It returns ErrNewerVersion rather than overwrite a newer schema.
 func (c *Config) SaveTo(path string) error {
+	if c.Version > CurrentVersion {
+		return fmt.Errorf("%w: not overwriting schema version %d", ErrNewerVersion, c.Version)
+	}
+	c.migrate(time.Now())
+
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -88,21 +204,86 @@ func (c *Config) SaveTo(path string) error {
 	}
 
 	// Write to file
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := writeFileAtomic(path, data, 0644); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
 	return nil
 }
 
-// GetXboxMAC returns the saved Xbox MAC address as a net.HardwareAddr.
+// writeTemp writes data to the temp file; a variable so tests can simulate
+// a write failing part way through.
+var writeTemp = func(f *os.File, data []byte) error {
+	_, err := f.Write(data)
+	return err
+}
+
+// writeFileAtomic writes data to a temp file in path's directory and renames
+// it over path, so a crash mid-write never leaves a truncated file behind.
+// An existing file's mode is preserved; otherwise perm is used. The rename is
+// atomic on POSIX and best-effort on Windows.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		if tmpPath != "" {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if err := writeTemp(tmp, data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	tmpPath = ""
+	return nil
+}
+
+// GetXboxMAC returns the saved Xbox MAC address as a net.HardwareAddr,
+// preferring the most recently seen valid entry in RecentXboxMACs and falling
+// back to LastXboxMAC (configs written by older versions).
 // Returns nil if no MAC is saved or if the saved MAC is invalid.
 func (c *Config) GetXboxMAC() net.HardwareAddr {
+	var best net.HardwareAddr
+	var bestSeen time.Time
+	for _, entry := range c.RecentXboxMACs {
+		mac, err := macaddr.Parse(entry.MAC)
+		if err != nil {
+			continue
+		}
+		if best == nil || entry.LastSeen.After(bestSeen) {
+			best, bestSeen = mac, entry.LastSeen
+		}
+	}
+	if best != nil {
+		return best
+	}
+
 	if c.LastXboxMAC == "" {
 		return nil
 	}
 
-	mac, err := net.ParseMAC(c.LastXboxMAC)
+	mac, err := macaddr.Parse(c.LastXboxMAC)
 	if err != nil {
 		return nil
 	}
@@ -110,7 +291,39 @@ func (c *Config) GetXboxMAC() net.HardwareAddr {
 	return mac
 }
 
-// SetXboxMAC saves the Xbox MAC address.
+// SetXboxMAC saves the Xbox MAC address as seen now.
 func (c *Config) SetXboxMAC(mac net.HardwareAddr) {
-	c.LastXboxMAC = mac.String()
+	c.AddXboxMAC(mac, time.Now())
+}
+
+// AddXboxMAC records mac as seen at seen. It moves to the front of
+// RecentXboxMACs (replacing any older entry for the same MAC), the list is
+// capped at MaxRecentXboxMACs, and LastXboxMAC is updated.
+func (c *Config) AddXboxMAC(mac net.HardwareAddr, seen time.Time) {
+	s := mac.String()
+
+	recent := make([]MACEntry, 0, MaxRecentXboxMACs)
+	recent = append(recent, MACEntry{MAC: s, LastSeen: seen})
+	for _, entry := range c.RecentXboxMACs {
+		if len(recent) == MaxRecentXboxMACs {
+			break
+		}
+		if sameMAC(entry.MAC, s) {
+			continue
+		}
+		recent = append(recent, entry)
+	}
+
+	c.RecentXboxMACs = recent
+	c.LastXboxMAC = s
+}
+
+// sameMAC reports whether a and b are the same MAC, ignoring formatting.
+func sameMAC(a, b string) bool {
+	ma, errA := macaddr.Parse(a)
+	mb, errB := macaddr.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return ma.String() == mb.String()
 }
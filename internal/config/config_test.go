@@ -1,10 +1,16 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfig_SaveAndLoad(t *testing.T) {
@@ -52,6 +58,145 @@ func TestConfig_LoadNonExistent(t *testing.T) {
 	}
 }
 
+func TestLoadFrom_MigratesV0(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	if err := os.WriteFile(configPath, []byte(`{"last_xbox_mac": "00:50:F2:1A:2B:3C"}`), 0644); err != nil {
+		t.Fatalf("Failed to write v0 config: %v", err)
+	}
+	modTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(configPath, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+
+	cfg, err := LoadFrom(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load v0 config: %v", err)
+	}
+
+	if cfg.Version != CurrentVersion {
+		t.Errorf("Expected Version %d, got %d", CurrentVersion, cfg.Version)
+	}
+	if cfg.LastXboxMAC != "00:50:F2:1A:2B:3C" {
+		t.Errorf("Expected LastXboxMAC kept, got %q", cfg.LastXboxMAC)
+	}
+	if len(cfg.RecentXboxMACs) != 1 {
+		t.Fatalf("Expected 1 recent MAC, got %+v", cfg.RecentXboxMACs)
+	}
+	if entry := cfg.RecentXboxMACs[0]; entry.MAC != "00:50:f2:1a:2b:3c" || !entry.LastSeen.Equal(modTime) {
+		t.Errorf("Expected migrated entry 00:50:f2:1a:2b:3c seen at %v, got %+v", modTime, entry)
+	}
+
+	// The migrated schema is written back.
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	var onDisk Config
+	if err := json.Unmarshal(data, &onDisk); err != nil {
+		t.Fatalf("Failed to parse rewritten config: %v", err)
+	}
+	if onDisk.Version != CurrentVersion || len(onDisk.RecentXboxMACs) != 1 {
+		t.Errorf("Expected rewritten v%d config with 1 recent MAC, got %s", CurrentVersion, data)
+	}
+}
+
+func TestLoadFrom_InvalidMACWarns(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	data := []byte(`{
+  "version": 1,
+  "last_xbox_mac": "00:50:F2:ZZ:2B:3C",
+  "recent_xbox_macs": [
+    {"mac": "00-50-F2-00-00-01", "last_seen": "2026-01-01T00:00:00Z"},
+    {"mac": "00:50:f2:00:00", "last_seen": "2026-01-02T00:00:00Z"}
+  ]
+}`)
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadFrom(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if len(cfg.Warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, got %q", cfg.Warnings)
+	}
+	if !strings.Contains(cfg.Warnings[0], "00:50:F2:ZZ:2B:3C") || !strings.Contains(cfg.Warnings[0], "invalid") {
+		t.Errorf("Expected warning naming the invalid MAC, got %q", cfg.Warnings[0])
+	}
+	if cfg.LastXboxMAC != "" {
+		t.Errorf("Expected invalid LastXboxMAC to be dropped, got %q", cfg.LastXboxMAC)
+	}
+	if len(cfg.RecentXboxMACs) != 1 || cfg.RecentXboxMACs[0].MAC != "00:50:f2:00:00:01" {
+		t.Errorf("Expected one normalized recent MAC, got %+v", cfg.RecentXboxMACs)
+	}
+	if got := cfg.GetXboxMAC(); got.String() != "00:50:f2:00:00:01" {
+		t.Errorf("Expected GetXboxMAC 00:50:f2:00:00:01, got %v", got)
+	}
+}
+
+func TestLoadFrom_MigrationKeepsInvalidMAC(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	// A v0 file, so loading it rewrites it
+	if err := os.WriteFile(configPath, []byte(`{"last_xbox_mac": "00:50:F2:ZZ:2B:3C"}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadFrom(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.LastXboxMAC != "" || len(cfg.Warnings) != 1 {
+		t.Errorf("Expected the invalid MAC ignored with a warning, got %q, %q", cfg.LastXboxMAC, cfg.Warnings)
+	}
+
+	// The file is migrated, but the MAC is left for the user to fix
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	var onDisk Config
+	if err := json.Unmarshal(data, &onDisk); err != nil {
+		t.Fatalf("Failed to parse rewritten config: %v", err)
+	}
+	if onDisk.Version != CurrentVersion || onDisk.LastXboxMAC != "00:50:F2:ZZ:2B:3C" {
+		t.Errorf("Expected a v%d config keeping the invalid MAC, got %s", CurrentVersion, data)
+	}
+}
+
+func TestLoadFrom_NewerVersion(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	future := []byte(`{"version": 99, "last_xbox_mac": "00:50:f2:1a:2b:3c", "future_field": true}`)
+	if err := os.WriteFile(configPath, future, 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadFrom(configPath)
+	if !errors.Is(err, ErrNewerVersion) {
+		t.Fatalf("Expected ErrNewerVersion, got %v", err)
+	}
+	if cfg == nil || cfg.LastXboxMAC != "00:50:f2:1a:2b:3c" {
+		t.Fatalf("Expected known fields to be loaded, got %+v", cfg)
+	}
+
+	if err := cfg.SaveTo(configPath); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("Expected SaveTo to refuse newer schema, got %v", err)
+	}
+	data, _ := os.ReadFile(configPath)
+	if string(data) != string(future) {
+		t.Errorf("Newer config was overwritten: %s", data)
+	}
+}
+
 func TestConfig_GetXboxMAC(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -109,6 +254,125 @@ func TestConfig_SetXboxMAC(t *testing.T) {
 	}
 }
 
+func TestConfig_SaveToFailedWriteKeepsOldConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	good := &Config{LastXboxMAC: "00:50:f2:1a:2b:3c"}
+	if err := good.SaveTo(configPath); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	if err := os.Chmod(configPath, 0600); err != nil {
+		t.Fatalf("Failed to chmod config: %v", err)
+	}
+
+	orig := writeTemp
+	writeTemp = func(f *os.File, data []byte) error {
+		f.Write(data[:len(data)/2])
+		return errors.New("simulated crash")
+	}
+	defer func() { writeTemp = orig }()
+
+	bad := &Config{LastXboxMAC: "00:50:f2:ff:ff:ff"}
+	if err := bad.SaveTo(configPath); err == nil {
+		t.Fatal("Expected error from failed write")
+	}
+
+	loaded, err := LoadFrom(configPath)
+	if err != nil {
+		t.Fatalf("Config corrupted by failed write: %v", err)
+	}
+	if loaded.LastXboxMAC != good.LastXboxMAC {
+		t.Errorf("Expected LastXboxMAC %q, got %q", good.LastXboxMAC, loaded.LastXboxMAC)
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to read dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only config.json in dir, found %d entries", len(entries))
+	}
+
+	// A successful save preserves the existing file mode.
+	writeTemp = orig
+	if err := bad.SaveTo(configPath); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	info, err := os.Stat(configPath)
+	if err != nil {
+		t.Fatalf("Failed to stat config: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600 preserved, got %v", info.Mode().Perm())
+	}
+}
+
+func TestConfig_AddXboxMAC_Dedupes(t *testing.T) {
+	cfg := &Config{}
+	a, _ := net.ParseMAC("00:50:f2:00:00:01")
+	b, _ := net.ParseMAC("00:50:f2:00:00:02")
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cfg.AddXboxMAC(a, base)
+	cfg.AddXboxMAC(b, base.Add(time.Minute))
+	cfg.AddXboxMAC(a, base.Add(2*time.Minute))
+
+	if len(cfg.RecentXboxMACs) != 2 {
+		t.Fatalf("Expected 2 recent MACs, got %d: %+v", len(cfg.RecentXboxMACs), cfg.RecentXboxMACs)
+	}
+	if cfg.RecentXboxMACs[0].MAC != a.String() || !cfg.RecentXboxMACs[0].LastSeen.Equal(base.Add(2*time.Minute)) {
+		t.Errorf("Expected %s seen at +2m first, got %+v", a, cfg.RecentXboxMACs[0])
+	}
+	if cfg.RecentXboxMACs[1].MAC != b.String() {
+		t.Errorf("Expected %s second, got %+v", b, cfg.RecentXboxMACs[1])
+	}
+	if cfg.LastXboxMAC != a.String() {
+		t.Errorf("Expected LastXboxMAC %s, got %q", a, cfg.LastXboxMAC)
+	}
+	if got := cfg.GetXboxMAC(); got.String() != a.String() {
+		t.Errorf("Expected GetXboxMAC %s, got %v", a, got)
+	}
+}
+
+func TestConfig_AddXboxMAC_Caps(t *testing.T) {
+	cfg := &Config{}
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < MaxRecentXboxMACs+3; i++ {
+		mac := net.HardwareAddr{0x00, 0x50, 0xf2, 0x00, 0x00, byte(i)}
+		cfg.AddXboxMAC(mac, base.Add(time.Duration(i)*time.Minute))
+	}
+
+	if len(cfg.RecentXboxMACs) != MaxRecentXboxMACs {
+		t.Fatalf("Expected %d recent MACs, got %d", MaxRecentXboxMACs, len(cfg.RecentXboxMACs))
+	}
+	newest := net.HardwareAddr{0x00, 0x50, 0xf2, 0x00, 0x00, byte(MaxRecentXboxMACs + 2)}
+	if cfg.RecentXboxMACs[0].MAC != newest.String() {
+		t.Errorf("Expected newest %s first, got %s", newest, cfg.RecentXboxMACs[0].MAC)
+	}
+	oldestKept := net.HardwareAddr{0x00, 0x50, 0xf2, 0x00, 0x00, 3}
+	if last := cfg.RecentXboxMACs[MaxRecentXboxMACs-1].MAC; last != oldestKept.String() {
+		t.Errorf("Expected oldest kept %s last, got %s", oldestKept, last)
+	}
+}
+
+func TestConfig_GetXboxMAC_PrefersMostRecent(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cfg := &Config{
+		LastXboxMAC: "00:50:f2:00:00:09",
+		RecentXboxMACs: []MACEntry{
+			{MAC: "00:50:f2:00:00:01", LastSeen: base},
+			{MAC: "invalid", LastSeen: base.Add(time.Hour)},
+			{MAC: "00:50:f2:00:00:02", LastSeen: base.Add(time.Minute)},
+		},
+	}
+
+	if got := cfg.GetXboxMAC(); got.String() != "00:50:f2:00:00:02" {
+		t.Errorf("Expected most recent valid MAC 00:50:f2:00:00:02, got %v", got)
+	}
+}
+
 func TestDefaultConfigPath(t *testing.T) {
 	path, err := DefaultConfigPath()
 	if err != nil {
@@ -129,3 +393,91 @@ func TestDefaultConfigPath(t *testing.T) {
 		t.Errorf("Expected config directory to be .xbslink-ng, got %q", filepath.Base(dir))
 	}
 }
+
+func TestResolve(t *testing.T) {
+	newFlags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("listen", flag.ContinueOnError)
+		fs.String("interface", "", "")
+		fs.String("key", "", "")
+		fs.String("xbox-mac", "", "")
+		fs.String("color", "auto", "")
+		fs.String("log", "info", "")
+		return fs
+	}
+	saved := &Config{LastXboxMAC: "00:50:F2:1A:2B:3C"}
+	env := map[string]string{"NO_COLOR": "1"}
+	getenv := func(name string) string { return env[name] }
+
+	fs := newFlags()
+	if err := fs.Parse([]string{"--interface", "eth0", "--key", "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	eff := Resolve(fs, saved, getenv)
+
+	tests := []struct {
+		name   string
+		value  string
+		source Source
+	}{
+		{"interface", "eth0", SourceFlag},
+		{"key", "(redacted)", SourceFlag},
+		{"xbox-mac", "00:50:f2:1a:2b:3c", SourceConfig},
+		{"color", "never", SourceEnv},
+		{"log", "info", SourceDefault},
+	}
+	for _, tt := range tests {
+		s, ok := eff.Get(tt.name)
+		if !ok {
+			t.Errorf("%s: missing from effective config", tt.name)
+			continue
+		}
+		if s.Value != tt.value || s.Source != tt.source {
+			t.Errorf("%s = %q (%s), want %q (%s)", tt.name, s.Value, s.Source, tt.value, tt.source)
+		}
+	}
+	if s, _ := eff.Get("color"); s.String() != "color = never (env: NO_COLOR)" {
+		t.Errorf("color line = %q", s.String())
+	}
+	if strings.Contains(eff.String(), "hunter2") {
+		t.Error("effective config shows the key")
+	}
+	// Values from the config and environment land on the flags
+	if got := fs.Lookup("xbox-mac").Value.String(); got != "00:50:f2:1a:2b:3c" {
+		t.Errorf("--xbox-mac after Resolve = %q, want the saved MAC", got)
+	}
+	if got := fs.Lookup("color").Value.String(); got != "never" {
+		t.Errorf("--color after Resolve = %q, want never from NO_COLOR", got)
+	}
+	if got := fs.Lookup("key").Value.String(); got != "hunter2" {
+		t.Errorf("--key after Resolve = %q, want it unredacted", got)
+	}
+
+	// The command line beats the environment and the saved config
+	fs = newFlags()
+	if err := fs.Parse([]string{"--xbox-mac", "00:50:F2:00:00:01", "--color", "always"}); err != nil {
+		t.Fatal(err)
+	}
+	eff = Resolve(fs, saved, getenv)
+	if s, _ := eff.Get("xbox-mac"); s.Value != "00:50:F2:00:00:01" || s.Source != SourceFlag {
+		t.Errorf("xbox-mac = %q (%s), want the flag's", s.Value, s.Source)
+	}
+	if s, _ := eff.Get("color"); s.Value != "always" || s.Source != SourceFlag {
+		t.Errorf("color = %q (%s), want the flag's", s.Value, s.Source)
+	}
+	if got := fs.Lookup("xbox-mac").Value.String(); got != "00:50:F2:00:00:01" {
+		t.Errorf("--xbox-mac after Resolve = %q, want the flag's", got)
+	}
+	if s, _ := eff.Get("key"); s.Value != "" || s.Source != SourceDefault {
+		t.Errorf("unset key = %q (%s), want empty default", s.Value, s.Source)
+	}
+
+	// Nothing saved and no environment: defaults
+	fs = newFlags()
+	fs.Parse(nil)
+	eff = Resolve(fs, nil, func(string) string { return "" })
+	for _, s := range eff {
+		if s.Source != SourceDefault {
+			t.Errorf("%s: source %s, want default", s.Name, s.Source)
+		}
+	}
+}
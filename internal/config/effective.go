@@ -0,0 +1,126 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/xbslink/xbslink-ng/internal/logging"
+)
+
+// Source is where an effective setting's value came from.
+type Source string
+
+const (
+	SourceDefault Source = "default" // the flag's default
+	SourceFlag    Source = "flag"    // given on the command line
+	SourceConfig  Source = "config"  // the saved config file
+	SourceEnv     Source = "env"     // an environment variable
+)
+
+// redactedFlags are flags whose values are never shown, only whether they
+// are set.
+var redactedFlags = map[string]bool{"key": true, "http-token": true}
+
+// Setting is one resolved setting.
+type Setting struct {
+	Name   string
+	Value  string
+	Source Source
+	// Detail says more about the source, e.g. the environment variable.
+	Detail string
+}
+
+// String formats s as "name = value (source)".
+func (s Setting) String() string {
+	value := s.Value
+	if value == "" {
+		value = `""`
+	}
+	source := string(s.Source)
+	if s.Detail != "" {
+		source += ": " + s.Detail
+	}
+	return fmt.Sprintf("%s = %s (%s)", s.Name, value, source)
+}
+
+// Effective is the configuration in effect, one Setting per flag in
+// lexical order.
+type Effective []Setting
+
+// Resolve settles the value of every flag in fs and records where it came
+// from. Precedence, lowest first: the flag's default, the saved config (for
+// --xbox-mac), the environment (for --color), the command line. Values
+// taken from the config or environment are set on fs, so variables bound
+// to the flags see them. fs must already be parsed, and Resolve called only
+// once; saved may be nil. Secret values such as --key are redacted in the
+// result.
+func Resolve(fs *flag.FlagSet, saved *Config, getenv func(string) string) Effective {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var eff Effective
+	fs.VisitAll(func(f *flag.Flag) {
+		s := Setting{Name: f.Name, Value: f.Value.String(), Source: SourceDefault}
+		if set[f.Name] {
+			s.Source = SourceFlag
+		} else if value, variable, ok := fromEnv(f.Name, getenv); ok {
+			s.Value, s.Source, s.Detail = value, SourceEnv, variable
+		} else if value, ok := fromConfig(f.Name, saved); ok {
+			s.Value, s.Source = value, SourceConfig
+		}
+		if s.Source == SourceEnv || s.Source == SourceConfig {
+			f.Value.Set(s.Value)
+		}
+		if redactedFlags[f.Name] && s.Value != "" {
+			s.Value = "(redacted)"
+		}
+		eff = append(eff, s)
+	})
+	return eff
+}
+
+// fromEnv returns the value the environment gives a flag, and the variable
+// it came from.
+func fromEnv(name string, getenv func(string) string) (value, variable string, ok bool) {
+	switch name {
+	case "color":
+		mode, variable, ok := logging.EnvColorMode(getenv)
+		return string(mode), variable, ok
+	}
+	return "", "", false
+}
+
+// fromConfig returns the value the saved config gives a flag.
+func fromConfig(name string, saved *Config) (string, bool) {
+	if saved == nil {
+		return "", false
+	}
+	switch name {
+	case "xbox-mac":
+		if mac := saved.GetXboxMAC(); mac != nil {
+			return mac.String(), true
+		}
+	}
+	return "", false
+}
+
+// Get returns the setting called name.
+func (e Effective) Get(name string) (Setting, bool) {
+	for _, s := range e {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Setting{}, false
+}
+
+// String formats e one setting per line, for pasting into a bug report.
+func (e Effective) String() string {
+	var b strings.Builder
+	for _, s := range e {
+		b.WriteString(s.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
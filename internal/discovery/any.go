@@ -0,0 +1,183 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// DiscoverAny limits.
+const (
+	// MaxConcurrentHandles bounds how many interfaces DiscoverAny listens on
+	// at once.
+	MaxConcurrentHandles = 8
+	// AnyScanSlice is how long DiscoverAny listens on one interface before
+	// moving on, when there are more interfaces than MaxConcurrentHandles.
+	AnyScanSlice = 5 * time.Second
+)
+
+// scanSlice is AnyScanSlice; tests shorten it.
+var scanSlice = AnyScanSlice
+
+// ErrNoInterfaces is returned by DiscoverAny when no interface is up, or none
+// could be opened.
+var ErrNoInterfaces = errors.New("no usable interfaces")
+
+// packetSource is the part of *pcap.Handle that DiscoverAny reads.
+type packetSource interface {
+	ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	Close()
+}
+
+// openSource opens a System Link capture on the named interface. Tests
+// replace it.
+var openSource = func(name string, cfg Config) (packetSource, error) {
+	cfg.Interface = name
+	handle, err := openHandle(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+// DiscoverAny is Discover on every interface that is up at once, for when
+// the user doesn't know which one the Xbox is on. It returns the first
+// source of System Link traffic, with Result.Interface naming the interface
+// it was seen on; cfg.Interface is ignored. At most MaxConcurrentHandles
+// captures are open at a time, taking turns of AnyScanSlice if there are
+// more interfaces; all are closed before it returns. cfg.Timeout bounds it
+// as it does Discover.
+func DiscoverAny(ctx context.Context, cfg Config) (*Result, error) {
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	names := upDevices(devices)
+	if len(names) == 0 {
+		return nil, ErrNoInterfaces
+	}
+	return discoverAny(ctx, cfg, names)
+}
+
+// upDevices returns the names of devices worth listening on: up and not
+// loopback. Without pcap flags (older libpcap), any device with an address.
+func upDevices(devices []pcap.Interface) []string {
+	const (
+		pcapIfLoopback = 0x1
+		pcapIfUp       = 0x2
+	)
+	var names []string
+	for _, dev := range devices {
+		if dev.Flags&pcapIfLoopback != 0 {
+			continue
+		}
+		if dev.Flags&pcapIfUp != 0 || (dev.Flags == 0 && len(dev.Addresses) > 0) {
+			names = append(names, dev.Name)
+		}
+	}
+	return names
+}
+
+// discoverAny runs DiscoverAny's workers over names.
+func discoverAny(ctx context.Context, cfg Config, names []string) (*Result, error) {
+	ctx, cancel := withTimeout(ctx, cfg)
+
+	workers := min(len(names), MaxConcurrentHandles)
+	var slice time.Duration // 0: listen until done
+	if len(names) > workers {
+		slice = scanSlice
+	}
+
+	queue := make(chan string, len(names))
+	for _, name := range names {
+		queue <- name
+	}
+	found := make(chan Result, workers)
+
+	// Interfaces that fail to open are dropped; if all do, give up
+	var mu sync.Mutex
+	remaining := len(names)
+	var lastErr error
+	allFailed := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var name string
+				select {
+				case <-ctx.Done():
+					return
+				case name = <-queue:
+				}
+
+				result, ok, err := scanInterface(ctx, cfg, name, slice)
+				switch {
+				case ok:
+					found <- result
+					return
+				case err != nil:
+					if cfg.Logger != nil {
+						cfg.Logger.Debug("Discovery skipping %s: %v", name, err)
+					}
+					mu.Lock()
+					remaining--
+					lastErr = err
+					if remaining == 0 {
+						close(allFailed)
+					}
+					mu.Unlock()
+				default:
+					queue <- name // turn over; listen again later
+				}
+			}
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait() // every handle closed before returning
+	}()
+
+	select {
+	case result := <-found:
+		return &result, nil
+	case <-allFailed:
+		mu.Lock()
+		defer mu.Unlock()
+		return nil, fmt.Errorf("%w: %v", ErrNoInterfaces, lastErr)
+	case <-ctx.Done():
+		return nil, doneErr(ctx)
+	}
+}
+
+// scanInterface listens on name for System Link traffic until it finds a
+// source, ctx is done, or slice (if nonzero) elapses.
+func scanInterface(ctx context.Context, cfg Config, name string, slice time.Duration) (Result, bool, error) {
+	src, err := openSource(name, cfg)
+	if err != nil {
+		return Result{}, false, err
+	}
+	defer src.Close()
+
+	var deadline time.Time
+	if slice > 0 {
+		deadline = time.Now().Add(slice)
+	}
+	for ctx.Err() == nil && (deadline.IsZero() || time.Now().Before(deadline)) {
+		data, _, err := src.ZeroCopyReadPacketData()
+		if err != nil {
+			continue // timeouts and transient errors, as in Discover
+		}
+		if mac, ok := sourceMAC(data); ok {
+			return Result{MAC: mac, LastSeen: time.Now(), Interface: name}, true, nil
+		}
+	}
+	return Result{}, false, nil
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/google/gopacket/pcap"
 
 	"github.com/xbslink/xbslink-ng/internal/logging"
+	"github.com/xbslink/xbslink-ng/internal/macaddr"
 )
 
 // Xbox System Link uses UDP port 3074 (registered with IANA for Xbox).
@@ -28,26 +29,150 @@ const (
 // Errors returned by discovery operations.
 var (
 	ErrDiscoveryCancelled = errors.New("discovery cancelled")
+	ErrDiscoveryTimeout   = errors.New("discovery timed out")
 	ErrInterfaceNotFound  = errors.New("interface not found")
 )
 
 // Result represents a discovered Xbox console.
 type Result struct {
-	MAC      net.HardwareAddr
-	LastSeen time.Time
+	MAC       net.HardwareAddr
+	LastSeen  time.Time
+	Interface string // Interface the traffic was seen on
 }
 
 // Config holds discovery configuration.
 type Config struct {
-	Interface string          // Network interface name
-	Logger    *logging.Logger // Logger (optional)
+	Interface   string          // Network interface name
+	Logger      *logging.Logger // Logger (optional)
+	Promiscuous *bool           // Open the interface in promiscuous mode (default: true)
+
+	// Timeout bounds Discover and DiscoverAny, which then return
+	// ErrDiscoveryTimeout if nothing was found. 0 waits until the context
+	// is cancelled. Watch ignores it.
+	Timeout time.Duration
+}
+
+// withTimeout bounds ctx by cfg.Timeout, if set.
+func withTimeout(ctx context.Context, cfg Config) (context.Context, context.CancelFunc) {
+	if cfg.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, cfg.Timeout, ErrDiscoveryTimeout)
+}
+
+// doneErr returns the error for discovery ending because ctx is done:
+// ErrDiscoveryTimeout if Config.Timeout ran out, ErrDiscoveryCancelled
+// otherwise (including the caller's own deadline).
+func doneErr(ctx context.Context) error {
+	if errors.Is(context.Cause(ctx), ErrDiscoveryTimeout) {
+		return ErrDiscoveryTimeout
+	}
+	return ErrDiscoveryCancelled
 }
 
 // Discover passively listens for Xbox System Link traffic on the specified interface.
 // It detects any device sending UDP traffic on port 3074 (Xbox System Link port).
 // Returns immediately when the first Xbox is detected.
-// The operation can be cancelled via the context.
+// The operation can be cancelled via the context, or bounded by cfg.Timeout.
 func Discover(ctx context.Context, cfg Config) (*Result, error) {
+	src, err := openSource(cfg.Interface, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	ctx, cancel := withTimeout(ctx, cfg)
+	defer cancel()
+
+	// Listen for packets
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, doneErr(ctx)
+		default:
+		}
+
+		data, _, err := src.ZeroCopyReadPacketData()
+		if err != nil {
+			// Timeouts and other (possibly transient) errors: keep listening
+			continue
+		}
+
+		// Found a device sending System Link traffic
+		if mac, ok := sourceMAC(data); ok {
+			return &Result{
+				MAC:       mac,
+				LastSeen:  time.Now(),
+				Interface: cfg.Interface,
+			}, nil
+		}
+	}
+}
+
+// Watch passively listens like Discover, but keeps going until ctx is
+// cancelled and calls found once for each new source MAC. MACs for which
+// ignore returns true are skipped without being remembered, so they are
+// checked again on their next packet. Only inbound packets are captured where
+// the platform allows it, so frames injected by this host aren't reported.
+// Returns nil when ctx is cancelled.
+func Watch(ctx context.Context, cfg Config, ignore func(net.HardwareAddr) bool, found func(Result)) error {
+	handle, err := openHandle(cfg)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	if err := handle.SetDirection(pcap.DirectionIn); err != nil && cfg.Logger != nil {
+		cfg.Logger.Debug("Discovery watch can't limit capture to inbound packets: %v", err)
+	}
+
+	tracker := newMACTracker(ignore)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		data, _, err := handle.ZeroCopyReadPacketData()
+		if err != nil {
+			continue
+		}
+
+		if result, ok := tracker.observe(data, time.Now()); ok {
+			found(result)
+		}
+	}
+}
+
+// macTracker remembers the source MACs Watch has already reported.
+type macTracker struct {
+	seen   map[string]bool
+	ignore func(net.HardwareAddr) bool
+}
+
+// newMACTracker creates a tracker; ignore may be nil.
+func newMACTracker(ignore func(net.HardwareAddr) bool) *macTracker {
+	return &macTracker{seen: make(map[string]bool), ignore: ignore}
+}
+
+// observe returns a Result if data comes from a source MAC that is neither
+// ignored nor already reported.
+func (t *macTracker) observe(data []byte, now time.Time) (Result, bool) {
+	mac, ok := sourceMAC(data)
+	if !ok || t.seen[string(mac)] {
+		return Result{}, false
+	}
+	if t.ignore != nil && t.ignore(mac) {
+		return Result{}, false
+	}
+	t.seen[string(mac)] = true
+	return Result{MAC: mac, LastSeen: now}, true
+}
+
+// openHandle opens a capture on cfg.Interface filtered to System Link
+// traffic, promiscuous unless cfg.Promiscuous says otherwise.
+func openHandle(cfg Config) (*pcap.Handle, error) {
 	// Find the interface
 	iface, err := findInterface(cfg.Interface)
 	if err != nil {
@@ -65,7 +190,7 @@ func Discover(ctx context.Context, cfg Config) (*Result, error) {
 	if err := inactive.SetSnapLen(SnapLen); err != nil {
 		return nil, fmt.Errorf("failed to set snap length: %w", err)
 	}
-	if err := inactive.SetPromisc(true); err != nil {
+	if err := inactive.SetPromisc(cfg.Promiscuous == nil || *cfg.Promiscuous); err != nil {
 		return nil, fmt.Errorf("failed to set promiscuous mode: %w", err)
 	}
 	if err := inactive.SetTimeout(ReadTimeout); err != nil {
@@ -77,7 +202,6 @@ func Discover(ctx context.Context, cfg Config) (*Result, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to activate capture on %s: %w", cfg.Interface, err)
 	}
-	defer handle.Close()
 
 	// BPF filter for Xbox System Link traffic:
 	// - UDP port 3074 (Xbox System Link port)
@@ -85,52 +209,35 @@ func Discover(ctx context.Context, cfg Config) (*Result, error) {
 	filter := fmt.Sprintf("udp port %d", XboxSystemLinkPort)
 
 	if err := handle.SetBPFFilter(filter); err != nil {
+		handle.Close()
 		return nil, fmt.Errorf("failed to set BPF filter: %w", err)
 	}
 
 	if cfg.Logger != nil {
 		cfg.Logger.Debug("Listening for Xbox System Link traffic (UDP port %d)", XboxSystemLinkPort)
 	}
+	return handle, nil
+}
 
-	// Listen for packets
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, ErrDiscoveryCancelled
-		default:
-		}
-
-		data, _, err := handle.ZeroCopyReadPacketData()
-		if err != nil {
-			if err == pcap.NextErrorTimeoutExpired {
-				continue
-			}
-			// Other errors might be transient, continue
-			continue
-		}
-
-		// Need at least 14 bytes for Ethernet header
-		if len(data) < 14 {
-			continue
-		}
-
-		// Extract source MAC (bytes 6-11 of Ethernet frame)
-		srcMAC := net.HardwareAddr(data[6:12])
-
-		// Skip broadcast/multicast source MACs (invalid)
-		if srcMAC[0]&0x01 != 0 {
-			continue
-		}
+// sourceMAC returns a copy of the source MAC of an Ethernet frame, or false
+// if data is too short or the source is broadcast/multicast (invalid).
+func sourceMAC(data []byte) (net.HardwareAddr, bool) {
+	// Need at least 14 bytes for Ethernet header
+	if len(data) < 14 {
+		return nil, false
+	}
 
-		// Found a device sending System Link traffic
-		mac := make(net.HardwareAddr, 6)
-		copy(mac, srcMAC)
+	// Extract source MAC (bytes 6-11 of Ethernet frame)
+	srcMAC := net.HardwareAddr(data[6:12])
 
-		return &Result{
-			MAC:      mac,
-			LastSeen: time.Now(),
-		}, nil
+	// Skip broadcast/multicast source MACs (invalid)
+	if !macaddr.IsUnicast(srcMAC) {
+		return nil, false
 	}
+
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, srcMAC)
+	return mac, true
 }
 
 // findInterface finds an interface by name using pcap.
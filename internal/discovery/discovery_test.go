@@ -1,7 +1,17 @@
 package discovery
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
 )
 
 func TestXboxSystemLinkPortConstant(t *testing.T) {
@@ -19,3 +29,235 @@ func TestSnapLenSufficient(t *testing.T) {
 		t.Errorf("SnapLen = %d, want at least %d", SnapLen, minRequired)
 	}
 }
+
+// frameFrom builds a minimal Ethernet frame with the given source MAC.
+func frameFrom(mac string) []byte {
+	src, _ := net.ParseMAC(mac)
+	frame := make([]byte, 42)
+	copy(frame[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], src)
+	return frame
+}
+
+func TestSourceMAC(t *testing.T) {
+	frame := frameFrom("00:50:f2:1a:2b:3c")
+	mac, ok := sourceMAC(frame)
+	if !ok || mac.String() != "00:50:f2:1a:2b:3c" {
+		t.Fatalf("sourceMAC() = %v, %v", mac, ok)
+	}
+	frame[6] = 0xAA
+	if mac[0] != 0x00 {
+		t.Error("sourceMAC should copy the MAC out of the frame")
+	}
+
+	if _, ok := sourceMAC(frame[:13]); ok {
+		t.Error("accepted a truncated frame")
+	}
+	if _, ok := sourceMAC(frameFrom("01:00:5e:00:00:01")); ok {
+		t.Error("accepted a multicast source MAC")
+	}
+}
+
+func TestMACTracker_ReportsEachNewMACOnce(t *testing.T) {
+	bridged, _ := net.ParseMAC("00:50:f2:00:00:01")
+	ready := false
+	tracker := newMACTracker(func(mac net.HardwareAddr) bool {
+		return !ready || bytes.Equal(mac, bridged)
+	})
+	now := time.Now()
+
+	// Ignored MACs are not remembered, so they are reported once ignore allows
+	other := frameFrom("00:50:f2:00:00:02")
+	if _, ok := tracker.observe(other, now); ok {
+		t.Fatal("reported a MAC while ignore returned true")
+	}
+	ready = true
+
+	if _, ok := tracker.observe(frameFrom("00:50:f2:00:00:01"), now); ok {
+		t.Error("reported the bridged MAC")
+	}
+	result, ok := tracker.observe(other, now)
+	if !ok || result.MAC.String() != "00:50:f2:00:00:02" || !result.LastSeen.Equal(now) {
+		t.Fatalf("observe() = %+v, %v", result, ok)
+	}
+	if _, ok := tracker.observe(other, now); ok {
+		t.Error("reported the same MAC twice")
+	}
+}
+
+// fakeSource is a packetSource that returns its frames after delay, then
+// read timeouts.
+type fakeSource struct {
+	frames  [][]byte
+	delay   time.Time
+	sources *fakeSources
+}
+
+func (s *fakeSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	time.Sleep(time.Millisecond) // like the pcap read timeout
+	if len(s.frames) == 0 || time.Now().Before(s.delay) {
+		return nil, gopacket.CaptureInfo{}, pcap.NextErrorTimeoutExpired
+	}
+	frame := s.frames[0]
+	s.frames = s.frames[1:]
+	return frame, gopacket.CaptureInfo{}, nil
+}
+
+func (s *fakeSource) Close() { s.sources.closed() }
+
+// fakeSources stands in for openSource, tracking open handles.
+type fakeSources struct {
+	mu      sync.Mutex
+	traffic map[string]time.Duration // interface -> when its Xbox starts talking
+	broken  map[string]bool
+	open    int
+	maxOpen int
+	opened  int
+}
+
+func (f *fakeSources) install(t *testing.T) {
+	orig := openSource
+	t.Cleanup(func() { openSource = orig })
+	start := time.Now()
+	openSource = func(name string, cfg Config) (packetSource, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.broken[name] {
+			return nil, errors.New("permission denied")
+		}
+		f.open++
+		f.opened++
+		f.maxOpen = max(f.maxOpen, f.open)
+		src := &fakeSource{sources: f}
+		if after, ok := f.traffic[name]; ok {
+			src.frames = [][]byte{frameFrom("01:00:5e:00:00:01"), frameFrom("00:50:f2:1a:2b:3c")}
+			src.delay = start.Add(after)
+		}
+		return src, nil
+	}
+}
+
+func (f *fakeSources) closed() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.open--
+}
+
+func (f *fakeSources) stillOpen() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open
+}
+
+func TestDiscoverAny_ReturnsFirstInterfaceWithTraffic(t *testing.T) {
+	sources := &fakeSources{traffic: map[string]time.Duration{
+		"eth1":  20 * time.Millisecond,
+		"wlan0": 500 * time.Millisecond,
+	}}
+	sources.install(t)
+
+	result, err := discoverAny(context.Background(), Config{}, []string{"eth0", "wlan0", "eth1"})
+	if err != nil {
+		t.Fatalf("discoverAny() failed: %v", err)
+	}
+	if result.Interface != "eth1" || result.MAC.String() != "00:50:f2:1a:2b:3c" {
+		t.Errorf("discoverAny() = %s on %s, want 00:50:f2:1a:2b:3c on eth1", result.MAC, result.Interface)
+	}
+	if n := sources.stillOpen(); n != 0 {
+		t.Errorf("%d handles left open", n)
+	}
+}
+
+func TestDiscoverAny_BoundsOpenHandles(t *testing.T) {
+	orig := scanSlice
+	scanSlice = 10 * time.Millisecond
+	t.Cleanup(func() { scanSlice = orig })
+
+	var names []string
+	for i := range 3 * MaxConcurrentHandles {
+		names = append(names, fmt.Sprintf("dev%d", i))
+	}
+	last := names[len(names)-1]
+	sources := &fakeSources{traffic: map[string]time.Duration{last: 0}}
+	sources.install(t)
+
+	result, err := discoverAny(context.Background(), Config{}, names)
+	if err != nil {
+		t.Fatalf("discoverAny() failed: %v", err)
+	}
+	if result.Interface != last {
+		t.Errorf("found on %s, want %s", result.Interface, last)
+	}
+	if sources.maxOpen > MaxConcurrentHandles {
+		t.Errorf("%d handles open at once, want at most %d", sources.maxOpen, MaxConcurrentHandles)
+	}
+	if n := sources.stillOpen(); n != 0 {
+		t.Errorf("%d handles left open", n)
+	}
+}
+
+func TestDiscoverAny_Failures(t *testing.T) {
+	sources := &fakeSources{broken: map[string]bool{"eth0": true, "eth1": true}}
+	sources.install(t)
+
+	if _, err := discoverAny(context.Background(), Config{}, []string{"eth0", "eth1"}); !errors.Is(err, ErrNoInterfaces) {
+		t.Errorf("all interfaces broken: err = %v, want ErrNoInterfaces", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := discoverAny(ctx, Config{}, []string{"eth0", "eth2"}); !errors.Is(err, ErrDiscoveryCancelled) {
+		t.Errorf("no traffic: err = %v, want ErrDiscoveryCancelled", err)
+	}
+	if n := sources.stillOpen(); n != 0 {
+		t.Errorf("%d handles left open", n)
+	}
+}
+
+func TestDiscover_Timeout(t *testing.T) {
+	sources := &fakeSources{traffic: map[string]time.Duration{"eth1": 0}}
+	sources.install(t)
+
+	start := time.Now()
+	_, err := Discover(context.Background(), Config{Interface: "eth0", Timeout: 30 * time.Millisecond})
+	if !errors.Is(err, ErrDiscoveryTimeout) {
+		t.Errorf("Discover() with no traffic = %v, want ErrDiscoveryTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Discover() took %v with a 30ms timeout", elapsed)
+	}
+	_, err = discoverAny(context.Background(), Config{Timeout: 30 * time.Millisecond}, []string{"eth0", "eth2"})
+	if !errors.Is(err, ErrDiscoveryTimeout) {
+		t.Errorf("discoverAny() with no traffic = %v, want ErrDiscoveryTimeout", err)
+	}
+
+	// Traffic within the timeout is still found
+	result, err := Discover(context.Background(), Config{Interface: "eth1", Timeout: time.Minute})
+	if err != nil || result.MAC.String() != "00:50:f2:1a:2b:3c" {
+		t.Errorf("Discover() with traffic = %v, %v", result, err)
+	}
+
+	// The caller's own cancellation is not a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := Discover(ctx, Config{Interface: "eth0", Timeout: time.Minute}); !errors.Is(err, ErrDiscoveryCancelled) {
+		t.Errorf("Discover() with the caller's deadline = %v, want ErrDiscoveryCancelled", err)
+	}
+	if n := sources.stillOpen(); n != 0 {
+		t.Errorf("%d handles left open", n)
+	}
+}
+
+func TestUpDevices(t *testing.T) {
+	addr := []pcap.InterfaceAddress{{IP: net.ParseIP("192.168.1.20")}}
+	devices := []pcap.Interface{
+		{Name: "lo", Flags: 0x1 | 0x2, Addresses: addr},
+		{Name: "eth0", Flags: 0x2 | 0x4},
+		{Name: "eth1"},                  // down
+		{Name: "eth2", Addresses: addr}, // no flags: older libpcap
+	}
+	got := upDevices(devices)
+	if len(got) != 2 || got[0] != "eth0" || got[1] != "eth2" {
+		t.Errorf("upDevices() = %v, want [eth0 eth2]", got)
+	}
+}
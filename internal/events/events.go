@@ -12,12 +12,33 @@ const (
 	EventLatency      EventType = "latency"
 	EventDiscovery    EventType = "discovery"
 	EventError        EventType = "error"
+	EventListening    EventType = "listening"
+)
+
+// Reason codes carried in ErrorData.Reason and StateChangedData.Reason.
+const (
+	ReasonAuthFailed       = "auth_failed"       // HMAC verification failed (wrong or missing key)
+	ReasonVersionMismatch  = "version_mismatch"  // Peer speaks a different protocol version
+	ReasonChallengeInvalid = "challenge_invalid" // HELLO_ACK challenge response didn't verify
+	ReasonInvalidMessage   = "invalid_message"   // Message could not be decoded
+	ReasonTimeout          = "timeout"           // No HELLO_ACK before the handshake timeout
+	ReasonModeMismatch     = "mode_mismatch"     // One side uses --key and the other doesn't
+	ReasonRateLimited      = "rate_limited"      // Peer stopped answering us after too many bad handshakes
+	ReasonPeerError        = "peer_error"        // Peer sent an ERROR message
+	ReasonIdleTimeout      = "idle_timeout"      // No frames crossed for --idle-timeout
+	ReasonMaxDuration      = "max_duration"      // Session reached --max-duration
+	ReasonCaptureFailed    = "capture_failed"    // Packet capture kept failing (e.g. interface gone)
+	ReasonFrameLoop        = "frame_loop"        // Injected frames came back through capture
 )
 
 // Envelope wraps every emitted event with type and timestamp.
+// SessionID is random per emitter (one bridge run) and Seq counts up from 1
+// within it, so consumers can group by session and spot dropped events.
 type Envelope struct {
 	Type      EventType   `json:"type"`
 	Timestamp time.Time   `json:"timestamp"`
+	SessionID string      `json:"session_id"`
+	Seq       uint64      `json:"seq"`
 	Data      interface{} `json:"data"`
 }
 
@@ -25,16 +46,54 @@ type Envelope struct {
 type StateChangedData struct {
 	State    string `json:"state"`
 	PeerAddr string `json:"peer_addr,omitempty"`
+
+	// Set when State is CONNECTED.
+	PeerVersion  uint16 `json:"peer_version,omitempty"`  // Protocol version the peer reported
+	SecurityMode string `json:"security_mode,omitempty"` // "secure" (HMAC) or "insecure"
+
+	// Set when State is DISCONNECTED and the bridge ended the session itself.
+	Reason string `json:"reason,omitempty"` // e.g. "idle_timeout"
+
+	// Set on the periodic reports while State is CONNECTING: how long we
+	// have waited for a peer and, in connect mode, how many attempts failed.
+	WaitingSec float64 `json:"waiting_sec,omitempty"`
+	Attempts   int     `json:"attempts,omitempty"`
 }
 
 // StatsData is the payload for stats events.
 type StatsData struct {
-	TxPackets    uint64  `json:"tx_packets"`
-	TxBytes      uint64  `json:"tx_bytes"`
-	RxPackets    uint64  `json:"rx_packets"`
-	RxBytes      uint64  `json:"rx_bytes"`
-	RTTCurrentMs float64 `json:"rtt_current_ms"`
-	RTTAvgMs     float64 `json:"rtt_avg_ms"`
+	TxPackets         uint64  `json:"tx_packets"`
+	TxBytes           uint64  `json:"tx_bytes"`
+	RxPackets         uint64  `json:"rx_packets"`
+	RxBytes           uint64  `json:"rx_bytes"`
+	RTTCurrentMs      float64 `json:"rtt_current_ms"`
+	RTTAvgMs          float64 `json:"rtt_avg_ms"`
+	JitterMs          float64 `json:"jitter_ms,omitempty"`
+	PongsLost         uint64  `json:"pongs_lost,omitempty"`
+	QualityScore      int     `json:"quality_score,omitempty"`
+	Quality           string  `json:"quality,omitempty"` // good, fair, poor or unknown
+	HandshakeFailures uint64  `json:"handshake_failures"`
+	UptimeSec         float64 `json:"uptime_sec"`
+	TxCongested       uint64  `json:"tx_congested,omitempty"`
+	LoopedFrames      uint64  `json:"looped_frames,omitempty"`
+	TxOversizeDropped uint64  `json:"tx_oversize_dropped,omitempty"`
+	NonEthernetII     uint64  `json:"non_ethernet_ii,omitempty"`
+	Filtered          uint64  `json:"filtered,omitempty"`
+	InjectRetries     uint64  `json:"inject_retries,omitempty"`
+	InjectDrops       uint64  `json:"inject_drops,omitempty"`
+
+	// Received messages the codec rejected (see protocol.CodecStats).
+	HMACFailures uint64 `json:"hmac_failures,omitempty"`
+	Replays      uint64 `json:"replays,omitempty"`
+	DecodeErrors uint64 `json:"decode_errors,omitempty"`
+	RxCorrupt    uint64 `json:"rx_corrupt,omitempty"`
+
+	// Session summary fields, set only on the final event when the bridge stops.
+	Final     bool    `json:"final,omitempty"`
+	RTTMinMs  float64 `json:"rtt_min_ms,omitempty"`
+	RTTMaxMs  float64 `json:"rtt_max_ms,omitempty"`
+	TxDropped uint64  `json:"tx_dropped,omitempty"`
+	RxDropped uint64  `json:"rx_dropped,omitempty"`
 }
 
 // LatencyData is the payload for latency events.
@@ -42,16 +101,36 @@ type LatencyData struct {
 	RTTMs            float64 `json:"rtt_ms"`
 	IsSpike          bool    `json:"is_spike"`
 	ExceedsThreshold bool    `json:"exceeds_threshold"`
+
+	// One-way delays to and from the peer, when both sides run with
+	// --clock-skew; only as accurate as the two clocks' synchronization.
+	UpMs   float64 `json:"up_ms,omitempty"`
+	DownMs float64 `json:"down_ms,omitempty"`
 }
 
 // DiscoveryData is the payload for discovery events.
 type DiscoveryData struct {
 	MAC string `json:"mac"`
+
+	// Set when --watch-discovery sees System Link traffic from a device other
+	// than the Xbox being bridged (which is BridgedMAC).
+	Unexpected bool   `json:"unexpected,omitempty"`
+	BridgedMAC string `json:"bridged_mac,omitempty"`
+}
+
+// ListeningData is the payload for listening events, emitted once listen
+// mode is bound with the address to give the peer.
+type ListeningData struct {
+	Address string `json:"address"` // ip:port for the peer's --address
+	Private bool   `json:"private"` // Address is a LAN address; internet peers need the public IP
+	KeySet  bool   `json:"key_set"` // --key is set, so the peer needs it too
 }
 
 // ErrorData is the payload for error events.
 type ErrorData struct {
-	Message string `json:"message"`
+	Message  string `json:"message"`
+	Reason   string `json:"reason,omitempty"`
+	PeerAddr string `json:"peer_addr,omitempty"`
 }
 
 // Emitter is the interface for emitting structured events.
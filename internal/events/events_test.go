@@ -3,9 +3,14 @@ package events
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestJSONLineWriter_Emit(t *testing.T) {
@@ -53,11 +58,53 @@ func TestJSONLineWriter_MultipleEvents(t *testing.T) {
 		t.Fatalf("got %d lines, want 3", len(lines))
 	}
 
-	// Verify each line is valid JSON
+	// Verify each line is valid JSON with increasing seq in one session
 	for i, line := range lines {
 		var env Envelope
 		if err := json.Unmarshal([]byte(line), &env); err != nil {
 			t.Errorf("line %d: failed to parse: %v", i, err)
+			continue
+		}
+		if env.Seq != uint64(i+1) {
+			t.Errorf("line %d: seq = %d, want %d", i, env.Seq, i+1)
+		}
+		if env.SessionID == "" || env.SessionID != w.SessionID() {
+			t.Errorf("line %d: session_id = %q, want %q", i, env.SessionID, w.SessionID())
+		}
+	}
+}
+
+func TestJSONLineWriter_SessionIDPerWriter(t *testing.T) {
+	a := NewJSONLineWriter(&bytes.Buffer{})
+	b := NewJSONLineWriter(&bytes.Buffer{})
+	if a.SessionID() == b.SessionID() {
+		t.Errorf("two writers share session ID %q", a.SessionID())
+	}
+}
+
+func TestAsyncJSONLineWriter_Seq(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewAsyncJSONLineWriter(&buf)
+
+	for i := 0; i < 5; i++ {
+		w.Emit(EventStats, StatsData{TxPackets: uint64(i)})
+	}
+	w.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want 5", len(lines))
+	}
+	for i, line := range lines {
+		var env Envelope
+		if err := json.Unmarshal([]byte(line), &env); err != nil {
+			t.Fatalf("line %d: failed to parse: %v", i, err)
+		}
+		if env.Seq != uint64(i+1) {
+			t.Errorf("line %d: seq = %d, want %d", i, env.Seq, i+1)
+		}
+		if env.SessionID != w.SessionID() {
+			t.Errorf("line %d: session_id = %q, want %q", i, env.SessionID, w.SessionID())
 		}
 	}
 }
@@ -106,6 +153,25 @@ func TestJSONLineWriter_ErrorEventPayload(t *testing.T) {
 	}
 }
 
+func TestJSONLineWriter_ErrorEventReason(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewJSONLineWriter(&buf)
+
+	w.Emit(EventError, ErrorData{Message: "handshake failed", Reason: ReasonAuthFailed, PeerAddr: "1.2.3.4:5"})
+
+	var env Envelope
+	if err := json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &env); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	data := env.Data.(map[string]interface{})
+	if data["reason"] != ReasonAuthFailed {
+		t.Errorf("data.reason = %v, want %s", data["reason"], ReasonAuthFailed)
+	}
+	if data["peer_addr"] != "1.2.3.4:5" {
+		t.Errorf("data.peer_addr = %v, want 1.2.3.4:5", data["peer_addr"])
+	}
+}
+
 func TestJSONLineWriter_Close_WithCloser(t *testing.T) {
 	var buf bytes.Buffer
 	w := NewJSONLineWriter(&buf)
@@ -133,3 +199,344 @@ func TestNopEmitter_Close(t *testing.T) {
 // Verify interface compliance at compile time.
 var _ Emitter = (*JSONLineWriter)(nil)
 var _ Emitter = NopEmitter{}
+
+func TestFilterEmitter_DropsUnselectedTypes(t *testing.T) {
+	var buf bytes.Buffer
+	f := NewFilterEmitter(NewJSONLineWriter(&buf), EventStateChanged, EventError)
+
+	f.Emit(EventLatency, LatencyData{RTTMs: 12.5})
+	f.Emit(EventStateChanged, StateChangedData{State: "connected"})
+	f.Emit(EventStats, StatsData{})
+	f.Emit(EventError, ErrorData{Message: "boom"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+	want := []EventType{EventStateChanged, EventError}
+	for i, line := range lines {
+		var env Envelope
+		if err := json.Unmarshal([]byte(line), &env); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if env.Type != want[i] {
+			t.Errorf("line %d type = %q, want %q", i, env.Type, want[i])
+		}
+	}
+}
+
+func TestParseEventTypes(t *testing.T) {
+	types, err := ParseEventTypes(" state_changed, error ,")
+	if err != nil {
+		t.Fatalf("ParseEventTypes: %v", err)
+	}
+	if len(types) != 2 || types[0] != EventStateChanged || types[1] != EventError {
+		t.Errorf("types = %v, want [state_changed error]", types)
+	}
+
+	if types, err := ParseEventTypes("listening"); err != nil || len(types) != 1 || types[0] != EventListening {
+		t.Errorf("ParseEventTypes(listening) = %v, %v", types, err)
+	}
+
+	if _, err := ParseEventTypes("state_changed,bogus"); !errors.Is(err, ErrUnknownEventType) {
+		t.Errorf("err = %v, want ErrUnknownEventType", err)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that counts Sync calls, like an *os.File.
+type syncBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	syncs int
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncs++
+	return nil
+}
+
+func (s *syncBuffer) syncCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.syncs
+}
+
+func TestJSONLineWriter_SyncEachLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	w := NewJSONLineWriterSync(f, 0)
+	defer w.Close()
+
+	w.Emit(EventStateChanged, StateChangedData{State: "connected"})
+
+	// The line is on disk before Close, via an independent read.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var env Envelope
+	if err := json.Unmarshal(bytes.TrimSpace(data), &env); err != nil {
+		t.Fatalf("line not durable: %q: %v", data, err)
+	}
+	if env.Type != EventStateChanged {
+		t.Errorf("type = %q, want %q", env.Type, EventStateChanged)
+	}
+
+	sb := &syncBuffer{}
+	sw := NewJSONLineWriterSync(sb, 0)
+	sw.Emit(EventStats, StatsData{})
+	sw.Emit(EventStats, StatsData{})
+	if got := sb.syncCount(); got != 2 {
+		t.Errorf("syncs = %d, want 2 (one per line)", got)
+	}
+}
+
+func TestJSONLineWriter_SyncInterval(t *testing.T) {
+	sb := &syncBuffer{}
+	w := NewJSONLineWriterSync(sb, time.Hour)
+
+	w.Emit(EventStats, StatsData{})
+	w.Emit(EventStats, StatsData{})
+	if got := sb.syncCount(); got != 0 {
+		t.Errorf("syncs = %d before interval, want 0", got)
+	}
+
+	w.Close()
+	if got := sb.syncCount(); got != 1 {
+		t.Errorf("syncs = %d after Close, want 1", got)
+	}
+
+	off := &syncBuffer{}
+	w = NewJSONLineWriterSync(off, -1)
+	w.Emit(EventStats, StatsData{})
+	w.Close()
+	if got := off.syncCount(); got != 0 {
+		t.Errorf("syncs = %d with syncing disabled, want 0", got)
+	}
+}
+
+func TestAsyncJSONLineWriter_SyncsOnTimer(t *testing.T) {
+	sb := &syncBuffer{}
+	w := NewAsyncJSONLineWriterSync(sb, 10*time.Millisecond)
+	defer w.Close()
+
+	w.Emit(EventStats, StatsData{})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for sb.syncCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("async writer never synced an idle file")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// warnings collects WarnFunc calls.
+type warnings struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (w *warnings) warn(format string, args ...interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lines = append(w.lines, fmt.Sprintf(format, args...))
+}
+
+func (w *warnings) get() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.lines...)
+}
+
+// readEvents returns the types of the events in the file at path.
+func readEvents(t *testing.T, path string) []EventType {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var types []EventType
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var env Envelope
+		if err := json.Unmarshal(line, &env); err != nil {
+			t.Fatalf("bad line %q: %v", line, err)
+		}
+		types = append(types, env.Type)
+	}
+	return types
+}
+
+func TestJSONLineFile_ReopensAfterWriteFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	w, err := OpenJSONLineFile(path, -1)
+	if err != nil {
+		t.Fatalf("OpenJSONLineFile: %v", err)
+	}
+	defer w.Close()
+	var warned warnings
+	w.SetWarnFunc(warned.warn)
+
+	w.Emit(EventStateChanged, StateChangedData{State: "CONNECTED"})
+	w.w.(*os.File).Close() // every write fails from here
+
+	for range ReopenAfterFailures - 1 {
+		w.Emit(EventLatency, LatencyData{})
+	}
+	if got := warned.get(); len(got) != 1 || !strings.Contains(got[0], "write failed") {
+		t.Fatalf("warnings while failing = %q, want one write failure", got)
+	}
+
+	w.Emit(EventStats, StatsData{}) // reopens the file and writes
+	w.Emit(EventError, ErrorData{})
+
+	if got := readEvents(t, path); len(got) != 3 || got[0] != EventStateChanged || got[1] != EventStats || got[2] != EventError {
+		t.Errorf("events in file = %v, want [state_changed stats error]", got)
+	}
+	if got := warned.get(); len(got) != 2 || !strings.Contains(got[1], "reopened") {
+		t.Errorf("warnings = %q, want a write failure then a reopen", got)
+	}
+}
+
+func TestJSONLineFile_ReopensRotatedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.jsonl")
+	w, err := OpenJSONLineFile(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenJSONLineFile: %v", err)
+	}
+	defer w.Close()
+	var warned warnings
+	w.SetWarnFunc(warned.warn)
+
+	w.Emit(EventStateChanged, StateChangedData{State: "CONNECTED"})
+	w.Flush()
+	if got := warned.get(); len(got) != 0 {
+		t.Fatalf("warnings before rotation = %q, want none", got)
+	}
+
+	if err := os.Rename(path, filepath.Join(dir, "events.jsonl.1")); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	w.Emit(EventStats, StatsData{})
+
+	if got := readEvents(t, path); len(got) != 1 || got[0] != EventStats {
+		t.Errorf("events in new file = %v, want [stats]", got)
+	}
+	if got := warned.get(); len(got) != 1 || !strings.Contains(got[0], "moved or removed") {
+		t.Errorf("warnings = %q, want one rotation notice", got)
+	}
+}
+
+// failingWriter fails every write until fixed.
+type failingWriter struct {
+	fixed bool
+	buf   bytes.Buffer
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if !f.fixed {
+		return 0, errors.New("no space left on device")
+	}
+	return f.buf.Write(p)
+}
+
+func TestJSONLineWriter_WarnsOnceOnWriteFailure(t *testing.T) {
+	fw := &failingWriter{}
+	w := NewJSONLineWriterSync(fw, -1)
+	var warned warnings
+	w.SetWarnFunc(warned.warn)
+
+	for range 2 * ReopenAfterFailures {
+		w.Emit(EventStats, StatsData{})
+	}
+	if got := warned.get(); len(got) != 1 || !strings.Contains(got[0], "no space left on device") {
+		t.Errorf("warnings = %q, want exactly one naming the error", got)
+	}
+
+	fw.fixed = true
+	w.Emit(EventStats, StatsData{})
+	if got := warned.get(); len(got) != 2 || !strings.Contains(got[1], "writing again") {
+		t.Errorf("warnings after recovery = %q, want a recovery notice", got)
+	}
+	if fw.buf.Len() == 0 {
+		t.Error("event not written after recovery")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewJSONLineWriter(&buf)
+	w.Emit(EventStateChanged, StateChangedData{State: "CONNECTED", PeerAddr: "1.2.3.4:31415"})
+	w.Emit(EventLatency, LatencyData{RTTMs: 10})
+	w.Emit(EventLatency, LatencyData{RTTMs: 40, IsSpike: true})
+	w.Emit(EventStats, StatsData{TxBytes: 100, RxBytes: 200, TxPackets: 1, RxPackets: 2})
+	w.Emit(EventError, ErrorData{Message: "peer unresponsive"})
+	w.Emit(EventStateChanged, StateChangedData{State: "DISCONNECTED"})
+	w.Emit(EventStats, StatsData{TxBytes: 150, RxBytes: 250, TxPackets: 2, RxPackets: 3, Final: true})
+	buf.WriteString("not json\n")
+	buf.WriteString(`{"type":"latency","data":"wrong shape"}` + "\n")
+	w.Emit(EventStateChanged, StateChangedData{State: "CONNECTED"})
+	w.Emit(EventLatency, LatencyData{RTTMs: 20})
+	w.Emit(EventStats, StatsData{TxBytes: 50, RxBytes: 60})
+	w.Emit(EventStateChanged, StateChangedData{State: "DISCONNECTED", Reason: ReasonIdleTimeout})
+
+	s, err := Summarize(&buf)
+	if err != nil {
+		t.Fatalf("Summarize: %v", err)
+	}
+
+	if s.Events != 11 || s.Malformed != 2 {
+		t.Errorf("events = %d, malformed = %d; want 11, 2", s.Events, s.Malformed)
+	}
+	if len(s.Periods) != 2 {
+		t.Fatalf("periods = %d, want 2", len(s.Periods))
+	}
+	if s.Periods[0].PeerAddr != "1.2.3.4:31415" || s.Periods[0].Reason != "peer unresponsive" {
+		t.Errorf("period 0 = %+v", s.Periods[0])
+	}
+	if s.Periods[1].Reason != ReasonIdleTimeout {
+		t.Errorf("period 1 reason = %q, want %q", s.Periods[1].Reason, ReasonIdleTimeout)
+	}
+	if s.RTTSamples != 3 || s.RTTMinMs != 10 || s.RTTMaxMs != 40 || s.RTTAvgMs() != 70.0/3 || s.Spikes != 1 {
+		t.Errorf("rtt = %d samples, min %v, max %v, avg %v, spikes %d", s.RTTSamples, s.RTTMinMs, s.RTTMaxMs, s.RTTAvgMs(), s.Spikes)
+	}
+	// Final 150/250 for the first run, then 50/60 after the counters reset.
+	if s.TxBytes != 200 || s.RxBytes != 310 {
+		t.Errorf("bytes = tx %d rx %d, want tx 200 rx 310", s.TxBytes, s.RxBytes)
+	}
+
+	var out bytes.Buffer
+	s.Print(&out)
+	for _, want := range []string{"Connections: 2", "idle_timeout", "2 malformed", "max 40.0ms"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestSummarize_SeqGaps(t *testing.T) {
+	input := `{"type":"latency","session_id":"a","seq":1,"data":{"rtt_ms":5}}
+{"type":"latency","session_id":"a","seq":4,"data":{"rtt_ms":5}}
+{"type":"latency","session_id":"b","seq":1,"data":{"rtt_ms":5}}
+`
+	s, err := Summarize(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Summarize: %v", err)
+	}
+	if s.Missing != 2 || s.Sessions != 2 {
+		t.Errorf("missing = %d, sessions = %d; want 2, 2", s.Missing, s.Sessions)
+	}
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownEventType is returned by ParseEventTypes for an unrecognized name.
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// eventTypes lists every EventType, for validating filter lists.
+var eventTypes = []EventType{
+	EventStateChanged,
+	EventStats,
+	EventLatency,
+	EventDiscovery,
+	EventError,
+	EventListening,
+}
+
+// ParseEventTypes parses a comma-separated list of event type names such as
+// "state_changed,error". An empty string yields nil.
+func ParseEventTypes(s string) ([]EventType, error) {
+	var types []EventType
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		t, ok := lookupEventType(name)
+		if !ok {
+			return nil, fmt.Errorf("%w %q (valid: %s)", ErrUnknownEventType, name, validEventTypes())
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func lookupEventType(name string) (EventType, bool) {
+	for _, t := range eventTypes {
+		if string(t) == name {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+func validEventTypes() string {
+	names := make([]string, len(eventTypes))
+	for i, t := range eventTypes {
+		names[i] = string(t)
+	}
+	return strings.Join(names, ", ")
+}
+
+// FilterEmitter passes only selected event types through to another Emitter.
+type FilterEmitter struct {
+	next  Emitter
+	allow map[EventType]bool
+}
+
+// NewFilterEmitter creates a FilterEmitter that forwards events of the given
+// types to next and drops all others.
+func NewFilterEmitter(next Emitter, types ...EventType) *FilterEmitter {
+	allow := make(map[EventType]bool, len(types))
+	for _, t := range types {
+		allow[t] = true
+	}
+	return &FilterEmitter{next: next, allow: allow}
+}
+
+// Emit forwards the event if its type is selected.
+func (f *FilterEmitter) Emit(eventType EventType, data interface{}) {
+	if f.allow[eventType] {
+		f.next.Emit(eventType, data)
+	}
+}
+
+// Close closes the underlying emitter.
+func (f *FilterEmitter) Close() error {
+	return f.next.Close()
+}
@@ -1,25 +1,207 @@
 package events
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultSyncInterval is how often writers over a file sync it to disk.
+const DefaultSyncInterval = time.Second
+
+// ReopenAfterFailures is how many writes in a row must fail before a writer
+// opened with OpenJSONLineFile reopens its file.
+const ReopenAfterFailures = 3
+
+// WarnFunc reports a problem with the events output, like Logger.Warn.
+type WarnFunc func(format string, args ...interface{})
+
+// syncer is implemented by writers that can flush to stable storage,
+// such as *os.File.
+type syncer interface {
+	Sync() error
+}
+
+// newSessionID returns a random identifier for one emitter's events.
+func newSessionID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
 // JSONLineWriter writes JSON Lines (one JSON object per line) to an io.Writer.
 // It is safe for concurrent use.
 type JSONLineWriter struct {
-	mu  sync.Mutex
-	enc *json.Encoder
-	w   io.Writer
+	mu        sync.Mutex
+	enc       *json.Encoder
+	w         io.Writer
+	sessionID string
+	seq       uint64 // guarded by mu
+
+	// Syncing, for writers with a Sync method; guarded by mu.
+	syncer       syncer
+	syncInterval time.Duration
+	lastSync     time.Time
+	dirty        bool
+
+	// Write failure handling; guarded by mu.
+	path     string   // file opened by OpenJSONLineFile, "" for other writers
+	reopen   bool     // path is a regular file, safe to reopen
+	warn     WarnFunc // nil: failures are silent
+	failures int      // writes failed in a row
+}
+
+// openEventsFile opens path for appending events, creating it if needed.
+func openEventsFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 }
 
-// NewJSONLineWriter creates a new JSONLineWriter that writes to w.
+// OpenJSONLineFile opens (or creates) the file at path for appending and
+// returns a writer over it, syncing as described for NewJSONLineWriterSync.
+// Unlike a writer over an *os.File, it reopens a regular file after
+// ReopenAfterFailures failed writes in a row (e.g. the disk filled up), and
+// when flushing notices the file was moved or removed (log rotation). Named
+// pipes are never reopened, since opening one blocks until a reader appears.
+func OpenJSONLineFile(path string, syncInterval time.Duration) (*JSONLineWriter, error) {
+	f, err := openEventsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	j := NewJSONLineWriterSync(f, syncInterval)
+	j.path = path
+	if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+		j.reopen = true
+	}
+	return j, nil
+}
+
+// NewJSONLineWriter creates a new JSONLineWriter that writes to w,
+// syncing file targets every DefaultSyncInterval.
 func NewJSONLineWriter(w io.Writer) *JSONLineWriter {
+	return NewJSONLineWriterSync(w, DefaultSyncInterval)
+}
+
+// NewJSONLineWriterSync creates a new JSONLineWriter that writes to w.
+// If w has a Sync method (such as *os.File), it is synced after every line
+// when syncInterval is 0, or after a write once syncInterval has passed since
+// the last sync. A negative syncInterval disables syncing.
+func NewJSONLineWriterSync(w io.Writer, syncInterval time.Duration) *JSONLineWriter {
+	j := &JSONLineWriter{enc: newEncoder(w), w: w, sessionID: newSessionID(), syncInterval: syncInterval}
+	if s, ok := w.(syncer); ok && syncInterval >= 0 {
+		j.syncer = s
+		j.lastSync = time.Now()
+	}
+	return j
+}
+
+// newEncoder returns the JSON encoder for event lines written to w.
+func newEncoder(w io.Writer) *json.Encoder {
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
-	return &JSONLineWriter{enc: enc, w: w}
+	return enc
+}
+
+// SessionID returns the session ID stamped on every event.
+func (j *JSONLineWriter) SessionID() string {
+	return j.sessionID
+}
+
+// SetWarnFunc makes j report write failures through warn: once when writes
+// start failing, and when a reopened file brings them back. Events are
+// still dropped while writes fail.
+func (j *JSONLineWriter) SetWarnFunc(warn WarnFunc) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.warn = warn
+}
+
+// describe names the output in warnings.
+func (j *JSONLineWriter) describe() string {
+	if j.path != "" {
+		return fmt.Sprintf("events output %q", j.path)
+	}
+	return "events output"
+}
+
+func (j *JSONLineWriter) warnLocked(format string, args ...interface{}) {
+	if j.warn != nil {
+		j.warn(format, args...)
+	}
+}
+
+// encodeLocked writes env, tracking failures and reopening the file once
+// they persist.
+func (j *JSONLineWriter) encodeLocked(env Envelope) {
+	err := j.enc.Encode(env)
+	if err == nil {
+		if j.failures > 0 {
+			j.failures = 0
+			j.warnLocked("%s: writing again", j.describe())
+		}
+		j.wroteLocked()
+		return
+	}
+
+	// json.Encoder keeps failing once its writer has; start a new one
+	j.enc = newEncoder(j.w)
+	j.failures++
+	if j.failures == 1 {
+		if j.reopen {
+			j.warnLocked("%s: write failed, dropping events until it can be reopened: %v", j.describe(), err)
+		} else {
+			j.warnLocked("%s: write failed, dropping events: %v", j.describe(), err)
+		}
+	}
+	if j.reopen && j.failures%ReopenAfterFailures == 0 && j.reopenLocked() == nil {
+		if j.enc.Encode(env) == nil {
+			j.failures = 0
+			j.warnLocked("%s: reopened, writing again", j.describe())
+			j.wroteLocked()
+		}
+	}
+}
+
+// reopenLocked replaces the file with a fresh handle on path.
+func (j *JSONLineWriter) reopenLocked() error {
+	f, err := openEventsFile(j.path)
+	if err != nil {
+		return err
+	}
+	if c, ok := j.w.(io.Closer); ok {
+		_ = c.Close()
+	}
+	j.w = f
+	j.enc = newEncoder(f)
+	if j.syncInterval >= 0 {
+		j.syncer = f
+	}
+	j.dirty = false
+	return nil
+}
+
+// checkRotatedLocked reopens path if it no longer names the open file.
+func (j *JSONLineWriter) checkRotatedLocked() {
+	f, ok := j.w.(*os.File)
+	if !j.reopen || !ok {
+		return
+	}
+	current, err := os.Stat(j.path)
+	if err == nil {
+		if open, err := f.Stat(); err == nil && os.SameFile(open, current) {
+			return
+		}
+	}
+	if err := j.reopenLocked(); err != nil {
+		j.warnLocked("%s: file was moved or removed and can't be reopened: %v", j.describe(), err)
+		return
+	}
+	j.warnLocked("%s: file was moved or removed; reopened it", j.describe())
 }
 
 // Emit writes a JSON line with the event envelope.
@@ -28,17 +210,60 @@ func (j *JSONLineWriter) Emit(eventType EventType, data interface{}) {
 	env := Envelope{
 		Type:      eventType,
 		Timestamp: time.Now(),
+		SessionID: j.sessionID,
 		Data:      data,
 	}
 
 	j.mu.Lock()
 	defer j.mu.Unlock()
-	// Silently drop errors — events are diagnostic, not critical
-	_ = j.enc.Encode(env)
+	j.seq++
+	env.Seq = j.seq
+	// Failed writes drop the event — events are diagnostic, not critical
+	j.encodeLocked(env)
+}
+
+// write encodes an envelope that already carries its session ID and Seq.
+func (j *JSONLineWriter) write(env Envelope) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.encodeLocked(env)
 }
 
-// Close closes the underlying writer if it implements io.Closer.
+// wroteLocked syncs after a write if the sync interval calls for it.
+func (j *JSONLineWriter) wroteLocked() {
+	if j.syncer == nil {
+		return
+	}
+	j.dirty = true
+	if j.syncInterval == 0 || time.Since(j.lastSync) >= j.syncInterval {
+		j.syncLocked()
+	}
+}
+
+func (j *JSONLineWriter) syncLocked() {
+	if j.syncer == nil || !j.dirty {
+		return
+	}
+	_ = j.syncer.Sync()
+	j.dirty = false
+	j.lastSync = time.Now()
+}
+
+// Flush syncs any lines written since the last sync to stable storage.
+// It does nothing if the underlying writer cannot sync. A writer from
+// OpenJSONLineFile then reopens its path if the file was moved or removed.
+func (j *JSONLineWriter) Flush() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.syncLocked()
+	j.checkRotatedLocked()
+}
+
+// Close syncs and then closes the underlying writer if it implements io.Closer.
 func (j *JSONLineWriter) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.syncLocked()
 	if c, ok := j.w.(io.Closer); ok {
 		return c.Close()
 	}
@@ -48,32 +273,70 @@ func (j *JSONLineWriter) Close() error {
 // AsyncJSONLineWriter wraps JSONLineWriter with non-blocking async emission.
 // Events are queued to a buffered channel and written by a background goroutine.
 // If the buffer is full, events are dropped immediately (UDP mindset: performance over perfection).
+// Seq is assigned before queuing, so dropped events show up as gaps.
+// File targets are also synced on a timer so quiet periods don't leave the
+// last events unsynced.
 type AsyncJSONLineWriter struct {
 	events chan Envelope
 	done   chan struct{}
 	wg     sync.WaitGroup
 	w      *JSONLineWriter
+	seq    atomic.Uint64
 }
 
-// NewAsyncJSONLineWriter creates a new AsyncJSONLineWriter that writes to w.
+// NewAsyncJSONLineWriter creates a new AsyncJSONLineWriter that writes to w,
+// syncing file targets every DefaultSyncInterval.
 // Events are buffered in a channel with capacity 64.
 func NewAsyncJSONLineWriter(w io.Writer) *AsyncJSONLineWriter {
+	return NewAsyncJSONLineWriterSync(w, DefaultSyncInterval)
+}
+
+// NewAsyncJSONLineWriterSync creates a new AsyncJSONLineWriter that writes to
+// w, syncing as described for NewJSONLineWriterSync.
+func NewAsyncJSONLineWriterSync(w io.Writer, syncInterval time.Duration) *AsyncJSONLineWriter {
+	return newAsyncJSONLineWriter(NewJSONLineWriterSync(w, syncInterval), syncInterval)
+}
+
+func newAsyncJSONLineWriter(w *JSONLineWriter, syncInterval time.Duration) *AsyncJSONLineWriter {
 	a := &AsyncJSONLineWriter{
 		events: make(chan Envelope, 64),
 		done:   make(chan struct{}),
-		w:      NewJSONLineWriter(w),
+		w:      w,
 	}
 	a.wg.Add(1)
-	go a.writer()
+	go a.writer(syncInterval)
 	return a
 }
 
+// OpenAsyncJSONLineFile is OpenJSONLineFile with async emission, syncing
+// file targets on a timer as for NewAsyncJSONLineWriterSync.
+func OpenAsyncJSONLineFile(path string, syncInterval time.Duration) (*AsyncJSONLineWriter, error) {
+	w, err := OpenJSONLineFile(path, syncInterval)
+	if err != nil {
+		return nil, err
+	}
+	return newAsyncJSONLineWriter(w, syncInterval), nil
+}
+
+// SessionID returns the session ID stamped on every event.
+func (a *AsyncJSONLineWriter) SessionID() string {
+	return a.w.sessionID
+}
+
+// SetWarnFunc reports write failures through warn; see
+// JSONLineWriter.SetWarnFunc.
+func (a *AsyncJSONLineWriter) SetWarnFunc(warn WarnFunc) {
+	a.w.SetWarnFunc(warn)
+}
+
 // Emit queues an event for async writing.
 // If the buffer is full, the event is dropped immediately (non-blocking).
 func (a *AsyncJSONLineWriter) Emit(eventType EventType, data interface{}) {
 	env := Envelope{
 		Type:      eventType,
 		Timestamp: time.Now(),
+		SessionID: a.w.sessionID,
+		Seq:       a.seq.Add(1),
 		Data:      data,
 	}
 
@@ -86,17 +349,27 @@ func (a *AsyncJSONLineWriter) Emit(eventType EventType, data interface{}) {
 }
 
 // writer is the background goroutine that handles potentially-blocking I/O.
-func (a *AsyncJSONLineWriter) writer() {
+func (a *AsyncJSONLineWriter) writer(syncInterval time.Duration) {
 	defer a.wg.Done()
+
+	var tick <-chan time.Time
+	if a.w.syncer != nil && syncInterval > 0 {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
 	for {
 		select {
 		case env := <-a.events:
-			a.w.Emit(env.Type, env.Data)
+			a.w.write(env)
+		case <-tick:
+			a.w.Flush()
 		case <-a.done:
 			// Drain remaining events before shutdown
 			for len(a.events) > 0 {
 				env := <-a.events
-				a.w.Emit(env.Type, env.Data)
+				a.w.write(env)
 			}
 			return
 		}
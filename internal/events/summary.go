@@ -0,0 +1,280 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSummaryLine bounds a single JSON line read by Summarize.
+const maxSummaryLine = 1024 * 1024
+
+// ConnectionPeriod is one CONNECTED..DISCONNECTED span in an events file.
+type ConnectionPeriod struct {
+	Start    time.Time
+	End      time.Time // zero if the file ends while connected
+	PeerAddr string
+	Reason   string // why it ended: the DISCONNECTED reason or last error
+}
+
+// Summary is a session report built from a JSON Lines events stream.
+type Summary struct {
+	Events    int    // well-formed events read
+	Malformed int    // lines that were not valid event envelopes
+	Sessions  int    // distinct session IDs
+	Missing   uint64 // events lost to gaps in seq
+	First     time.Time
+	Last      time.Time
+
+	Periods           []ConnectionPeriod
+	DisconnectReasons map[string]int
+
+	RTTSamples int
+	RTTMinMs   float64
+	RTTMaxMs   float64
+	RTTSumMs   float64
+	Spikes     int
+
+	TxPackets uint64
+	TxBytes   uint64
+	RxPackets uint64
+	RxBytes   uint64
+}
+
+// RTTAvgMs returns the mean RTT over all latency events, or 0 if none.
+func (s *Summary) RTTAvgMs() float64 {
+	if s.RTTSamples == 0 {
+		return 0
+	}
+	return s.RTTSumMs / float64(s.RTTSamples)
+}
+
+// rawEnvelope is Envelope with its payload left undecoded.
+type rawEnvelope struct {
+	Type      EventType       `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	SessionID string          `json:"session_id"`
+	Seq       uint64          `json:"seq"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// summarySession tracks per-session state while reading.
+type summarySession struct {
+	lastSeq   uint64
+	open      int // index into Periods of the open period, or -1
+	lastError string
+	stats     StatsData // latest counters of the current bridge run
+}
+
+// Summarize reads a JSON Lines events stream (as written by JSONLineWriter)
+// and builds a Summary. Lines that are not valid envelopes, or whose payload
+// doesn't match their type, are counted in Malformed and skipped.
+func Summarize(r io.Reader) (*Summary, error) {
+	s := &Summary{DisconnectReasons: make(map[string]int)}
+	sessions := make(map[string]*summarySession)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSummaryLine)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var env rawEnvelope
+		if err := json.Unmarshal([]byte(line), &env); err != nil || env.Type == "" {
+			s.Malformed++
+			continue
+		}
+
+		sess := sessions[env.SessionID]
+		if sess == nil {
+			sess = &summarySession{open: -1}
+			sessions[env.SessionID] = sess
+		}
+		if !s.add(sess, env) {
+			s.Malformed++
+			continue
+		}
+
+		s.Events++
+		if s.First.IsZero() || env.Timestamp.Before(s.First) {
+			s.First = env.Timestamp
+		}
+		if env.Timestamp.After(s.Last) {
+			s.Last = env.Timestamp
+		}
+		if env.Seq > sess.lastSeq+1 {
+			s.Missing += env.Seq - sess.lastSeq - 1
+		}
+		if env.Seq > sess.lastSeq {
+			sess.lastSeq = env.Seq
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return s, err
+	}
+
+	s.Sessions = len(sessions)
+	for _, sess := range sessions {
+		s.bankStats(sess)
+	}
+	return s, nil
+}
+
+// add applies one event to the summary, reporting false if its payload
+// could not be decoded.
+func (s *Summary) add(sess *summarySession, env rawEnvelope) bool {
+	switch env.Type {
+	case EventStateChanged:
+		var data StateChangedData
+		if json.Unmarshal(env.Data, &data) != nil {
+			return false
+		}
+		switch data.State {
+		case "CONNECTED":
+			s.Periods = append(s.Periods, ConnectionPeriod{Start: env.Timestamp, PeerAddr: data.PeerAddr})
+			sess.open = len(s.Periods) - 1
+			sess.lastError = ""
+		case "DISCONNECTED":
+			if sess.open < 0 {
+				break
+			}
+			reason := data.Reason
+			if reason == "" {
+				reason = sess.lastError
+			}
+			if reason == "" {
+				reason = "unknown"
+			}
+			p := &s.Periods[sess.open]
+			p.End = env.Timestamp
+			p.Reason = reason
+			s.DisconnectReasons[reason]++
+			sess.open = -1
+		}
+
+	case EventLatency:
+		var data LatencyData
+		if json.Unmarshal(env.Data, &data) != nil {
+			return false
+		}
+		if s.RTTSamples == 0 || data.RTTMs < s.RTTMinMs {
+			s.RTTMinMs = data.RTTMs
+		}
+		if data.RTTMs > s.RTTMaxMs {
+			s.RTTMaxMs = data.RTTMs
+		}
+		s.RTTSamples++
+		s.RTTSumMs += data.RTTMs
+		if data.IsSpike {
+			s.Spikes++
+		}
+
+	case EventStats:
+		var data StatsData
+		if json.Unmarshal(env.Data, &data) != nil {
+			return false
+		}
+		// Counters restart with each bridge run; a drop means a new run began.
+		if data.TxBytes < sess.stats.TxBytes || data.RxBytes < sess.stats.RxBytes {
+			s.bankStats(sess)
+		}
+		sess.stats = data
+		if data.Final {
+			s.bankStats(sess)
+		}
+
+	case EventError:
+		var data ErrorData
+		if json.Unmarshal(env.Data, &data) != nil {
+			return false
+		}
+		if data.Reason != "" {
+			sess.lastError = data.Reason
+		} else {
+			sess.lastError = data.Message
+		}
+
+	case EventDiscovery:
+		var data DiscoveryData
+		if json.Unmarshal(env.Data, &data) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// bankStats adds the session's current run counters to the totals.
+func (s *Summary) bankStats(sess *summarySession) {
+	s.TxPackets += sess.stats.TxPackets
+	s.TxBytes += sess.stats.TxBytes
+	s.RxPackets += sess.stats.RxPackets
+	s.RxBytes += sess.stats.RxBytes
+	sess.stats = StatsData{}
+}
+
+// Print writes a human-readable report of the summary to w.
+func (s *Summary) Print(w io.Writer) {
+	fmt.Fprintf(w, "Events: %d", s.Events)
+	if s.Malformed > 0 {
+		fmt.Fprintf(w, " (%d malformed lines skipped)", s.Malformed)
+	}
+	if s.Missing > 0 {
+		fmt.Fprintf(w, " (%d missing from gaps in seq)", s.Missing)
+	}
+	fmt.Fprintln(w)
+	if s.Events == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Span:   %s - %s (%s)\n",
+		s.First.Local().Format(time.DateTime), s.Last.Local().Format(time.DateTime),
+		s.Last.Sub(s.First).Round(time.Second))
+	fmt.Fprintf(w, "Runs:   %d\n", s.Sessions)
+
+	fmt.Fprintf(w, "\nConnections: %d\n", len(s.Periods))
+	for _, p := range s.Periods {
+		peer := p.PeerAddr
+		if peer == "" {
+			peer = "peer"
+		}
+		if p.End.IsZero() {
+			fmt.Fprintf(w, "  %s  %s  connected (no disconnect recorded)\n",
+				p.Start.Local().Format(time.DateTime), peer)
+			continue
+		}
+		fmt.Fprintf(w, "  %s  %s  %s, ended: %s\n",
+			p.Start.Local().Format(time.DateTime), peer, p.End.Sub(p.Start).Round(time.Second), p.Reason)
+	}
+
+	if len(s.DisconnectReasons) > 0 {
+		fmt.Fprintln(w, "\nDisconnect reasons:")
+		reasons := make([]string, 0, len(s.DisconnectReasons))
+		for reason := range s.DisconnectReasons {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool {
+			ci, cj := s.DisconnectReasons[reasons[i]], s.DisconnectReasons[reasons[j]]
+			if ci != cj {
+				return ci > cj
+			}
+			return reasons[i] < reasons[j]
+		})
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "  %-24s %d\n", reason, s.DisconnectReasons[reason])
+		}
+	}
+
+	fmt.Fprintln(w)
+	if s.RTTSamples > 0 {
+		fmt.Fprintf(w, "RTT:     min %.1fms | avg %.1fms | max %.1fms (%d samples, %d spikes)\n",
+			s.RTTMinMs, s.RTTAvgMs(), s.RTTMaxMs, s.RTTSamples, s.Spikes)
+	} else {
+		fmt.Fprintln(w, "RTT:     no latency events")
+	}
+	fmt.Fprintf(w, "Traffic: TX %d pkts (%d bytes) | RX %d pkts (%d bytes)\n",
+		s.TxPackets, s.TxBytes, s.RxPackets, s.RxBytes)
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -55,24 +58,202 @@ const (
 	colorBold   = "\033[1m"
 )
 
+// Color is an ANSI color used to highlight part of a message.
+type Color string
+
+// Colors available to callers for highlighting values within a message.
+const (
+	ColorRed    Color = colorRed
+	ColorYellow Color = colorYellow
+	ColorGreen  Color = colorGreen
+)
+
+// Colorize wraps s in the given color. Callers should only use it when
+// ColorEnabled reports true.
+func Colorize(c Color, s string) string {
+	return string(c) + s + colorReset
+}
+
+// ColorMode selects when the logger uses ANSI colors (--color).
+type ColorMode string
+
+const (
+	// ColorAuto honors NO_COLOR, then FORCE_COLOR / CLICOLOR_FORCE /
+	// CLICOLOR, and otherwise colors only terminal output. "" is ColorAuto.
+	ColorAuto ColorMode = "auto"
+	// ColorAlways colors output even when it is not a terminal.
+	ColorAlways ColorMode = "always"
+	// ColorNever never colors output.
+	ColorNever ColorMode = "never"
+)
+
+// ParseColorMode parses a --color value: auto, always or never.
+func ParseColorMode(s string) (ColorMode, error) {
+	switch mode := ColorMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "", ColorAuto:
+		return ColorAuto, nil
+	case ColorAlways, ColorNever:
+		return mode, nil
+	default:
+		return ColorAuto, fmt.Errorf("invalid color mode %q: must be auto, always, or never", s)
+	}
+}
+
+// enabled reports whether output to w should be colored in this mode.
+func (m ColorMode) enabled(w io.Writer) bool {
+	switch m {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	if mode, _, ok := EnvColorMode(os.Getenv); ok {
+		return mode == ColorAlways
+	}
+	f, ok := w.(*os.File)
+	return ok && isTTY(f)
+}
+
+// EnvColorMode reads the NO_COLOR, FORCE_COLOR, CLICOLOR_FORCE and CLICOLOR
+// conventions through getenv, in that order of precedence. If one of them
+// decides, it returns ColorAlways or ColorNever, the deciding variable and
+// ok; ColorAuto defers to it.
+func EnvColorMode(getenv func(string) string) (mode ColorMode, variable string, ok bool) {
+	// https://no-color.org: any non-empty value disables color
+	if getenv("NO_COLOR") != "" {
+		return ColorNever, "NO_COLOR", true
+	}
+	if v := getenv("FORCE_COLOR"); v != "" {
+		if v == "0" || strings.EqualFold(v, "false") {
+			return ColorNever, "FORCE_COLOR", true
+		}
+		return ColorAlways, "FORCE_COLOR", true
+	}
+	if v := getenv("CLICOLOR_FORCE"); v != "" && v != "0" {
+		return ColorAlways, "CLICOLOR_FORCE", true
+	}
+	if getenv("CLICOLOR") == "0" {
+		return ColorNever, "CLICOLOR", true
+	}
+	return ColorAuto, "", false
+}
+
+// DefaultTimestampFormat is the layout used for log timestamps unless
+// SetTimestampFormat is called.
+const DefaultTimestampFormat = "2006-01-02 15:04:05"
+
 // Logger provides leveled logging with optional color support.
 type Logger struct {
-	level     Level
+	// level is read without mu, so calls below it (trace logging in the
+	// packet loops) never contend for the lock
+	level *atomic.Int32
+	*sink
+	fields string // "key=value " pairs from With, prefixed to every message
+}
+
+// sink is the output side of a Logger, shared with the loggers made by With.
+type sink struct {
 	output    io.Writer
 	useColor  bool
+	colorMode ColorMode // decides useColor on SetOutput
 	mu        sync.Mutex
 	timestamp string // format string for timestamps
+	utc       bool   // format timestamps in UTC instead of local time
+
+	// modules holds the levels set with SetModuleLevel, guarded by mu
+	modules map[string]*atomic.Int32
 }
 
+// Modules that have their own logger, for --log-module.
+const (
+	ModuleBridge    = "bridge"
+	ModuleCapture   = "capture"
+	ModuleDiscovery = "discovery"
+	ModuleTransport = "transport"
+)
+
+// Modules lists the module names accepted by ParseModuleLevels.
+var Modules = []string{ModuleBridge, ModuleCapture, ModuleDiscovery, ModuleTransport}
+
 // NewLogger creates a new logger with the specified level.
-// Color output is automatically enabled if writing to a terminal.
+// Color output follows ColorAuto: NO_COLOR and FORCE_COLOR are honored, and
+// otherwise it is enabled if writing to a terminal.
 func NewLogger(level Level) *Logger {
+	l := &Logger{
+		level: new(atomic.Int32),
+		sink: &sink{
+			output:    os.Stdout,
+			useColor:  ColorAuto.enabled(os.Stdout),
+			colorMode: ColorAuto,
+			timestamp: DefaultTimestampFormat,
+		},
+	}
+	l.level.Store(int32(level))
+	return l
+}
+
+// With returns a child logger that prefixes every message with the given
+// key/value pairs, e.g. With("peer", addr) logs "peer=1.2.3.4:31415 ...".
+// The child shares its parent's level, output and settings, so changing
+// them on either changes both. Fields accumulate across nested With calls.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
 	return &Logger{
-		level:     level,
-		output:    os.Stdout,
-		useColor:  isTTY(os.Stdout),
-		timestamp: "2006-01-02 15:04:05",
+		level:  l.level,
+		sink:   l.sink,
+		fields: l.fields + formatFields(keyvals),
+	}
+}
+
+// SetModuleLevel gives the named module a level of its own, independent of
+// the logger's level. It must be called before Module hands out that
+// module's logger.
+func (l *Logger) SetModuleLevel(module string, level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lv, ok := l.modules[module]
+	if !ok {
+		lv = new(atomic.Int32)
+		if l.modules == nil {
+			l.modules = make(map[string]*atomic.Int32)
+		}
+		l.modules[module] = lv
 	}
+	lv.Store(int32(level))
+}
+
+// Module returns the logger for the named module. It shares the output and
+// fields of l, and uses the module's level if SetModuleLevel set one;
+// otherwise it shares l's level, so SetLevel on either changes both.
+func (l *Logger) Module(name string) *Logger {
+	l.mu.Lock()
+	level, ok := l.modules[name]
+	l.mu.Unlock()
+	if !ok {
+		level = l.level
+	}
+	return &Logger{level: level, sink: l.sink, fields: l.fields}
+}
+
+// formatFields renders key/value pairs as "key=value " text. A value that
+// is empty or contains spaces, quotes or '=' is quoted; a trailing key
+// without a value is logged under !BADKEY, as log/slog does.
+func formatFields(keyvals []interface{}) string {
+	var sb strings.Builder
+	for i := 0; i < len(keyvals); i += 2 {
+		key, value := "!BADKEY", keyvals[i]
+		if i+1 < len(keyvals) {
+			key, value = fmt.Sprint(keyvals[i]), keyvals[i+1]
+		}
+		v := fmt.Sprint(value)
+		if v == "" || strings.ContainsAny(v, " \t\"=") {
+			v = strconv.Quote(v)
+		}
+		sb.WriteString(key)
+		sb.WriteByte('=')
+		sb.WriteString(v)
+		sb.WriteByte(' ')
+	}
+	return sb.String()
 }
 
 // SetOutput sets the output writer for the logger.
@@ -81,45 +262,83 @@ func (l *Logger) SetOutput(w io.Writer) {
 	defer l.mu.Unlock()
 	l.output = w
 	// Re-evaluate color support based on new output
-	if f, ok := w.(*os.File); ok {
-		l.useColor = isTTY(f)
-	} else {
-		l.useColor = false
-	}
+	l.useColor = l.colorMode.enabled(w)
+}
+
+// SetColorMode sets when color output is used, for the current output and
+// any set later with SetOutput.
+func (l *Logger) SetColorMode(mode ColorMode) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.colorMode = mode
+	l.useColor = mode.enabled(l.output)
 }
 
-// SetColorEnabled explicitly enables or disables color output.
+// SetColorEnabled explicitly enables or disables color output until the
+// next SetOutput or SetColorMode.
 func (l *Logger) SetColorEnabled(enabled bool) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.useColor = enabled
 }
 
-// SetLevel changes the logging level.
-func (l *Logger) SetLevel(level Level) {
+// ColorEnabled reports whether color output is enabled.
+func (l *Logger) ColorEnabled() bool {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.level = level
+	return l.useColor
 }
 
-// GetLevel returns the current logging level.
-func (l *Logger) GetLevel() Level {
+// SetTimestampFormat sets the time layout used for log timestamps
+// (see time.Format). An empty layout restores DefaultTimestampFormat.
+func (l *Logger) SetTimestampFormat(layout string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	return l.level
+	if layout == "" {
+		layout = DefaultTimestampFormat
+	}
+	l.timestamp = layout
 }
 
-// log writes a log message at the specified level.
-func (l *Logger) log(level Level, format string, args ...interface{}) {
+// SetUTC selects UTC (true) or local time (false) for log timestamps.
+func (l *Logger) SetUTC(utc bool) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	l.utc = utc
+}
+
+// now returns the current timestamp string. Caller must hold l.mu.
+func (l *Logger) now() string {
+	t := time.Now()
+	if l.utc {
+		t = t.UTC()
+	}
+	return t.Format(l.timestamp)
+}
+
+// SetLevel changes the logging level.
+func (l *Logger) SetLevel(level Level) {
+	l.level.Store(int32(level))
+}
 
-	if level > l.level {
+// GetLevel returns the current logging level.
+func (l *Logger) GetLevel() Level {
+	return Level(l.level.Load())
+}
+
+// log writes a log message at the specified level.
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	if level > l.GetLevel() {
 		return
 	}
 
-	timestamp := time.Now().Format(l.timestamp)
-	message := fmt.Sprintf(format, args...)
+	// Format outside the lock; only the write is serialized
+	message := l.fields + fmt.Sprintf(format, args...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	timestamp := l.now()
 
 	var levelStr string
 	var colorCode string
@@ -179,8 +398,8 @@ func (l *Logger) Stats(format string, args ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	timestamp := time.Now().Format(l.timestamp)
-	message := fmt.Sprintf(format, args...)
+	timestamp := l.now()
+	message := l.fields + fmt.Sprintf(format, args...)
 
 	if l.useColor {
 		fmt.Fprintf(l.output, "%s [%sSTATS%s] %s\n", timestamp, colorBold, colorReset, message)
@@ -189,6 +408,61 @@ func (l *Logger) Stats(format string, args ...interface{}) {
 	}
 }
 
+// Raw writes a line verbatim, without timestamp or level prefix.
+// It is used for machine-readable output such as CSV stats.
+func (l *Logger) Raw(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.output, line)
+}
+
+// ParseTimestampFormat resolves a --log-timeformat value to a time layout.
+// Accepts the names "default", "rfc3339" and "rfc3339nano" (case-insensitive);
+// anything else is used as a Go time layout and must contain at least one
+// layout element.
+func ParseTimestampFormat(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "default":
+		return DefaultTimestampFormat, nil
+	case "rfc3339":
+		return time.RFC3339, nil
+	case "rfc3339nano":
+		return time.RFC3339Nano, nil
+	}
+	// A layout without any layout elements formats to itself
+	if time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(s) == s {
+		return "", fmt.Errorf("invalid timestamp format %q (use default, rfc3339, rfc3339nano, or a Go time layout)", s)
+	}
+	return s, nil
+}
+
+// ParseModuleLevels parses a --log-module value: comma-separated
+// module=level pairs such as "capture=warn,bridge=trace". Modules must be
+// listed in Modules; an empty string sets no levels.
+func ParseModuleLevels(s string) (map[string]Level, error) {
+	levels := make(map[string]Level)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid module level %q: want module=level", part)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(Modules, name) {
+			return nil, fmt.Errorf("unknown module %q: must be one of %s", name, strings.Join(Modules, ", "))
+		}
+		level, err := ParseLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", name, err)
+		}
+		levels[name] = level
+	}
+	return levels, nil
+}
+
 // ParseLevel parses a string into a Level.
 // Valid values: error, warn, info, debug, trace (case-insensitive).
 func ParseLevel(s string) (Level, error) {
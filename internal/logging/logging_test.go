@@ -2,8 +2,12 @@ package logging
 
 import (
 	"bytes"
+	"io"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewLogger(t *testing.T) {
@@ -168,6 +172,144 @@ func TestLogger_Stats(t *testing.T) {
 	}
 }
 
+func TestLogger_Raw(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(LevelError)
+	logger.SetOutput(&buf)
+	logger.SetColorEnabled(true)
+
+	logger.Raw("a,b,c")
+
+	if got := buf.String(); got != "a,b,c\n" {
+		t.Errorf("Raw output = %q, want %q", got, "a,b,c\n")
+	}
+}
+
+func TestLogger_ColorEnabled(t *testing.T) {
+	logger := NewLogger(LevelInfo)
+
+	logger.SetColorEnabled(true)
+	if !logger.ColorEnabled() {
+		t.Error("ColorEnabled() = false after enabling")
+	}
+	logger.SetColorEnabled(false)
+	if logger.ColorEnabled() {
+		t.Error("ColorEnabled() = true after disabling")
+	}
+}
+
+func TestLogger_ColorMode(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		mode ColorMode
+		want bool
+	}{
+		{"auto, not a terminal", nil, ColorAuto, false},
+		{"auto, NO_COLOR", map[string]string{"NO_COLOR": "1"}, ColorAuto, false},
+		{"auto, FORCE_COLOR", map[string]string{"FORCE_COLOR": "1"}, ColorAuto, true},
+		{"auto, FORCE_COLOR=0", map[string]string{"FORCE_COLOR": "0"}, ColorAuto, false},
+		{"auto, NO_COLOR beats FORCE_COLOR", map[string]string{"NO_COLOR": "1", "FORCE_COLOR": "1"}, ColorAuto, false},
+		{"auto, CLICOLOR_FORCE", map[string]string{"CLICOLOR_FORCE": "1"}, ColorAuto, true},
+		{"auto, CLICOLOR=0", map[string]string{"CLICOLOR": "0", "CLICOLOR_FORCE": "0"}, ColorAuto, false},
+		{"always", nil, ColorAlways, true},
+		{"always, NO_COLOR", map[string]string{"NO_COLOR": "1"}, ColorAlways, true},
+		{"never", nil, ColorNever, false},
+		{"never, FORCE_COLOR", map[string]string{"FORCE_COLOR": "1"}, ColorNever, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"NO_COLOR", "FORCE_COLOR", "CLICOLOR_FORCE", "CLICOLOR"} {
+				t.Setenv(name, tt.env[name])
+			}
+			var buf bytes.Buffer
+			logger := NewLogger(LevelInfo)
+			logger.SetColorMode(tt.mode)
+			logger.SetOutput(&buf) // the mode carries over to new outputs
+			if got := logger.ColorEnabled(); got != tt.want {
+				t.Errorf("ColorEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseColorMode(t *testing.T) {
+	for input, want := range map[string]ColorMode{"": ColorAuto, "auto": ColorAuto, "Always": ColorAlways, " never ": ColorNever} {
+		if got, err := ParseColorMode(input); err != nil || got != want {
+			t.Errorf("ParseColorMode(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseColorMode("sometimes"); err == nil {
+		t.Error("ParseColorMode(sometimes) should fail")
+	}
+}
+
+func TestColorize(t *testing.T) {
+	if got := Colorize(ColorGreen, "8ms"); got != "\033[32m8ms\033[0m" {
+		t.Errorf("Colorize() = %q", got)
+	}
+}
+
+func TestLogger_TimestampFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout string
+		utc    bool
+		prefix *regexp.Regexp
+	}{
+		{"default", "", false, regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2} \[`)},
+		{"rfc3339 utc", time.RFC3339, true, regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z \[`)},
+		{"custom", "15:04:05.000", false, regexp.MustCompile(`^\d{2}:\d{2}:\d{2}\.\d{3} \[`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := NewLogger(LevelInfo)
+			logger.SetOutput(&buf)
+			logger.SetTimestampFormat(tt.layout)
+			logger.SetUTC(tt.utc)
+
+			logger.Info("hello")
+			logger.Stats("stats")
+
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				if !tt.prefix.MatchString(line) {
+					t.Errorf("line %q does not match %s", line, tt.prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTimestampFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", DefaultTimestampFormat},
+		{"default", DefaultTimestampFormat},
+		{"RFC3339", time.RFC3339},
+		{"rfc3339nano", time.RFC3339Nano},
+		{"2006-01-02T15:04:05", "2006-01-02T15:04:05"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTimestampFormat(tt.input)
+		if err != nil {
+			t.Errorf("ParseTimestampFormat(%q) error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ParseTimestampFormat(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+
+	if _, err := ParseTimestampFormat("iso"); err == nil {
+		t.Error("expected error for layout with no time elements")
+	}
+}
+
 func TestLogger_FormatArgs(t *testing.T) {
 	var buf bytes.Buffer
 	logger := NewLogger(LevelInfo)
@@ -290,3 +432,174 @@ func TestLogger_ConcurrentAccess(t *testing.T) {
 		t.Error("expected some output")
 	}
 }
+
+func TestLogger_With(t *testing.T) {
+	var buf bytes.Buffer
+	parent := NewLogger(LevelInfo)
+	parent.SetOutput(&buf)
+	parent.SetColorEnabled(false)
+
+	child := parent.With("session", 3, "peer", "203.0.113.50:31415")
+	child.Info("hello %s", "world")
+	if got := buf.String(); !strings.Contains(got, "[INFO]  session=3 peer=203.0.113.50:31415 hello world\n") {
+		t.Errorf("child output = %q", got)
+	}
+
+	// The parent is unchanged
+	buf.Reset()
+	parent.Info("plain")
+	if got := buf.String(); strings.Contains(got, "session=") || !strings.Contains(got, "[INFO]  plain\n") {
+		t.Errorf("parent output = %q", got)
+	}
+
+	// Nested fields accumulate, and awkward values are quoted
+	buf.Reset()
+	child.With("reason", "peer went away", "empty", "", "dangling").Stats("done")
+	want := `session=3 peer=203.0.113.50:31415 reason="peer went away" empty="" !BADKEY=dangling done`
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Errorf("nested output = %q, want it to contain %q", got, want)
+	}
+
+	// Level and output are shared in both directions
+	parent.SetLevel(LevelDebug)
+	if child.GetLevel() != LevelDebug {
+		t.Errorf("child level = %s after parent.SetLevel(DEBUG)", child.GetLevel())
+	}
+	child.SetLevel(LevelError)
+	if parent.GetLevel() != LevelError {
+		t.Errorf("parent level = %s after child.SetLevel(ERROR)", parent.GetLevel())
+	}
+	var other bytes.Buffer
+	parent.SetOutput(&other)
+	child.Error("moved")
+	if !strings.Contains(other.String(), "session=3") {
+		t.Errorf("child did not follow parent.SetOutput: %q", other.String())
+	}
+}
+
+func TestLogger_Module(t *testing.T) {
+	var buf bytes.Buffer
+	root := NewLogger(LevelInfo)
+	root.SetOutput(&buf)
+	root.SetModuleLevel(ModuleCapture, LevelWarn)
+	root.SetModuleLevel(ModuleBridge, LevelTrace)
+
+	capture := root.Module(ModuleCapture)
+	bridge := root.Module(ModuleBridge)
+	transport := root.Module(ModuleTransport)
+
+	capture.Info("capture info")
+	capture.Warn("capture warn")
+	bridge.Trace("bridge trace")
+	transport.Info("transport info")
+	transport.Debug("transport debug")
+	root.Debug("root debug")
+
+	out := buf.String()
+	for _, want := range []string{"capture warn", "bridge trace", "transport info"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"capture info", "transport debug", "root debug"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("unexpected %q in:\n%s", unwanted, out)
+		}
+	}
+
+	// Modules without a level follow the root; the others keep theirs
+	root.SetLevel(LevelDebug)
+	if transport.GetLevel() != LevelDebug {
+		t.Errorf("transport level = %s, want DEBUG after root.SetLevel", transport.GetLevel())
+	}
+	if capture.GetLevel() != LevelWarn {
+		t.Errorf("capture level = %s, want WARN", capture.GetLevel())
+	}
+	if got := root.Module(ModuleCapture).GetLevel(); got != LevelWarn {
+		t.Errorf("second Module(capture) level = %s, want WARN", got)
+	}
+
+	// Module loggers write to the shared output and keep With fields
+	var other bytes.Buffer
+	root.SetOutput(&other)
+	root.With("session", 1).Module(ModuleCapture).Error("moved")
+	if !strings.Contains(other.String(), "session=1 moved") {
+		t.Errorf("module logger output = %q", other.String())
+	}
+}
+
+func TestParseModuleLevels(t *testing.T) {
+	levels, err := ParseModuleLevels(" capture=warn, Bridge=TRACE ,")
+	if err != nil {
+		t.Fatalf("ParseModuleLevels() error = %v", err)
+	}
+	if len(levels) != 2 || levels[ModuleCapture] != LevelWarn || levels[ModuleBridge] != LevelTrace {
+		t.Errorf("levels = %v", levels)
+	}
+	if levels, err := ParseModuleLevels(""); err != nil || len(levels) != 0 {
+		t.Errorf("ParseModuleLevels(\"\") = %v, %v", levels, err)
+	}
+	for _, s := range []string{"capture", "nope=info", "capture=loud"} {
+		if _, err := ParseModuleLevels(s); err == nil {
+			t.Errorf("ParseModuleLevels(%q) succeeded", s)
+		}
+	}
+}
+
+// TestLogger_SetLevelWhileLogging changes the level while other goroutines
+// log and read it; run with -race.
+func TestLogger_SetLevelWhileLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(LevelInfo)
+	logger.SetOutput(&buf)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if logger.GetLevel() >= LevelTrace {
+					logger.Trace("frame %d", j)
+				}
+				logger.Debug("message %d", j)
+			}
+		}()
+	}
+	for _, level := range []Level{LevelTrace, LevelError, LevelDebug, LevelWarn} {
+		logger.SetLevel(level)
+	}
+	wg.Wait()
+
+	logger.SetLevel(LevelError)
+	buf.Reset()
+	logger.Info("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("logged %q below the level set last", buf.String())
+	}
+}
+
+// BenchmarkLogger_BelowLevel measures trace calls at info level from many
+// goroutines, as the packet loops make them. The level check takes no lock,
+// so this should scale with GOMAXPROCS instead of contending.
+func BenchmarkLogger_BelowLevel(b *testing.B) {
+	logger := NewLogger(LevelInfo)
+	logger.SetOutput(io.Discard)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			logger.Trace("Received frame: %s -> %s (%d bytes)", "00:50:f2:00:00:01", "ff:ff:ff:ff:ff:ff", 60)
+		}
+	})
+}
+
+// BenchmarkLogger_Written measures logged calls from many goroutines; only
+// the write itself is serialized.
+func BenchmarkLogger_Written(b *testing.B) {
+	logger := NewLogger(LevelTrace)
+	logger.SetOutput(io.Discard)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			logger.Trace("Received frame: %s -> %s (%d bytes)", "00:50:f2:00:00:01", "ff:ff:ff:ff:ff:ff", 60)
+		}
+	})
+}
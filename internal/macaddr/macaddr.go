@@ -0,0 +1,33 @@
+// Package macaddr parses Ethernet MAC addresses. It has no dependencies so
+// that packages such as config can validate MACs without pulling in pcap.
+package macaddr
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrInvalid is returned when a string is not a valid 6-byte MAC address.
+var ErrInvalid = errors.New("invalid MAC address format")
+
+// Parse parses a MAC address in XX:XX:XX:XX:XX:XX or XX-XX-XX-XX-XX-XX format.
+func Parse(s string) (net.HardwareAddr, error) {
+	// Normalize to colon separator
+	s = strings.ReplaceAll(s, "-", ":")
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("%w: expected 6 bytes, got %d", ErrInvalid, len(mac))
+	}
+	return mac, nil
+}
+
+// IsUnicast reports whether mac is a 6-byte unicast address: not broadcast
+// or multicast (group bit clear), so it can be a frame's source.
+func IsUnicast(mac net.HardwareAddr) bool {
+	return len(mac) == 6 && mac[0]&0x01 == 0
+}
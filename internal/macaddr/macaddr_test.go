@@ -0,0 +1,59 @@
+package macaddr
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "00:50:F2:1A:2B:3C", want: "00:50:f2:1a:2b:3c"},
+		{in: "00-50-f2-1a-2b-3c", want: "00:50:f2:1a:2b:3c"},
+		{in: "00:50:f2:1a:2b", wantErr: true},
+		{in: "00:00:5e:00:53:01:02:03", wantErr: true},
+		{in: "not-a-mac", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		mac, err := Parse(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("Parse(%q) error = %v, want ErrInvalid", tt.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.in, err)
+			continue
+		}
+		if mac.String() != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, mac, tt.want)
+		}
+	}
+}
+
+func TestIsUnicast(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"00:50:f2:1a:2b:3c", true},
+		{"02:00:00:00:00:01", true}, // locally administered
+		{"ff:ff:ff:ff:ff:ff", false},
+		{"01:00:5e:00:00:fb", false}, // IPv4 multicast
+		{"33:33:00:00:00:01", false}, // IPv6 multicast
+	}
+	for _, tt := range tests {
+		mac, _ := Parse(tt.in)
+		if got := IsUnicast(mac); got != tt.want {
+			t.Errorf("IsUnicast(%s) = %t, want %t", tt.in, got, tt.want)
+		}
+	}
+	if IsUnicast(nil) {
+		t.Error("IsUnicast(nil) = true")
+	}
+}
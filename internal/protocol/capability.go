@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/cpu"
+)
+
+// HardwareAES reports whether this CPU has the AES instructions Go's
+// crypto/aes uses, and what they are called on this architecture. Software
+// AES is roughly an order of magnitude slower, which bounds the throughput
+// of an AES-based cipher such as AES-GCM.
+func HardwareAES() (name string, available bool) {
+	switch runtime.GOARCH {
+	case "amd64", "386":
+		// AES-GCM also needs carry-less multiply for GHASH
+		return "AES-NI", cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ
+	case "arm64":
+		return "ARMv8 AES", cpu.ARM64.HasAES && cpu.ARM64.HasPMULL
+	case "s390x":
+		return "CPACF AES", cpu.S390X.HasAES && cpu.S390X.HasGHASH
+	case "ppc64le":
+		// POWER8 and later, which Go requires on ppc64le, always have it
+		return "POWER8 AES", true
+	default:
+		return "hardware AES", false
+	}
+}
+
+// AESCapability returns a one-line summary of HardwareAES for display, such
+// as "AES-NI: available".
+func AESCapability() string {
+	name, ok := HardwareAES()
+	if ok {
+		return name + ": available"
+	}
+	return name + ": not available (software AES is much slower)"
+}
@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+)
+
+// Key derivation. From KDFVersion on, messages are not signed with the
+// passphrase given to NewCodec but with a key stretched from it by
+// PBKDF2-HMAC-SHA256 under a fixed application salt, so a short or guessable
+// passphrase costs an attacker KDFIterations HMACs per guess and every
+// passphrase becomes a uniform DerivedKeySize-byte key. The salt is fixed so
+// both peers derive the same key without exchanging anything.
+const (
+	KDFIterations  = 200000
+	DerivedKeySize = 32
+	kdfSalt        = "xbslink-ng/hmac-key/v1"
+)
+
+// DeriveKey returns the HMAC key protocol v3+ uses for passphrase. It takes
+// a few tens of milliseconds by design. It fails only if PBKDF2 refuses the
+// parameters, which happens in FIPS 140-only mode for passphrases shorter
+// than 14 bytes.
+func DeriveKey(passphrase []byte) ([]byte, error) {
+	key, err := pbkdf2.Key(sha256.New, string(passphrase), []byte(kdfSalt), KDFIterations, DerivedKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	return key, nil
+}
+
+// macKey hands out HMAC-SHA256 instances keyed with one key.
+type macKey struct {
+	pool sync.Pool
+}
+
+func newMACKey(key []byte) *macKey {
+	k := &macKey{}
+	k.pool.New = func() interface{} {
+		return hmac.New(sha256.New, key)
+	}
+	return k
+}
+
+// append appends HMAC-SHA256 of data to dst, reusing a pooled hash.
+func (k *macKey) append(dst, data []byte) []byte {
+	h := k.pool.Get().(hash.Hash)
+	h.Reset()
+	h.Write(data)
+	dst = h.Sum(dst)
+	k.pool.Put(h)
+	return dst
+}
+
+// Limits on a key given in hex or base64 (see DecodeKey): at least 128 bits,
+// and no longer than an HMAC-SHA256 block.
+const (
+	MinKeySize = 16
+	MaxKeySize = 64
+)
+
+// KeyFormat says how a key is written (--key-format).
+type KeyFormat string
+
+const (
+	// KeyFormatRaw is a passphrase, used as typed and stretched by DeriveKey.
+	KeyFormatRaw KeyFormat = "raw"
+	// KeyFormatHex is a random key in hex, used as is.
+	KeyFormatHex KeyFormat = "hex"
+	// KeyFormatBase64 is a random key in base64 (standard or URL-safe,
+	// padded or not), used as is.
+	KeyFormatBase64 KeyFormat = "base64"
+)
+
+// ParseKeyFormat parses a --key-format value.
+// Valid values: raw, hex, base64 (case-insensitive).
+func ParseKeyFormat(s string) (KeyFormat, error) {
+	switch f := KeyFormat(strings.ToLower(strings.TrimSpace(s))); f {
+	case KeyFormatRaw, KeyFormatHex, KeyFormatBase64:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid key format %q (valid: raw, hex, base64)", s)
+	}
+}
+
+// DecodeKey decodes key written in format f. A raw key is returned as is,
+// for NewCodec; a hex or base64 key is decoded, for NewCodecFromKey, and
+// must be MinKeySize to MaxKeySize bytes.
+func DecodeKey(key string, f KeyFormat) ([]byte, error) {
+	var decoded []byte
+	var err error
+	switch f {
+	case KeyFormatRaw:
+		return []byte(key), nil
+	case KeyFormatHex:
+		decoded, err = hex.DecodeString(strings.TrimSpace(key))
+	case KeyFormatBase64:
+		decoded, err = decodeBase64(strings.TrimSpace(key))
+	default:
+		return nil, fmt.Errorf("invalid key format %q", f)
+	}
+	if err != nil {
+		// Not wrapped: hex errors quote the offending character of the key
+		return nil, fmt.Errorf("key is not valid %s", f)
+	}
+	if len(decoded) < MinKeySize || len(decoded) > MaxKeySize {
+		return nil, fmt.Errorf("%s key decodes to %d bytes, want %d-%d (e.g. 32 random bytes)", f, len(decoded), MinKeySize, MaxKeySize)
+	}
+	return decoded, nil
+}
+
+// decodeBase64 accepts any of the standard and URL-safe alphabets, with or
+// without padding.
+func decodeBase64(s string) ([]byte, error) {
+	var err error
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		var decoded []byte
+		if decoded, err = enc.DecodeString(s); err == nil {
+			return decoded, nil
+		}
+	}
+	return nil, err
+}
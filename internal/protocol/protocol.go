@@ -4,7 +4,6 @@ package protocol
 import (
 	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -14,15 +13,41 @@ import (
 // Protocol constants.
 const (
 	// ProtocolVersion is the current protocol version.
-	ProtocolVersion uint16 = 1
+	ProtocolVersion uint16 = 4
+
+	// MinProtocolVersion is the oldest version still accepted. A listener
+	// answers a HELLO in the version the peer sent, so v1 connectors keep
+	// working. v1 uses the plain challenge response (see challengeResponse)
+	// and has no HELLO_CONFIRM, so only the listener proves the key.
+	MinProtocolVersion uint16 = 1
+
+	// KDFVersion is the first version keyed with DeriveKey(passphrase)
+	// rather than the raw passphrase (see UseVersion).
+	KDFVersion uint16 = 3
+
+	// FlagsVersion is the first version whose HELLO and HELLO_ACK carry a
+	// flags byte offering optional features, such as FlagFrameCRC.
+	FlagsVersion uint16 = 4
+
+	// Flags offered in a v4+ HELLO or HELLO_ACK. A feature is used for the
+	// session when both peers offer it (see UsePeerFlags).
+	FlagFrameCRC byte = 0x01 // Send frames as MsgFrameCRC (--frame-crc)
 
 	// Message types.
-	MsgFrame    byte = 0x00 // Raw Ethernet frame
-	MsgHello    byte = 0x01 // Initiate connection
-	MsgHelloAck byte = 0x02 // Accept connection
-	MsgPing     byte = 0x03 // Latency probe
-	MsgPong     byte = 0x04 // Latency response
-	MsgBye      byte = 0x05 // Graceful disconnect
+	MsgFrame        byte = 0x00 // Raw Ethernet frame
+	MsgHello        byte = 0x01 // Initiate connection
+	MsgHelloAck     byte = 0x02 // Accept connection
+	MsgPing         byte = 0x03 // Latency probe
+	MsgPong         byte = 0x04 // Latency response
+	MsgBye          byte = 0x05 // Graceful disconnect
+	MsgError        byte = 0x06 // Error report (always unauthenticated, see EncodeError)
+	MsgHelloConfirm byte = 0x07 // Answer the listener's challenge (v2+)
+	MsgFrameCRC     byte = 0x08 // Raw Ethernet frame + CRC-32 (v4+, FlagFrameCRC)
+
+	// Error codes carried in MsgError.
+	ErrorCodeModeMismatch       uint16 = 1 // Peers disagree on secure/insecure mode
+	ErrorCodeVersionUnsupported uint16 = 2 // Peer speaks a protocol version we don't
+	ErrorCodeRateLimited        uint16 = 3 // Peer is sending too many bad handshakes
 
 	// Size constants.
 	NonceSize        = 8  // 8-byte nonce for replay protection
@@ -31,16 +56,45 @@ const (
 	ChallengeRespLen = 32 // HMAC response to challenge
 
 	// Header sizes.
-	MinHeaderSize       = 1                    // Type only (insecure mode)
-	SecureHeaderSize    = 1 + NonceSize        // Type + Nonce
-	MinPayloadSize      = 0                    // BYE has no payload
-	MaxFrameSize        = 1514                 // Max Ethernet frame size
-	MinEthernetFrame    = 14                   // Min Ethernet frame (header only)
-	HelloPayloadSize    = 2 + ChallengeSize    // version (2) + challenge (16)
-	HelloAckPayloadSize = 2 + ChallengeRespLen // version (2) + response (32)
-	PingPongPayloadSize = 8                    // timestamp (8 bytes)
+	MinHeaderSize           = 1                                   // Type only (insecure mode)
+	SecureHeaderSize        = 1 + NonceSize                       // Type + Nonce
+	MinSecureSize           = 1 + NonceSize + HMACSize            // Type + Nonce + HMAC (secure mode)
+	MinPayloadSize          = 0                                   // BYE has no payload
+	MaxFrameSize            = 1514                                // Max Ethernet frame size (default codec limit)
+	MaxJumboFrameSize       = 9018                                // 9000-byte MTU + Ethernet header + VLAN tag
+	FrameCRCSize            = 4                                   // CRC-32 trailing a MsgFrameCRC frame
+	MinEthernetFrame        = 14                                  // Min Ethernet frame (header only)
+	HelloPayloadSize        = 2 + ChallengeSize                   // version (2) + challenge (16)
+	HelloAckPayloadSize     = 2 + ChallengeRespLen                // version (2) + response (32)
+	HelloAckV2PayloadSize   = HelloAckPayloadSize + ChallengeSize // v2+ appends the listener's challenge (16)
+	HelloV4PayloadSize      = HelloPayloadSize + 1                // v4+ appends flags (1)
+	HelloAckV4PayloadSize   = HelloAckV2PayloadSize + 1           // v4+ appends flags (1)
+	HelloConfirmPayloadSize = ChallengeRespLen                    // response (32)
+	PingPongPayloadSize     = 8                                   // timestamp (8 bytes)
+	PongClockPayloadSize    = PingPongPayloadSize + 8             // timestamp + responder's clock (8 bytes)
+	ErrorHeaderSize         = 1 + len(errorMarker)                // Type + marker
+	ErrorPayloadSize        = 2                                   // code (2 bytes), followed by optional message
+	MaxErrorMsgLen          = 64                                  // Max length of the ERROR message text
+)
+
+// MaxMessageSize is the largest message any codec produces: a
+// MaxJumboFrameSize frame sent as MsgFrameCRC in secure mode.
+const MaxMessageSize = MinSecureSize + MaxJumboFrameSize + FrameCRCSize
+
+// Contexts prefixing the challenge in v2+ challenge responses, so they can't
+// be confused with HMACs computed over other data with the same key. The two
+// directions use different contexts so a HELLO_ACK response can't be
+// reflected back as a HELLO_CONFIRM.
+const (
+	authContext    = "xbslink-auth-v1"
+	confirmContext = "xbslink-confirm-v1"
 )
 
+// errorMarker follows the type byte of every MsgError. Error messages are sent
+// with insecure framing so they can cross the secure/insecure boundary; the
+// marker lets either side recognize them without a shared key.
+const errorMarker = "XBER"
+
 // Errors returned by protocol functions.
 var (
 	ErrMessageTooShort   = errors.New("message too short")
@@ -50,24 +104,133 @@ var (
 	ErrInvalidPayload    = errors.New("invalid payload size")
 	ErrVersionMismatch   = errors.New("protocol version mismatch")
 	ErrChallengeRequired = errors.New("challenge required but not present")
+	ErrFrameCorrupt      = errors.New("frame CRC mismatch")
 )
 
 // Codec handles encoding and decoding of protocol messages with optional HMAC authentication.
 type Codec struct {
-	key        []byte // Pre-shared key for HMAC (nil = insecure mode)
-	sendNonce  uint64 // Monotonic counter for outgoing messages
-	recvNonce  uint64 // Last received nonce (for replay protection)
-	secureMode bool   // True if key is set
+	key        *macKey     // HMAC key for v3+: DeriveKey(passphrase) (nil = insecure mode)
+	legacyKey  *macKey     // HMAC key for v1 and v2: the raw passphrase
+	legacy     atomic.Bool // The session uses legacyKey (UseVersion)
+	sendNonce  uint64      // Monotonic counter for outgoing messages
+	recvNonce  uint64      // Last received nonce (for replay protection)
+	secureMode bool        // True if key is set
+	maxFrame   int         // Largest frame encoded or accepted (SetMaxFrameSize)
+	flags      byte        // Flags offered in our HELLO / HELLO_ACK (SetFrameCRC)
+	frameCRC   atomic.Bool // The session sends MsgFrameCRC (UsePeerFlags)
+
+	// Decode failure counters (atomic), see Stats.
+	hmacFailures uint64
+	replays      uint64
+	decodeErrors uint64
+	corrupt      uint64
+}
+
+// CodecStats counts messages a Codec rejected while decoding.
+type CodecStats struct {
+	HMACFailures uint64 // ErrInvalidHMAC: wrong key, forgery, or (secure mode) any garbled message
+	Replays      uint64 // ErrReplayDetected: nonce not increasing
+	DecodeErrors uint64 // Any other decode failure (short, unknown type, bad payload)
+	RxCorrupt    uint64 // ErrFrameCorrupt: a MsgFrameCRC frame damaged in transit
 }
 
 // NewCodec creates a new protocol codec.
 // If key is nil or empty, the codec operates in insecure mode (no HMAC, no nonces).
-func NewCodec(key []byte) *Codec {
-	return &Codec{
-		key:        key,
+// Otherwise key is the passphrase: sessions at KDFVersion and later are
+// keyed with DeriveKey(key), older ones with key itself. It fails only if
+// DeriveKey does.
+func NewCodec(key []byte) (*Codec, error) {
+	c := &Codec{
 		sendNonce:  0,
 		recvNonce:  0,
 		secureMode: len(key) > 0,
+		maxFrame:   MaxFrameSize,
+	}
+	if c.secureMode {
+		derived, err := DeriveKey(key)
+		if err != nil {
+			return nil, err
+		}
+		c.key = newMACKey(derived)
+		c.legacyKey = newMACKey(key)
+	}
+	return c, nil
+}
+
+// NewCodecFromKey creates a secure codec keyed with key itself at every
+// protocol version, for a random key that needs no stretching (--key-format
+// hex or base64). Both peers must be given the key the same way.
+func NewCodecFromKey(key []byte) *Codec {
+	c := &Codec{secureMode: true, maxFrame: MaxFrameSize}
+	c.key = newMACKey(key)
+	c.legacyKey = c.key
+	return c
+}
+
+// UseVersion keys the session for protocol version v: below KDFVersion
+// messages are signed with the raw passphrase, from it with the derived key.
+// A codec starts at ProtocolVersion; EncodeHelloAck switches to the version
+// it answers in. A HELLO is accepted under either key, as long as its
+// version matches the key it was signed with.
+func (c *Codec) UseVersion(v uint16) {
+	c.legacy.Store(v < KDFVersion)
+}
+
+// macKeyFor returns the HMAC key for the legacy (pre-KDFVersion) or the
+// derived-key protocol.
+func (c *Codec) macKeyFor(legacy bool) *macKey {
+	if legacy {
+		return c.legacyKey
+	}
+	return c.key
+}
+
+// SetMaxFrameSize sets the largest Ethernet frame the codec encodes or
+// accepts, MaxFrameSize by default. Jumbo-frame setups can raise it up to
+// MaxJumboFrameSize; both peers must use the same value, since larger frames
+// are rejected with ErrInvalidPayload. Call it before the codec is in use.
+func (c *Codec) SetMaxFrameSize(n int) error {
+	if n < MaxFrameSize || n > MaxJumboFrameSize {
+		return fmt.Errorf("max frame size %d out of range [%d, %d]", n, MaxFrameSize, MaxJumboFrameSize)
+	}
+	c.maxFrame = n
+	return nil
+}
+
+// MaxFrameSize returns the largest frame the codec encodes or accepts.
+func (c *Codec) MaxFrameSize() int {
+	return c.maxFrame
+}
+
+// checkFrameSize returns an error if a frame of n bytes can't be encoded.
+func (c *Codec) checkFrameSize(n int) error {
+	if n < MinEthernetFrame || n > c.maxFrame {
+		return fmt.Errorf("frame size %d out of range [%d, %d]", n, MinEthernetFrame, c.maxFrame)
+	}
+	return nil
+}
+
+// Stats returns the decode failure counters. It is safe for concurrent use.
+func (c *Codec) Stats() CodecStats {
+	return CodecStats{
+		HMACFailures: atomic.LoadUint64(&c.hmacFailures),
+		Replays:      atomic.LoadUint64(&c.replays),
+		DecodeErrors: atomic.LoadUint64(&c.decodeErrors),
+		RxCorrupt:    atomic.LoadUint64(&c.corrupt),
+	}
+}
+
+// countDecodeError records a decode failure in the matching counter.
+func (c *Codec) countDecodeError(err error) {
+	switch {
+	case errors.Is(err, ErrInvalidHMAC):
+		atomic.AddUint64(&c.hmacFailures, 1)
+	case errors.Is(err, ErrReplayDetected):
+		atomic.AddUint64(&c.replays, 1)
+	case errors.Is(err, ErrFrameCorrupt):
+		atomic.AddUint64(&c.corrupt, 1)
+	default:
+		atomic.AddUint64(&c.decodeErrors, 1)
 	}
 }
 
@@ -81,101 +244,217 @@ func (c *Codec) nextNonce() uint64 {
 	return atomic.AddUint64(&c.sendNonce, 1)
 }
 
-// computeHMAC computes HMAC-SHA256 over the given data.
+// computeHMAC computes HMAC-SHA256 over the given data with the session key.
 func (c *Codec) computeHMAC(data []byte) []byte {
-	h := hmac.New(sha256.New, c.key)
-	h.Write(data)
-	return h.Sum(nil)
+	return c.appendHMAC(nil, data)
+}
+
+// appendHMAC appends HMAC-SHA256 of data with the session key to dst.
+func (c *Codec) appendHMAC(dst, data []byte) []byte {
+	return c.macKeyFor(c.legacy.Load()).append(dst, data)
 }
 
-// verifyHMAC verifies the HMAC signature.
+// verifyHMAC verifies the HMAC signature of Type+Nonce+Payload against the
+// one key data may be signed with: for a HELLO, the key of the version it
+// claims, since a HELLO may open a session in any version; for anything
+// else, the session's. The type and version are read before they are
+// authenticated, but only to pick the key, and the HMAC covers them, so a
+// HELLO can't claim a version other than the one it was signed for. Every
+// message costs exactly one HMAC.
 func (c *Codec) verifyHMAC(data, sig []byte) bool {
-	expected := c.computeHMAC(data)
-	return hmac.Equal(expected, sig)
+	legacy := c.legacy.Load()
+	if len(data) >= 1+8+2 && data[0] == MsgHello {
+		legacy = binary.BigEndian.Uint16(data[9:11]) < KDFVersion
+	}
+	return hmac.Equal(c.macKeyFor(legacy).append(nil, data), sig)
 }
 
 // encode creates a wire-format message with optional HMAC.
 // Format (secure):  [Type(1)][Nonce(8)][Payload(var)][HMAC(32)]
 // Format (insecure): [Type(1)][Payload(var)]
 func (c *Codec) encode(msgType byte, payload []byte) []byte {
+	return c.encodeInto(make([]byte, 0, c.EncodedSize(len(payload))), msgType, payload)
+}
+
+// encodeInto is encode writing into dst[:0], growing it only if it is too small.
+func (c *Codec) encodeInto(dst []byte, msgType byte, payload []byte) []byte {
+	var nonce uint64
+	if c.secureMode {
+		nonce = c.nextNonce()
+	}
+	return c.encodeIntoNonce(dst, msgType, payload, nonce)
+}
+
+// encodeIntoNonce is encodeInto with the nonce already chosen.
+func (c *Codec) encodeIntoNonce(dst []byte, msgType byte, payload []byte, nonce uint64) []byte {
+	msg := append(dst[:0], msgType)
+
 	if c.secureMode {
 		// Secure mode: Type + Nonce + Payload + HMAC
-		nonce := c.nextNonce()
-		msg := make([]byte, 1+NonceSize+len(payload)+HMACSize)
-		msg[0] = msgType
-		binary.BigEndian.PutUint64(msg[1:9], nonce)
-		copy(msg[9:9+len(payload)], payload)
+		msg = binary.BigEndian.AppendUint64(msg, nonce)
+		msg = append(msg, payload...)
 
 		// Compute HMAC over Type+Nonce+Payload
-		mac := c.computeHMAC(msg[:9+len(payload)])
-		copy(msg[9+len(payload):], mac)
-		return msg
+		return c.appendHMAC(msg, msg)
 	}
 
 	// Insecure mode: Type + Payload
-	msg := make([]byte, 1+len(payload))
-	msg[0] = msgType
-	copy(msg[1:], payload)
-	return msg
+	return append(msg, payload...)
 }
 
-// decode parses a wire-format message and verifies HMAC if in secure mode.
-// Returns message type, payload, and any error.
-func (c *Codec) decode(data []byte) (msgType byte, payload []byte, err error) {
-	if len(data) < MinHeaderSize {
-		return 0, nil, ErrMessageTooShort
+// EncodedSize returns the wire size of a message carrying payloadLen bytes,
+// counting a frame's CRC-32 if the codec offers FlagFrameCRC.
+func (c *Codec) EncodedSize(payloadLen int) int {
+	if c.flags&FlagFrameCRC != 0 {
+		payloadLen += FrameCRCSize
 	}
-
 	if c.secureMode {
-		// Secure mode: need at least Type + Nonce + HMAC
-		minLen := 1 + NonceSize + HMACSize
-		if len(data) < minLen {
-			return 0, nil, ErrMessageTooShort
-		}
+		return MinSecureSize + payloadLen
+	}
+	return MinHeaderSize + payloadLen
+}
 
-		// Extract components
-		msgType = data[0]
-		nonce := binary.BigEndian.Uint64(data[1:9])
-		payloadEnd := len(data) - HMACSize
-		payload = data[9:payloadEnd]
-		sig := data[payloadEnd:]
+// decode parses a wire-format message and verifies HMAC and nonce if in
+// secure mode, accepting unsigned error reports if handshake is set (see
+// authenticate). Returns message type, payload, and any error.
+func (c *Codec) decode(data []byte, handshake bool) (msgType byte, payload []byte, err error) {
+	msgType, nonce, payload, err := c.authenticate(data, handshake)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := c.checkNonce(msgType, nonce); err != nil {
+		return 0, nil, err
+	}
+	return msgType, payload, nil
+}
+
+// authenticate is decode without the replay check, also returning the
+// message nonce (0 in insecure mode and for error reports).
+//
+// Error reports are never signed, so a peer can send one even when it
+// doesn't share our key. In secure mode they are only accepted during the
+// handshake, where they explain a rejected HELLO; once a session is up only
+// authenticated messages are acted on, and an unsigned error report fails
+// the HMAC check like any other forgery.
+//
+// In secure mode every malformed message is reported as ErrInvalidHMAC, and an
+// HMAC is computed even when the message is too short to carry one. Nothing is
+// inspected before the constant-time HMAC check, so a truncated or garbled
+// message is indistinguishable (by error or timing) from a forged one.
+func (c *Codec) authenticate(data []byte, handshake bool) (msgType byte, nonce uint64, payload []byte, err error) {
+	if isErrorMessage(data) && (handshake || !c.secureMode) {
+		return MsgError, 0, data[ErrorHeaderSize:], nil
+	}
 
-		// Verify HMAC
-		if !c.verifyHMAC(data[:payloadEnd], sig) {
-			return 0, nil, ErrInvalidHMAC
+	if c.secureMode {
+		if len(data) < MinSecureSize {
+			// Burn an HMAC computation over the whole message so short
+			// messages cost the same as ones that fail verification.
+			var zero [HMACSize]byte
+			c.verifyHMAC(data, zero[:])
+			return 0, 0, nil, ErrInvalidHMAC
 		}
 
-		// Verify nonce is increasing (replay protection) for non-handshake traffic.
-		// HELLO/HELLO_ACK are exempt so peers can reconnect even if their sender
-		// nonce counter restarts from 1 (e.g. process restart).
-		if msgType != MsgHello && msgType != MsgHelloAck {
-			if nonce > 0 && nonce <= atomic.LoadUint64(&c.recvNonce) {
-				return 0, nil, ErrReplayDetected
-			}
-			atomic.StoreUint64(&c.recvNonce, nonce)
+		// Split into Type+Nonce+Payload and trailing HMAC
+		payloadEnd := len(data) - HMACSize
+		if !c.verifyHMAC(data[:payloadEnd], data[payloadEnd:]) {
+			return 0, 0, nil, ErrInvalidHMAC
 		}
 
-		return msgType, payload, nil
+		// Only authenticated content is examined from here on
+		msgType = data[0]
+		nonce = binary.BigEndian.Uint64(data[1:9])
+		payload = data[9:payloadEnd]
+		return msgType, nonce, payload, nil
 	}
 
 	// Insecure mode: Type + Payload
+	if len(data) < MinHeaderSize {
+		return 0, 0, nil, ErrMessageTooShort
+	}
 	msgType = data[0]
 	payload = data[1:]
-	return msgType, payload, nil
+	return msgType, 0, payload, nil
+}
+
+// checkNonce verifies nonce is increasing (replay protection) for
+// authenticated non-handshake traffic, and records it as the latest seen.
+// HELLO/HELLO_ACK are exempt so peers can reconnect even if their sender
+// nonce counter restarts from 1 (e.g. process restart).
+func (c *Codec) checkNonce(msgType byte, nonce uint64) error {
+	if !c.secureMode || msgType == MsgError || msgType == MsgHello || msgType == MsgHelloAck {
+		return nil
+	}
+	if nonce > 0 && nonce <= atomic.LoadUint64(&c.recvNonce) {
+		return ErrReplayDetected
+	}
+	atomic.StoreUint64(&c.recvNonce, nonce)
+	return nil
 }
 
 // EncodeFrame encodes a raw Ethernet frame.
 func (c *Codec) EncodeFrame(frame []byte) ([]byte, error) {
-	if len(frame) < MinEthernetFrame || len(frame) > MaxFrameSize {
-		return nil, fmt.Errorf("frame size %d out of range [%d, %d]", len(frame), MinEthernetFrame, MaxFrameSize)
+	if err := c.checkFrameSize(len(frame)); err != nil {
+		return nil, err
+	}
+	return c.encodeFrameInto(make([]byte, 0, c.EncodedSize(len(frame))), frame), nil
+}
+
+// EncodeFrameInto encodes a raw Ethernet frame into dst, reusing its storage.
+// The returned slice aliases dst when cap(dst) >= EncodedSize(len(frame)),
+// so callers can keep one buffer per sending goroutine and avoid allocating
+// per frame. frame and dst must not overlap.
+func (c *Codec) EncodeFrameInto(dst, frame []byte) ([]byte, error) {
+	if err := c.checkFrameSize(len(frame)); err != nil {
+		return nil, err
 	}
-	return c.encode(MsgFrame, frame), nil
+	return c.encodeFrameInto(dst, frame), nil
+}
+
+// ReserveNonce takes the next outgoing nonce for EncodeFrameIntoNonce.
+func (c *Codec) ReserveNonce() uint64 {
+	return c.nextNonce()
+}
+
+// EncodeFrameIntoNonce is EncodeFrameInto using a nonce from ReserveNonce, so
+// several goroutines can encode frames at once. The peer drops messages whose
+// nonce isn't higher than the last one it accepted, so messages must be sent
+// in the order their nonces were reserved. nonce is ignored in insecure mode.
+func (c *Codec) EncodeFrameIntoNonce(dst, frame []byte, nonce uint64) ([]byte, error) {
+	if err := c.checkFrameSize(len(frame)); err != nil {
+		return nil, err
+	}
+	return c.encodeFrameIntoNonce(dst, frame, nonce), nil
+}
+
+// SupportedVersion reports whether v is a protocol version this codec can speak.
+func SupportedVersion(v uint16) bool {
+	return v >= MinProtocolVersion && v <= ProtocolVersion
+}
+
+// challengeResponse computes the response to challenge for the given protocol
+// version: HMAC-SHA256(key, challenge) for v1, and
+// HMAC-SHA256(key, context || challenge || version) from v2 on, binding the
+// response to the protocol, the direction and the version being negotiated.
+// context is authContext for HELLO_ACK and confirmContext for HELLO_CONFIRM.
+// key is the one for version (see UseVersion), whatever the session's.
+func (c *Codec) challengeResponse(context string, challenge []byte, version uint16) []byte {
+	key := c.macKeyFor(version < KDFVersion)
+	if version < 2 {
+		return key.append(nil, challenge)
+	}
+	data := make([]byte, 0, len(context)+ChallengeSize+2)
+	data = append(data, context...)
+	data = append(data, challenge...)
+	data = binary.BigEndian.AppendUint16(data, version)
+	return key.append(nil, data)
 }
 
 // EncodeHello encodes a HELLO message with a challenge for authentication.
 func (c *Codec) EncodeHello() ([]byte, []byte, error) {
-	payload := make([]byte, HelloPayloadSize)
+	payload := make([]byte, HelloV4PayloadSize)
 	binary.BigEndian.PutUint16(payload[0:2], ProtocolVersion)
+	payload[HelloPayloadSize] = c.flags
 
 	// Generate random challenge
 	challenge := payload[2 : 2+ChallengeSize]
@@ -186,20 +465,53 @@ func (c *Codec) EncodeHello() ([]byte, []byte, error) {
 	return c.encode(MsgHello, payload), challenge, nil
 }
 
-// EncodeHelloAck encodes a HELLO_ACK message with challenge response.
-// The response is HMAC-SHA256(key, challenge) if in secure mode, or zeros if insecure.
-func (c *Codec) EncodeHelloAck(challenge []byte) []byte {
-	payload := make([]byte, HelloAckPayloadSize)
-	binary.BigEndian.PutUint16(payload[0:2], ProtocolVersion)
+// EncodeHelloAck encodes a HELLO_ACK message answering challenge in the given
+// protocol version, which should be the version from the peer's HELLO.
+// The response is computed by challengeResponse if in secure mode, or zeros if insecure.
+// From v2 on the HELLO_ACK also carries a fresh challenge of our own, which is
+// returned for checking the peer's HELLO_CONFIRM; for v1 it is nil.
+// The session switches to version (see UseVersion).
+func (c *Codec) EncodeHelloAck(challenge []byte, version uint16) ([]byte, []byte, error) {
+	c.UseVersion(version)
+
+	size := HelloAckPayloadSize
+	if version >= FlagsVersion {
+		size = HelloAckV4PayloadSize
+	} else if version >= 2 {
+		size = HelloAckV2PayloadSize
+	}
+	payload := make([]byte, size)
+	binary.BigEndian.PutUint16(payload[0:2], version)
+	if version >= FlagsVersion {
+		payload[HelloAckV2PayloadSize] = c.flags
+	}
 
 	// Compute challenge response
 	if c.secureMode && len(challenge) == ChallengeSize {
-		response := c.computeHMAC(challenge)
-		copy(payload[2:], response)
+		copy(payload[2:HelloAckPayloadSize], c.challengeResponse(authContext, challenge, version))
 	}
 	// If insecure, leave response as zeros
 
-	return c.encode(MsgHelloAck, payload)
+	var ours []byte
+	if version >= 2 {
+		ours = payload[HelloAckPayloadSize:HelloAckV2PayloadSize]
+		if _, err := rand.Read(ours); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate challenge: %w", err)
+		}
+	}
+
+	return c.encode(MsgHelloAck, payload), ours, nil
+}
+
+// EncodeHelloConfirm encodes a HELLO_CONFIRM answering the challenge carried
+// in a v2+ HELLO_ACK, proving to the listener that we hold the key before any
+// frame is sent. The response is zeros if insecure.
+func (c *Codec) EncodeHelloConfirm(challenge []byte, version uint16) []byte {
+	payload := make([]byte, HelloConfirmPayloadSize)
+	if c.secureMode && len(challenge) == ChallengeSize {
+		copy(payload, c.challengeResponse(confirmContext, challenge, version))
+	}
+	return c.encode(MsgHelloConfirm, payload)
 }
 
 // EncodePing encodes a PING message with a timestamp.
@@ -216,84 +528,305 @@ func (c *Codec) EncodePong(timestamp int64) []byte {
 	return c.encode(MsgPong, payload)
 }
 
+// EncodePongWithClock encodes a PONG that also carries the responder's clock
+// (Unix nanoseconds) when it answered, for estimating clock skew. Peers that
+// don't know the field read only the echoed timestamp.
+func (c *Codec) EncodePongWithClock(timestamp, clock int64) []byte {
+	payload := make([]byte, PongClockPayloadSize)
+	binary.BigEndian.PutUint64(payload, uint64(timestamp))
+	binary.BigEndian.PutUint64(payload[PingPongPayloadSize:], uint64(clock))
+	return c.encode(MsgPong, payload)
+}
+
+// EncodePingNonce is EncodePing using a nonce from ReserveNonce, so a PING
+// can be queued behind frames encoded with EncodeFrameIntoNonce and sent in
+// nonce order with them. nonce is ignored in insecure mode.
+func (c *Codec) EncodePingNonce(timestamp int64, nonce uint64) []byte {
+	payload := make([]byte, PingPongPayloadSize)
+	binary.BigEndian.PutUint64(payload, uint64(timestamp))
+	return c.encodeIntoNonce(make([]byte, 0, c.EncodedSize(len(payload))), MsgPing, payload, nonce)
+}
+
+// EncodePongNonce is EncodePongWithClock using a nonce from ReserveNonce (see
+// EncodePingNonce), or EncodePong if clock is 0.
+func (c *Codec) EncodePongNonce(timestamp, clock int64, nonce uint64) []byte {
+	size := PingPongPayloadSize
+	if clock != 0 {
+		size = PongClockPayloadSize
+	}
+	payload := make([]byte, size)
+	binary.BigEndian.PutUint64(payload, uint64(timestamp))
+	if clock != 0 {
+		binary.BigEndian.PutUint64(payload[PingPongPayloadSize:], uint64(clock))
+	}
+	return c.encodeIntoNonce(make([]byte, 0, c.EncodedSize(len(payload))), MsgPong, payload, nonce)
+}
+
 // EncodeBye encodes a BYE message for graceful disconnect.
 func (c *Codec) EncodeBye() []byte {
 	return c.encode(MsgBye, nil)
 }
 
+// EncodeError encodes an ERROR message with a numeric code and an optional
+// short human-readable message (truncated to MaxErrorMsgLen bytes).
+// Error messages always use insecure framing so a peer can read them even when
+// the two sides disagree on the key; receivers must treat them as advisory,
+// and a secure codec accepts them only during the handshake (see Decode).
+func EncodeError(code uint16, text string) []byte {
+	if len(text) > MaxErrorMsgLen {
+		text = text[:MaxErrorMsgLen]
+	}
+	msg := make([]byte, ErrorHeaderSize+ErrorPayloadSize+len(text))
+	msg[0] = MsgError
+	copy(msg[1:ErrorHeaderSize], errorMarker)
+	binary.BigEndian.PutUint16(msg[ErrorHeaderSize:], code)
+	copy(msg[ErrorHeaderSize+ErrorPayloadSize:], text)
+	return msg
+}
+
+// EncodeModeMismatch encodes an error telling the peer that its HELLO was
+// framed for the other security mode (one side has --key, the other doesn't).
+func EncodeModeMismatch() []byte {
+	return EncodeError(ErrorCodeModeMismatch, "")
+}
+
+// isErrorMessage reports whether data is a marked MsgError.
+func isErrorMessage(data []byte) bool {
+	return len(data) >= ErrorHeaderSize && data[0] == MsgError &&
+		string(data[1:ErrorHeaderSize]) == errorMarker
+}
+
+// IsModeMismatchHello reports whether data is a HELLO framed for the opposite
+// security mode: an insecure HELLO reaching a secure codec, or a signed HELLO
+// reaching an insecure one. Call it only after Decode has rejected data.
+func (c *Codec) IsModeMismatchHello(data []byte) bool {
+	var versionOff, overhead int
+	if c.secureMode {
+		// Peer used insecure framing: Type + Payload
+		versionOff, overhead = 1, 1
+	} else {
+		// Peer used secure framing: Type + Nonce + Payload + HMAC
+		versionOff, overhead = SecureHeaderSize, SecureHeaderSize+HMACSize
+	}
+	if len(data) < overhead+HelloPayloadSize || data[0] != MsgHello {
+		return false
+	}
+	version := binary.BigEndian.Uint16(data[versionOff : versionOff+2])
+	size := HelloPayloadSize
+	if version >= FlagsVersion {
+		size = HelloV4PayloadSize
+	}
+	return len(data) == overhead+size && SupportedVersion(version)
+}
+
 // Message represents a decoded protocol message.
 type Message struct {
 	Type      byte
 	Frame     []byte // For MsgFrame
 	Version   uint16 // For MsgHello, MsgHelloAck
-	Challenge []byte // For MsgHello (16 bytes)
-	Response  []byte // For MsgHelloAck (32 bytes)
+	Flags     byte   // For MsgHello, MsgHelloAck from FlagsVersion (FlagFrameCRC, ...)
+	Challenge []byte // For MsgHello, and MsgHelloAck from v2 (16 bytes)
+	Response  []byte // For MsgHelloAck, MsgHelloConfirm (32 bytes)
 	Timestamp int64  // For MsgPing, MsgPong
+	PeerClock int64  // For MsgPong: the responder's clock, if it sent one (0 otherwise)
+	ErrorCode uint16 // For MsgError
+	ErrorMsg  string // For MsgError (unauthenticated, printable ASCII only)
 }
 
-// Decode parses a wire-format message into a structured Message.
+// Decode parses a handshake message into a structured Message. Unlike
+// DecodeInto it accepts an unsigned ERROR report in secure mode, since a
+// peer rejecting our HELLO may not share our key; such reports must only be
+// treated as advisory. Frame, Challenge, and Response alias data (see
+// DecodeInto).
+//
+// Failures are counted in Stats.
 func (c *Codec) Decode(data []byte) (*Message, error) {
-	msgType, payload, err := c.decode(data)
-	if err != nil {
+	msg := &Message{}
+	if err := c.decodeInto(msg, data, true); err != nil {
+		c.countDecodeError(err)
 		return nil, err
 	}
+	return msg, nil
+}
+
+// DecodeInto parses a wire-format message into dst without allocating, so a
+// receive loop can reuse one Message for every packet. All fields of dst are
+// overwritten; on error dst's contents are unspecified.
+//
+// Aliasing: Frame, Challenge, and Response are slices of data, not copies.
+// They are only valid until data is reused (typically the next socket read);
+// callers that keep them longer must copy them first.
+//
+// DecodeInto is for session traffic: in secure mode an unsigned ERROR report
+// is rejected with ErrInvalidHMAC (see Decode for the handshake).
+//
+// Failures are counted in Stats.
+func (c *Codec) DecodeInto(dst *Message, data []byte) error {
+	err := c.decodeInto(dst, data, false)
+	if err != nil {
+		c.countDecodeError(err)
+	}
+	return err
+}
+
+// DecodeUnordered is DecodeInto without the replay check, so several
+// goroutines can decode (and verify the HMACs of) messages at once. It
+// returns the message's nonce, which must then be passed to AcceptNonce with
+// the message type, one message at a time in arrival order, before the
+// message is acted on. Aliasing is as for DecodeInto.
+//
+// Failures are counted in Stats.
+func (c *Codec) DecodeUnordered(dst *Message, data []byte) (nonce uint64, err error) {
+	msgType, nonce, payload, err := c.authenticate(data, false)
+	if err == nil {
+		err = c.parseInto(dst, msgType, payload)
+	}
+	if err != nil {
+		c.countDecodeError(err)
+		return 0, err
+	}
+	return nonce, nil
+}
+
+// AcceptNonce applies DecodeInto's replay check to a message decoded with
+// DecodeUnordered, returning ErrReplayDetected if it must be dropped.
+// It must not be called concurrently. Rejections are counted in Stats.
+func (c *Codec) AcceptNonce(msgType byte, nonce uint64) error {
+	err := c.checkNonce(msgType, nonce)
+	if err != nil {
+		c.countDecodeError(err)
+	}
+	return err
+}
+
+// decodeInto implements Decode and DecodeInto without counting failures.
+func (c *Codec) decodeInto(dst *Message, data []byte, handshake bool) error {
+	msgType, payload, err := c.decode(data, handshake)
+	if err != nil {
+		return err
+	}
+	return c.parseInto(dst, msgType, payload)
+}
 
-	msg := &Message{Type: msgType}
+// parseInto fills dst from an authenticated message's type and payload.
+func (c *Codec) parseInto(dst *Message, msgType byte, payload []byte) error {
+	*dst = Message{Type: msgType}
 
 	switch msgType {
-	case MsgFrame:
-		if len(payload) < MinEthernetFrame {
-			return nil, fmt.Errorf("%w: frame too small (%d bytes)", ErrInvalidPayload, len(payload))
+	case MsgFrame, MsgFrameCRC:
+		frame := payload
+		if msgType == MsgFrameCRC {
+			frame = payload[:max(len(payload)-FrameCRCSize, 0)]
+		}
+		if len(frame) < MinEthernetFrame {
+			return fmt.Errorf("%w: frame too small (%d bytes)", ErrInvalidPayload, len(frame))
 		}
-		if len(payload) > MaxFrameSize {
-			return nil, fmt.Errorf("%w: frame too large (%d bytes)", ErrInvalidPayload, len(payload))
+		if len(frame) > c.maxFrame {
+			return fmt.Errorf("%w: frame too large (%d bytes)", ErrInvalidPayload, len(frame))
 		}
-		msg.Frame = payload
+		if msgType == MsgFrameCRC {
+			if !checkFrameCRC(frame, payload[len(frame):]) {
+				return ErrFrameCorrupt
+			}
+			// Checked; the caller handles it like any frame
+			dst.Type = MsgFrame
+		}
+		dst.Frame = frame
 
 	case MsgHello:
 		if len(payload) < HelloPayloadSize {
-			return nil, fmt.Errorf("%w: HELLO payload too small", ErrInvalidPayload)
+			return fmt.Errorf("%w: HELLO payload too small", ErrInvalidPayload)
 		}
-		msg.Version = binary.BigEndian.Uint16(payload[0:2])
-		msg.Challenge = payload[2 : 2+ChallengeSize]
-		if msg.Version != ProtocolVersion {
-			return nil, fmt.Errorf("%w: expected %d, got %d", ErrVersionMismatch, ProtocolVersion, msg.Version)
+		dst.Version = binary.BigEndian.Uint16(payload[0:2])
+		dst.Challenge = payload[2 : 2+ChallengeSize]
+		if !SupportedVersion(dst.Version) {
+			return fmt.Errorf("%w: expected %d-%d, got %d", ErrVersionMismatch, MinProtocolVersion, ProtocolVersion, dst.Version)
+		}
+		if dst.Version >= FlagsVersion {
+			if len(payload) < HelloV4PayloadSize {
+				return fmt.Errorf("%w: HELLO payload too small", ErrInvalidPayload)
+			}
+			dst.Flags = payload[HelloPayloadSize]
 		}
 
 	case MsgHelloAck:
 		if len(payload) < HelloAckPayloadSize {
-			return nil, fmt.Errorf("%w: HELLO_ACK payload too small", ErrInvalidPayload)
+			return fmt.Errorf("%w: HELLO_ACK payload too small", ErrInvalidPayload)
+		}
+		dst.Version = binary.BigEndian.Uint16(payload[0:2])
+		dst.Response = payload[2 : 2+ChallengeRespLen]
+		if !SupportedVersion(dst.Version) {
+			return fmt.Errorf("%w: expected %d-%d, got %d", ErrVersionMismatch, MinProtocolVersion, ProtocolVersion, dst.Version)
 		}
-		msg.Version = binary.BigEndian.Uint16(payload[0:2])
-		msg.Response = payload[2 : 2+ChallengeRespLen]
-		if msg.Version != ProtocolVersion {
-			return nil, fmt.Errorf("%w: expected %d, got %d", ErrVersionMismatch, ProtocolVersion, msg.Version)
+		if dst.Version >= 2 {
+			if len(payload) < HelloAckV2PayloadSize {
+				return fmt.Errorf("%w: HELLO_ACK payload too small", ErrInvalidPayload)
+			}
+			dst.Challenge = payload[HelloAckPayloadSize:HelloAckV2PayloadSize]
+		}
+		if dst.Version >= FlagsVersion {
+			if len(payload) < HelloAckV4PayloadSize {
+				return fmt.Errorf("%w: HELLO_ACK payload too small", ErrInvalidPayload)
+			}
+			dst.Flags = payload[HelloAckV2PayloadSize]
 		}
 
+	case MsgHelloConfirm:
+		if len(payload) < HelloConfirmPayloadSize {
+			return fmt.Errorf("%w: HELLO_CONFIRM payload too small", ErrInvalidPayload)
+		}
+		dst.Response = payload[:ChallengeRespLen]
+
 	case MsgPing:
 		if len(payload) < PingPongPayloadSize {
-			return nil, fmt.Errorf("%w: PING payload too small", ErrInvalidPayload)
+			return fmt.Errorf("%w: PING payload too small", ErrInvalidPayload)
 		}
-		msg.Timestamp = int64(binary.BigEndian.Uint64(payload))
+		dst.Timestamp = int64(binary.BigEndian.Uint64(payload))
 
 	case MsgPong:
 		if len(payload) < PingPongPayloadSize {
-			return nil, fmt.Errorf("%w: PONG payload too small", ErrInvalidPayload)
+			return fmt.Errorf("%w: PONG payload too small", ErrInvalidPayload)
+		}
+		dst.Timestamp = int64(binary.BigEndian.Uint64(payload))
+		if len(payload) >= PongClockPayloadSize {
+			dst.PeerClock = int64(binary.BigEndian.Uint64(payload[PingPongPayloadSize:]))
 		}
-		msg.Timestamp = int64(binary.BigEndian.Uint64(payload))
 
 	case MsgBye:
 		// No payload expected
 
+	case MsgError:
+		if len(payload) < ErrorPayloadSize {
+			return fmt.Errorf("%w: ERROR payload too small", ErrInvalidPayload)
+		}
+		if len(payload) > ErrorPayloadSize+MaxErrorMsgLen {
+			return fmt.Errorf("%w: ERROR message too long", ErrInvalidPayload)
+		}
+		dst.ErrorCode = binary.BigEndian.Uint16(payload[0:2])
+		dst.ErrorMsg = sanitizeErrorText(payload[2:])
+
 	default:
-		return nil, fmt.Errorf("%w: 0x%02x", ErrUnknownMsgType, msgType)
+		return fmt.Errorf("%w: 0x%02x", ErrUnknownMsgType, msgType)
 	}
 
-	return msg, nil
+	return nil
 }
 
 // VerifyChallengeResponse verifies the challenge response in a HELLO_ACK.
-func (c *Codec) VerifyChallengeResponse(challenge, response []byte) bool {
+// version is the version the HELLO_ACK reports; a response computed for a
+// different version does not verify.
+func (c *Codec) VerifyChallengeResponse(challenge, response []byte, version uint16) bool {
+	return c.verifyResponse(authContext, challenge, response, version)
+}
+
+// VerifyConfirmResponse verifies the response in a HELLO_CONFIRM to the
+// challenge we sent in our HELLO_ACK for the given version.
+func (c *Codec) VerifyConfirmResponse(challenge, response []byte, version uint16) bool {
+	return c.verifyResponse(confirmContext, challenge, response, version)
+}
+
+// verifyResponse checks response against challengeResponse in constant time.
+func (c *Codec) verifyResponse(context string, challenge, response []byte, version uint16) bool {
 	if !c.secureMode {
 		// In insecure mode, always accept (response should be zeros anyway)
 		return true
@@ -301,7 +834,7 @@ func (c *Codec) VerifyChallengeResponse(challenge, response []byte) bool {
 	if len(challenge) != ChallengeSize || len(response) != ChallengeRespLen {
 		return false
 	}
-	expected := c.computeHMAC(challenge)
+	expected := c.challengeResponse(context, challenge, version)
 	return hmac.Equal(expected, response)
 }
 
@@ -310,6 +843,33 @@ func (c *Codec) ResetRecvNonce() {
 	atomic.StoreUint64(&c.recvNonce, 0)
 }
 
+// sanitizeErrorText replaces anything but printable ASCII with '?' so that
+// text from an unauthenticated peer can be logged safely.
+func sanitizeErrorText(b []byte) string {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		if c < 0x20 || c > 0x7e {
+			c = '?'
+		}
+		out[i] = c
+	}
+	return string(out)
+}
+
+// ErrorCodeName returns a human-readable name for an ERROR code.
+func ErrorCodeName(code uint16) string {
+	switch code {
+	case ErrorCodeModeMismatch:
+		return "mode mismatch"
+	case ErrorCodeVersionUnsupported:
+		return "version unsupported"
+	case ErrorCodeRateLimited:
+		return "rate limited"
+	default:
+		return fmt.Sprintf("error %d", code)
+	}
+}
+
 // MessageTypeName returns a human-readable name for a message type.
 func MessageTypeName(t byte) string {
 	switch t {
@@ -325,6 +885,12 @@ func MessageTypeName(t byte) string {
 		return "PONG"
 	case MsgBye:
 		return "BYE"
+	case MsgError:
+		return "ERROR"
+	case MsgHelloConfirm:
+		return "HELLO_CONFIRM"
+	case MsgFrameCRC:
+		return "FRAME_CRC"
 	default:
 		return fmt.Sprintf("UNKNOWN(0x%02x)", t)
 	}
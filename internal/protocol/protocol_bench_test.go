@@ -5,9 +5,10 @@ import (
 )
 
 func BenchmarkEncodeFrame_64(b *testing.B) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	frame := makeTestFrame(64)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_, _ = codec.EncodeFrame(frame)
@@ -15,7 +16,7 @@ func BenchmarkEncodeFrame_64(b *testing.B) {
 }
 
 func BenchmarkEncodeFrame_1500(b *testing.B) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	frame := makeTestFrame(1500)
 
 	b.ResetTimer()
@@ -25,9 +26,10 @@ func BenchmarkEncodeFrame_1500(b *testing.B) {
 }
 
 func BenchmarkEncodeFrame_Secure_64(b *testing.B) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	frame := makeTestFrame(64)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_, _ = codec.EncodeFrame(frame)
@@ -35,7 +37,7 @@ func BenchmarkEncodeFrame_Secure_64(b *testing.B) {
 }
 
 func BenchmarkEncodeFrame_Secure_1500(b *testing.B) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	frame := makeTestFrame(1500)
 
 	b.ResetTimer()
@@ -44,8 +46,67 @@ func BenchmarkEncodeFrame_Secure_1500(b *testing.B) {
 	}
 }
 
+func BenchmarkEncodeFrameInto_64(b *testing.B) {
+	codec := newTestCodec(nil)
+	frame := makeTestFrame(64)
+	dst := make([]byte, 0, codec.EncodedSize(MaxFrameSize))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst, _ = codec.EncodeFrameInto(dst, frame)
+	}
+}
+
+func BenchmarkEncodeFrameInto_Secure_64(b *testing.B) {
+	codec := newTestCodec(testKey)
+	frame := makeTestFrame(64)
+	dst := make([]byte, 0, codec.EncodedSize(MaxFrameSize))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst, _ = codec.EncodeFrameInto(dst, frame)
+	}
+}
+
+func BenchmarkEncodeFrameInto_Secure_1500(b *testing.B) {
+	codec := newTestCodec(testKey)
+	frame := makeTestFrame(1500)
+	dst := make([]byte, 0, codec.EncodedSize(MaxFrameSize))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst, _ = codec.EncodeFrameInto(dst, frame)
+	}
+}
+
+func BenchmarkDecode_Alloc_64(b *testing.B) {
+	codec := newTestCodec(nil)
+	encoded, _ := codec.EncodeFrame(makeTestFrame(64))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = codec.Decode(encoded)
+	}
+}
+
+func BenchmarkDecodeInto_64(b *testing.B) {
+	codec := newTestCodec(nil)
+	encoded, _ := codec.EncodeFrame(makeTestFrame(64))
+	var msg Message
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = codec.DecodeInto(&msg, encoded)
+	}
+}
+
 func BenchmarkDecodeFrame_64(b *testing.B) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	frame := makeTestFrame(64)
 	encoded, _ := codec.EncodeFrame(frame)
 
@@ -56,7 +117,7 @@ func BenchmarkDecodeFrame_64(b *testing.B) {
 }
 
 func BenchmarkDecodeFrame_1500(b *testing.B) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	frame := makeTestFrame(1500)
 	encoded, _ := codec.EncodeFrame(frame)
 
@@ -67,7 +128,7 @@ func BenchmarkDecodeFrame_1500(b *testing.B) {
 }
 
 func BenchmarkDecodeFrame_Secure_64(b *testing.B) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	frame := makeTestFrame(64)
 	encoded, _ := codec.EncodeFrame(frame)
 
@@ -80,7 +141,7 @@ func BenchmarkDecodeFrame_Secure_64(b *testing.B) {
 }
 
 func BenchmarkDecodeFrame_Secure_1500(b *testing.B) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	frame := makeTestFrame(1500)
 	encoded, _ := codec.EncodeFrame(frame)
 
@@ -92,7 +153,7 @@ func BenchmarkDecodeFrame_Secure_1500(b *testing.B) {
 }
 
 func BenchmarkEncodePing(b *testing.B) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	timestamp := int64(1234567890)
 
 	b.ResetTimer()
@@ -102,7 +163,7 @@ func BenchmarkEncodePing(b *testing.B) {
 }
 
 func BenchmarkEncodePong(b *testing.B) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	timestamp := int64(1234567890)
 
 	b.ResetTimer()
@@ -112,7 +173,7 @@ func BenchmarkEncodePong(b *testing.B) {
 }
 
 func BenchmarkHMAC_Compute(b *testing.B) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	data := makeTestFrame(1500)
 
 	b.ResetTimer()
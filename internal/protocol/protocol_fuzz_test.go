@@ -13,7 +13,7 @@ func FuzzDecode(f *testing.F) {
 	f.Add([]byte{MsgBye})
 	f.Add([]byte{0xFF}) // Unknown type
 
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	f.Fuzz(func(t *testing.T, data []byte) {
 		// Should not panic
@@ -22,7 +22,7 @@ func FuzzDecode(f *testing.F) {
 }
 
 func FuzzDecodeSecure(f *testing.F) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	
 	// Generate valid seeds
 	frame := makeTestFrame(64)
@@ -37,7 +37,7 @@ func FuzzDecodeSecure(f *testing.F) {
 
 	// Reset codec for fuzzing
 	f.Fuzz(func(t *testing.T, data []byte) {
-		fuzzCodec := NewCodec(testKey)
+		fuzzCodec := newTestCodec(testKey)
 		// Should not panic
 		_, _ = fuzzCodec.Decode(data)
 	})
@@ -54,7 +54,7 @@ func FuzzEncodeDecodeFrame(f *testing.F) {
 			return // Skip invalid sizes
 		}
 
-		codec := NewCodec(nil)
+		codec := newTestCodec(nil)
 		encoded, err := codec.EncodeFrame(frame)
 		if err != nil {
 			return // Invalid frame
@@ -70,3 +70,31 @@ func FuzzEncodeDecodeFrame(f *testing.F) {
 		}
 	})
 }
+
+func FuzzEncodeDecodeError(f *testing.F) {
+	f.Add(uint16(ErrorCodeModeMismatch), "")
+	f.Add(uint16(ErrorCodeVersionUnsupported), "expected protocol version 1")
+	f.Add(uint16(0xFFFF), "\x00\xff\n")
+
+	f.Fuzz(func(t *testing.T, code uint16, text string) {
+		encoded := EncodeError(code, text)
+
+		for _, codec := range []*Codec{newTestCodec(nil), newTestCodec(testKey)} {
+			msg, err := codec.Decode(encoded)
+			if err != nil {
+				t.Fatalf("decode failed after encode: %v", err)
+			}
+			if msg.Type != MsgError || msg.ErrorCode != code {
+				t.Fatalf("got type %d code %d, want ERROR code %d", msg.Type, msg.ErrorCode, code)
+			}
+			if len(msg.ErrorMsg) > MaxErrorMsgLen {
+				t.Fatalf("error msg length %d exceeds %d", len(msg.ErrorMsg), MaxErrorMsgLen)
+			}
+			for _, c := range []byte(msg.ErrorMsg) {
+				if c < 0x20 || c > 0x7e {
+					t.Fatalf("unsanitized byte 0x%02x in error msg", c)
+				}
+			}
+		}
+	})
+}
@@ -2,7 +2,13 @@ package protocol
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -10,29 +16,44 @@ import (
 // Test key for secure mode tests
 var testKey = []byte("test-secret-key!")
 
+// newTestCodec is NewCodec for keys DeriveKey is known to accept.
+func newTestCodec(key []byte) *Codec {
+	codec, err := NewCodec(key)
+	if err != nil {
+		panic(err)
+	}
+	return codec
+}
+
 func TestNewCodec_WithKey(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec, err := NewCodec(testKey)
+	if err != nil {
+		t.Fatalf("NewCodec() failed: %v", err)
+	}
 	if !codec.IsSecure() {
 		t.Error("expected codec to be secure with key")
 	}
 }
 
 func TestNewCodec_WithoutKey(t *testing.T) {
-	codec := NewCodec(nil)
+	codec, err := NewCodec(nil)
+	if err != nil {
+		t.Fatalf("NewCodec() failed: %v", err)
+	}
 	if codec.IsSecure() {
 		t.Error("expected codec to be insecure with nil key")
 	}
 }
 
 func TestNewCodec_EmptyKey(t *testing.T) {
-	codec := NewCodec([]byte{})
+	codec := newTestCodec([]byte{})
 	if codec.IsSecure() {
 		t.Error("expected codec to be insecure with empty key")
 	}
 }
 
 func TestEncodeFrame_Roundtrip_Insecure(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	frame := makeTestFrame(100)
 
 	encoded, err := codec.EncodeFrame(frame)
@@ -54,7 +75,7 @@ func TestEncodeFrame_Roundtrip_Insecure(t *testing.T) {
 }
 
 func TestEncodeFrame_Roundtrip_Secure(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	frame := makeTestFrame(100)
 
 	encoded, err := codec.EncodeFrame(frame)
@@ -75,8 +96,110 @@ func TestEncodeFrame_Roundtrip_Secure(t *testing.T) {
 	}
 }
 
+func TestEncodeFrameInto_MatchesEncodeFrame(t *testing.T) {
+	frame := makeTestFrame(64)
+
+	for _, key := range [][]byte{nil, testKey} {
+		// Nonces advance per encode, so compare decoded content not bytes
+		codec := newTestCodec(key)
+		dst := make([]byte, 0, codec.EncodedSize(MaxFrameSize))
+
+		encoded, err := codec.EncodeFrameInto(dst, frame)
+		if err != nil {
+			t.Fatalf("EncodeFrameInto failed: %v", err)
+		}
+		if &encoded[0] != &dst[:1][0] {
+			t.Error("EncodeFrameInto did not reuse dst")
+		}
+		if len(encoded) != codec.EncodedSize(len(frame)) {
+			t.Errorf("encoded length = %d, want %d", len(encoded), codec.EncodedSize(len(frame)))
+		}
+
+		msg, err := newTestCodec(key).Decode(encoded)
+		if err != nil {
+			t.Fatalf("Decode failed (secure=%v): %v", codec.IsSecure(), err)
+		}
+		if !bytes.Equal(msg.Frame, frame) {
+			t.Error("frame mismatch after roundtrip")
+		}
+	}
+}
+
+func TestEncodeFrameInto_GrowsSmallBuffer(t *testing.T) {
+	codec := newTestCodec(testKey)
+	encoded, err := codec.EncodeFrameInto(nil, makeTestFrame(64))
+	if err != nil {
+		t.Fatalf("EncodeFrameInto failed: %v", err)
+	}
+	if _, err := newTestCodec(testKey).Decode(encoded); err != nil {
+		t.Errorf("Decode failed: %v", err)
+	}
+}
+
+func TestEncodeFrameInto_NoAllocs(t *testing.T) {
+	codec := newTestCodec(nil)
+	frame := makeTestFrame(64)
+	dst := make([]byte, 0, codec.EncodedSize(MaxFrameSize))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		dst, _ = codec.EncodeFrameInto(dst, frame)
+	})
+	if allocs != 0 {
+		t.Errorf("EncodeFrameInto allocated %.1f times per frame, want 0", allocs)
+	}
+}
+
+func TestDecodeInto_ReusesMessage(t *testing.T) {
+	codec := newTestCodec(nil)
+	var msg Message
+
+	if err := codec.DecodeInto(&msg, codec.EncodePing(42)); err != nil {
+		t.Fatalf("DecodeInto(PING) failed: %v", err)
+	}
+	if msg.Type != MsgPing || msg.Timestamp != 42 {
+		t.Errorf("got type %d timestamp %d, want PING 42", msg.Type, msg.Timestamp)
+	}
+
+	// Fields from the previous message must not leak into the next
+	if err := codec.DecodeInto(&msg, codec.EncodeBye()); err != nil {
+		t.Fatalf("DecodeInto(BYE) failed: %v", err)
+	}
+	if msg.Type != MsgBye || msg.Timestamp != 0 {
+		t.Errorf("got type %d timestamp %d, want BYE with zero timestamp", msg.Type, msg.Timestamp)
+	}
+}
+
+func TestDecodeInto_FrameAliasesInput(t *testing.T) {
+	codec := newTestCodec(nil)
+	encoded, _ := codec.EncodeFrame(makeTestFrame(64))
+
+	var msg Message
+	if err := codec.DecodeInto(&msg, encoded); err != nil {
+		t.Fatalf("DecodeInto failed: %v", err)
+	}
+
+	// Documented aliasing: Frame shares storage with the input buffer
+	encoded[1] ^= 0xFF
+	if msg.Frame[0] != encoded[1] {
+		t.Error("expected Frame to alias the input buffer")
+	}
+}
+
+func TestDecodeInto_NoAllocs(t *testing.T) {
+	codec := newTestCodec(nil)
+	encoded, _ := codec.EncodeFrame(makeTestFrame(64))
+	var msg Message
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = codec.DecodeInto(&msg, encoded)
+	})
+	if allocs != 0 {
+		t.Errorf("DecodeInto allocated %.1f times per frame, want 0", allocs)
+	}
+}
+
 func TestEncodeFrame_MinSize(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	frame := makeTestFrame(MinEthernetFrame)
 
 	encoded, err := codec.EncodeFrame(frame)
@@ -95,7 +218,7 @@ func TestEncodeFrame_MinSize(t *testing.T) {
 }
 
 func TestEncodeFrame_MaxSize(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	frame := makeTestFrame(MaxFrameSize)
 
 	encoded, err := codec.EncodeFrame(frame)
@@ -114,7 +237,7 @@ func TestEncodeFrame_MaxSize(t *testing.T) {
 }
 
 func TestEncodeFrame_TooSmall(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	frame := makeTestFrame(10) // Less than MinEthernetFrame
 
 	_, err := codec.EncodeFrame(frame)
@@ -124,7 +247,7 @@ func TestEncodeFrame_TooSmall(t *testing.T) {
 }
 
 func TestEncodeFrame_TooLarge(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	frame := makeTestFrame(MaxFrameSize + 1)
 
 	_, err := codec.EncodeFrame(frame)
@@ -133,8 +256,54 @@ func TestEncodeFrame_TooLarge(t *testing.T) {
 	}
 }
 
+func TestCodec_MaxFrameSize(t *testing.T) {
+	sender := newTestCodec(testKey)
+	receiver := newTestCodec(testKey)
+	if got := sender.MaxFrameSize(); got != MaxFrameSize {
+		t.Fatalf("default MaxFrameSize() = %d, want %d", got, MaxFrameSize)
+	}
+
+	const jumbo = 9000
+	if err := sender.SetMaxFrameSize(jumbo); err != nil {
+		t.Fatalf("SetMaxFrameSize(%d) failed: %v", jumbo, err)
+	}
+	atLimit, err := sender.EncodeFrame(makeTestFrame(jumbo))
+	if err != nil {
+		t.Fatalf("encode at the limit failed: %v", err)
+	}
+	if _, err := sender.EncodeFrame(makeTestFrame(jumbo + 1)); err == nil {
+		t.Error("expected error encoding a frame above the limit")
+	}
+	if _, err := sender.EncodeFrameInto(nil, makeTestFrame(jumbo+1)); err == nil {
+		t.Error("expected error from EncodeFrameInto above the limit")
+	}
+
+	// A peer still at the default rejects it; raised to match, accepts it
+	if _, err := receiver.Decode(atLimit); !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("default receiver: err = %v, want ErrInvalidPayload", err)
+	}
+	receiver.ResetRecvNonce()
+	if err := receiver.SetMaxFrameSize(jumbo); err != nil {
+		t.Fatalf("SetMaxFrameSize(%d) failed: %v", jumbo, err)
+	}
+	if msg, err := receiver.Decode(atLimit); err != nil || len(msg.Frame) != jumbo {
+		t.Errorf("raised receiver: decode = %v, want a %d-byte frame", err, jumbo)
+	}
+
+	for _, n := range []int{MaxFrameSize - 1, MaxJumboFrameSize + 1} {
+		if err := sender.SetMaxFrameSize(n); err == nil {
+			t.Errorf("SetMaxFrameSize(%d) succeeded", n)
+		}
+	}
+	largest := newTestCodec(testKey)
+	largest.SetFrameCRC(true)
+	if got := largest.EncodedSize(MaxJumboFrameSize); got != MaxMessageSize {
+		t.Errorf("EncodedSize(MaxJumboFrameSize) with frame CRCs = %d, want MaxMessageSize %d", got, MaxMessageSize)
+	}
+}
+
 func TestEncodeHello_Format(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	encoded, challenge, err := codec.EncodeHello()
 	if err != nil {
@@ -162,13 +331,16 @@ func TestEncodeHello_Format(t *testing.T) {
 }
 
 func TestEncodeHelloAck_Format(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	challenge := make([]byte, ChallengeSize)
 	for i := range challenge {
 		challenge[i] = byte(i)
 	}
 
-	encoded := codec.EncodeHelloAck(challenge)
+	encoded, ours, err := codec.EncodeHelloAck(challenge, ProtocolVersion)
+	if err != nil {
+		t.Fatalf("encode hello_ack failed: %v", err)
+	}
 
 	msg, err := codec.Decode(encoded)
 	if err != nil {
@@ -184,10 +356,13 @@ func TestEncodeHelloAck_Format(t *testing.T) {
 	if len(msg.Response) != ChallengeRespLen {
 		t.Errorf("expected response length %d, got %d", ChallengeRespLen, len(msg.Response))
 	}
+	if len(ours) != ChallengeSize || !bytes.Equal(msg.Challenge, ours) {
+		t.Errorf("HELLO_ACK challenge = %x, want %x", msg.Challenge, ours)
+	}
 }
 
 func TestHandshake_ChallengeResponse(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 
 	// Simulate HELLO
 	_, challenge, err := codec.EncodeHello()
@@ -196,8 +371,11 @@ func TestHandshake_ChallengeResponse(t *testing.T) {
 	}
 
 	// Simulate HELLO_ACK with same codec (same key)
-	codec2 := NewCodec(testKey)
-	ackEncoded := codec2.EncodeHelloAck(challenge)
+	codec2 := newTestCodec(testKey)
+	ackEncoded, _, err := codec2.EncodeHelloAck(challenge, ProtocolVersion)
+	if err != nil {
+		t.Fatalf("encode hello_ack failed: %v", err)
+	}
 
 	// Decode the ACK
 	msg, err := codec.Decode(ackEncoded)
@@ -206,14 +384,422 @@ func TestHandshake_ChallengeResponse(t *testing.T) {
 	}
 
 	// Verify challenge response
-	if !codec.VerifyChallengeResponse(challenge, msg.Response) {
+	if !codec.VerifyChallengeResponse(challenge, msg.Response, ProtocolVersion) {
 		t.Error("challenge response verification failed")
 	}
 }
 
+func TestChallengeResponse_VersionsDontCrossVerify(t *testing.T) {
+	codec := newTestCodec(testKey)
+	_, challenge, err := codec.EncodeHello()
+	if err != nil {
+		t.Fatalf("encode hello failed: %v", err)
+	}
+
+	v1 := codec.challengeResponse(authContext, challenge, 1)
+	v2 := codec.challengeResponse(authContext, challenge, 2)
+	v3 := codec.challengeResponse(authContext, challenge, 3)
+
+	// v1 is the plain HMAC of the challenge under the raw passphrase, as
+	// sent by older releases
+	mac := hmac.New(sha256.New, testKey)
+	mac.Write(challenge)
+	if !bytes.Equal(v1, mac.Sum(nil)) {
+		t.Error("v1 response is not HMAC(key, challenge)")
+	}
+	if bytes.Equal(v1, v2) || bytes.Equal(v2, v3) {
+		t.Fatal("responses for different versions are identical")
+	}
+
+	tests := []struct {
+		name     string
+		response []byte
+		version  uint16
+		want     bool
+	}{
+		{"v1 response as v1", v1, 1, true},
+		{"v2 response as v2", v2, 2, true},
+		{"v1 response as v2", v1, 2, false},
+		{"v2 response as v1", v2, 1, false},
+		{"v3 response as v3", v3, 3, true},
+		{"v2 response as v3", v2, 3, false},
+		{"v3 response as v2", v3, 2, false},
+	}
+	for _, tt := range tests {
+		if got := codec.VerifyChallengeResponse(challenge, tt.response, tt.version); got != tt.want {
+			t.Errorf("%s: VerifyChallengeResponse() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	derive := func(passphrase string) []byte {
+		t.Helper()
+		key, err := DeriveKey([]byte(passphrase))
+		if err != nil {
+			t.Fatalf("DeriveKey(%q) failed: %v", passphrase, err)
+		}
+		return key
+	}
+
+	// Pinned: peers of different releases must derive the same key
+	const want = "01fc0f45ede4e107486be0e773731e2ccac400824d4eb4cebc80497cdb43ec42"
+	key := derive("correct horse battery staple")
+	if got := hex.EncodeToString(key); got != want {
+		t.Errorf("DeriveKey() = %s, want %s", got, want)
+	}
+	if !bytes.Equal(derive("correct horse battery staple"), key) {
+		t.Error("DeriveKey() is not stable")
+	}
+	if other := derive("correct horse battery stapler"); bytes.Equal(other, key) {
+		t.Error("different passphrases derived the same key")
+	}
+	if len(derive("x")) != DerivedKeySize {
+		t.Errorf("derived key is not %d bytes", DerivedKeySize)
+	}
+}
+
+func TestHandshake_KeyMatchesVersion(t *testing.T) {
+	server := newTestCodec(testKey)
+	hello := func(c *Codec, version uint16) []byte {
+		payload := make([]byte, HelloPayloadSize)
+		binary.BigEndian.PutUint16(payload, version)
+		return c.encode(MsgHello, payload)
+	}
+
+	// A HELLO must be signed with its version's key
+	derived := newTestCodec(testKey)
+	if _, err := server.Decode(hello(derived, 2)); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("v2 HELLO signed with the derived key: err = %v, want ErrInvalidHMAC", err)
+	}
+	raw := newTestCodec(testKey)
+	raw.UseVersion(2)
+	if _, err := server.Decode(hello(raw, ProtocolVersion)); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("v%d HELLO signed with the raw key: err = %v, want ErrInvalidHMAC", ProtocolVersion, err)
+	}
+	if _, err := server.Decode(hello(newTestCodec([]byte("other passphrase")), ProtocolVersion)); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("HELLO with another passphrase: err = %v, want ErrInvalidHMAC", err)
+	}
+
+	// Outside a HELLO, a v3 session never falls back to the raw key
+	frame, _ := raw.EncodeFrame(makeTestFrame(64))
+	if _, err := server.Decode(frame); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("raw-key frame in a v%d session: err = %v, want ErrInvalidHMAC", ProtocolVersion, err)
+	}
+
+	// Answering a v2 HELLO keys the session with the raw passphrase
+	msg, err := server.Decode(hello(raw, 2))
+	if err != nil {
+		t.Fatalf("v2 HELLO rejected: %v", err)
+	}
+	ack, _, err := server.EncodeHelloAck(msg.Challenge, msg.Version)
+	if err != nil {
+		t.Fatalf("encode hello_ack failed: %v", err)
+	}
+	if _, err := raw.Decode(ack); err != nil {
+		t.Errorf("v2 peer rejected the v2 HELLO_ACK: %v", err)
+	}
+	if _, err := derived.Decode(ack); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("v2 HELLO_ACK verified with the derived key: err = %v", err)
+	}
+	frame, _ = raw.EncodeFrame(makeTestFrame(64))
+	if _, err := server.Decode(frame); err != nil {
+		t.Errorf("frame from the v2 peer rejected: %v", err)
+	}
+	frame, _ = derived.EncodeFrame(makeTestFrame(64))
+	if _, err := server.Decode(frame); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("derived-key frame in a v2 session: err = %v, want ErrInvalidHMAC", err)
+	}
+}
+
+func TestParseKeyFormat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want KeyFormat
+		ok   bool
+	}{
+		{"raw", KeyFormatRaw, true},
+		{"HEX", KeyFormatHex, true},
+		{" base64 ", KeyFormatBase64, true},
+		{"", "", false},
+		{"b64", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeyFormat(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseKeyFormat(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i * 7)
+	}
+	tests := []struct {
+		name   string
+		in     string
+		format KeyFormat
+		want   []byte // nil: an error
+	}{
+		{"raw", " pass phrase ", KeyFormatRaw, []byte(" pass phrase ")},
+		{"hex", hex.EncodeToString(key), KeyFormatHex, key},
+		{"hex upper, newline", strings.ToUpper(hex.EncodeToString(key)) + "\n", KeyFormatHex, key},
+		{"base64", base64.StdEncoding.EncodeToString(key), KeyFormatBase64, key},
+		{"base64 unpadded", base64.RawStdEncoding.EncodeToString(key), KeyFormatBase64, key},
+		{"base64 url", base64.URLEncoding.EncodeToString(key), KeyFormatBase64, key},
+		{"hex min", hex.EncodeToString(key[:MinKeySize]), KeyFormatHex, key[:MinKeySize]},
+		{"hex odd length", hex.EncodeToString(key)[1:], KeyFormatHex, nil},
+		{"hex bad character", "zz" + hex.EncodeToString(key)[2:], KeyFormatHex, nil},
+		{"hex passphrase", "correct horse battery staple", KeyFormatHex, nil},
+		{"base64 bad character", "!" + base64.StdEncoding.EncodeToString(key)[1:], KeyFormatBase64, nil},
+		{"hex too short", hex.EncodeToString(key[:8]), KeyFormatHex, nil},
+		{"base64 too short", base64.StdEncoding.EncodeToString(key[:MinKeySize-1]), KeyFormatBase64, nil},
+		{"hex too long", hex.EncodeToString(make([]byte, MaxKeySize+1)), KeyFormatHex, nil},
+		{"empty hex", "", KeyFormatHex, nil},
+	}
+	for _, tt := range tests {
+		got, err := DecodeKey(tt.in, tt.format)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: DecodeKey() = %x, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: DecodeKey() = %x, %v, want %x", tt.name, got, err, tt.want)
+		}
+	}
+
+	// Errors must not echo the key
+	if _, err := DecodeKey("5ec7e7q", KeyFormatHex); err == nil || strings.Contains(err.Error(), "q") {
+		t.Errorf("DecodeKey() error = %v", err)
+	}
+}
+
+func TestNewCodecFromKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	client := NewCodecFromKey(key)
+	server := NewCodecFromKey(key)
+
+	// The key is used as is, not stretched
+	frame, err := client.EncodeFrame(makeTestFrame(64))
+	if err != nil {
+		t.Fatalf("EncodeFrame() failed: %v", err)
+	}
+	if _, err := server.Decode(frame); err != nil {
+		t.Errorf("frame rejected: %v", err)
+	}
+	if _, err := newTestCodec(key).Decode(frame); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("passphrase codec accepted a frame: err = %v, want ErrInvalidHMAC", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(frame[:len(frame)-HMACSize])
+	if !hmac.Equal(mac.Sum(nil), frame[len(frame)-HMACSize:]) {
+		t.Error("frame not signed with the key itself")
+	}
+
+	// ...at every version
+	for _, version := range []uint16{1, 2, ProtocolVersion} {
+		client, server := NewCodecFromKey(key), NewCodecFromKey(key)
+		client.UseVersion(version)
+		payload := make([]byte, HelloV4PayloadSize)
+		binary.BigEndian.PutUint16(payload, version)
+		msg, err := server.Decode(client.encode(MsgHello, payload))
+		if err != nil {
+			t.Errorf("v%d HELLO rejected: %v", version, err)
+			continue
+		}
+		ack, _, err := server.EncodeHelloAck(msg.Challenge, msg.Version)
+		if err != nil {
+			t.Fatalf("encode hello_ack failed: %v", err)
+		}
+		if _, err := client.Decode(ack); err != nil {
+			t.Errorf("v%d HELLO_ACK rejected: %v", version, err)
+		}
+	}
+}
+
+func TestFrameCRC_Negotiation(t *testing.T) {
+	tests := []struct {
+		name           string
+		client, server bool
+		clientVersion  uint16
+		want           bool
+	}{
+		{"both offer", true, true, ProtocolVersion, true},
+		{"client only", true, false, ProtocolVersion, false},
+		{"server only", false, true, ProtocolVersion, false},
+		{"v3 client", true, true, 3, false},
+	}
+	for _, tt := range tests {
+		client, server := newTestCodec(nil), newTestCodec(nil)
+		client.SetFrameCRC(tt.client)
+		server.SetFrameCRC(tt.server)
+
+		hello, _, _ := client.EncodeHello()
+		if tt.clientVersion < FlagsVersion {
+			// An older peer's HELLO: no flags byte
+			hello = hello[:1+HelloPayloadSize]
+			binary.BigEndian.PutUint16(hello[1:], tt.clientVersion)
+		}
+		msg, err := server.Decode(hello)
+		if err != nil {
+			t.Fatalf("%s: HELLO rejected: %v", tt.name, err)
+		}
+		server.UsePeerFlags(msg.Flags)
+		ack, _, err := server.EncodeHelloAck(msg.Challenge, msg.Version)
+		if err != nil {
+			t.Fatalf("%s: encode hello_ack failed: %v", tt.name, err)
+		}
+		msg, err = client.Decode(ack)
+		if err != nil {
+			t.Fatalf("%s: HELLO_ACK rejected: %v", tt.name, err)
+		}
+		client.UsePeerFlags(msg.Flags)
+
+		if client.FrameCRC() != tt.want || server.FrameCRC() != tt.want {
+			t.Errorf("%s: FrameCRC() = %t / %t, want %t", tt.name, client.FrameCRC(), server.FrameCRC(), tt.want)
+		}
+		encoded, _ := client.EncodeFrame(makeTestFrame(64))
+		if want := map[bool]byte{false: MsgFrame, true: MsgFrameCRC}[tt.want]; encoded[0] != want {
+			t.Errorf("%s: frame sent as %s", tt.name, MessageTypeName(encoded[0]))
+		}
+	}
+}
+
+func TestFrameCRC_RejectsCorruptFrame(t *testing.T) {
+	for _, key := range [][]byte{nil, testKey} {
+		sender, receiver := newTestCodec(key), newTestCodec(key)
+		sender.SetFrameCRC(true)
+		sender.UsePeerFlags(FlagFrameCRC)
+
+		frame := makeTestFrame(100)
+		encoded, err := sender.EncodeFrame(frame)
+		if err != nil {
+			t.Fatalf("EncodeFrame() failed: %v", err)
+		}
+		if len(encoded) != sender.EncodedSize(len(frame)) {
+			t.Errorf("encoded %d bytes, EncodedSize says %d", len(encoded), sender.EncodedSize(len(frame)))
+		}
+		msg, err := receiver.Decode(encoded)
+		if err != nil {
+			t.Fatalf("CRC frame rejected: %v", err)
+		}
+		if msg.Type != MsgFrame || !bytes.Equal(msg.Frame, frame) {
+			t.Errorf("decoded %s of %d bytes, want the %d-byte frame", MessageTypeName(msg.Type), len(msg.Frame), len(frame))
+		}
+		if key != nil {
+			continue // A flipped bit fails the HMAC before the CRC is checked
+		}
+
+		encoded, _ = sender.EncodeFrame(frame)
+		encoded[20] ^= 0x04
+		if _, err := receiver.Decode(encoded); !errors.Is(err, ErrFrameCorrupt) {
+			t.Errorf("corrupted frame: err = %v, want ErrFrameCorrupt", err)
+		}
+		if _, err := receiver.Decode([]byte{MsgFrameCRC, 1, 2, 3}); !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("CRC frame shorter than its CRC: err = %v, want ErrInvalidPayload", err)
+		}
+		if stats := receiver.Stats(); stats.RxCorrupt != 1 || stats.DecodeErrors != 1 {
+			t.Errorf("Stats() = %+v, want 1 corrupt frame and 1 decode error", stats)
+		}
+	}
+}
+
+func TestHandshake_NegotiatesPeerVersion(t *testing.T) {
+	client := newTestCodec(testKey)
+	server := newTestCodec(testKey)
+
+	// A v1 peer's HELLO, signed with the raw passphrase, is accepted and
+	// answered in v1
+	client.UseVersion(1)
+	payload := make([]byte, HelloPayloadSize)
+	binary.BigEndian.PutUint16(payload, 1)
+	challenge := payload[2:]
+	copy(challenge, "0123456789abcdef")
+
+	msg, err := server.Decode(client.encode(MsgHello, payload))
+	if err != nil {
+		t.Fatalf("v1 hello rejected: %v", err)
+	}
+	if msg.Version != 1 {
+		t.Fatalf("hello version = %d, want 1", msg.Version)
+	}
+
+	ackEncoded, ours, err := server.EncodeHelloAck(msg.Challenge, msg.Version)
+	if err != nil {
+		t.Fatalf("encode hello_ack failed: %v", err)
+	}
+	if ours != nil {
+		t.Errorf("v1 HELLO_ACK carries a challenge")
+	}
+	ack, err := client.Decode(ackEncoded)
+	if err != nil {
+		t.Fatalf("decode ack failed: %v", err)
+	}
+	if ack.Version != 1 || !client.VerifyChallengeResponse(challenge, ack.Response, ack.Version) {
+		t.Errorf("v1 ack (version %d) did not verify", ack.Version)
+	}
+}
+
+func TestHandshake_Confirm(t *testing.T) {
+	client := newTestCodec(testKey)
+	server := newTestCodec(testKey)
+
+	_, challenge, err := client.EncodeHello()
+	if err != nil {
+		t.Fatalf("encode hello failed: %v", err)
+	}
+	ackEncoded, ours, err := server.EncodeHelloAck(challenge, ProtocolVersion)
+	if err != nil {
+		t.Fatalf("encode hello_ack failed: %v", err)
+	}
+	ack, err := client.Decode(ackEncoded)
+	if err != nil {
+		t.Fatalf("decode hello_ack failed: %v", err)
+	}
+
+	msg, err := server.Decode(client.EncodeHelloConfirm(ack.Challenge, ack.Version))
+	if err != nil {
+		t.Fatalf("decode hello_confirm failed: %v", err)
+	}
+	if msg.Type != MsgHelloConfirm {
+		t.Fatalf("expected type HELLO_CONFIRM, got %s", MessageTypeName(msg.Type))
+	}
+	if !server.VerifyConfirmResponse(ours, msg.Response, ProtocolVersion) {
+		t.Error("confirm response verification failed")
+	}
+
+	// A HELLO_ACK response reflected back as a confirmation must not verify
+	if server.VerifyConfirmResponse(ours, server.challengeResponse(authContext, ours, ProtocolVersion), ProtocolVersion) {
+		t.Error("reflected HELLO_ACK response verified as HELLO_CONFIRM")
+	}
+}
+
+func TestHandshake_ConfirmWrongKey(t *testing.T) {
+	server := newTestCodec(testKey)
+	client := newTestCodec([]byte("different-key!!"))
+
+	_, ours, err := server.EncodeHelloAck(make([]byte, ChallengeSize), ProtocolVersion)
+	if err != nil {
+		t.Fatalf("encode hello_ack failed: %v", err)
+	}
+
+	// The response itself doesn't verify, and on the wire the message HMAC fails first
+	response := client.challengeResponse(confirmContext, ours, ProtocolVersion)
+	if server.VerifyConfirmResponse(ours, response, ProtocolVersion) {
+		t.Error("confirm response from wrong key verified")
+	}
+	if _, err := server.Decode(client.EncodeHelloConfirm(ours, ProtocolVersion)); err != ErrInvalidHMAC {
+		t.Errorf("expected ErrInvalidHMAC, got %v", err)
+	}
+}
+
 func TestHandshake_WrongKey(t *testing.T) {
-	codec1 := NewCodec(testKey)
-	codec2 := NewCodec([]byte("different-key!!"))
+	codec1 := newTestCodec(testKey)
+	codec2 := newTestCodec([]byte("different-key!!"))
 
 	// Simulate HELLO
 	_, challenge, err := codec1.EncodeHello()
@@ -222,7 +808,10 @@ func TestHandshake_WrongKey(t *testing.T) {
 	}
 
 	// Simulate HELLO_ACK with different key
-	ackEncoded := codec2.EncodeHelloAck(challenge)
+	ackEncoded, _, err := codec2.EncodeHelloAck(challenge, ProtocolVersion)
+	if err != nil {
+		t.Fatalf("encode hello_ack failed: %v", err)
+	}
 
 	// Decode will fail due to HMAC mismatch
 	_, err = codec1.Decode(ackEncoded)
@@ -232,19 +821,19 @@ func TestHandshake_WrongKey(t *testing.T) {
 }
 
 func TestHandshake_InsecureMode(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	// In insecure mode, challenge response should always verify
 	challenge := make([]byte, ChallengeSize)
 	response := make([]byte, ChallengeRespLen)
 
-	if !codec.VerifyChallengeResponse(challenge, response) {
+	if !codec.VerifyChallengeResponse(challenge, response, ProtocolVersion) {
 		t.Error("insecure mode should always verify")
 	}
 }
 
 func TestEncodePing_Roundtrip(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	timestamp := time.Now().UnixNano()
 
 	encoded := codec.EncodePing(timestamp)
@@ -263,7 +852,7 @@ func TestEncodePing_Roundtrip(t *testing.T) {
 }
 
 func TestEncodePong_Roundtrip(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 	timestamp := time.Now().UnixNano()
 
 	encoded := codec.EncodePong(timestamp)
@@ -281,8 +870,32 @@ func TestEncodePong_Roundtrip(t *testing.T) {
 	}
 }
 
+func TestEncodePongWithClock_Roundtrip(t *testing.T) {
+	key := []byte("test-key")
+	sender, receiver := newTestCodec(key), newTestCodec(key)
+	timestamp := time.Now().UnixNano()
+	clock := timestamp + int64(1500*time.Millisecond)
+
+	msg, err := receiver.Decode(sender.EncodePongWithClock(timestamp, clock))
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if msg.Type != MsgPong || msg.Timestamp != timestamp || msg.PeerClock != clock {
+		t.Errorf("got type %s, timestamp %d, peer clock %d; want PONG, %d, %d",
+			MessageTypeName(msg.Type), msg.Timestamp, msg.PeerClock, timestamp, clock)
+	}
+
+	// A plain PONG carries no clock, and DecodeInto doesn't keep a stale one
+	if err := receiver.DecodeInto(msg, sender.EncodePong(timestamp)); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if msg.PeerClock != 0 {
+		t.Errorf("PeerClock = %d for a PONG without a clock, want 0", msg.PeerClock)
+	}
+}
+
 func TestEncodeBye_Format(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	encoded := codec.EncodeBye()
 
@@ -297,7 +910,7 @@ func TestEncodeBye_Format(t *testing.T) {
 }
 
 func TestDecode_ValidHMAC(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	frame := makeTestFrame(100)
 
 	encoded, err := codec.EncodeFrame(frame)
@@ -316,8 +929,8 @@ func TestDecode_ValidHMAC(t *testing.T) {
 }
 
 func TestDecode_InvalidHMAC(t *testing.T) {
-	codec1 := NewCodec(testKey)
-	codec2 := NewCodec([]byte("different-key!!"))
+	codec1 := newTestCodec(testKey)
+	codec2 := newTestCodec([]byte("different-key!!"))
 
 	frame := makeTestFrame(100)
 	encoded, err := codec1.EncodeFrame(frame)
@@ -331,8 +944,41 @@ func TestDecode_InvalidHMAC(t *testing.T) {
 	}
 }
 
+func TestCodecStats_CountsFailures(t *testing.T) {
+	sender := newTestCodec(testKey)
+	receiver := newTestCodec(testKey)
+	wrongKey := newTestCodec([]byte("different-key!!"))
+
+	first, _ := sender.EncodeFrame(makeTestFrame(100))
+	second, _ := sender.EncodeFrame(makeTestFrame(100))
+	forged, _ := wrongKey.EncodeFrame(makeTestFrame(100))
+
+	if _, err := receiver.Decode(first); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if _, err := receiver.Decode(second); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	receiver.Decode(first)  // replay
+	receiver.Decode(forged) // wrong key
+	receiver.Decode(forged)
+
+	want := CodecStats{HMACFailures: 2, Replays: 1}
+	if got := receiver.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	// Insecure mode: malformed messages count as decode errors.
+	insecure := newTestCodec(nil)
+	insecure.Decode(nil)
+	insecure.Decode([]byte{MsgFrame, 0x01})
+	if got := insecure.Stats(); got != (CodecStats{DecodeErrors: 2}) {
+		t.Errorf("insecure Stats() = %+v, want 2 decode errors", got)
+	}
+}
+
 func TestDecode_TamperedPayload(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	frame := makeTestFrame(100)
 
 	encoded, err := codec.EncodeFrame(frame)
@@ -350,7 +996,7 @@ func TestDecode_TamperedPayload(t *testing.T) {
 }
 
 func TestDecode_TruncatedHMAC(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 	frame := makeTestFrame(100)
 
 	encoded, err := codec.EncodeFrame(frame)
@@ -362,27 +1008,64 @@ func TestDecode_TruncatedHMAC(t *testing.T) {
 	truncated := encoded[:len(encoded)-10]
 
 	_, err = codec.Decode(truncated)
-	// Either ErrMessageTooShort (if length check fails) or ErrInvalidHMAC (if HMAC check fails)
-	if err != ErrMessageTooShort && err != ErrInvalidHMAC {
-		t.Errorf("expected ErrMessageTooShort or ErrInvalidHMAC, got %v", err)
+	if err != ErrInvalidHMAC {
+		t.Errorf("expected ErrInvalidHMAC, got %v", err)
 	}
 }
 
 func TestDecode_MessageTooShort_Secure(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 
 	// Message shorter than minimum secure header (Type + Nonce + HMAC = 41 bytes)
 	tooShort := make([]byte, 30)
 	tooShort[0] = MsgFrame
 
+	// Malformed secure messages are reported the same way as forged ones
 	_, err := codec.Decode(tooShort)
-	if err != ErrMessageTooShort {
-		t.Errorf("expected ErrMessageTooShort, got %v", err)
+	if err != ErrInvalidHMAC {
+		t.Errorf("expected ErrInvalidHMAC, got %v", err)
+	}
+}
+
+func TestDecode_MalformedSecure_SameErrorAsTampered(t *testing.T) {
+	codec := newTestCodec(testKey)
+
+	encoded, err := codec.EncodeFrame(makeTestFrame(100))
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	tampered := make([]byte, len(encoded))
+	copy(tampered, encoded)
+	tampered[20] ^= 0xFF
+
+	// A HELLO from an insecure peer: valid structure, but no nonce or HMAC
+	insecureHello, _, err := newTestCodec(nil).EncodeHello()
+	if err != nil {
+		t.Fatalf("encode hello failed: %v", err)
+	}
+
+	cases := map[string][]byte{
+		"tampered":       tampered,
+		"empty":          {},
+		"type only":      {MsgFrame},
+		"header only":    encoded[:SecureHeaderSize],
+		"one short":      encoded[:MinSecureSize-1],
+		"insecure hello": insecureHello,
+		"unknown type":   append([]byte{0xFF}, encoded[1:]...),
+		"wrong key":      mustEncodeFrame(t, newTestCodec([]byte("some-other-key!!"))),
+	}
+
+	for name, data := range cases {
+		_, err := newTestCodec(testKey).Decode(data)
+		if err != ErrInvalidHMAC {
+			t.Errorf("%s: expected ErrInvalidHMAC, got %v", name, err)
+		}
 	}
 }
 
 func TestDecode_ReplayProtection(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 
 	// Send two frames
 	frame1 := makeTestFrame(50)
@@ -410,7 +1093,7 @@ func TestDecode_ReplayProtection(t *testing.T) {
 }
 
 func TestDecode_NonceOutOfOrder(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 
 	// Create a message with high nonce
 	frame := makeTestFrame(50)
@@ -434,8 +1117,91 @@ func TestDecode_NonceOutOfOrder(t *testing.T) {
 	}
 }
 
+func TestDecodeUnordered_AcceptNonce(t *testing.T) {
+	sender := newTestCodec(testKey)
+	receiver := newTestCodec(testKey)
+
+	// Reserve nonces up front and encode in reverse, as parallel workers may
+	n1, n2 := sender.ReserveNonce(), sender.ReserveNonce()
+	second, err := sender.EncodeFrameIntoNonce(nil, makeTestFrame(64), n2)
+	if err != nil {
+		t.Fatalf("EncodeFrameIntoNonce failed: %v", err)
+	}
+	first, err := sender.EncodeFrameIntoNonce(nil, makeTestFrame(64), n1)
+	if err != nil {
+		t.Fatalf("EncodeFrameIntoNonce failed: %v", err)
+	}
+
+	// Verification doesn't depend on order; acceptance does
+	var msg Message
+	got2, err := receiver.DecodeUnordered(&msg, second)
+	if err != nil || got2 != n2 {
+		t.Fatalf("DecodeUnordered(second) = %d, %v; want %d", got2, err, n2)
+	}
+	got1, err := receiver.DecodeUnordered(&msg, first)
+	if err != nil || got1 != n1 {
+		t.Fatalf("DecodeUnordered(first) = %d, %v; want %d", got1, err, n1)
+	}
+
+	if err := receiver.AcceptNonce(MsgFrame, n1); err != nil {
+		t.Errorf("AcceptNonce(first) = %v", err)
+	}
+	if err := receiver.AcceptNonce(MsgFrame, n2); err != nil {
+		t.Errorf("AcceptNonce(second) = %v", err)
+	}
+	if err := receiver.AcceptNonce(MsgFrame, n1); err != ErrReplayDetected {
+		t.Errorf("AcceptNonce(replay) = %v, want ErrReplayDetected", err)
+	}
+	if stats := receiver.Stats(); stats.Replays != 1 {
+		t.Errorf("Replays = %d, want 1", stats.Replays)
+	}
+
+	// A forged message fails before any nonce is involved
+	first[len(first)-1] ^= 0xFF
+	if _, err := receiver.DecodeUnordered(&msg, first); err != ErrInvalidHMAC {
+		t.Errorf("DecodeUnordered(tampered) = %v, want ErrInvalidHMAC", err)
+	}
+}
+
+func TestEncodePingPongNonce(t *testing.T) {
+	sender := newTestCodec(testKey)
+	receiver := newTestCodec(testKey)
+
+	// Interleaved with frames in reservation order, nothing is a replay
+	n1, n2, n3, n4 := sender.ReserveNonce(), sender.ReserveNonce(), sender.ReserveNonce(), sender.ReserveNonce()
+	frame, _ := sender.EncodeFrameIntoNonce(nil, makeTestFrame(64), n1)
+	messages := [][]byte{
+		frame,
+		sender.EncodePingNonce(42, n2),
+		sender.EncodePongNonce(42, 0, n3),
+		sender.EncodePongNonce(42, 1234, n4),
+	}
+	want := []Message{
+		{Type: MsgFrame},
+		{Type: MsgPing, Timestamp: 42},
+		{Type: MsgPong, Timestamp: 42},
+		{Type: MsgPong, Timestamp: 42, PeerClock: 1234},
+	}
+	for i, data := range messages {
+		msg, err := receiver.Decode(data)
+		if err != nil {
+			t.Fatalf("message %d: Decode failed: %v", i, err)
+		}
+		if msg.Type != want[i].Type || msg.Timestamp != want[i].Timestamp || msg.PeerClock != want[i].PeerClock {
+			t.Errorf("message %d = %s ts=%d clock=%d, want %s ts=%d clock=%d", i,
+				MessageTypeName(msg.Type), msg.Timestamp, msg.PeerClock,
+				MessageTypeName(want[i].Type), want[i].Timestamp, want[i].PeerClock)
+		}
+	}
+
+	// A plain PONG is the same size as EncodePong's
+	if got, want := len(sender.EncodePongNonce(1, 0, sender.ReserveNonce())), len(sender.EncodePong(1)); got != want {
+		t.Errorf("EncodePongNonce without a clock is %d bytes, EncodePong %d", got, want)
+	}
+}
+
 func TestResetRecvNonce(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 
 	frame := makeTestFrame(50)
 	encoded, _ := codec.EncodeFrame(frame)
@@ -456,7 +1222,7 @@ func TestResetRecvNonce(t *testing.T) {
 	codec.ResetRecvNonce()
 
 	// Now create a new codec to encode (simulate reconnect)
-	codec2 := NewCodec(testKey)
+	codec2 := newTestCodec(testKey)
 	frame2 := makeTestFrame(50)
 	encoded2, _ := codec2.EncodeFrame(frame2)
 
@@ -468,9 +1234,9 @@ func TestResetRecvNonce(t *testing.T) {
 }
 
 func TestDecode_HelloAllowsSessionRestartedNonce(t *testing.T) {
-	listener := NewCodec(testKey)
+	listener := newTestCodec(testKey)
 
-	peer1 := NewCodec(testKey)
+	peer1 := newTestCodec(testKey)
 	hello1, _, err := peer1.EncodeHello()
 	if err != nil {
 		t.Fatalf("first hello encode failed: %v", err)
@@ -480,7 +1246,7 @@ func TestDecode_HelloAllowsSessionRestartedNonce(t *testing.T) {
 	}
 
 	// Simulate peer restart: sender nonce returns to 1.
-	peer2 := NewCodec(testKey)
+	peer2 := newTestCodec(testKey)
 	hello2, _, err := peer2.EncodeHello()
 	if err != nil {
 		t.Fatalf("second hello encode failed: %v", err)
@@ -491,25 +1257,25 @@ func TestDecode_HelloAllowsSessionRestartedNonce(t *testing.T) {
 }
 
 func TestDecode_HelloAckAllowsSessionRestartedNonce(t *testing.T) {
-	client := NewCodec(testKey)
+	client := newTestCodec(testKey)
 
-	server1 := NewCodec(testKey)
+	server1 := newTestCodec(testKey)
 	challenge := make([]byte, ChallengeSize)
-	ack1 := server1.EncodeHelloAck(challenge)
+	ack1, _, _ := server1.EncodeHelloAck(challenge, ProtocolVersion)
 	if _, err := client.Decode(ack1); err != nil {
 		t.Fatalf("first hello_ack decode failed: %v", err)
 	}
 
 	// Simulate peer restart: sender nonce returns to 1.
-	server2 := NewCodec(testKey)
-	ack2 := server2.EncodeHelloAck(challenge)
+	server2 := newTestCodec(testKey)
+	ack2, _, _ := server2.EncodeHelloAck(challenge, ProtocolVersion)
 	if _, err := client.Decode(ack2); err != nil {
 		t.Fatalf("second hello_ack decode failed after peer restart: %v", err)
 	}
 }
 
 func TestDecode_EmptyMessage(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	_, err := codec.Decode([]byte{})
 	if err != ErrMessageTooShort {
@@ -518,7 +1284,7 @@ func TestDecode_EmptyMessage(t *testing.T) {
 }
 
 func TestDecode_UnknownType(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	// Create message with unknown type
 	msg := []byte{0xFF}
@@ -530,7 +1296,7 @@ func TestDecode_UnknownType(t *testing.T) {
 }
 
 func TestDecode_InvalidFramePayload_TooSmall(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	// Create a FRAME message with too-small payload
 	msg := make([]byte, 1+10) // Type + 10 bytes (less than MinEthernetFrame)
@@ -543,7 +1309,7 @@ func TestDecode_InvalidFramePayload_TooSmall(t *testing.T) {
 }
 
 func TestDecode_InvalidPingPayload(t *testing.T) {
-	codec := NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	// Create a PING message with too-small payload
 	msg := make([]byte, 1+4) // Type + 4 bytes (less than 8)
@@ -555,6 +1321,126 @@ func TestDecode_InvalidPingPayload(t *testing.T) {
 	}
 }
 
+func TestEncodeModeMismatch_DecodesInBothModes(t *testing.T) {
+	data := EncodeModeMismatch()
+
+	for _, codec := range []*Codec{newTestCodec(testKey), newTestCodec(nil)} {
+		msg, err := codec.Decode(data)
+		if err != nil {
+			t.Fatalf("Decode (secure=%v) failed: %v", codec.IsSecure(), err)
+		}
+		if msg.Type != MsgError {
+			t.Errorf("type = %s, want ERROR", MessageTypeName(msg.Type))
+		}
+		if msg.ErrorCode != ErrorCodeModeMismatch {
+			t.Errorf("error code = %d, want %d", msg.ErrorCode, ErrorCodeModeMismatch)
+		}
+	}
+}
+
+func TestEncodeError_Roundtrip(t *testing.T) {
+	for _, codec := range []*Codec{newTestCodec(testKey), newTestCodec(nil)} {
+		msg, err := codec.Decode(EncodeError(ErrorCodeVersionUnsupported, "expected protocol version 1"))
+		if err != nil {
+			t.Fatalf("Decode (secure=%v) failed: %v", codec.IsSecure(), err)
+		}
+		if msg.ErrorCode != ErrorCodeVersionUnsupported {
+			t.Errorf("error code = %d, want %d", msg.ErrorCode, ErrorCodeVersionUnsupported)
+		}
+		if msg.ErrorMsg != "expected protocol version 1" {
+			t.Errorf("error msg = %q", msg.ErrorMsg)
+		}
+	}
+}
+
+func TestDecodeInto_RejectsUnsignedErrorInSecureMode(t *testing.T) {
+	data := EncodeError(ErrorCodeRateLimited, "go away")
+
+	// Outside the handshake an unsigned ERROR could be anyone's
+	secure := newTestCodec(testKey)
+	var msg Message
+	if err := secure.DecodeInto(&msg, data); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("DecodeInto (secure) = %v, want ErrInvalidHMAC", err)
+	}
+	if _, err := secure.DecodeUnordered(&msg, data); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("DecodeUnordered (secure) = %v, want ErrInvalidHMAC", err)
+	}
+	if got := secure.Stats().HMACFailures; got != 2 {
+		t.Errorf("HMACFailures = %d, want 2", got)
+	}
+
+	// Nothing is authenticated in insecure mode
+	if err := newTestCodec(nil).DecodeInto(&msg, data); err != nil || msg.Type != MsgError {
+		t.Errorf("DecodeInto (insecure) = %s, %v, want ERROR", MessageTypeName(msg.Type), err)
+	}
+}
+
+func TestEncodeError_TruncatesLongMessage(t *testing.T) {
+	long := strings.Repeat("x", MaxErrorMsgLen+10)
+	msg, err := newTestCodec(nil).Decode(EncodeError(ErrorCodeRateLimited, long))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if len(msg.ErrorMsg) != MaxErrorMsgLen {
+		t.Errorf("error msg length = %d, want %d", len(msg.ErrorMsg), MaxErrorMsgLen)
+	}
+}
+
+func TestDecode_ErrorMessageTooLong(t *testing.T) {
+	data := append(EncodeError(ErrorCodeRateLimited, ""), make([]byte, MaxErrorMsgLen+1)...)
+	_, err := newTestCodec(nil).Decode(data)
+	if !errors.Is(err, ErrInvalidPayload) {
+		t.Errorf("expected ErrInvalidPayload, got %v", err)
+	}
+}
+
+func TestDecode_ErrorMessageSanitized(t *testing.T) {
+	msg, err := newTestCodec(nil).Decode(EncodeError(99, "bad\n\x1b[31mnews\xff"))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if msg.ErrorMsg != "bad??[31mnews?" {
+		t.Errorf("error msg = %q, want control bytes replaced", msg.ErrorMsg)
+	}
+	if got := ErrorCodeName(99); got != "error 99" {
+		t.Errorf("ErrorCodeName(99) = %q", got)
+	}
+}
+
+func TestIsModeMismatchHello(t *testing.T) {
+	secure := newTestCodec(testKey)
+	insecure := newTestCodec(nil)
+
+	secureHello, _, _ := newTestCodec(testKey).EncodeHello()
+	insecureHello, _, _ := newTestCodec(nil).EncodeHello()
+
+	// Each side must fail to decode the other's HELLO before the check applies
+	if _, err := secure.Decode(insecureHello); err == nil {
+		t.Fatal("secure codec decoded an insecure HELLO")
+	}
+	if _, err := insecure.Decode(secureHello); err == nil {
+		t.Fatal("insecure codec decoded a signed HELLO")
+	}
+
+	if !secure.IsModeMismatchHello(insecureHello) {
+		t.Error("secure codec should flag insecure HELLO")
+	}
+	if !insecure.IsModeMismatchHello(secureHello) {
+		t.Error("insecure codec should flag signed HELLO")
+	}
+
+	// Same-mode HELLOs and other traffic are not mismatches
+	if secure.IsModeMismatchHello(secureHello) {
+		t.Error("secure codec flagged its own HELLO format")
+	}
+	if insecure.IsModeMismatchHello(insecureHello) {
+		t.Error("insecure codec flagged its own HELLO format")
+	}
+	if secure.IsModeMismatchHello(newTestCodec(nil).EncodeBye()) {
+		t.Error("insecure BYE flagged as HELLO")
+	}
+}
+
 func TestMessageTypeName_AllTypes(t *testing.T) {
 	tests := []struct {
 		msgType  byte
@@ -566,6 +1452,7 @@ func TestMessageTypeName_AllTypes(t *testing.T) {
 		{MsgPing, "PING"},
 		{MsgPong, "PONG"},
 		{MsgBye, "BYE"},
+		{MsgError, "ERROR"},
 	}
 
 	for _, tt := range tests {
@@ -585,19 +1472,48 @@ func TestMessageTypeName_Unknown(t *testing.T) {
 }
 
 func TestVerifyChallengeResponse_InvalidLengths(t *testing.T) {
-	codec := NewCodec(testKey)
+	codec := newTestCodec(testKey)
 
 	// Wrong challenge length
-	if codec.VerifyChallengeResponse(make([]byte, 5), make([]byte, ChallengeRespLen)) {
+	if codec.VerifyChallengeResponse(make([]byte, 5), make([]byte, ChallengeRespLen), ProtocolVersion) {
 		t.Error("should reject wrong challenge length")
 	}
 
 	// Wrong response length
-	if codec.VerifyChallengeResponse(make([]byte, ChallengeSize), make([]byte, 5)) {
+	if codec.VerifyChallengeResponse(make([]byte, ChallengeSize), make([]byte, 5), ProtocolVersion) {
 		t.Error("should reject wrong response length")
 	}
 }
 
+// mustEncodeFrame encodes a 64-byte test frame with codec.
+func mustEncodeFrame(t *testing.T, codec *Codec) []byte {
+	t.Helper()
+	encoded, err := codec.EncodeFrame(makeTestFrame(64))
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	return encoded
+}
+
+func TestSelfTest(t *testing.T) {
+	for _, key := range [][]byte{nil, testKey} {
+		result, err := SelfTest(key, 64, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("SelfTest(secure=%t) error: %v", key != nil, err)
+		}
+		if result.Secure != (key != nil) || result.FrameSize != 64 {
+			t.Errorf("result = %+v, want secure=%t frame size 64", result, key != nil)
+		}
+		if result.Frames == 0 || result.FramesPerSec() <= 0 || result.PerFrame() <= 0 {
+			t.Errorf("result = %+v, want frames measured", result)
+		}
+	}
+
+	if _, err := SelfTest(nil, MaxFrameSize+1, time.Millisecond); err == nil {
+		t.Error("expected an error for an oversized frame")
+	}
+}
+
 // Helper function to create a test frame
 func makeTestFrame(size int) []byte {
 	frame := make([]byte, size)
@@ -611,3 +1527,17 @@ func makeTestFrame(size int) []byte {
 	}
 	return frame
 }
+
+func TestAESCapability(t *testing.T) {
+	name, ok := HardwareAES()
+	if name == "" {
+		t.Fatal("HardwareAES returned no name")
+	}
+	got := AESCapability()
+	if !strings.HasPrefix(got, name+": ") {
+		t.Errorf("AESCapability() = %q, want prefix %q", got, name+": ")
+	}
+	if strings.Contains(got, "not available") == ok {
+		t.Errorf("AESCapability() = %q but HardwareAES reported %t", got, ok)
+	}
+}
@@ -0,0 +1,52 @@
+// Package ratelimit provides the token bucket behind the handshake limiter
+// and xbox-sim's traffic pacing.
+package ratelimit
+
+import "time"
+
+// Bucket is a token bucket holding up to burst tokens, refilled at rate
+// tokens per second. Callers pass the current time, so tests can drive it
+// with a fake clock. It is not safe for concurrent use.
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// New creates a full Bucket.
+func New(rate, burst float64, now time.Time) *Bucket {
+	return &Bucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// refill adds the tokens earned since the last call, up to burst.
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.last = now
+		b.tokens = min(b.tokens+elapsed.Seconds()*b.rate, b.burst)
+	}
+}
+
+// Allow takes n tokens and returns true if the bucket holds that many at
+// now; otherwise it takes nothing and returns false.
+func (b *Bucket) Allow(now time.Time, n float64) bool {
+	b.refill(now)
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// Wait takes n tokens, going into debt if the bucket holds fewer, and returns
+// how long from now until the debt is paid off (0 if there is none). A
+// sender that sleeps that long after each send holds the rate on average,
+// even for n larger than burst.
+func (b *Bucket) Wait(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket_Allow(t *testing.T) {
+	now := time.Now()
+	b := New(10, 5, now)
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if b.Allow(now, 1) {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("allowed %d in a burst, want 5", allowed)
+	}
+	if b.Allow(now, 1) {
+		t.Error("allowed with the bucket empty")
+	}
+
+	now = now.Add(100 * time.Millisecond) // One token at 10/s
+	if !b.Allow(now, 1) {
+		t.Error("expected a token after 100ms")
+	}
+	if b.Allow(now, 1) {
+		t.Error("allowed more than refilled")
+	}
+
+	now = now.Add(time.Hour)
+	if b.Allow(now, 6) {
+		t.Error("allowed more than the burst after refilling")
+	}
+	if !b.Allow(now, 5) {
+		t.Error("expected a full bucket after an hour")
+	}
+
+	// A clock going backwards refills nothing
+	if b.Allow(now.Add(-time.Minute), 1) {
+		t.Error("allowed after the clock went back")
+	}
+}
+
+func TestBucket_Wait(t *testing.T) {
+	now := time.Now()
+	b := New(1000, 100, now) // 1000 tokens/s
+
+	if d := b.Wait(now, 100); d != 0 {
+		t.Errorf("Wait() within the burst = %v, want 0", d)
+	}
+	if d := b.Wait(now, 50); d != 50*time.Millisecond {
+		t.Errorf("Wait() 50 tokens over = %v, want 50ms", d)
+	}
+
+	// Larger than the burst: the debt is paid off at the rate
+	now = now.Add(50 * time.Millisecond)
+	if d := b.Wait(now, 500); d != 500*time.Millisecond {
+		t.Errorf("Wait() 500 tokens = %v, want 500ms", d)
+	}
+
+	// A sender sleeping as told holds the rate: 10s of 100-token sends
+	start := now.Add(500 * time.Millisecond)
+	now = start
+	sent := 0
+	for now.Before(start.Add(10 * time.Second)) {
+		now = now.Add(b.Wait(now, 100))
+		sent += 100
+	}
+	if sent < 9900 || sent > 10200 {
+		t.Errorf("paced sender sent %d tokens in 10s, want about 10000", sent)
+	}
+}
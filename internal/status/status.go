@@ -0,0 +1,178 @@
+// Package status serves the bridge's state as JSON over HTTP, for dashboards
+// and health checks.
+package status
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/bridge"
+)
+
+// ShutdownTimeout bounds how long Close waits for requests in flight.
+const ShutdownTimeout = 2 * time.Second
+
+// Source is the part of *bridge.Bridge that the status endpoints read.
+type Source interface {
+	State() bridge.State
+	PeerAddr() net.Addr
+	GetStats() *bridge.Stats
+}
+
+// Report is the body of /stats.json.
+type Report struct {
+	State     string  `json:"state"`
+	Peer      string  `json:"peer,omitempty"`
+	RTTMs     float64 `json:"rtt_ms"`
+	RTTAvgMs  float64 `json:"rtt_avg_ms"`
+	Quality   string  `json:"quality,omitempty"` // good, fair, poor or unknown
+	Score     int     `json:"quality_score"`
+	TxPackets uint64  `json:"tx_packets"`
+	TxBytes   uint64  `json:"tx_bytes"`
+	RxPackets uint64  `json:"rx_packets"`
+	RxBytes   uint64  `json:"rx_bytes"`
+	UptimeSec float64 `json:"uptime_sec"`
+}
+
+// Handler serves /healthz and /stats.json for the current session's bridge.
+// A new bridge is created for every reconnect, so the caller swaps it in
+// with Set; until then the bridge reports as DISCONNECTED.
+type Handler struct {
+	source atomic.Pointer[Source]
+	mux    *http.ServeMux
+}
+
+// NewHandler creates a Handler with no bridge set.
+func NewHandler() *Handler {
+	h := &Handler{mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /healthz", h.healthz)
+	h.mux.HandleFunc("GET /stats.json", h.stats)
+	return h
+}
+
+// Set makes src the bridge the endpoints report on.
+func (h *Handler) Set(src Source) {
+	h.source.Store(&src)
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Report builds the /stats.json body from the current bridge.
+func (h *Handler) Report() Report {
+	p := h.source.Load()
+	if p == nil {
+		return Report{State: bridge.StateDisconnected.String()}
+	}
+	src := *p
+
+	report := Report{State: src.State().String()}
+	if addr := src.PeerAddr(); addr != nil {
+		report.Peer = addr.String()
+	}
+	stats := src.GetStats()
+	quality := stats.QualityScore()
+	report.Quality, report.Score = quality.Label, quality.Score
+	snap := stats.Snapshot()
+	report.RTTMs = float64(snap.RTTCurrent) / float64(time.Millisecond)
+	report.RTTAvgMs = float64(snap.RTTAvg) / float64(time.Millisecond)
+	report.TxPackets = snap.TxPackets
+	report.TxBytes = snap.TxBytes
+	report.RxPackets = snap.RxPackets
+	report.RxBytes = snap.RxBytes
+	if !snap.StartTime.IsZero() {
+		report.UptimeSec = time.Since(snap.StartTime).Seconds()
+	}
+	return report
+}
+
+// healthz answers 200 while a peer is connected, 503 otherwise.
+func (h *Handler) healthz(w http.ResponseWriter, _ *http.Request) {
+	state := h.Report().State
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if state != bridge.StateConnected.String() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write([]byte(state + "\n"))
+}
+
+// stats writes the Report as JSON.
+func (h *Handler) stats(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Report())
+}
+
+// RequireToken wraps h so that every request must carry
+// "Authorization: Bearer <token>", for serving the endpoints beyond
+// localhost or behind a reverse proxy. Other requests get 401.
+func RequireToken(token string, h http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(strings.TrimSpace(r.Header.Get("Authorization")))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="xbslink-ng"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Server is a running status HTTP server.
+type Server struct {
+	srv *http.Server
+	ln  net.Listener
+}
+
+// Start listens on addr and serves h in the background. A bare port such as
+// ":8080" binds to localhost only; give a host (e.g. "0.0.0.0:8080") to
+// serve other machines. Bind errors are returned here rather than from the
+// background goroutine.
+func Start(addr string, h http.Handler) (*Server, error) {
+	ln, err := net.Listen("tcp", listenAddr(addr))
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		srv: &http.Server{Handler: h, ReadHeaderTimeout: 5 * time.Second},
+		ln:  ln,
+	}
+	go s.srv.Serve(ln)
+	return s, nil
+}
+
+// listenAddr binds a bare port to localhost.
+func listenAddr(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return addr
+}
+
+// Local reports whether the server only accepts connections from this
+// machine.
+func (s *Server) Local() bool {
+	addr, ok := s.ln.Addr().(*net.TCPAddr)
+	return ok && addr.IP.IsLoopback()
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Close stops the server, waiting up to ShutdownTimeout for requests in
+// flight.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
+}
@@ -0,0 +1,155 @@
+package status
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/bridge"
+)
+
+// fakeSource is a Source with fixed values.
+type fakeSource struct {
+	state bridge.State
+	peer  net.Addr
+	stats *bridge.Stats
+}
+
+func (f *fakeSource) State() bridge.State     { return f.state }
+func (f *fakeSource) PeerAddr() net.Addr      { return f.peer }
+func (f *fakeSource) GetStats() *bridge.Stats { return f.stats }
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestHandler_NoBridge(t *testing.T) {
+	h := NewHandler()
+
+	if rec := get(t, h, "/healthz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz = %d, want 503 before any bridge", rec.Code)
+	}
+	var report Report
+	rec := get(t, h, "/stats.json")
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("/stats.json: %v", err)
+	}
+	if report.State != "DISCONNECTED" {
+		t.Errorf("state = %q, want DISCONNECTED", report.State)
+	}
+}
+
+func TestHandler_Connected(t *testing.T) {
+	stats := &bridge.Stats{TxPackets: 12, TxBytes: 1200, RxPackets: 7, RxBytes: 700}
+	stats.SetStartTime(time.Now().Add(-time.Minute))
+	stats.AddRTTSample(25 * time.Millisecond)
+	src := &fakeSource{
+		state: bridge.StateConnected,
+		peer:  &net.UDPAddr{IP: net.ParseIP("203.0.113.50"), Port: 31415},
+		stats: stats,
+	}
+	h := NewHandler()
+	h.Set(src)
+
+	if rec := get(t, h, "/healthz"); rec.Code != http.StatusOK || rec.Body.String() != "CONNECTED\n" {
+		t.Errorf("/healthz = %d %q, want 200 CONNECTED", rec.Code, rec.Body.String())
+	}
+
+	rec := get(t, h, "/stats.json")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var report Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("/stats.json: %v", err)
+	}
+	if report.State != "CONNECTED" || report.Peer != "203.0.113.50:31415" ||
+		report.TxPackets != 12 || report.TxBytes != 1200 || report.RxPackets != 7 || report.RxBytes != 700 {
+		t.Errorf("report = %+v", report)
+	}
+	if report.RTTMs != 25 {
+		t.Errorf("rtt_ms = %v, want 25", report.RTTMs)
+	}
+	if report.Quality != "good" || report.Score != 97 {
+		t.Errorf("quality = %q %d, want good 97", report.Quality, report.Score)
+	}
+	if report.UptimeSec < 60 {
+		t.Errorf("uptime_sec = %v, want at least 60", report.UptimeSec)
+	}
+
+	src.state = bridge.StateConnecting
+	if rec := get(t, h, "/healthz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz while connecting = %d, want 503", rec.Code)
+	}
+	if rec := get(t, h, "/nope"); rec.Code != http.StatusNotFound {
+		t.Errorf("/nope = %d, want 404", rec.Code)
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	h := RequireToken("s3cret", NewHandler())
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		{"token prefix", "Bearer s3cre", http.StatusUnauthorized},
+		{"not bearer", "Basic s3cret", http.StatusUnauthorized},
+		{"valid", "Bearer s3cret", http.StatusServiceUnavailable}, // no bridge yet
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: /healthz = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without WWW-Authenticate", tt.name)
+		}
+	}
+	if rec := get(t, h, "/stats.json"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("/stats.json without a token = %d, want 401", rec.Code)
+	}
+}
+
+func TestServer_StartClose(t *testing.T) {
+	srv, err := Start(":0", NewHandler())
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	if !srv.Local() {
+		t.Errorf("bare port bound to %s, want localhost only", srv.Addr())
+	}
+	resp, err := http.Get("http://" + srv.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/healthz = %d, want 503", resp.StatusCode)
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+	if _, err := http.Get("http://" + srv.Addr().String() + "/healthz"); err == nil {
+		t.Error("server still answering after Close")
+	}
+	again, err := Start(srv.Addr().String(), NewHandler())
+	if err != nil {
+		t.Fatalf("address not released after Close: %v", err)
+	}
+	again.Close()
+}
@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"net"
+	"runtime"
+
+	"golang.org/x/net/ipv4"
+)
+
+// DefaultBatchSize is the number of datagrams a BatchReader pulls per syscall.
+const DefaultBatchSize = 32
+
+// BatchSupported reports whether batched socket I/O is available on this
+// platform. x/net implements ReadBatch/WriteBatch everywhere except Windows;
+// it uses recvmmsg/sendmmsg on Linux and one datagram per call elsewhere.
+func BatchSupported() bool {
+	return runtime.GOOS != "windows"
+}
+
+// BatchReader receives several datagrams per syscall (recvmmsg on Linux).
+// Each datagram is read into its own buffer, so the data returned by
+// Datagram stays valid until the next Read. It is not safe for concurrent use.
+type BatchReader struct {
+	t    *Transport
+	pc   *ipv4.PacketConn
+	msgs []ipv4.Message
+}
+
+// NewBatchReader creates a reader that receives up to size datagrams of at
+// most bufSize bytes per Read. Callers should check BatchSupported first.
+func (t *Transport) NewBatchReader(size, bufSize int) *BatchReader {
+	msgs := make([]ipv4.Message, size)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, bufSize)}
+	}
+	return &BatchReader{
+		t:    t,
+		pc:   ipv4.NewPacketConn(t.conn),
+		msgs: msgs,
+	}
+}
+
+// Read blocks until at least one datagram arrives (or the transport's read
+// deadline expires) and returns how many were received.
+func (r *BatchReader) Read() (int, error) {
+	r.t.mu.RLock()
+	if r.t.closed {
+		r.t.mu.RUnlock()
+		return 0, ErrClosed
+	}
+	r.t.mu.RUnlock()
+
+	return r.pc.ReadBatch(r.msgs, 0)
+}
+
+// Datagram returns the data and sender of the i-th datagram from the last Read.
+func (r *BatchReader) Datagram(i int) ([]byte, *net.UDPAddr) {
+	m := &r.msgs[i]
+	addr, _ := m.Addr.(*net.UDPAddr)
+	return m.Buffers[0][:m.N], addr
+}
+
+// BatchWriter sends several datagrams to the peer per syscall (sendmmsg on
+// Linux). It is not safe for concurrent use.
+type BatchWriter struct {
+	t    *Transport
+	pc   *ipv4.PacketConn
+	msgs []ipv4.Message
+}
+
+// NewBatchWriter creates a writer that sends up to size datagrams per syscall.
+// Callers should check BatchSupported first.
+func (t *Transport) NewBatchWriter(size int) *BatchWriter {
+	msgs := make([]ipv4.Message, size)
+	for i := range msgs {
+		msgs[i].Buffers = make([][]byte, 1)
+	}
+	return &BatchWriter{
+		t:    t,
+		pc:   ipv4.NewPacketConn(t.conn),
+		msgs: msgs,
+	}
+}
+
+// Write sends datagrams to the peer in order and returns how many were sent.
+// Batches larger than the writer's size, and partial batch writes, are
+// continued with further syscalls; on error the datagrams after the returned
+// count were not sent. A full send buffer is reported as ErrSendCongested.
+func (w *BatchWriter) Write(datagrams [][]byte) (int, error) {
+	w.t.mu.RLock()
+	if w.t.closed {
+		w.t.mu.RUnlock()
+		return 0, ErrClosed
+	}
+	if !w.t.connected {
+		w.t.mu.RUnlock()
+		return 0, ErrNotConnected
+	}
+	peerAddr := w.t.peerAddr
+	w.t.mu.RUnlock()
+
+	w.t.beginSend()
+	defer w.t.endSend()
+
+	sent := 0
+	for sent < len(datagrams) {
+		count := len(datagrams) - sent
+		if count > len(w.msgs) {
+			count = len(w.msgs)
+		}
+		for i := 0; i < count; i++ {
+			w.msgs[i].Buffers[0] = datagrams[sent+i]
+			w.msgs[i].Addr = peerAddr
+		}
+
+		n, err := w.pc.WriteBatch(w.msgs[:count], 0)
+		sent += n
+		if err != nil {
+			return sent, classifySendError(err)
+		}
+	}
+	return sent, nil
+}
@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Conn is a link to a single peer that carries encoded protocol messages.
+// The bridge depends only on Conn, so the raw UDP Transport is one backend
+// among possible others (e.g. a QUIC or WebRTC datachannel backend that rides
+// over NAT traversal instead of needing a forwarded port).
+type Conn interface {
+	// WaitForPeer blocks until a peer completes the handshake (listen mode).
+	WaitForPeer(ctx context.Context) error
+	// Connect performs the handshake with the configured peer (connect mode).
+	Connect(ctx context.Context) error
+	// Send sends one encoded message to the peer.
+	Send(data []byte) error
+	// Recv reads one encoded message into buf and returns its length and source.
+	// Connection-oriented backends return ErrPeerClosed when the peer hangs up.
+	Recv(buf []byte) (int, net.Addr, error)
+	// SetReadDeadline bounds Recv; an expired deadline returns a net.Error
+	// whose Timeout method reports true.
+	SetReadDeadline(deadline time.Time) error
+	// SendBye tells the peer we are disconnecting.
+	SendBye() error
+	// PeerAddr returns the connected peer's address, or nil before the handshake.
+	PeerAddr() net.Addr
+	// PeerInfo returns what was negotiated with the peer during the handshake.
+	PeerInfo() PeerInfo
+	// HandshakeFailures returns the number of rejected handshake attempts.
+	HandshakeFailures() uint64
+	// Close closes the connection.
+	Close() error
+}
+
+// PeerInfo describes what was negotiated with the connected peer.
+type PeerInfo struct {
+	Version uint16 // Protocol version from the peer's HELLO or HELLO_ACK
+	Secure  bool   // Messages are authenticated with HMAC-SHA256 (--key on both sides)
+
+	// FrameCRC is set when frames carry a CRC-32 (--frame-crc on both sides)
+	FrameCRC bool
+}
+
+// Mode returns "secure" or "insecure".
+func (p PeerInfo) Mode() string {
+	if p.Secure {
+		return "secure"
+	}
+	return "insecure"
+}
+
+// String summarizes the session for logs, e.g. "protocol v1, secure (HMAC-SHA256)".
+func (p PeerInfo) String() string {
+	var s string
+	if p.Secure {
+		s = fmt.Sprintf("protocol v%d, secure (HMAC-SHA256)", p.Version)
+	} else {
+		s = fmt.Sprintf("protocol v%d, insecure (no --key, unauthenticated)", p.Version)
+	}
+	if p.FrameCRC {
+		s += ", frame CRC"
+	}
+	return s
+}
+
+// BatchConn is a Conn that can move several datagrams per syscall.
+// Callers should type-assert for it and fall back to Send/Recv otherwise.
+type BatchConn interface {
+	Conn
+	NewBatchReader(size, bufSize int) *BatchReader
+	NewBatchWriter(size int) *BatchWriter
+}
+
+// Transport is the raw UDP backend.
+var _ BatchConn = (*Transport)(nil)
+
+// Backend selects the Conn implementation.
+type Backend string
+
+// Available backends.
+const (
+	BackendUDP Backend = "udp" // Raw UDP (Transport), the default
+	BackendTCP Backend = "tcp" // Length-prefixed TCP stream (TCPTransport)
+)
+
+// ParseBackend parses a --transport value.
+// Valid values: udp, tcp (case-insensitive).
+func ParseBackend(s string) (Backend, error) {
+	switch b := Backend(strings.ToLower(strings.TrimSpace(s))); b {
+	case BackendUDP, BackendTCP:
+		return b, nil
+	default:
+		return "", fmt.Errorf("invalid transport %q (valid: udp, tcp)", s)
+	}
+}
+
+// MaxUDPMessageSize is the largest UDP payload over IPv4 (65535 minus the
+// 20-byte IP and 8-byte UDP headers).
+const MaxUDPMessageSize = 65507
+
+// MaxMessageSize returns the largest protocol message backend can carry.
+func MaxMessageSize(backend Backend) int {
+	if backend == BackendTCP {
+		return MaxTCPMessageSize
+	}
+	return MaxUDPMessageSize
+}
+
+// Open creates a Conn using the given backend.
+func Open(backend Backend, cfg Config) (Conn, error) {
+	switch backend {
+	case BackendTCP:
+		return NewTCP(cfg)
+	case BackendUDP, "":
+		return New(cfg)
+	default:
+		return nil, fmt.Errorf("unknown transport backend %q", backend)
+	}
+}
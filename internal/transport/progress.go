@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/events"
+	"github.com/xbslink/xbslink-ng/internal/logging"
+)
+
+// Progress reporting while waiting for a peer.
+const (
+	// DefaultWaitProgress is a sensible Config.WaitProgress, the
+	// --wait-progress default.
+	DefaultWaitProgress = 30 * time.Second
+	// WaitHintAfter is how long WaitForPeer waits before its reports add a
+	// hint about port forwarding.
+	WaitHintAfter = time.Minute
+	// progressPoll bounds how late a report can be.
+	progressPoll = time.Second
+)
+
+// stateConnecting is the state_changed state reported while waiting; it
+// matches bridge.StateConnecting, which owns the state names.
+const stateConnecting = "CONNECTING"
+
+// waitProgress tells the user, every interval, that WaitForPeer is still
+// waiting and for how long, since otherwise a listener whose peer can't get
+// through is silent.
+type waitProgress struct {
+	logger   *logging.Logger
+	emitter  events.Emitter
+	interval time.Duration // 0 = never report
+	ports    string        // e.g. "UDP port 31415", for the hint
+	now      func() time.Time
+	start    time.Time
+	next     time.Time
+}
+
+func newWaitProgress(interval time.Duration, ports string, logger *logging.Logger, emitter events.Emitter, now func() time.Time) *waitProgress {
+	start := now()
+	return &waitProgress{
+		logger:   logger,
+		emitter:  emitter,
+		interval: interval,
+		ports:    ports,
+		now:      now,
+		start:    start,
+		next:     start.Add(interval),
+	}
+}
+
+// ticks returns a ticker channel to call check on, and a function to stop
+// it. The channel is nil, so never fires, when reports are off.
+func (p *waitProgress) ticks() (<-chan time.Time, func()) {
+	if p.interval <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(min(progressPoll, p.interval))
+	return ticker.C, ticker.Stop
+}
+
+// check reports progress if a report is due, and reports whether it did.
+func (p *waitProgress) check() bool {
+	if p.interval <= 0 {
+		return false
+	}
+	now := p.now()
+	if now.Before(p.next) {
+		return false
+	}
+	for !p.next.After(now) {
+		p.next = p.next.Add(p.interval)
+	}
+
+	waited := now.Sub(p.start)
+	if waited >= WaitHintAfter {
+		p.logger.Info("Still waiting for a peer after %v; check that %s is forwarded to this machine and that the peer is using your public IP",
+			waited.Round(time.Second), p.ports)
+	} else {
+		p.logger.Info("Still waiting for a peer after %v...", waited.Round(time.Second))
+	}
+	p.emitter.Emit(events.EventStateChanged, events.StateChangedData{
+		State:      stateConnecting,
+		WaitingSec: waited.Seconds(),
+	})
+	return true
+}
+
+// describePorts formats the ports of addrs as "UDP port 31415" or
+// "UDP ports 31415, 443".
+func describePorts(network string, addrs ...net.Addr) string {
+	ports := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) == 1 {
+		return fmt.Sprintf("%s port %s", network, ports[0])
+	}
+	return fmt.Sprintf("%s ports %s", network, strings.Join(ports, ", "))
+}
@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"net"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/ratelimit"
+)
+
+// Handshake rate limiting constants (listen mode).
+const (
+	// HandshakeRateLimit is the maximum number of packets per second WaitForPeer
+	// will process (decode/verify) across all sources.
+	HandshakeRateLimit = 100
+	// MaxHandshakeFailures is the number of bad packets a single source may send
+	// within HandshakeFailureWindow before it is temporarily ignored.
+	MaxHandshakeFailures = 10
+	// HandshakeFailureWindow is the window over which per-source failures are counted.
+	HandshakeFailureWindow = 10 * time.Second
+	// HandshakeBlockDuration is how long a source is ignored after too many failures.
+	HandshakeBlockDuration = 30 * time.Second
+	// HandshakeReplyInterval is the minimum interval between BYE replies to one source.
+	HandshakeReplyInterval = 1 * time.Second
+	// HandshakeEventInterval is the minimum interval between handshake failure
+	// events for one source; failures in between are counted in the next one.
+	HandshakeEventInterval = 10 * time.Second
+	// maxTrackedSources bounds the limiter's memory when flooded from many addresses.
+	maxTrackedSources = 1024
+)
+
+// sourceState tracks handshake behaviour for a single source IP.
+type sourceState struct {
+	failures     int
+	windowStart  time.Time
+	blockedUntil time.Time
+	lastReply    time.Time
+	lastEvent    time.Time
+	suppressed   int // Failure events withheld since lastEvent
+}
+
+// handshakeLimiter throttles handshake processing in listen mode.
+// It caps the global processing rate (token bucket) and temporarily ignores
+// sources that keep sending packets that fail to decode. It is only used from
+// the WaitForPeer goroutine and is not safe for concurrent use.
+type handshakeLimiter struct {
+	sources map[string]*sourceState
+	global  *ratelimit.Bucket
+	now     func() time.Time
+}
+
+// newHandshakeLimiter creates a limiter with a full global token bucket.
+func newHandshakeLimiter() *handshakeLimiter {
+	return &handshakeLimiter{
+		sources: make(map[string]*sourceState),
+		global:  ratelimit.New(HandshakeRateLimit, HandshakeRateLimit, time.Now()),
+		now:     time.Now,
+	}
+}
+
+// allow reports whether a packet from ip should be processed.
+// Packets from blocked sources are rejected without consuming global budget.
+func (l *handshakeLimiter) allow(ip net.IP) bool {
+	now := l.now()
+
+	if s, ok := l.sources[ip.String()]; ok && now.Before(s.blockedUntil) {
+		return false
+	}
+
+	return l.global.Allow(now, 1)
+}
+
+// fail records a bad packet from ip.
+// Returns true if this failure caused the source to be blocked.
+func (l *handshakeLimiter) fail(ip net.IP) bool {
+	now := l.now()
+	s := l.source(ip, now)
+
+	if now.Sub(s.windowStart) > HandshakeFailureWindow {
+		s.failures = 0
+		s.windowStart = now
+	}
+	s.failures++
+
+	if s.failures >= MaxHandshakeFailures {
+		s.failures = 0
+		s.windowStart = now
+		s.blockedUntil = now.Add(HandshakeBlockDuration)
+		return true
+	}
+	return false
+}
+
+// allowReply reports whether a reply may be sent to ip, limiting each source
+// to one reply per HandshakeReplyInterval so the listener can't be used as a reflector.
+func (l *handshakeLimiter) allowReply(ip net.IP) bool {
+	now := l.now()
+	s := l.source(ip, now)
+
+	if !s.lastReply.IsZero() && now.Sub(s.lastReply) < HandshakeReplyInterval {
+		return false
+	}
+	s.lastReply = now
+	return true
+}
+
+// allowEvent reports whether a handshake failure from ip may be emitted as
+// an event, limiting each source to one per HandshakeEventInterval so junk
+// packets can't grow the events file without bound. When it may, it also
+// returns how many failures from ip were withheld since the last event.
+func (l *handshakeLimiter) allowEvent(ip net.IP) (suppressed int, ok bool) {
+	now := l.now()
+	s := l.source(ip, now)
+
+	if !s.lastEvent.IsZero() && now.Sub(s.lastEvent) < HandshakeEventInterval {
+		s.suppressed++
+		return 0, false
+	}
+	suppressed, s.suppressed = s.suppressed, 0
+	s.lastEvent = now
+	return suppressed, true
+}
+
+// source returns the state for ip, creating it if needed.
+func (l *handshakeLimiter) source(ip net.IP, now time.Time) *sourceState {
+	key := ip.String()
+	if s, ok := l.sources[key]; ok {
+		return s
+	}
+
+	if len(l.sources) >= maxTrackedSources {
+		l.prune(now)
+	}
+
+	s := &sourceState{windowStart: now}
+	l.sources[key] = s
+	return s
+}
+
+// prune drops sources that are neither blocked nor recently active.
+// If everything is still active the map is reset rather than growing without bound.
+func (l *handshakeLimiter) prune(now time.Time) {
+	for key, s := range l.sources {
+		if now.After(s.blockedUntil) && now.Sub(s.windowStart) > HandshakeFailureWindow {
+			delete(l.sources, key)
+		}
+	}
+	if len(l.sources) >= maxTrackedSources {
+		l.sources = make(map[string]*sourceState)
+	}
+}
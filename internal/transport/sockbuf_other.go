@@ -0,0 +1,10 @@
+//go:build !unix
+
+package transport
+
+import "net"
+
+// socketBufferSizes is not implemented on this platform.
+func socketBufferSizes(conn *net.UDPConn) (read, write int, err error) {
+	return 0, 0, errBufferSizeUnknown
+}
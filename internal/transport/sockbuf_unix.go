@@ -0,0 +1,40 @@
+//go:build unix
+
+package transport
+
+import (
+	"net"
+	"runtime"
+	"syscall"
+)
+
+// socketBufferSizes returns the kernel's effective receive and send buffer
+// sizes for conn. Linux reports double the usable size (it reserves half for
+// bookkeeping), so the value is halved there to compare with what was requested.
+func socketBufferSizes(conn *net.UDPConn) (read, write int, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		read, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		if sockErr != nil {
+			return
+		}
+		write, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if runtime.GOOS == "linux" {
+		read /= 2
+		write /= 2
+	}
+	return read, write, nil
+}
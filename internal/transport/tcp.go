@@ -0,0 +1,711 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/events"
+	"github.com/xbslink/xbslink-ng/internal/logging"
+	"github.com/xbslink/xbslink-ng/internal/protocol"
+)
+
+// TCP framing constants.
+const (
+	// tcpLengthSize is the size of the big-endian length prefix on each message.
+	tcpLengthSize = 2
+	// MaxTCPMessageSize is the largest protocol message carried over TCP
+	// (a full jumbo frame in secure mode).
+	MaxTCPMessageSize = protocol.MaxMessageSize
+)
+
+// TCP listen mode handshake limits.
+const (
+	// TCPAcceptTimeout bounds the handshake on an accepted connection, from
+	// its HELLO to its HELLO_CONFIRM. A peer answers within a round trip.
+	TCPAcceptTimeout = 2 * time.Second
+	// MaxPendingTCPHandshakes is how many accepted connections may be
+	// handshaking at once; further ones are closed until one finishes.
+	MaxPendingTCPHandshakes = 16
+)
+
+// ErrMessageTooLarge is returned when a TCP message exceeds MaxTCPMessageSize.
+var ErrMessageTooLarge = errors.New("message too large for TCP framing")
+
+// appendFrame appends msg to dst with its length prefix.
+func appendFrame(dst, msg []byte) ([]byte, error) {
+	if len(msg) > MaxTCPMessageSize {
+		return dst, ErrMessageTooLarge
+	}
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(msg)))
+	return append(dst, msg...), nil
+}
+
+// frameReader splits a TCP byte stream into length-prefixed messages.
+// A read error (such as a deadline) keeps any partial message buffered, so
+// callers can poll with short deadlines without losing stream alignment.
+// The buffer is allocated once: a length prefix over MaxTCPMessageSize fails
+// with ErrMessageTooLarge as soon as it is read, without waiting for the
+// bytes it announces, and the stream can't be resynchronized after it.
+type frameReader struct {
+	r          io.Reader
+	buf        []byte
+	start, end int   // Unconsumed bytes are buf[start:end]
+	err        error // Error to return once buffered messages are consumed
+}
+
+// newFrameReader creates a reader with room for several full messages.
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{
+		r:   r,
+		buf: make([]byte, 4*(tcpLengthSize+MaxTCPMessageSize)),
+	}
+}
+
+// next copies the next complete message into dst and returns its length.
+func (f *frameReader) next(dst []byte) (int, error) {
+	for {
+		if avail := f.end - f.start; avail >= tcpLengthSize {
+			size := int(binary.BigEndian.Uint16(f.buf[f.start:]))
+			if size > MaxTCPMessageSize {
+				return 0, ErrMessageTooLarge
+			}
+			if avail >= tcpLengthSize+size {
+				if len(dst) < size {
+					return 0, io.ErrShortBuffer
+				}
+				n := copy(dst, f.buf[f.start+tcpLengthSize:f.start+tcpLengthSize+size])
+				f.start += tcpLengthSize + size
+				return n, nil
+			}
+		}
+
+		if f.err != nil {
+			err := f.err
+			f.err = nil
+			return 0, err
+		}
+
+		// Move the partial message to the front and read more
+		if f.start > 0 {
+			f.end = copy(f.buf, f.buf[f.start:f.end])
+			f.start = 0
+		}
+		n, err := f.r.Read(f.buf[f.end:])
+		f.end += n
+		if err != nil {
+			f.err = err
+		}
+	}
+}
+
+// TCPTransport carries protocol messages over a TCP stream, for networks that
+// block or throttle UDP. Each message is prefixed with its 16-bit length.
+// Head-of-line blocking makes latency worse than UDP under any packet loss.
+type TCPTransport struct {
+	mode      Mode
+	codec     *protocol.Codec
+	logger    *logging.Logger
+	emitter   events.Emitter
+	limiter   *handshakeLimiter
+	allowFrom []*net.IPNet
+
+	waitProgress time.Duration // Config.WaitProgress
+
+	localPort uint16
+	peerAddr  string           // Configured peer (connect mode)
+	listener  *net.TCPListener // Listen mode only
+	conn      *net.TCPConn     // Set once the handshake completes
+	reader    *frameReader
+	peerInfo  PeerInfo
+
+	wmu  sync.Mutex // Serializes writes so messages never interleave
+	wbuf []byte
+
+	mu        sync.RWMutex
+	connected bool
+	closed    bool
+
+	handshakeFailures atomic.Uint64
+
+	// Buffer for handshake reads in connect mode (listen mode handshakes
+	// each use their own)
+	readBuf []byte
+}
+
+// TCPTransport is a Conn without batched I/O.
+var _ Conn = (*TCPTransport)(nil)
+
+// NewTCP creates a TCP transport. It uses the same Config as New; AllowFrom
+// and RequireSecure apply, while SocketBuffer and DropOnCongestion are ignored.
+func NewTCP(cfg Config) (*TCPTransport, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	emitter := cfg.Emitter
+	if emitter == nil {
+		emitter = events.NopEmitter{}
+	}
+
+	t := &TCPTransport{
+		mode:      cfg.Mode,
+		codec:     cfg.Codec,
+		logger:    cfg.Logger,
+		emitter:   emitter,
+		limiter:   newHandshakeLimiter(),
+		allowFrom: cfg.AllowFrom,
+		localPort: cfg.LocalPort,
+		peerAddr:  cfg.PeerAddr,
+		wbuf:      make([]byte, 0, tcpLengthSize+MaxTCPMessageSize),
+		readBuf:   make([]byte, MaxTCPMessageSize),
+
+		waitProgress: cfg.WaitProgress,
+	}
+
+	switch cfg.Mode {
+	case ModeListen:
+		port := cfg.LocalPort
+		switch len(cfg.ListenPorts) {
+		case 0:
+		case 1:
+			port = cfg.ListenPorts[0]
+		default:
+			return nil, errors.New("multiple listen ports are only supported by the UDP transport")
+		}
+		ln, err := net.ListenTCP("tcp", &net.TCPAddr{Port: int(port)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind to port %d: %w", port, err)
+		}
+		t.listener = ln
+		t.logger.Info("Listening on TCP :%d", port)
+	case ModeConnect:
+		if _, err := net.ResolveTCPAddr("tcp", cfg.PeerAddr); err != nil {
+			return nil, fmt.Errorf("failed to resolve peer address %q: %w", cfg.PeerAddr, err)
+		}
+		t.logger.Info("Connecting to peer %s over TCP", cfg.PeerAddr)
+	default:
+		return nil, fmt.Errorf("unknown mode: %d", cfg.Mode)
+	}
+
+	return t, nil
+}
+
+// WaitForPeer accepts connections until one completes the handshake (listen mode).
+// Each connection is handshaken in its own goroutine within TCPAcceptTimeout,
+// so a client that connects and stays silent can't hold up a real peer.
+func (t *TCPTransport) WaitForPeer(ctx context.Context) error {
+	if t.mode != ModeListen {
+		return errors.New("WaitForPeer only valid in listen mode")
+	}
+
+	t.logger.Info("Waiting for peer connection...")
+
+	// Results are buffered for every pending handshake, so a handshake still
+	// running when WaitForPeer returns never blocks; they are drained on the
+	// way out and their connections closed.
+	results := make(chan tcpHandshake, MaxPendingTCPHandshakes)
+	pending := 0
+	hsCtx, cancelHandshakes := context.WithCancel(ctx)
+	defer func() {
+		cancelHandshakes()
+		for ; pending > 0; pending-- {
+			(<-results).conn.Close()
+		}
+	}()
+
+	progress := newWaitProgress(t.waitProgress, describePorts("TCP", t.listener.Addr()), t.logger, t.emitter, time.Now)
+	stop := context.AfterFunc(ctx, func() { t.listener.SetDeadline(time.Now()) })
+	defer stop()
+	for {
+		t.listener.SetDeadline(time.Now().Add(ReadTimeout))
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		progress.check() // Accept times out every ReadTimeout
+
+		// The first handshake to succeed carries the session
+		for collected := false; !collected; {
+			select {
+			case res := <-results:
+				pending--
+				if t.finishHandshake(res) {
+					return nil
+				}
+			default:
+				collected = true
+			}
+		}
+
+		conn, err := t.listener.AcceptTCP()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue // Timeout, check context and try again
+			}
+			return fmt.Errorf("accept error: %w", err)
+		}
+
+		addr := conn.RemoteAddr().(*net.TCPAddr)
+		if !ipAllowed(t.allowFrom, addr.IP) {
+			t.logger.Trace("Rejecting connection from %s (not in --allow-from)", addr)
+			conn.Close()
+			continue
+		}
+		if pending >= MaxPendingTCPHandshakes {
+			t.logger.Trace("Rejecting connection from %s (%d handshakes pending)", addr, pending)
+			conn.Close()
+			continue
+		}
+		if !t.limiter.allow(addr.IP) {
+			conn.Close()
+			continue
+		}
+
+		pending++
+		go func() { results <- t.acceptHello(hsCtx, conn) }()
+	}
+}
+
+// tcpHandshake is the outcome of acceptHello on one accepted connection.
+// Failures are reported by finishHandshake, on the WaitForPeer goroutine,
+// since the handshake limiter is not safe for concurrent use.
+type tcpHandshake struct {
+	conn    *net.TCPConn
+	reader  *frameReader
+	version uint16 // The peer's protocol version, on success
+	flags   byte   // The peer's HELLO flags, on success
+	reason  string // events.Reason* of a failure to report, or ""
+	err     error
+}
+
+// finishHandshake reports a failed handshake, or makes a successful one the
+// session, and returns whether it did.
+func (t *TCPTransport) finishHandshake(res tcpHandshake) bool {
+	addr := res.conn.RemoteAddr().(*net.TCPAddr)
+	if res.err != nil {
+		if res.reason != "" {
+			t.handshakeFailed(res.reason, addr, res.err)
+		}
+		t.logger.Debug("Handshake from %s failed: %v", addr, res.err)
+		res.conn.Close()
+		if t.limiter.fail(addr.IP) {
+			t.logger.Warn("Too many invalid handshake attempts from %s, ignoring it for %v", addr.IP, HandshakeBlockDuration)
+		}
+		return false
+	}
+
+	// Handshakes running at once share the codec: settle it on this peer's
+	// version and flags, and start its nonces afresh
+	t.codec.UseVersion(res.version)
+	t.codec.UsePeerFlags(res.flags)
+	t.codec.ResetRecvNonce()
+	t.setPeerInfo(res.version)
+	t.setConnected(res.conn, res.reader)
+	t.logger.Info("Peer connected: %s (%s)", addr, t.PeerInfo())
+	return true
+}
+
+// acceptHello reads the peer's HELLO on a new connection, answers with
+// HELLO_ACK and, from protocol v2, checks the peer's HELLO_CONFIRM, all
+// within TCPAcceptTimeout.
+func (t *TCPTransport) acceptHello(ctx context.Context, conn *net.TCPConn) tcpHandshake {
+	res := tcpHandshake{conn: conn, reader: newFrameReader(conn)}
+	fail := func(reason string, err error) tcpHandshake {
+		res.reason, res.err = reason, err
+		return res
+	}
+	addr := conn.RemoteAddr()
+	buf := make([]byte, MaxTCPMessageSize)
+	deadline := time.Now().Add(TCPAcceptTimeout)
+
+	n, err := t.readHandshake(ctx, conn, res.reader, buf, deadline)
+	if err != nil {
+		return fail(events.ReasonTimeout, err)
+	}
+
+	msg, err := t.codec.Decode(buf[:n])
+	if err != nil {
+		if t.codec.IsModeMismatchHello(buf[:n]) {
+			t.logger.Warn("Rejected HELLO from %s: %s", addr, modeMismatchDetail(t.codec.IsSecure(), true))
+			t.writeTo(conn, protocol.EncodeError(protocol.ErrorCodeModeMismatch, ""))
+			return fail(events.ReasonModeMismatch, ErrModeMismatch)
+		}
+		if errors.Is(err, protocol.ErrVersionMismatch) {
+			t.writeTo(conn, protocol.EncodeError(protocol.ErrorCodeVersionUnsupported, versionUnsupportedText))
+		}
+		return fail(handshakeFailureReason(err), err)
+	}
+
+	if msg.Type != protocol.MsgHello {
+		return fail(events.ReasonInvalidMessage, fmt.Errorf("expected HELLO, got %s", protocol.MessageTypeName(msg.Type)))
+	}
+
+	t.logger.Info("Received HELLO from %s (version %d)", addr, msg.Version)
+
+	// Reset nonce state for new session (prevents "replay attack detected" on reconnection)
+	t.codec.ResetRecvNonce()
+
+	t.codec.UsePeerFlags(msg.Flags)
+	ack, challenge, err := t.codec.EncodeHelloAck(msg.Challenge, msg.Version)
+	if err != nil {
+		return fail("", fmt.Errorf("failed to encode HELLO_ACK: %w", err))
+	}
+	if err := t.writeTo(conn, ack); err != nil {
+		return fail("", fmt.Errorf("failed to send HELLO_ACK: %w", err))
+	}
+	if challenge != nil {
+		if reason, err := t.awaitHelloConfirm(ctx, conn, res.reader, buf, deadline, challenge, msg.Version); err != nil {
+			return fail(reason, err)
+		}
+	}
+	res.version, res.flags = msg.Version, msg.Flags
+	return res
+}
+
+// awaitHelloConfirm reads the peer's answer to the challenge in our v2+
+// HELLO_ACK and verifies it, returning the events.Reason* of a failure.
+func (t *TCPTransport) awaitHelloConfirm(ctx context.Context, conn *net.TCPConn, reader *frameReader, buf []byte, deadline time.Time, challenge []byte, version uint16) (string, error) {
+	n, err := t.readHandshake(ctx, conn, reader, buf, deadline)
+	if err != nil {
+		return events.ReasonTimeout, err
+	}
+
+	msg, err := t.codec.Decode(buf[:n])
+	if err != nil {
+		return handshakeFailureReason(err), err
+	}
+	if msg.Type != protocol.MsgHelloConfirm {
+		return events.ReasonInvalidMessage, fmt.Errorf("expected HELLO_CONFIRM, got %s", protocol.MessageTypeName(msg.Type))
+	}
+	if !t.codec.VerifyConfirmResponse(challenge, msg.Response, version) {
+		t.logger.Warn("Rejected HELLO_CONFIRM from %s: challenge response invalid", conn.RemoteAddr())
+		return events.ReasonChallengeInvalid, ErrChallengeInvalid
+	}
+	t.logger.Debug("Challenge-response verified")
+	return "", nil
+}
+
+// Connect dials the peer and performs the handshake (connect mode).
+// Retries forever with the same backoff as the UDP transport.
+func (t *TCPTransport) Connect(ctx context.Context) error {
+	if t.mode != ModeConnect {
+		return errors.New("Connect only valid in connect mode")
+	}
+
+	return connectWithBackoff(ctx, t.logger, t.emitter, t.codec, t.attemptHandshake)
+}
+
+// attemptHandshake dials the peer once and exchanges HELLO/HELLO_ACK.
+func (t *TCPTransport) attemptHandshake(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: HandshakeTimeout}
+	if t.localPort != 0 {
+		dialer.LocalAddr = &net.TCPAddr{Port: int(t.localPort)}
+	}
+
+	c, err := dialer.DialContext(ctx, "tcp", t.peerAddr)
+	if err != nil {
+		return fmt.Errorf("failed to dial: %w", err)
+	}
+	conn := c.(*net.TCPConn)
+	addr := conn.RemoteAddr()
+
+	hello, challenge, err := t.codec.EncodeHello()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to encode HELLO: %w", err)
+	}
+
+	t.logger.Debug("Sending HELLO to %s", addr)
+	if err := t.writeTo(conn, hello); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to send HELLO: %w", err)
+	}
+
+	reader := newFrameReader(conn)
+	if err := t.awaitHelloAck(ctx, conn, reader, challenge); err != nil {
+		conn.Close()
+		return err
+	}
+
+	t.setConnected(conn, reader)
+	t.logger.Info("Connected to peer: %s (%s)", addr, t.PeerInfo())
+	return nil
+}
+
+// awaitHelloAck reads the reply to our HELLO, verifies the challenge response
+// and answers the listener's challenge with HELLO_CONFIRM (v2+).
+func (t *TCPTransport) awaitHelloAck(ctx context.Context, conn *net.TCPConn, reader *frameReader, challenge []byte) error {
+	addr := conn.RemoteAddr()
+
+	n, err := t.readHandshake(ctx, conn, reader, t.readBuf, time.Now().Add(HandshakeTimeout))
+	if err != nil {
+		t.handshakeFailed(events.ReasonTimeout, addr, err)
+		return err
+	}
+
+	msg, err := t.codec.Decode(t.readBuf[:n])
+	if err != nil {
+		if t.codec.IsSecure() && n < protocol.MinSecureSize {
+			t.logger.Warn("Invalid message from peer (pre-shared key mismatch? server may not be using encryption)")
+		}
+		t.handshakeFailed(handshakeFailureReason(err), addr, err)
+		return err
+	}
+
+	switch msg.Type {
+	case protocol.MsgError:
+		return t.peerRejected(addr, msg)
+	case protocol.MsgHelloAck:
+	default:
+		err := fmt.Errorf("expected HELLO_ACK, got %s", protocol.MessageTypeName(msg.Type))
+		t.handshakeFailed(events.ReasonInvalidMessage, addr, err)
+		return err
+	}
+
+	if t.codec.IsSecure() {
+		if !t.codec.VerifyChallengeResponse(challenge, msg.Response, msg.Version) {
+			t.handshakeFailed(events.ReasonChallengeInvalid, addr, ErrChallengeInvalid)
+			return ErrChallengeInvalid
+		}
+		t.logger.Debug("Challenge-response verified")
+	}
+
+	// Answer the listener's challenge so it can authenticate us too
+	if msg.Version >= 2 {
+		if err := t.writeTo(conn, t.codec.EncodeHelloConfirm(msg.Challenge, msg.Version)); err != nil {
+			return fmt.Errorf("failed to send HELLO_CONFIRM: %w", err)
+		}
+	}
+
+	// Reset nonce state for new session (prevents "replay attack detected" on reconnection)
+	t.codec.ResetRecvNonce()
+	t.codec.UsePeerFlags(msg.Flags)
+	t.setPeerInfo(msg.Version)
+	return nil
+}
+
+// readHandshake reads one message into buf before deadline, polling so ctx
+// cancellation is noticed.
+func (t *TCPTransport) readHandshake(ctx context.Context, conn *net.TCPConn, reader *frameReader, buf []byte, deadline time.Time) (int, error) {
+	defer interruptReads(ctx, conn)()
+	for time.Now().Before(deadline) {
+		conn.SetReadDeadline(time.Now().Add(ReadTimeout))
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		n, err := reader.next(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			return 0, fmt.Errorf("read error: %w", err)
+		}
+		return n, nil
+	}
+	return 0, errors.New("handshake timeout")
+}
+
+// peerRejected reports an ERROR received in reply to our HELLO and returns the
+// error that ends this handshake attempt.
+func (t *TCPTransport) peerRejected(addr net.Addr, msg *protocol.Message) error {
+	if msg.ErrorCode == protocol.ErrorCodeModeMismatch {
+		t.logger.Error("Handshake rejected by %s: %s", addr, modeMismatchDetail(t.codec.IsSecure(), false))
+		t.handshakeFailed(events.ReasonModeMismatch, addr, ErrModeMismatch)
+		return ErrModeMismatch
+	}
+
+	err := fmt.Errorf("%w: %s", ErrPeerError, protocol.ErrorCodeName(msg.ErrorCode))
+	if msg.ErrorMsg != "" {
+		err = fmt.Errorf("%w (%s)", err, msg.ErrorMsg)
+	}
+	t.logger.Error("Handshake rejected by %s: %v", addr, err)
+
+	reason := events.ReasonPeerError
+	if msg.ErrorCode == protocol.ErrorCodeVersionUnsupported {
+		reason = events.ReasonVersionMismatch
+	}
+	t.handshakeFailed(reason, addr, err)
+	return err
+}
+
+// handshakeFailed counts a failed handshake attempt and emits an error event,
+// at most one per source per HandshakeEventInterval (see allowEvent).
+func (t *TCPTransport) handshakeFailed(reason string, addr net.Addr, err error) {
+	t.handshakeFailures.Add(1)
+	var suppressed int
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		if suppressed, ok = t.limiter.allowEvent(tcpAddr.IP); !ok {
+			return
+		}
+	}
+	t.emitter.Emit(events.EventError, events.ErrorData{
+		Message:  fmt.Sprintf("handshake failed: %v", err) + suppressedSuffix(suppressed),
+		Reason:   reason,
+		PeerAddr: addr.String(),
+	})
+}
+
+// setPeerInfo records what the peer reported in its HELLO or HELLO_ACK.
+func (t *TCPTransport) setPeerInfo(version uint16) {
+	t.mu.Lock()
+	t.peerInfo = PeerInfo{Version: version, Secure: t.codec.IsSecure(), FrameCRC: t.codec.FrameCRC()}
+	t.mu.Unlock()
+}
+
+// setConnected makes conn the active connection.
+func (t *TCPTransport) setConnected(conn *net.TCPConn, reader *frameReader) {
+	t.mu.Lock()
+	t.conn = conn
+	t.reader = reader
+	t.connected = true
+	t.mu.Unlock()
+}
+
+// writeTo sends one framed message on conn.
+func (t *TCPTransport) writeTo(conn *net.TCPConn, data []byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	buf, err := appendFrame(t.wbuf[:0], data)
+	if err != nil {
+		return err
+	}
+	t.wbuf = buf
+	_, err = conn.Write(buf)
+	return err
+}
+
+// HandshakeFailures returns the number of failed handshake attempts seen by this transport.
+func (t *TCPTransport) HandshakeFailures() uint64 {
+	return t.handshakeFailures.Load()
+}
+
+// Send sends data to the connected peer.
+func (t *TCPTransport) Send(data []byte) error {
+	t.mu.RLock()
+	if t.closed {
+		t.mu.RUnlock()
+		return ErrClosed
+	}
+	if !t.connected {
+		t.mu.RUnlock()
+		return ErrNotConnected
+	}
+	conn := t.conn
+	t.mu.RUnlock()
+
+	return t.writeTo(conn, data)
+}
+
+// Recv receives one message from the peer.
+// Returns ErrPeerClosed once the peer has closed the connection.
+func (t *TCPTransport) Recv(buf []byte) (int, net.Addr, error) {
+	t.mu.RLock()
+	if t.closed {
+		t.mu.RUnlock()
+		return 0, nil, ErrClosed
+	}
+	if !t.connected {
+		t.mu.RUnlock()
+		return 0, nil, ErrNotConnected
+	}
+	conn, reader := t.conn, t.reader
+	t.mu.RUnlock()
+
+	n, err := reader.next(buf)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			return 0, nil, ErrPeerClosed
+		}
+		return 0, nil, err
+	}
+	return n, conn.RemoteAddr(), nil
+}
+
+// SetReadDeadline sets the read deadline for Recv.
+func (t *TCPTransport) SetReadDeadline(deadline time.Time) error {
+	t.mu.RLock()
+	conn := t.conn
+	t.mu.RUnlock()
+
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return conn.SetReadDeadline(deadline)
+}
+
+// SendBye sends a graceful disconnect message.
+func (t *TCPTransport) SendBye() error {
+	t.mu.RLock()
+	if !t.connected || t.closed {
+		t.mu.RUnlock()
+		return nil
+	}
+	conn := t.conn
+	t.mu.RUnlock()
+
+	return t.writeTo(conn, t.codec.EncodeBye())
+}
+
+// PeerAddr returns the connected peer's address, or nil if there is none.
+func (t *TCPTransport) PeerAddr() net.Addr {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.RemoteAddr()
+}
+
+// PeerInfo returns what was negotiated with the peer (zero before the handshake).
+func (t *TCPTransport) PeerInfo() PeerInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.peerInfo
+}
+
+// LocalAddr returns the listening address (listen mode) or the local end of
+// the connection (connect mode, once connected).
+func (t *TCPTransport) LocalAddr() net.Addr {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	switch {
+	case t.listener != nil:
+		return t.listener.Addr()
+	case t.conn != nil:
+		return t.conn.LocalAddr()
+	default:
+		return nil
+	}
+}
+
+// Close closes the listener and connection.
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+
+	t.closed = true
+	t.connected = false
+
+	var err error
+	if t.listener != nil {
+		err = t.listener.Close()
+	}
+	if t.conn != nil {
+		if cerr := t.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
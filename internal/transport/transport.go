@@ -2,13 +2,21 @@
 package transport
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/xbslink/xbslink-ng/internal/events"
 	"github.com/xbslink/xbslink-ng/internal/logging"
 	"github.com/xbslink/xbslink-ng/internal/protocol"
 )
@@ -29,10 +37,15 @@ const (
 	DefaultReadBuffer = 65536
 	// DefaultWriteBuffer is the default UDP write buffer size.
 	DefaultWriteBuffer = 65536
+	// MinSocketBuffer is the smallest socket buffer size accepted in Config.
+	MinSocketBuffer = 4096
 	// HandshakeTimeout is the timeout for the initial handshake.
 	HandshakeTimeout = 10 * time.Second
 	// ReadTimeout is the timeout for individual read operations.
 	ReadTimeout = 100 * time.Millisecond
+	// CongestionWait is how long a send may wait for socket buffer space
+	// when DropOnCongestion is set before the packet is dropped.
+	CongestionWait = 2 * time.Millisecond
 )
 
 // Retry backoff intervals for connect mode.
@@ -44,6 +57,11 @@ var connectBackoff = []time.Duration{
 	10 * time.Second,
 }
 
+// versionUnsupportedText is the ERROR text sent in reply to a HELLO whose
+// protocol version we can't speak.
+var versionUnsupportedText = fmt.Sprintf("supported protocol versions %d-%d",
+	protocol.MinProtocolVersion, protocol.ProtocolVersion)
+
 // Errors returned by transport operations.
 var (
 	ErrNotConnected     = errors.New("transport not connected")
@@ -51,21 +69,45 @@ var (
 	ErrHandshakeFailed  = errors.New("handshake failed")
 	ErrChallengeInvalid = errors.New("challenge response invalid")
 	ErrClosed           = errors.New("transport closed")
+	ErrModeMismatch     = errors.New("security mode mismatch (one side uses --key, the other doesn't)")
+	ErrPeerError        = errors.New("peer reported an error")
+	ErrSendCongested    = errors.New("send buffer full")
+	ErrPeerClosed       = errors.New("peer closed the connection")
+	ErrKeyRequired      = errors.New("a pre-shared key is required (--require-key) but none is set")
+
+	errBufferSizeUnknown = errors.New("socket buffer size not available on this platform")
 )
 
 // Transport manages UDP communication with a peer.
 type Transport struct {
 	conn      *net.UDPConn
+	listening []*net.UDPConn // Candidate sockets while waiting for a peer (listen mode)
 	peerAddr  *net.UDPAddr
+	peerInfo  PeerInfo
 	mode      Mode
 	codec     *protocol.Codec
 	logger    *logging.Logger
-	challenge []byte // Challenge sent in HELLO (for verifying HELLO_ACK)
+	emitter   events.Emitter
+	challenge []byte       // Challenge sent in HELLO (for verifying HELLO_ACK)
+	pending   *pendingPeer // HELLO answered, awaiting HELLO_CONFIRM (listen mode)
+	limiter   *handshakeLimiter
+	allowFrom []*net.IPNet // Allowed peer source ranges (listen mode, nil = any)
+
+	// Requested and effective (read back from the kernel) socket buffer sizes
+	sockBuf        int
+	readBufferLen  int
+	writeBufferLen int
+
+	dropOnCongestion bool          // Bound sends by CongestionWait instead of blocking
+	waitProgress     time.Duration // Config.WaitProgress
+	handshakeTimeout time.Duration // HandshakeTimeout, shortened by tests
 
 	mu        sync.RWMutex
 	connected bool
 	closed    bool
 
+	handshakeFailures atomic.Uint64
+
 	// Buffer pool for reads
 	readBuf []byte
 }
@@ -73,33 +115,93 @@ type Transport struct {
 // Config holds transport configuration.
 type Config struct {
 	Mode      Mode
-	LocalPort uint16 // Port to bind (listen mode) or local port (connect mode, 0 = auto)
-	PeerAddr  string // Peer address in "host:port" format (connect mode only)
+	LocalPort uint16       // Port to bind (listen mode) or local port (connect mode, 0 = auto)
+	PeerAddr  string       // Peer address in "host:port" format (connect mode only)
+	AllowFrom []*net.IPNet // Source ranges allowed to handshake (listen mode only, nil = any)
 	Codec     *protocol.Codec
 	Logger    *logging.Logger
+	Emitter   events.Emitter // Optional: nil defaults to NopEmitter
+
+	// ListenPorts binds several ports at once in listen mode (overrides
+	// LocalPort). The first port to receive a valid HELLO carries the session
+	// and the others are closed, which improves the odds that some port gets
+	// through a restrictive firewall or NAT.
+	ListenPorts []uint16
+
+	// SocketBuffer is the requested UDP read and write buffer size in bytes
+	// (0 = DefaultReadBuffer/DefaultWriteBuffer).
+	SocketBuffer int
+
+	// DropOnCongestion makes Send and BatchWriter.Write give up after
+	// CongestionWait when the socket send buffer is full, returning
+	// ErrSendCongested, rather than blocking until space frees up.
+	DropOnCongestion bool
+
+	// RequireSecure refuses to run unauthenticated: New fails with
+	// ErrKeyRequired if Codec has no key. A keyed side always rejects a
+	// keyless peer during the handshake (ErrModeMismatch), so together this
+	// guarantees a session is never silently downgraded to insecure mode.
+	RequireSecure bool
+
+	// WaitProgress is how often WaitForPeer logs that it is still waiting,
+	// and for how long, emitting a state_changed event with WaitingSec set
+	// (0 = never).
+	WaitProgress time.Duration
 }
 
-// New creates a new transport with the given configuration.
-func New(cfg Config) (*Transport, error) {
+// validate checks the settings shared by every backend.
+func (cfg *Config) validate() error {
 	if cfg.Codec == nil {
-		return nil, errors.New("codec is required")
+		return errors.New("codec is required")
 	}
 	if cfg.Logger == nil {
-		return nil, errors.New("logger is required")
+		return errors.New("logger is required")
+	}
+	if cfg.RequireSecure && !cfg.Codec.IsSecure() {
+		return ErrKeyRequired
+	}
+	return nil
+}
+
+// New creates a new transport with the given configuration.
+func New(cfg Config) (*Transport, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.SocketBuffer != 0 && cfg.SocketBuffer < MinSocketBuffer {
+		return nil, fmt.Errorf("socket buffer must be at least %d bytes", MinSocketBuffer)
+	}
+
+	emitter := cfg.Emitter
+	if emitter == nil {
+		emitter = events.NopEmitter{}
 	}
 
 	t := &Transport{
-		mode:    cfg.Mode,
-		codec:   cfg.Codec,
-		logger:  cfg.Logger,
-		readBuf: make([]byte, DefaultReadBuffer),
+		mode:      cfg.Mode,
+		codec:     cfg.Codec,
+		logger:    cfg.Logger,
+		emitter:   emitter,
+		readBuf:   make([]byte, DefaultReadBuffer),
+		limiter:   newHandshakeLimiter(),
+		allowFrom: cfg.AllowFrom,
+		sockBuf:   cfg.SocketBuffer,
+
+		dropOnCongestion: cfg.DropOnCongestion,
+		waitProgress:     cfg.WaitProgress,
+		handshakeTimeout: HandshakeTimeout,
 	}
 
 	// Set up the UDP connection based on mode
 	var err error
 	switch cfg.Mode {
 	case ModeListen:
-		err = t.setupListen(cfg.LocalPort)
+		ports := cfg.ListenPorts
+		if len(ports) == 0 {
+			ports = []uint16{cfg.LocalPort}
+		}
+		err = t.setupListen(ports)
 	case ModeConnect:
 		err = t.setupConnect(cfg.LocalPort, cfg.PeerAddr)
 	default:
@@ -113,24 +215,26 @@ func New(cfg Config) (*Transport, error) {
 	return t, nil
 }
 
-// setupListen binds to the specified port for incoming connections.
-func (t *Transport) setupListen(port uint16) error {
-	addr := &net.UDPAddr{Port: int(port)}
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to bind to port %d: %w", port, err)
-	}
-
-	// Set socket buffer sizes
-	if err := conn.SetReadBuffer(DefaultReadBuffer); err != nil {
-		t.logger.Warn("Failed to set read buffer size: %v", err)
-	}
-	if err := conn.SetWriteBuffer(DefaultWriteBuffer); err != nil {
-		t.logger.Warn("Failed to set write buffer size: %v", err)
+// setupListen binds to each of ports for incoming connections.
+// The first socket is the active one until a peer is found.
+func (t *Transport) setupListen(ports []uint16) error {
+	names := make([]string, len(ports))
+	for i, port := range ports {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(port)})
+		if err != nil {
+			for _, c := range t.listening {
+				c.Close()
+			}
+			t.listening = nil
+			return fmt.Errorf("failed to bind to port %d: %w", port, err)
+		}
+		t.setBufferSizes(conn)
+		t.listening = append(t.listening, conn)
+		names[i] = fmt.Sprintf(":%d", port)
 	}
 
-	t.conn = conn
-	t.logger.Info("Listening on UDP :%d", port)
+	t.conn = t.listening[0]
+	t.logger.Info("Listening on UDP %s", strings.Join(names, ", "))
 	return nil
 }
 
@@ -150,87 +254,322 @@ func (t *Transport) setupConnect(localPort uint16, peerAddr string) error {
 		return fmt.Errorf("failed to bind to local port: %w", err)
 	}
 
-	// Set socket buffer sizes
-	if err := conn.SetReadBuffer(DefaultReadBuffer); err != nil {
+	t.setBufferSizes(conn)
+
+	t.conn = conn
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	t.logger.Info("Connecting to peer %s from local UDP port %d", peerAddr, port)
+	if localPort == 0 {
+		// Connect and listen aren't symmetric: an OS-assigned port only
+		// hears back from peers it has sent to
+		t.logger.Info("Local port %d was assigned by the OS: the peer's replies reach it through NAT, but it can't accept new connections. "+
+			"For others to reach you, run listen on a forwarded port (or connect with --port and forward that)", port)
+	}
+	return nil
+}
+
+// setBufferSizes applies the requested socket buffer sizes and reads back what
+// the kernel actually granted. The OS silently clamps requests above its limit
+// (net.core.rmem_max/wmem_max on Linux), so a smaller result is logged as a warning.
+func (t *Transport) setBufferSizes(conn *net.UDPConn) {
+	readWant, writeWant := DefaultReadBuffer, DefaultWriteBuffer
+	if t.sockBuf > 0 {
+		readWant, writeWant = t.sockBuf, t.sockBuf
+	}
+
+	if err := conn.SetReadBuffer(readWant); err != nil {
 		t.logger.Warn("Failed to set read buffer size: %v", err)
 	}
-	if err := conn.SetWriteBuffer(DefaultWriteBuffer); err != nil {
+	if err := conn.SetWriteBuffer(writeWant); err != nil {
 		t.logger.Warn("Failed to set write buffer size: %v", err)
 	}
 
-	t.conn = conn
-	t.logger.Info("Connecting to peer %s", peerAddr)
-	return nil
+	read, write, err := socketBufferSizes(conn)
+	if err != nil {
+		if !errors.Is(err, errBufferSizeUnknown) {
+			t.logger.Debug("Failed to read back socket buffer sizes: %v", err)
+		}
+		return
+	}
+	t.readBufferLen, t.writeBufferLen = read, write
+
+	if read < readWant {
+		t.logger.Warn("Socket read buffer is %d bytes, less than the %d requested (raise the OS limit, e.g. net.core.rmem_max)", read, readWant)
+	}
+	if write < writeWant {
+		t.logger.Warn("Socket write buffer is %d bytes, less than the %d requested (raise the OS limit, e.g. net.core.wmem_max)", write, writeWant)
+	}
+	t.logger.Debug("Socket buffers: read %d bytes, write %d bytes", read, write)
+}
+
+// SocketBufferSizes returns the effective socket read and write buffer sizes
+// reported by the kernel, or zeros if the platform can't report them.
+func (t *Transport) SocketBufferSizes() (read, write int) {
+	return t.readBufferLen, t.writeBufferLen
 }
 
 // WaitForPeer waits for an incoming connection (listen mode).
-// Returns when a valid HELLO is received and HELLO_ACK is sent.
+// Returns when a valid HELLO is received, HELLO_ACK is sent and (from
+// protocol v2) the peer's HELLO_CONFIRM verifies. With several
+// listen ports, the socket that received the HELLO becomes the connection and
+// the others are closed.
 func (t *Transport) WaitForPeer(ctx context.Context) error {
 	if t.mode != ModeListen {
 		return errors.New("WaitForPeer only valid in listen mode")
 	}
 
 	t.logger.Info("Waiting for peer connection...")
+	t.pending = nil
+
+	addrs := make([]net.Addr, len(t.listening))
+	for i, conn := range t.listening {
+		addrs[i] = conn.LocalAddr()
+	}
+	progress := newWaitProgress(t.waitProgress, describePorts("UDP", addrs...), t.logger, t.emitter, time.Now)
+	progressTicks, stopProgress := progress.ticks()
+	defer stopProgress()
+
+	packets := make(chan handshakePacket)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, conn := range t.listening {
+		wg.Add(1)
+		go func(conn *net.UDPConn) {
+			defer wg.Done()
+			t.readHandshakes(conn, packets, stop)
+		}(conn)
+	}
+	defer func() {
+		// Unblock pending reads so the readers exit before conns are reused
+		close(stop)
+		for _, conn := range t.listening {
+			conn.SetReadDeadline(time.Now())
+		}
+		wg.Wait()
+	}()
 
 	for {
+		var pkt handshakePacket
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case <-progressTicks:
+			progress.check()
+			continue
+		case pkt = <-packets:
+		}
+
+		if pkt.err != nil {
+			return fmt.Errorf("read error: %w", pkt.err)
+		}
+
+		done, err := t.handleHandshake(pkt.conn, pkt.addr, pkt.data)
+		if err != nil {
+			return err
+		}
+		if done {
+			// The winning reader is never released, so it can't consume
+			// the peer's first message before the bridge starts reading
+			t.useListener(pkt.conn)
+			return nil
+		}
+		pkt.release <- struct{}{}
+	}
+}
+
+// handshakePacket is a datagram read by readHandshakes. data aliases the
+// reader's buffer, which is reused once release is signalled; the reader
+// does not read again until then.
+type handshakePacket struct {
+	conn    *net.UDPConn
+	addr    *net.UDPAddr
+	data    []byte
+	err     error
+	release chan struct{}
+}
+
+// readHandshakes reads datagrams from conn and hands them to WaitForPeer one
+// at a time until stop is closed. A read error other than a timeout is
+// passed on and ends the reader.
+func (t *Transport) readHandshakes(conn *net.UDPConn, packets chan<- handshakePacket, stop <-chan struct{}) {
+	buf := make([]byte, DefaultReadBuffer)
+	release := make(chan struct{})
+
+	for {
+		select {
+		case <-stop:
+			return
 		default:
 		}
 
 		// Set read deadline
-		t.conn.SetReadDeadline(time.Now().Add(ReadTimeout))
+		conn.SetReadDeadline(time.Now().Add(ReadTimeout))
 
-		n, addr, err := t.conn.ReadFromUDP(t.readBuf)
+		n, addr, err := conn.ReadFromUDP(buf)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue // Timeout, check context and try again
+				continue // Timeout, check for stop and try again
 			}
-			return fmt.Errorf("read error: %w", err)
+			select {
+			case packets <- handshakePacket{conn: conn, err: err}:
+			case <-stop:
+			}
+			return
 		}
 
-		// Try to decode as HELLO
-		msg, err := t.codec.Decode(t.readBuf[:n])
-		if err != nil {
-			if errors.Is(err, protocol.ErrMessageTooShort) && t.codec.IsSecure() {
-				t.logger.Warn("Received unreadable message from %s (pre-shared key mismatch? peer may not be using encryption)", addr)
-			} else {
-				t.logger.Debug("Received invalid message from %s: %v", addr, err)
-			}
-			continue
+		select {
+		case packets <- handshakePacket{conn: conn, addr: addr, data: buf[:n], release: release}:
+		case <-stop:
+			return
+		}
+		select {
+		case <-release:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// handleHandshake processes one datagram received on conn while waiting for
+// a peer. Returns true once the handshake is complete.
+func (t *Transport) handleHandshake(conn *net.UDPConn, addr *net.UDPAddr, data []byte) (bool, error) {
+	// Ignore sources outside the allowlist entirely
+	if !t.sourceAllowed(addr.IP) {
+		t.logger.Trace("Ignoring packet from %s (not in --allow-from)", addr)
+		return false, nil
+	}
+
+	// Drop packets from throttled sources before doing any crypto work
+	if !t.limiter.allow(addr.IP) {
+		return false, nil
+	}
+
+	// Try to decode as HELLO
+	msg, err := t.codec.Decode(data)
+	if err != nil {
+		if t.codec.IsModeMismatchHello(data) {
+			t.logger.Warn("Rejected HELLO from %s: %s", addr, modeMismatchDetail(t.codec.IsSecure(), true))
+			t.handshakeFailed(events.ReasonModeMismatch, addr, ErrModeMismatch)
+			t.replyError(conn, addr, protocol.ErrorCodeModeMismatch, "")
+			t.failSource(conn, addr)
+			return false, nil
+		}
+		// Secure decode reports every malformed message as ErrInvalidHMAC, so
+		// use the length to spot a peer that isn't signing its messages.
+		if t.codec.IsSecure() && len(data) < protocol.MinSecureSize {
+			t.logger.Warn("Received unreadable message from %s (pre-shared key mismatch? peer may not be using encryption)", addr)
+		} else {
+			t.logger.Debug("Received invalid message from %s: %v", addr, err)
 		}
+		t.handshakeFailed(handshakeFailureReason(err), addr, err)
+		if errors.Is(err, protocol.ErrVersionMismatch) {
+			t.replyError(conn, addr, protocol.ErrorCodeVersionUnsupported, versionUnsupportedText)
+		}
+		t.failSource(conn, addr)
+		return false, nil
+	}
+
+	if msg.Type == protocol.MsgError {
+		// Never answer an error report, or two listeners could ping-pong forever
+		t.logger.Debug("Ignoring ERROR (%s) from %s while waiting for HELLO", protocol.ErrorCodeName(msg.ErrorCode), addr)
+		return false, nil
+	}
 
-		if msg.Type != protocol.MsgHello {
-			// Send BYE to signal we need fresh handshake (enables sub-second session reset detection)
+	if msg.Type == protocol.MsgHelloConfirm && t.pending != nil &&
+		t.pending.conn == conn && addrEqual(addr, t.pending.addr) {
+		return t.confirmPeer(msg), nil
+	}
+
+	if msg.Type != protocol.MsgHello {
+		// Send BYE to signal we need fresh handshake (enables sub-second session reset detection).
+		// Replies are rate-limited per source so we can't be used to reflect traffic.
+		if t.limiter.allowReply(addr.IP) {
 			bye := t.codec.EncodeBye()
-			t.conn.WriteToUDP(bye, addr)
+			conn.WriteToUDP(bye, addr)
 			t.logger.Debug("Expected HELLO from %s, got %s, sent BYE", addr, protocol.MessageTypeName(msg.Type))
-			continue
 		}
+		return false, nil
+	}
 
-		t.logger.Info("Received HELLO from %s (version %d)", addr, msg.Version)
+	t.logger.Info("Received HELLO from %s (version %d)", addr, msg.Version)
 
-		// Store peer address and challenge
-		t.peerAddr = addr
-		t.challenge = msg.Challenge
+	// Reset nonce state for new session (prevents "replay attack detected" on reconnection)
+	t.codec.ResetRecvNonce()
 
-		// Reset nonce state for new session (prevents "replay attack detected" on reconnection)
-		t.codec.ResetRecvNonce()
+	// Send HELLO_ACK with challenge response
+	t.codec.UsePeerFlags(msg.Flags)
+	ack, challenge, err := t.codec.EncodeHelloAck(msg.Challenge, msg.Version)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode HELLO_ACK: %w", err)
+	}
+	if _, err := conn.WriteToUDP(ack, addr); err != nil {
+		return false, fmt.Errorf("failed to send HELLO_ACK: %w", err)
+	}
 
-		// Send HELLO_ACK with challenge response
-		ack := t.codec.EncodeHelloAck(msg.Challenge)
-		if _, err := t.conn.WriteToUDP(ack, addr); err != nil {
-			return fmt.Errorf("failed to send HELLO_ACK: %w", err)
-		}
+	// v1 peers don't confirm; from v2 the peer must answer our challenge
+	// before it counts as connected. A newer HELLO replaces a pending one.
+	if challenge == nil {
+		t.peerConnected(addr, msg.Version)
+		return true, nil
+	}
+	t.pending = &pendingPeer{conn: conn, addr: addr, challenge: challenge, version: msg.Version}
+	t.logger.Debug("Sent HELLO_ACK to %s, waiting for HELLO_CONFIRM", addr)
+	return false, nil
+}
 
-		t.mu.Lock()
-		t.connected = true
-		t.mu.Unlock()
+// pendingPeer is a peer whose v2+ HELLO we answered and which must still
+// answer our HELLO_ACK challenge with a HELLO_CONFIRM.
+type pendingPeer struct {
+	conn      *net.UDPConn
+	addr      *net.UDPAddr
+	challenge []byte
+	version   uint16
+}
 
-		t.logger.Info("Peer connected: %s", addr)
-		return nil
+// confirmPeer checks a HELLO_CONFIRM from the pending peer. Returns true if
+// it proves the peer holds the key and the peer is now connected.
+func (t *Transport) confirmPeer(msg *protocol.Message) bool {
+	p := t.pending
+	t.pending = nil
+
+	if !t.codec.VerifyConfirmResponse(p.challenge, msg.Response, p.version) {
+		t.logger.Warn("Rejected HELLO_CONFIRM from %s: challenge response invalid", p.addr)
+		t.handshakeFailed(events.ReasonChallengeInvalid, p.addr, ErrChallengeInvalid)
+		t.failSource(p.conn, p.addr)
+		return false
 	}
+	t.logger.Debug("Challenge-response verified")
+
+	t.peerConnected(p.addr, p.version)
+	return true
+}
+
+// peerConnected records a completed handshake with addr (listen mode).
+func (t *Transport) peerConnected(addr *net.UDPAddr, version uint16) {
+	t.mu.Lock()
+	t.peerAddr = addr
+	t.peerInfo = PeerInfo{Version: version, Secure: t.codec.IsSecure(), FrameCRC: t.codec.FrameCRC()}
+	t.connected = true
+	t.mu.Unlock()
+
+	t.logger.Info("Peer connected: %s (%s)", addr, t.peerInfo)
+}
+
+// useListener makes conn the session socket and closes the other listen sockets.
+func (t *Transport) useListener(conn *net.UDPConn) {
+	if len(t.listening) > 1 {
+		for _, c := range t.listening {
+			if c != conn {
+				c.Close()
+			}
+		}
+		t.logger.Info("Using %s for this session", conn.LocalAddr())
+	}
+
+	t.mu.Lock()
+	t.conn = conn
+	t.listening = []*net.UDPConn{conn}
+	t.mu.Unlock()
 }
 
 // Connect establishes a connection to the peer (connect mode).
@@ -240,7 +579,15 @@ func (t *Transport) Connect(ctx context.Context) error {
 		return errors.New("Connect only valid in connect mode")
 	}
 
-	attempt := 0
+	return connectWithBackoff(ctx, t.logger, t.emitter, t.codec, t.attemptHandshake)
+}
+
+// connectWithBackoff calls attempt until it succeeds or ctx is done, waiting
+// between failures per connectBackoff. Each failure is logged and emitted
+// as a state_changed event with the attempt count and time spent so far.
+func connectWithBackoff(ctx context.Context, logger *logging.Logger, emitter events.Emitter, codec *protocol.Codec, attempt func(context.Context) error) error {
+	start := time.Now()
+	tries := 0
 	for {
 		select {
 		case <-ctx.Done():
@@ -248,19 +595,25 @@ func (t *Transport) Connect(ctx context.Context) error {
 		default:
 		}
 
-		err := t.attemptHandshake(ctx)
+		err := attempt(ctx)
 		if err == nil {
 			return nil // Success
 		}
 
 		// Determine backoff delay
-		backoffIdx := attempt
+		backoffIdx := tries
 		if backoffIdx >= len(connectBackoff) {
 			backoffIdx = len(connectBackoff) - 1
 		}
 		delay := connectBackoff[backoffIdx]
 
-		t.logger.Warn("Connection attempt %d failed: %v. Retrying in %v...", attempt+1, err, delay)
+		waited := time.Since(start)
+		logger.Warn("Connection attempt %d failed after %v of trying: %v. Retrying in %v...", tries+1, waited.Round(time.Second), err, delay)
+		emitter.Emit(events.EventStateChanged, events.StateChangedData{
+			State:      stateConnecting,
+			WaitingSec: waited.Seconds(),
+			Attempts:   tries + 1,
+		})
 
 		select {
 		case <-ctx.Done():
@@ -268,12 +621,21 @@ func (t *Transport) Connect(ctx context.Context) error {
 		case <-time.After(delay):
 		}
 
-		attempt++
+		tries++
 		// Reset codec nonce on retry
-		t.codec.ResetRecvNonce()
+		codec.ResetRecvNonce()
 	}
 }
 
+// interruptReads makes a read blocked on conn return as soon as ctx is done
+// instead of when its ReadTimeout expires, so Ctrl+C is answered at once.
+// Call the returned function once done reading.
+func interruptReads(ctx context.Context, conn interface{ SetReadDeadline(time.Time) error }) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+}
+
 // attemptHandshake performs a single handshake attempt.
 func (t *Transport) attemptHandshake(ctx context.Context) error {
 	// Send HELLO with challenge
@@ -288,16 +650,20 @@ func (t *Transport) attemptHandshake(ctx context.Context) error {
 		return fmt.Errorf("failed to send HELLO: %w", err)
 	}
 
-	// Wait for HELLO_ACK with timeout
-	deadline := time.Now().Add(HandshakeTimeout)
+	// Wait for HELLO_ACK with timeout. An ERROR is unauthenticated, so
+	// anyone who can guess our address could send one: it only ends the
+	// attempt if no valid HELLO_ACK arrives before the timeout.
+	var rejection *protocol.Message
+	defer interruptReads(ctx, t.conn)()
+	deadline := time.Now().Add(t.handshakeTimeout)
 	for time.Now().Before(deadline) {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+		// Checked after setting the deadline, so a cancellation that
+		// interruptReads saw first isn't undone for a whole ReadTimeout
+		t.conn.SetReadDeadline(time.Now().Add(ReadTimeout))
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		t.conn.SetReadDeadline(time.Now().Add(ReadTimeout))
 		n, addr, err := t.conn.ReadFromUDP(t.readBuf)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
@@ -315,11 +681,18 @@ func (t *Transport) attemptHandshake(ctx context.Context) error {
 		// Decode message
 		msg, err := t.codec.Decode(t.readBuf[:n])
 		if err != nil {
-			if errors.Is(err, protocol.ErrMessageTooShort) && t.codec.IsSecure() {
+			if t.codec.IsSecure() && n < protocol.MinSecureSize {
 				t.logger.Warn("Invalid message from peer (pre-shared key mismatch? server may not be using encryption)")
 			} else {
 				t.logger.Debug("Invalid message from peer: %v", err)
 			}
+			t.handshakeFailed(handshakeFailureReason(err), addr, err)
+			continue
+		}
+
+		if msg.Type == protocol.MsgError {
+			t.logger.Debug("Received ERROR (%s) from %s, still waiting for a HELLO_ACK", protocol.ErrorCodeName(msg.ErrorCode), addr)
+			rejection = msg
 			continue
 		}
 
@@ -330,27 +703,147 @@ func (t *Transport) attemptHandshake(ctx context.Context) error {
 
 		// Verify challenge response
 		if t.codec.IsSecure() {
-			if !t.codec.VerifyChallengeResponse(t.challenge, msg.Response) {
+			if !t.codec.VerifyChallengeResponse(t.challenge, msg.Response, msg.Version) {
+				t.handshakeFailed(events.ReasonChallengeInvalid, addr, ErrChallengeInvalid)
 				return ErrChallengeInvalid
 			}
 			t.logger.Debug("Challenge-response verified")
 		}
 
+		// Answer the listener's challenge so it can authenticate us too
+		if msg.Version >= 2 {
+			confirm := t.codec.EncodeHelloConfirm(msg.Challenge, msg.Version)
+			if _, err := t.conn.WriteToUDP(confirm, t.peerAddr); err != nil {
+				return fmt.Errorf("failed to send HELLO_CONFIRM: %w", err)
+			}
+		}
+
 		// Reset nonce state for new session (prevents "replay attack detected" on reconnection)
 		t.codec.ResetRecvNonce()
+		t.codec.UsePeerFlags(msg.Flags)
 
 		t.mu.Lock()
+		t.peerInfo = PeerInfo{Version: msg.Version, Secure: t.codec.IsSecure(), FrameCRC: t.codec.FrameCRC()}
 		t.connected = true
 		t.mu.Unlock()
 
-		t.logger.Info("Connected to peer: %s", t.peerAddr)
+		t.logger.Info("Connected to peer: %s (%s)", t.peerAddr, t.peerInfo)
 		return nil
 	}
 
-	return fmt.Errorf("handshake timeout after %v", HandshakeTimeout)
+	if rejection != nil {
+		return t.peerRejected(t.peerAddr, rejection)
+	}
+	err = fmt.Errorf("handshake timeout after %v", t.handshakeTimeout)
+	t.handshakeFailed(events.ReasonTimeout, t.peerAddr, err)
+	return err
+}
+
+// peerRejected reports an ERROR received in reply to our HELLO, when no
+// valid HELLO_ACK followed it, and returns the error that ends this
+// handshake attempt.
+func (t *Transport) peerRejected(addr *net.UDPAddr, msg *protocol.Message) error {
+	if msg.ErrorCode == protocol.ErrorCodeModeMismatch {
+		t.logger.Error("Handshake rejected by %s: %s", addr, modeMismatchDetail(t.codec.IsSecure(), false))
+		t.handshakeFailed(events.ReasonModeMismatch, addr, ErrModeMismatch)
+		return ErrModeMismatch
+	}
+
+	err := fmt.Errorf("%w: %s", ErrPeerError, protocol.ErrorCodeName(msg.ErrorCode))
+	if msg.ErrorMsg != "" {
+		err = fmt.Errorf("%w (%s)", err, msg.ErrorMsg)
+	}
+	t.logger.Error("Handshake rejected by %s: %v", addr, err)
+
+	reason := events.ReasonPeerError
+	switch msg.ErrorCode {
+	case protocol.ErrorCodeVersionUnsupported:
+		reason = events.ReasonVersionMismatch
+	case protocol.ErrorCodeRateLimited:
+		reason = events.ReasonRateLimited
+	}
+	t.handshakeFailed(reason, addr, err)
+	return err
+}
+
+// replyError sends an ERROR to addr from conn, subject to the per-source reply limit.
+func (t *Transport) replyError(conn *net.UDPConn, addr *net.UDPAddr, code uint16, text string) {
+	if t.limiter.allowReply(addr.IP) {
+		conn.WriteToUDP(protocol.EncodeError(code, text), addr)
+	}
+}
+
+// failSource records a bad handshake from addr and tells it when it gets blocked.
+func (t *Transport) failSource(conn *net.UDPConn, addr *net.UDPAddr) {
+	if t.limiter.fail(addr.IP) {
+		t.logger.Warn("Too many invalid handshake attempts from %s, ignoring it for %v", addr.IP, HandshakeBlockDuration)
+		t.replyError(conn, addr, protocol.ErrorCodeRateLimited, fmt.Sprintf("retry in %v", HandshakeBlockDuration))
+	}
+}
+
+// handshakeFailed counts a failed handshake attempt and emits an error event,
+// at most one per source per HandshakeEventInterval (see allowEvent).
+func (t *Transport) handshakeFailed(reason string, addr *net.UDPAddr, err error) {
+	t.handshakeFailures.Add(1)
+
+	data := events.ErrorData{
+		Message: fmt.Sprintf("handshake failed: %v", err),
+		Reason:  reason,
+	}
+	if addr != nil {
+		suppressed, ok := t.limiter.allowEvent(addr.IP)
+		if !ok {
+			return
+		}
+		data.Message += suppressedSuffix(suppressed)
+		data.PeerAddr = addr.String()
+	}
+	t.emitter.Emit(events.EventError, data)
+}
+
+// suppressedSuffix notes how many failures from the same source were left
+// out of the events since the last one, or returns "" if none were.
+func suppressedSuffix(suppressed int) string {
+	if suppressed == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d more from this source not reported)", suppressed)
+}
+
+// handshakeFailureReason maps a decode error to an event reason code.
+func handshakeFailureReason(err error) string {
+	switch {
+	case errors.Is(err, protocol.ErrInvalidHMAC):
+		return events.ReasonAuthFailed
+	case errors.Is(err, protocol.ErrVersionMismatch):
+		return events.ReasonVersionMismatch
+	default:
+		return events.ReasonInvalidMessage
+	}
+}
+
+// modeMismatchDetail explains a secure/insecure mismatch from our side's point of view.
+// fromPeer is true when we detected the peer's mismatched HELLO ourselves.
+func modeMismatchDetail(secure, fromPeer bool) string {
+	switch {
+	case secure && fromPeer:
+		return "peer is not using a key but this side requires one (--key); set the same --key on both sides"
+	case secure:
+		return "peer is not using a key but this side has --key; set the same --key on both sides"
+	case fromPeer:
+		return "peer is using a key (--key) but this side has none; set the same --key on both sides"
+	default:
+		return "peer requires a key (--key) but this side has none; set the same --key on both sides"
+	}
+}
+
+// HandshakeFailures returns the number of failed handshake attempts seen by this transport.
+func (t *Transport) HandshakeFailures() uint64 {
+	return t.handshakeFailures.Load()
 }
 
 // Send sends data to the connected peer.
+// Returns an error wrapping ErrSendCongested if the socket send buffer is full.
 func (t *Transport) Send(data []byte) error {
 	t.mu.RLock()
 	if t.closed {
@@ -364,13 +857,43 @@ func (t *Transport) Send(data []byte) error {
 	peerAddr := t.peerAddr
 	t.mu.RUnlock()
 
+	t.beginSend()
 	_, err := t.conn.WriteToUDP(data, peerAddr)
+	t.endSend()
+	return classifySendError(err)
+}
+
+// beginSend bounds how long the next write may block when DropOnCongestion
+// is set. endSend clears the deadline so other writes are unaffected.
+func (t *Transport) beginSend() {
+	if t.dropOnCongestion {
+		t.conn.SetWriteDeadline(time.Now().Add(CongestionWait))
+	}
+}
+
+// endSend clears the deadline set by beginSend.
+func (t *Transport) endSend() {
+	if t.dropOnCongestion {
+		t.conn.SetWriteDeadline(time.Time{})
+	}
+}
+
+// classifySendError wraps errors that mean the socket send buffer is full
+// (would-block, no buffer space, or the CongestionWait deadline) in
+// ErrSendCongested so callers can tell congestion from real failures.
+func classifySendError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOBUFS) {
+		return fmt.Errorf("%w: %v", ErrSendCongested, err)
+	}
 	return err
 }
 
 // Recv receives data from the peer.
 // Returns the raw bytes, sender address, and any error.
-func (t *Transport) Recv(buf []byte) (int, *net.UDPAddr, error) {
+func (t *Transport) Recv(buf []byte) (int, net.Addr, error) {
 	t.mu.RLock()
 	if t.closed {
 		t.mu.RUnlock()
@@ -379,7 +902,10 @@ func (t *Transport) Recv(buf []byte) (int, *net.UDPAddr, error) {
 	t.mu.RUnlock()
 
 	n, addr, err := t.conn.ReadFromUDP(buf)
-	return n, addr, err
+	if err != nil {
+		return n, nil, err
+	}
+	return n, addr, nil
 }
 
 // SetReadDeadline sets the read deadline on the underlying connection.
@@ -414,10 +940,17 @@ func (t *Transport) Close() error {
 	t.closed = true
 	t.connected = false
 
-	if t.conn != nil {
-		return t.conn.Close()
+	// In listen mode conn is one of the listening sockets
+	var err error
+	for _, c := range t.listening {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
 	}
-	return nil
+	if t.conn != nil && len(t.listening) == 0 {
+		err = t.conn.Close()
+	}
+	return err
 }
 
 // IsConnected returns true if the transport is connected to a peer.
@@ -427,13 +960,23 @@ func (t *Transport) IsConnected() bool {
 	return t.connected
 }
 
-// PeerAddr returns the connected peer's address.
-func (t *Transport) PeerAddr() *net.UDPAddr {
+// PeerAddr returns the connected peer's address, or nil if there is none.
+func (t *Transport) PeerAddr() net.Addr {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
+	if t.peerAddr == nil {
+		return nil // avoid a non-nil interface holding a nil pointer
+	}
 	return t.peerAddr
 }
 
+// PeerInfo returns what was negotiated with the peer (zero before the handshake).
+func (t *Transport) PeerInfo() PeerInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.peerInfo
+}
+
 // LocalAddr returns the local address.
 func (t *Transport) LocalAddr() net.Addr {
 	if t.conn == nil {
@@ -442,6 +985,127 @@ func (t *Transport) LocalAddr() net.Addr {
 	return t.conn.LocalAddr()
 }
 
+// sourceAllowed reports whether ip is permitted by the allowlist.
+// An empty allowlist permits every source.
+func (t *Transport) sourceAllowed(ip net.IP) bool {
+	return ipAllowed(t.allowFrom, ip)
+}
+
+// ipAllowed reports whether ip falls in any of allow; an empty list allows all.
+func ipAllowed(allow []*net.IPNet, ip net.IP) bool {
+	if len(allow) == 0 {
+		return true
+	}
+	for _, n := range allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadPeerAddress resolves a --address value that names where to read the
+// address from: "@path" reads it from a file and "-" from stdin. The address
+// is the first line that is not blank or a # comment, trimmed. Any other
+// value is returned unchanged.
+func ReadPeerAddress(value string, stdin io.Reader) (string, error) {
+	source := value
+	var r io.Reader
+	switch {
+	case value == "-":
+		source = "stdin"
+		r = stdin
+	case strings.HasPrefix(value, "@"):
+		path := value[1:]
+		if path == "" {
+			return "", errors.New("@ needs a file path, e.g. @peer.txt")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		source = path
+		r = f
+	default:
+		return value, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return line, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("reading %s: %w", source, err)
+	}
+	return "", fmt.Errorf("no address in %s", source)
+}
+
+// ParseAllowList parses a comma-separated list of CIDRs or bare IP addresses
+// (e.g. "203.0.113.0/24,198.51.100.7"). Bare addresses match only themselves.
+// Returns nil for an empty string.
+func ParseAllowList(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if strings.Contains(part, "/") {
+			_, n, err := net.ParseCIDR(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", part, err)
+			}
+			nets = append(nets, n)
+			continue
+		}
+
+		ip := net.ParseIP(part)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", part)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 8 * net.IPv4len
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+// ParsePortList parses a comma-separated list of ports (e.g. "31415,3074,443").
+// Ports must be 1-65535 and may not repeat.
+func ParsePortList(s string) ([]uint16, error) {
+	var ports []uint16
+	seen := make(map[uint16]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		n, err := strconv.ParseUint(part, 10, 16)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid port %q (must be between 1 and 65535)", part)
+		}
+		port := uint16(n)
+		if seen[port] {
+			return nil, fmt.Errorf("port %d listed more than once", port)
+		}
+		seen[port] = true
+		ports = append(ports, port)
+	}
+	if len(ports) == 0 {
+		return nil, errors.New("no ports given")
+	}
+	return ports, nil
+}
+
 // addrEqual compares two UDP addresses.
 func addrEqual(a, b *net.UDPAddr) bool {
 	if a == nil || b == nil {
@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/logging"
+)
+
+// benchBurst is how many datagrams the sender queues before the reader drains them.
+const benchBurst = 32
+
+// newBenchPair creates a listening transport and a loopback sender aimed at it.
+func newBenchPair(b *testing.B) (*Transport, *net.UDPConn) {
+	b.Helper()
+
+	tr, err := New(Config{
+		Mode:   ModeListen,
+		Codec:  newTestCodec(nil),
+		Logger: logging.NewLogger(logging.LevelError),
+	})
+	if err != nil {
+		b.Fatalf("failed to create transport: %v", err)
+	}
+	b.Cleanup(func() { tr.Close() })
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: tr.LocalAddr().(*net.UDPAddr).Port})
+	if err != nil {
+		b.Fatalf("dial failed: %v", err)
+	}
+	b.Cleanup(func() { conn.Close() })
+
+	return tr, conn
+}
+
+// BenchmarkRecv_Single drains bursts with one ReadFromUDP per datagram.
+func BenchmarkRecv_Single(b *testing.B) {
+	tr, conn := newBenchPair(b)
+	payload := make([]byte, 100)
+	buf := make([]byte, 1500)
+	tr.SetReadDeadline(time.Now().Add(time.Minute))
+
+	reads := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchBurst; j++ {
+			conn.Write(payload)
+		}
+		for got := 0; got < benchBurst; got++ {
+			if _, _, err := tr.Recv(buf); err != nil {
+				b.Fatalf("Recv failed: %v", err)
+			}
+			reads++
+		}
+	}
+	b.ReportMetric(float64(reads)/float64(b.N*benchBurst), "syscalls/pkt")
+}
+
+// BenchmarkRecv_Batch drains bursts with BatchReader (recvmmsg on Linux).
+func BenchmarkRecv_Batch(b *testing.B) {
+	if !BatchSupported() {
+		b.Skip("batched reads not supported on this platform")
+	}
+
+	tr, conn := newBenchPair(b)
+	payload := make([]byte, 100)
+	reader := tr.NewBatchReader(DefaultBatchSize, 1500)
+	tr.SetReadDeadline(time.Now().Add(time.Minute))
+
+	reads := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchBurst; j++ {
+			conn.Write(payload)
+		}
+		for got := 0; got < benchBurst; {
+			n, err := reader.Read()
+			if err != nil {
+				b.Fatalf("Read failed: %v", err)
+			}
+			got += n
+			reads++
+		}
+	}
+	b.ReportMetric(float64(reads)/float64(b.N*benchBurst), "syscalls/pkt")
+}
+
+// newBenchSender creates a connected transport aimed at a loopback receiver.
+func newBenchSender(b *testing.B) (*Transport, *net.UDPConn) {
+	b.Helper()
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		b.Fatalf("listen failed: %v", err)
+	}
+	b.Cleanup(func() { peer.Close() })
+	peer.SetReadBuffer(4 * 1024 * 1024)
+
+	tr, err := New(Config{
+		Mode:     ModeConnect,
+		PeerAddr: peer.LocalAddr().String(),
+		Codec:    newTestCodec(nil),
+		Logger:   logging.NewLogger(logging.LevelError),
+	})
+	if err != nil {
+		b.Fatalf("failed to create transport: %v", err)
+	}
+	b.Cleanup(func() { tr.Close() })
+	tr.connected = true
+
+	return tr, peer
+}
+
+// drain reads count datagrams from conn so the receiver never overflows.
+func drain(b *testing.B, conn *net.UDPConn, count int) {
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(time.Minute))
+	for i := 0; i < count; i++ {
+		if _, err := conn.Read(buf); err != nil {
+			b.Fatalf("drain failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkSend_Single sends bursts with one WriteToUDP per datagram.
+func BenchmarkSend_Single(b *testing.B) {
+	tr, peer := newBenchSender(b)
+	payload := make([]byte, 100)
+
+	writes := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchBurst; j++ {
+			if err := tr.Send(payload); err != nil {
+				b.Fatalf("Send failed: %v", err)
+			}
+			writes++
+		}
+		drain(b, peer, benchBurst)
+	}
+	b.ReportMetric(float64(writes)/float64(b.N*benchBurst), "syscalls/pkt")
+}
+
+// BenchmarkSend_Batch sends bursts with BatchWriter (sendmmsg on Linux).
+func BenchmarkSend_Batch(b *testing.B) {
+	if !BatchSupported() {
+		b.Skip("batched writes not supported on this platform")
+	}
+
+	tr, peer := newBenchSender(b)
+	writer := tr.NewBatchWriter(DefaultBatchSize)
+	burst := make([][]byte, benchBurst)
+	for i := range burst {
+		burst[i] = make([]byte, 100)
+	}
+
+	writes := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := writer.Write(burst); err != nil {
+			b.Fatalf("Write failed: %v", err)
+		}
+		writes++
+		drain(b, peer, benchBurst)
+	}
+	b.ReportMetric(float64(writes)/float64(b.N*benchBurst), "syscalls/pkt")
+}
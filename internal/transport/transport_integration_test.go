@@ -10,13 +10,12 @@ import (
 	"time"
 
 	"github.com/xbslink/xbslink-ng/internal/logging"
-	"github.com/xbslink/xbslink-ng/internal/protocol"
 )
 
 func TestIntegration_Handshake_Loopback(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec1 := protocol.NewCodec(nil)
-	codec2 := protocol.NewCodec(nil)
+	codec1 := newTestCodec(nil)
+	codec2 := newTestCodec(nil)
 
 	// Find free port
 	port := freePort()
@@ -103,8 +102,8 @@ func TestIntegration_Handshake_Loopback(t *testing.T) {
 func TestIntegration_Handshake_Secure(t *testing.T) {
 	key := []byte("shared-secret-16")
 	logger := logging.NewLogger(logging.LevelError)
-	codec1 := protocol.NewCodec(key)
-	codec2 := protocol.NewCodec(key)
+	codec1 := newTestCodec(key)
+	codec2 := newTestCodec(key)
 
 	port := freePort()
 
@@ -170,8 +169,8 @@ func TestIntegration_Handshake_Secure(t *testing.T) {
 
 func TestIntegration_Handshake_KeyMismatch(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec1 := protocol.NewCodec([]byte("key-for-listener"))
-	codec2 := protocol.NewCodec([]byte("key-for-connect!"))
+	codec1 := newTestCodec([]byte("key-for-listener"))
+	codec2 := newTestCodec([]byte("key-for-connect!"))
 
 	port := freePort()
 
@@ -220,8 +219,8 @@ func TestIntegration_Handshake_KeyMismatch(t *testing.T) {
 
 func TestIntegration_SendReceive(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec1 := protocol.NewCodec(nil)
-	codec2 := protocol.NewCodec(nil)
+	codec1 := newTestCodec(nil)
+	codec2 := newTestCodec(nil)
 
 	port := freePort()
 
@@ -1,18 +1,31 @@
 package transport
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/xbslink/xbslink-ng/internal/events"
 	"github.com/xbslink/xbslink-ng/internal/logging"
 	"github.com/xbslink/xbslink-ng/internal/protocol"
+	"github.com/xbslink/xbslink-ng/test/testutil"
 )
 
 func TestNew_ListenMode(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -35,7 +48,7 @@ func TestNew_ListenMode(t *testing.T) {
 
 func TestNew_ConnectMode(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	cfg := Config{
 		Mode:     ModeConnect,
@@ -72,7 +85,7 @@ func TestNew_MissingCodec(t *testing.T) {
 }
 
 func TestNew_MissingLogger(t *testing.T) {
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	cfg := Config{
 		Mode:      ModeListen,
@@ -89,7 +102,7 @@ func TestNew_MissingLogger(t *testing.T) {
 
 func TestNew_InvalidPeerAddr(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	cfg := Config{
 		Mode:     ModeConnect,
@@ -104,9 +117,115 @@ func TestNew_InvalidPeerAddr(t *testing.T) {
 	}
 }
 
+func TestNew_SocketBufferTooSmall(t *testing.T) {
+	_, err := New(Config{
+		Mode:         ModeListen,
+		Codec:        newTestCodec(nil),
+		Logger:       logging.NewLogger(logging.LevelError),
+		SocketBuffer: MinSocketBuffer - 1,
+	})
+	if err == nil {
+		t.Error("expected error for socket buffer below minimum")
+	}
+}
+
+func TestNew_RequireSecure(t *testing.T) {
+	logger := logging.NewLogger(logging.LevelError)
+	cfg := Config{Mode: ModeListen, Codec: newTestCodec(nil), Logger: logger, RequireSecure: true}
+
+	if _, err := New(cfg); !errors.Is(err, ErrKeyRequired) {
+		t.Errorf("New() without key = %v, want ErrKeyRequired", err)
+	}
+	if _, err := NewTCP(cfg); !errors.Is(err, ErrKeyRequired) {
+		t.Errorf("NewTCP() without key = %v, want ErrKeyRequired", err)
+	}
+
+	cfg.Codec = newTestCodec([]byte("shared-key"))
+	tr, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() with key: %v", err)
+	}
+	tr.Close()
+}
+
+func TestSocketBufferSizes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("buffer read-back semantics are Linux-specific")
+	}
+
+	var out bytes.Buffer
+	logger := logging.NewLogger(logging.LevelWarn)
+	logger.SetOutput(&out)
+
+	tr, err := New(Config{
+		Mode:         ModeListen,
+		Codec:        newTestCodec(nil),
+		Logger:       logger,
+		SocketBuffer: 16384,
+	})
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	read, write := tr.SocketBufferSizes()
+	tr.Close()
+
+	if read < 16384 || write < 16384 {
+		t.Errorf("SocketBufferSizes() = %d, %d, want >= 16384", read, write)
+	}
+	if out.Len() != 0 {
+		t.Errorf("unexpected warning for small buffer: %q", out.String())
+	}
+
+	// A request far above rmem_max is clamped by the kernel and reported
+	tr, err = New(Config{
+		Mode:         ModeListen,
+		Codec:        newTestCodec(nil),
+		Logger:       logger,
+		SocketBuffer: 1 << 30,
+	})
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	read, _ = tr.SocketBufferSizes()
+	tr.Close()
+
+	if read >= 1<<30 {
+		t.Skipf("kernel granted %d bytes; limit not enforced here", read)
+	}
+	if !strings.Contains(out.String(), "less than the 1073741824 requested") {
+		t.Errorf("expected clamp warning, got %q", out.String())
+	}
+}
+
+func TestClassifySendError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		congested bool
+	}{
+		{"would block", &net.OpError{Op: "write", Err: os.NewSyscallError("sendto", syscall.EAGAIN)}, true},
+		{"no buffer space", &net.OpError{Op: "write", Err: os.NewSyscallError("sendto", syscall.ENOBUFS)}, true},
+		{"deadline", &net.OpError{Op: "write", Err: os.ErrDeadlineExceeded}, true},
+		{"refused", &net.OpError{Op: "write", Err: os.NewSyscallError("sendto", syscall.ECONNREFUSED)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifySendError(tt.err)
+			if got := errors.Is(err, ErrSendCongested); got != tt.congested {
+				t.Errorf("errors.Is(%v, ErrSendCongested) = %v, want %v", err, got, tt.congested)
+			}
+		})
+	}
+
+	if classifySendError(nil) != nil {
+		t.Error("classifySendError(nil) should be nil")
+	}
+}
+
 func TestLocalAddr(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -138,7 +257,7 @@ func TestLocalAddr(t *testing.T) {
 
 func TestIsConnected_Initial(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -161,7 +280,7 @@ func TestIsConnected_Initial(t *testing.T) {
 
 func TestClose_BeforeConnect(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -190,7 +309,7 @@ func TestClose_BeforeConnect(t *testing.T) {
 
 func TestSend_NotConnected(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -214,7 +333,7 @@ func TestSend_NotConnected(t *testing.T) {
 
 func TestSetReadDeadline(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -239,7 +358,7 @@ func TestSetReadDeadline(t *testing.T) {
 
 func TestWaitForPeer_WrongMode(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	cfg := Config{
 		Mode:     ModeConnect,
@@ -263,7 +382,7 @@ func TestWaitForPeer_WrongMode(t *testing.T) {
 
 func TestConnect_WrongMode(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -288,7 +407,7 @@ func TestConnect_WrongMode(t *testing.T) {
 
 func TestSendBye_NotConnected(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -313,7 +432,7 @@ func TestSendBye_NotConnected(t *testing.T) {
 
 func TestRecv_Closed(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -339,7 +458,7 @@ func TestRecv_Closed(t *testing.T) {
 
 func TestSend_Closed(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -364,7 +483,7 @@ func TestSend_Closed(t *testing.T) {
 
 func TestPeerAddr_Initial(t *testing.T) {
 	logger := logging.NewLogger(logging.LevelError)
-	codec := protocol.NewCodec(nil)
+	codec := newTestCodec(nil)
 
 	port := freePort()
 	cfg := Config{
@@ -409,6 +528,1113 @@ func TestAddrEqual(t *testing.T) {
 	}
 }
 
+func TestHandshakeLimiter_BlocksRepeatedFailures(t *testing.T) {
+	now := time.Now()
+	l := newHandshakeLimiter()
+	l.now = func() time.Time { return now }
+
+	attacker := net.ParseIP("203.0.113.7")
+	friend := net.ParseIP("198.51.100.1")
+
+	for i := 0; i < MaxHandshakeFailures-1; i++ {
+		if !l.allow(attacker) {
+			t.Fatalf("attempt %d: expected allow before threshold", i)
+		}
+		if l.fail(attacker) {
+			t.Fatalf("attempt %d: blocked before threshold", i)
+		}
+	}
+	if !l.fail(attacker) {
+		t.Fatal("expected source to be blocked at threshold")
+	}
+	if l.allow(attacker) {
+		t.Error("expected blocked source to be dropped")
+	}
+	if !l.allow(friend) {
+		t.Error("expected other sources to be unaffected")
+	}
+
+	// Block expires
+	now = now.Add(HandshakeBlockDuration + time.Millisecond)
+	if !l.allow(attacker) {
+		t.Error("expected source to be allowed after block expires")
+	}
+}
+
+func TestHandshakeLimiter_FailureWindowResets(t *testing.T) {
+	now := time.Now()
+	l := newHandshakeLimiter()
+	l.now = func() time.Time { return now }
+
+	ip := net.ParseIP("203.0.113.7")
+	for i := 0; i < MaxHandshakeFailures*3; i++ {
+		if l.fail(ip) {
+			t.Fatalf("failure %d: slow failures should not block", i)
+		}
+		now = now.Add(HandshakeFailureWindow / time.Duration(MaxHandshakeFailures-2))
+	}
+}
+
+func TestHandshakeLimiter_GlobalRate(t *testing.T) {
+	now := time.Now()
+	l := newHandshakeLimiter()
+	l.now = func() time.Time { return now }
+
+	allowed := 0
+	for i := 0; i < HandshakeRateLimit*2; i++ {
+		// Spread across sources so only the global cap applies
+		if l.allow(net.IPv4(10, 0, byte(i>>8), byte(i))) {
+			allowed++
+		}
+	}
+	if allowed != HandshakeRateLimit {
+		t.Errorf("allowed %d packets in a burst, want %d", allowed, HandshakeRateLimit)
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if !l.allow(net.ParseIP("10.1.0.1")) {
+		t.Error("expected budget to refill over time")
+	}
+}
+
+func TestHandshakeLimiter_ReplyInterval(t *testing.T) {
+	now := time.Now()
+	l := newHandshakeLimiter()
+	l.now = func() time.Time { return now }
+
+	ip := net.ParseIP("203.0.113.7")
+	if !l.allowReply(ip) {
+		t.Fatal("expected first reply to be allowed")
+	}
+	if l.allowReply(ip) {
+		t.Error("expected second reply within interval to be suppressed")
+	}
+	now = now.Add(HandshakeReplyInterval)
+	if !l.allowReply(ip) {
+		t.Error("expected reply after interval to be allowed")
+	}
+}
+
+func TestHandshakeLimiter_EventInterval(t *testing.T) {
+	now := time.Now()
+	l := newHandshakeLimiter()
+	l.now = func() time.Time { return now }
+
+	ip := net.ParseIP("203.0.113.7")
+	if suppressed, ok := l.allowEvent(ip); !ok || suppressed != 0 {
+		t.Fatalf("first event = %d, %v, want 0, true", suppressed, ok)
+	}
+	for i := 0; i < 3; i++ {
+		if _, ok := l.allowEvent(ip); ok {
+			t.Fatalf("event %d within interval allowed", i+2)
+		}
+	}
+	if _, ok := l.allowEvent(net.ParseIP("198.51.100.1")); !ok {
+		t.Error("expected other sources to be unaffected")
+	}
+
+	// The next event reports what was withheld
+	now = now.Add(HandshakeEventInterval)
+	if suppressed, ok := l.allowEvent(ip); !ok || suppressed != 3 {
+		t.Errorf("event after interval = %d, %v, want 3, true", suppressed, ok)
+	}
+	now = now.Add(HandshakeEventInterval)
+	if suppressed, ok := l.allowEvent(ip); !ok || suppressed != 0 {
+		t.Errorf("event after a quiet interval = %d, %v, want 0, true", suppressed, ok)
+	}
+}
+
+func TestWaitForPeer_ThrottlesFlood(t *testing.T) {
+	logger := logging.NewLogger(logging.LevelError)
+	key := []byte("shared-secret-16")
+
+	port := freePort()
+	listener, err := New(Config{
+		Mode:      ModeListen,
+		LocalPort: uint16(port),
+		Codec:     newTestCodec(key),
+		Logger:    logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	// Made before the clock starts (see TestWaitForPeer_EmitsHandshakeFailures)
+	hello, _, _ := newTestCodec(key).EncodeHello()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- listener.WaitForPeer(ctx) }()
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Flood junk from one address, then send a valid HELLO from the same address
+	for i := 0; i < MaxHandshakeFailures*2; i++ {
+		conn.Write([]byte("junk junk junk"))
+	}
+	time.Sleep(100 * time.Millisecond)
+	conn.Write(hello)
+
+	if err := <-done; err != context.DeadlineExceeded {
+		t.Errorf("expected throttled source to be ignored, got %v", err)
+	}
+	if listener.IsConnected() {
+		t.Error("listener should not accept HELLO from a throttled source")
+	}
+}
+
+func TestParseAllowList(t *testing.T) {
+	nets, err := ParseAllowList(" 203.0.113.0/24, 198.51.100.7 ,2001:db8::/32,")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("got %d networks, want 3", len(nets))
+	}
+	if nets[1].String() != "198.51.100.7/32" {
+		t.Errorf("bare IP parsed as %s, want 198.51.100.7/32", nets[1])
+	}
+
+	empty, err := ParseAllowList("")
+	if err != nil || empty != nil {
+		t.Errorf("empty list = %v, %v; want nil, nil", empty, err)
+	}
+
+	for _, bad := range []string{"not-an-ip", "10.0.0.0/33", "10.0.0.1,bogus/8"} {
+		if _, err := ParseAllowList(bad); err == nil {
+			t.Errorf("ParseAllowList(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParsePortList(t *testing.T) {
+	ports, err := ParsePortList(" 31415, 3074,443 ")
+	if err != nil {
+		t.Fatalf("ParsePortList failed: %v", err)
+	}
+	if len(ports) != 3 || ports[0] != 31415 || ports[1] != 3074 || ports[2] != 443 {
+		t.Errorf("ParsePortList() = %v, want [31415 3074 443]", ports)
+	}
+
+	for _, input := range []string{"", "0", "65536", "abc", "3074,3074"} {
+		if _, err := ParsePortList(input); err == nil {
+			t.Errorf("ParsePortList(%q) should fail", input)
+		}
+	}
+}
+
+func TestReadPeerAddress(t *testing.T) {
+	got, err := ReadPeerAddress("203.0.113.50:31415", nil)
+	if err != nil || got != "203.0.113.50:31415" {
+		t.Errorf("plain address = %q, %v; want it unchanged", got, err)
+	}
+
+	got, err = ReadPeerAddress("-", strings.NewReader("\n  203.0.113.50:31415  \r\nignored\n"))
+	if err != nil || got != "203.0.113.50:31415" {
+		t.Errorf("stdin = %q, %v; want 203.0.113.50:31415", got, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "peer.txt")
+	if err := os.WriteFile(path, []byte("# written by the listener\n[2001:db8::1]:31415\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ReadPeerAddress("@"+path, nil)
+	if err != nil || got != "[2001:db8::1]:31415" {
+		t.Errorf("@file = %q, %v; want [2001:db8::1]:31415", got, err)
+	}
+
+	for name, tc := range map[string]struct {
+		value string
+		stdin io.Reader
+	}{
+		"empty stdin":  {"-", strings.NewReader("\n# nothing yet\n")},
+		"stdin error":  {"-", iotest.ErrReader(errors.New("broken pipe"))},
+		"missing file": {"@" + filepath.Join(t.TempDir(), "missing"), nil},
+		"no path":      {"@", nil},
+	} {
+		if _, err := ReadPeerAddress(tc.value, tc.stdin); err == nil {
+			t.Errorf("%s: ReadPeerAddress(%q) should fail", name, tc.value)
+		}
+	}
+}
+
+func TestHandshake_FrameCRC(t *testing.T) {
+	logger := logging.NewLogger(logging.LevelError)
+	port := freePort()
+	newCodec := func() *protocol.Codec {
+		c := newTestCodec(nil)
+		c.SetFrameCRC(true)
+		return c
+	}
+	listener, err := New(Config{Mode: ModeListen, ListenPorts: []uint16{uint16(port)}, Codec: newCodec(), Logger: logger})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+	connector, err := New(Config{Mode: ModeConnect, PeerAddr: fmt.Sprintf("127.0.0.1:%d", port), Codec: newCodec(), Logger: logger})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	defer connector.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- listener.WaitForPeer(ctx) }()
+	if err := connector.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("WaitForPeer failed: %v", err)
+	}
+
+	want := PeerInfo{Version: protocol.ProtocolVersion, FrameCRC: true}
+	if listener.PeerInfo() != want || connector.PeerInfo() != want {
+		t.Errorf("PeerInfo() = %+v / %+v, want %+v", listener.PeerInfo(), connector.PeerInfo(), want)
+	}
+	if !strings.HasSuffix(want.String(), ", frame CRC") {
+		t.Errorf("PeerInfo.String() = %q, want frame CRCs noted", want.String())
+	}
+}
+
+func TestWaitForPeer_MultiplePorts(t *testing.T) {
+	logger := logging.NewLogger(logging.LevelError)
+
+	first, second := freePort(), freePort()
+	for second == first {
+		second = freePort()
+	}
+	listener, err := New(Config{
+		Mode:        ModeListen,
+		ListenPorts: []uint16{uint16(first), uint16(second)},
+		Codec:       newTestCodec(nil),
+		Logger:      logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	// Only the second port is reachable for the connector
+	connector, err := New(Config{
+		Mode:     ModeConnect,
+		PeerAddr: fmt.Sprintf("127.0.0.1:%d", second),
+		Codec:    newTestCodec(nil),
+		Logger:   logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	defer connector.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- listener.WaitForPeer(ctx) }()
+
+	if err := connector.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("WaitForPeer failed: %v", err)
+	}
+
+	want := PeerInfo{Version: protocol.ProtocolVersion}
+	if listener.PeerInfo() != want || connector.PeerInfo() != want {
+		t.Errorf("PeerInfo() = %+v / %+v, want %+v", listener.PeerInfo(), connector.PeerInfo(), want)
+	}
+	if !strings.Contains(want.String(), "insecure") {
+		t.Errorf("PeerInfo.String() = %q, want insecure mode noted", want.String())
+	}
+
+	if got := listener.LocalAddr().(*net.UDPAddr).Port; got != second {
+		t.Errorf("session port = %d, want %d", got, second)
+	}
+
+	// The unused port is released
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{Port: first})
+	if err != nil {
+		t.Errorf("port %d still bound after selection: %v", first, err)
+	} else {
+		probe.Close()
+	}
+
+	// Traffic flows over the selected socket
+	if err := connector.Send([]byte("ping")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	buf := make([]byte, 64)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := listener.Recv(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Errorf("Recv() = %q, %v, want \"ping\"", buf[:n], err)
+	}
+}
+
+func TestSourceAllowed(t *testing.T) {
+	nets, err := ParseAllowList("203.0.113.0/24,198.51.100.7,2001:db8::/32")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	tr := &Transport{allowFrom: nets}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"203.0.113.1", true},
+		{"203.0.113.254", true},
+		{"203.0.114.1", false},
+		{"198.51.100.7", true},
+		{"198.51.100.8", false},
+		{"::ffff:203.0.113.9", true}, // IPv4-mapped, as seen on dual-stack sockets
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+	for _, tt := range tests {
+		if got := tr.sourceAllowed(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("sourceAllowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	// No allowlist accepts everything
+	open := &Transport{}
+	if !open.sourceAllowed(net.ParseIP("192.0.2.1")) {
+		t.Error("expected empty allowlist to accept any source")
+	}
+}
+
+func TestWaitForPeer_IgnoresSourceOutsideAllowList(t *testing.T) {
+	logger := logging.NewLogger(logging.LevelError)
+	nets, _ := ParseAllowList("192.0.2.0/24")
+
+	port := freePort()
+	listener, err := New(Config{
+		Mode:      ModeListen,
+		LocalPort: uint16(port),
+		AllowFrom: nets,
+		Codec:     newTestCodec(nil),
+		Logger:    logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- listener.WaitForPeer(ctx) }()
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	hello, _, _ := newTestCodec(nil).EncodeHello()
+	conn.Write(hello)
+
+	if err := <-done; err != context.DeadlineExceeded {
+		t.Errorf("expected HELLO from outside allowlist to be ignored, got %v", err)
+	}
+}
+
+func TestWaitForPeer_EmitsHandshakeFailures(t *testing.T) {
+	logger := logging.NewLogger(logging.LevelError)
+	emitter := &testutil.MockEmitter{}
+
+	port := freePort()
+	listener, err := New(Config{
+		Mode:      ModeListen,
+		LocalPort: uint16(port),
+		Codec:     newTestCodec([]byte("listener-key")),
+		Logger:    logger,
+		Emitter:   emitter,
+	})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	// Peer with a typo'd key. The codec is made before the clock starts:
+	// deriving its key is deliberately slow, more so under -race
+	typo := newTestCodec([]byte("listener-kye"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- listener.WaitForPeer(ctx) }()
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		hello, _, _ := typo.EncodeHello()
+		conn.Write(hello)
+	}
+	<-done
+
+	if got := listener.HandshakeFailures(); got != 3 {
+		t.Errorf("HandshakeFailures() = %d, want 3", got)
+	}
+
+	// Every failure is counted, but one source gets one event per interval
+	errs := emitter.GetEvents(events.EventError)
+	if len(errs) != 1 {
+		t.Fatalf("got %d error events, want 1", len(errs))
+	}
+	data, ok := errs[0].Data.(events.ErrorData)
+	if !ok {
+		t.Fatalf("event data is %T, want events.ErrorData", errs[0].Data)
+	}
+	if data.Reason != events.ReasonAuthFailed {
+		t.Errorf("reason = %q, want %q", data.Reason, events.ReasonAuthFailed)
+	}
+	if data.PeerAddr != conn.LocalAddr().String() {
+		t.Errorf("peer_addr = %q, want %q", data.PeerAddr, conn.LocalAddr().String())
+	}
+}
+
+func TestHandshake_ModeMismatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		listenerKey  []byte
+		connectorKey []byte
+	}{
+		{"secure listener, insecure connector", []byte("shared-key"), nil},
+		{"insecure listener, secure connector", nil, []byte("shared-key")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := logging.NewLogger(logging.LevelError)
+			listenEvents := &testutil.MockEmitter{}
+			connectEvents := &testutil.MockEmitter{}
+
+			port := freePort()
+			listener, err := New(Config{
+				Mode:      ModeListen,
+				LocalPort: uint16(port),
+				Codec:     newTestCodec(tt.listenerKey),
+				Logger:    logger,
+				Emitter:   listenEvents,
+			})
+			if err != nil {
+				t.Fatalf("failed to create listener: %v", err)
+			}
+			defer listener.Close()
+
+			connector, err := New(Config{
+				Mode:     ModeConnect,
+				PeerAddr: fmt.Sprintf("127.0.0.1:%d", port),
+				Codec:    newTestCodec(tt.connectorKey),
+				Logger:   logger,
+				Emitter:  connectEvents,
+			})
+			if err != nil {
+				t.Fatalf("failed to create connector: %v", err)
+			}
+			defer connector.Close()
+			// The ERROR only ends the attempt once it times out
+			connector.handshakeTimeout = 300 * time.Millisecond
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			go listener.WaitForPeer(ctx)
+
+			if err := connector.attemptHandshake(ctx); !errors.Is(err, ErrModeMismatch) {
+				t.Fatalf("attemptHandshake() = %v, want ErrModeMismatch", err)
+			}
+
+			for name, emitter := range map[string]*testutil.MockEmitter{"listener": listenEvents, "connector": connectEvents} {
+				errs := emitter.GetEvents(events.EventError)
+				if len(errs) == 0 {
+					t.Fatalf("%s emitted no error events", name)
+				}
+				if data := errs[0].Data.(events.ErrorData); data.Reason != events.ReasonModeMismatch {
+					t.Errorf("%s reason = %q, want %q", name, data.Reason, events.ReasonModeMismatch)
+				}
+			}
+		})
+	}
+}
+
+func TestHandshake_RequireSecureRefusesInsecurePeer(t *testing.T) {
+	logger := logging.NewLogger(logging.LevelError)
+
+	port := freePort()
+	listener, err := New(Config{
+		Mode:          ModeListen,
+		LocalPort:     uint16(port),
+		Codec:         newTestCodec([]byte("shared-key")),
+		Logger:        logger,
+		RequireSecure: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	connector, err := New(Config{
+		Mode:     ModeConnect,
+		PeerAddr: fmt.Sprintf("127.0.0.1:%d", port),
+		Codec:    newTestCodec(nil),
+		Logger:   logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	defer connector.Close()
+	connector.handshakeTimeout = 200 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- listener.WaitForPeer(ctx) }()
+
+	if err := connector.attemptHandshake(ctx); !errors.Is(err, ErrModeMismatch) {
+		t.Fatalf("attemptHandshake() = %v, want ErrModeMismatch", err)
+	}
+	if err := <-errCh; err == nil {
+		t.Fatal("WaitForPeer accepted an insecure peer")
+	}
+	if listener.IsConnected() {
+		t.Error("listener connected to an insecure peer")
+	}
+}
+
+func TestWaitForPeer_RejectsWrongConfirm(t *testing.T) {
+	key := []byte("shared-key")
+	logger := logging.NewLogger(logging.LevelError)
+	emitter := &testutil.MockEmitter{}
+
+	port := freePort()
+	listener, err := New(Config{
+		Mode:      ModeListen,
+		LocalPort: uint16(port),
+		Codec:     newTestCodec(key),
+		Logger:    logger,
+		Emitter:   emitter,
+	})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	// Made before the clock starts (see TestWaitForPeer_EmitsHandshakeFailures)
+	codec := newTestCodec(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go listener.WaitForPeer(ctx)
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// A signed HELLO (e.g. one replayed from an earlier session) gets a HELLO_ACK
+	hello, _, _ := codec.EncodeHello()
+	conn.Write(hello)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no HELLO_ACK from listener: %v", err)
+	}
+	ack, err := codec.Decode(buf[:n])
+	if err != nil || ack.Type != protocol.MsgHelloAck {
+		t.Fatalf("expected HELLO_ACK, got %v", err)
+	}
+	if listener.IsConnected() {
+		t.Fatal("listener connected before HELLO_CONFIRM")
+	}
+
+	// ...but answering some other challenge doesn't complete the handshake
+	conn.Write(codec.EncodeHelloConfirm(make([]byte, protocol.ChallengeSize), ack.Version))
+
+	deadline := time.Now().Add(time.Second)
+	for len(emitter.GetEvents(events.EventError)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	errs := emitter.GetEvents(events.EventError)
+	if len(errs) == 0 {
+		t.Fatal("no error event for invalid HELLO_CONFIRM")
+	}
+	if data := errs[0].Data.(events.ErrorData); data.Reason != events.ReasonChallengeInvalid {
+		t.Errorf("reason = %q, want %q", data.Reason, events.ReasonChallengeInvalid)
+	}
+	if listener.IsConnected() {
+		t.Error("listener connected after invalid HELLO_CONFIRM")
+	}
+}
+
+func TestConnect_RejectsWrongAckResponse(t *testing.T) {
+	key := []byte("shared-key")
+	logger := logging.NewLogger(logging.LevelError)
+
+	// Fake listener that answers HELLOs with a response to the wrong challenge
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer server.Close()
+	go func() {
+		codec := newTestCodec(key)
+		buf := make([]byte, 256)
+		n, addr, err := server.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		hello, err := codec.Decode(buf[:n])
+		if err != nil {
+			return
+		}
+		ack, _, _ := codec.EncodeHelloAck(make([]byte, protocol.ChallengeSize), hello.Version)
+		server.WriteToUDP(ack, addr)
+	}()
+
+	connector, err := New(Config{
+		Mode:     ModeConnect,
+		PeerAddr: server.LocalAddr().String(),
+		Codec:    newTestCodec(key),
+		Logger:   logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	defer connector.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := connector.attemptHandshake(ctx); !errors.Is(err, ErrChallengeInvalid) {
+		t.Fatalf("attemptHandshake() = %v, want ErrChallengeInvalid", err)
+	}
+	if connector.IsConnected() {
+		t.Error("connector connected to a listener that failed the challenge")
+	}
+}
+
+func TestConnect_IgnoresSpoofedError(t *testing.T) {
+	key := []byte("shared-key")
+	logger := logging.NewLogger(logging.LevelError)
+	emitter := &testutil.MockEmitter{}
+
+	// Fake listener that answers a HELLO with an ERROR, as an attacker
+	// racing the real listener might, then with a valid HELLO_ACK
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer server.Close()
+	codec := newTestCodec(key)
+	go func() {
+		buf := make([]byte, 256)
+		n, addr, err := server.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		hello, err := codec.Decode(buf[:n])
+		if err != nil {
+			return
+		}
+		server.WriteToUDP(protocol.EncodeError(protocol.ErrorCodeRateLimited, "go away"), addr)
+		ack, _, _ := codec.EncodeHelloAck(hello.Challenge, hello.Version)
+		server.WriteToUDP(ack, addr)
+	}()
+
+	connector, err := New(Config{
+		Mode:     ModeConnect,
+		PeerAddr: server.LocalAddr().String(),
+		Codec:    newTestCodec(key),
+		Logger:   logger,
+		Emitter:  emitter,
+	})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	defer connector.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := connector.attemptHandshake(ctx); err != nil {
+		t.Fatalf("attemptHandshake() = %v, want the ERROR ignored", err)
+	}
+	if !connector.IsConnected() {
+		t.Error("connector not connected after a valid HELLO_ACK")
+	}
+	if errs := emitter.GetEvents(events.EventError); len(errs) != 0 {
+		t.Errorf("got %d error events, want none: %+v", len(errs), errs)
+	}
+}
+
+// cancelLatency is how long Connect and WaitForPeer may take to return once
+// their context is cancelled: well under ReadTimeout, so they don't wait out
+// the read in progress.
+const cancelLatency = ReadTimeout / 2
+
+// returnsPromptlyOnCancel runs fn, cancels its context after it has had time
+// to block, and fails t unless fn returns within cancelLatency.
+func returnsPromptlyOnCancel(t *testing.T, name string, fn func(ctx context.Context) error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- fn(ctx) }()
+
+	time.Sleep(ReadTimeout / 4) // Mid-read
+	cancelled := time.Now()
+	cancel()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s = %v, want context.Canceled", name, err)
+		}
+		if elapsed := time.Since(cancelled); elapsed > cancelLatency {
+			t.Errorf("%s returned %v after cancel, want within %v", name, elapsed, cancelLatency)
+		}
+	case <-time.After(HandshakeTimeout):
+		t.Fatalf("%s did not return after cancel", name)
+	}
+}
+
+func TestCancel_ReturnsPromptly(t *testing.T) {
+	logger := logging.NewLogger(logging.LevelError)
+
+	// A peer that never answers leaves the handshake blocked in a read
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer silent.Close()
+	connector, err := New(Config{
+		Mode:     ModeConnect,
+		PeerAddr: silent.LocalAddr().String(),
+		Codec:    newTestCodec(nil),
+		Logger:   logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	defer connector.Close()
+	returnsPromptlyOnCancel(t, "UDP Connect", connector.Connect)
+
+	tcpSilent, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer tcpSilent.Close()
+	tcpConnector, err := NewTCP(Config{
+		Mode:     ModeConnect,
+		PeerAddr: tcpSilent.Addr().String(),
+		Codec:    newTestCodec(nil),
+		Logger:   logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create TCP connector: %v", err)
+	}
+	defer tcpConnector.Close()
+	returnsPromptlyOnCancel(t, "TCP Connect", tcpConnector.Connect)
+
+	tcpListener, err := NewTCP(Config{Mode: ModeListen, Codec: newTestCodec(nil), Logger: logger})
+	if err != nil {
+		t.Fatalf("failed to create TCP listener: %v", err)
+	}
+	defer tcpListener.Close()
+	returnsPromptlyOnCancel(t, "TCP WaitForPeer", tcpListener.WaitForPeer)
+
+	// Cancelled while waiting out the backoff after a failed attempt
+	failing := func(context.Context) error { return errors.New("no answer") }
+	returnsPromptlyOnCancel(t, "connectWithBackoff", func(ctx context.Context) error {
+		return connectWithBackoff(ctx, logger, events.NopEmitter{}, newTestCodec(nil), failing)
+	})
+}
+
+func TestWaitProgress_Cadence(t *testing.T) {
+	emitter := &testutil.MockEmitter{}
+	now := time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	p := newWaitProgress(30*time.Second, "UDP port 31415", logging.NewLogger(logging.LevelError), emitter, clock)
+
+	// Offsets from the start, and whether a report is due by then
+	steps := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{0, false},
+		{29 * time.Second, false},
+		{30 * time.Second, true},
+		{31 * time.Second, false},
+		{59 * time.Second, false},
+		{60 * time.Second, true},
+		{2*time.Minute + 5*time.Second, true}, // Late: one report, not two
+		{2*time.Minute + 20*time.Second, false},
+		{2*time.Minute + 30*time.Second, true},
+	}
+	for _, step := range steps {
+		now = time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC).Add(step.at)
+		if got := p.check(); got != step.want {
+			t.Errorf("check() at %v = %v, want %v", step.at, got, step.want)
+		}
+	}
+
+	reports := emitter.GetEvents(events.EventStateChanged)
+	if len(reports) != 4 {
+		t.Fatalf("emitted %d state_changed events, want 4", len(reports))
+	}
+	last := reports[3].Data.(events.StateChangedData)
+	if last.State != "CONNECTING" || last.WaitingSec != 150 {
+		t.Errorf("last report = %+v, want CONNECTING after 150s", last)
+	}
+
+	off := newWaitProgress(0, "UDP port 31415", logging.NewLogger(logging.LevelError), emitter, clock)
+	now = now.Add(time.Hour)
+	if off.check() {
+		t.Error("check() reported with WaitProgress 0")
+	}
+	if ticks, stop := off.ticks(); ticks != nil {
+		stop()
+		t.Error("ticks() returned a channel with WaitProgress 0")
+	}
+}
+
+func TestDescribePorts(t *testing.T) {
+	one := describePorts("UDP", &net.UDPAddr{Port: 31415})
+	two := describePorts("TCP", &net.TCPAddr{Port: 31415}, &net.TCPAddr{Port: 443})
+	if one != "UDP port 31415" || two != "TCP ports 31415, 443" {
+		t.Errorf("describePorts() = %q, %q", one, two)
+	}
+}
+
+func TestWaitForPeer_RepliesVersionUnsupported(t *testing.T) {
+	logger := logging.NewLogger(logging.LevelError)
+
+	port := freePort()
+	listener, err := New(Config{
+		Mode:      ModeListen,
+		LocalPort: uint16(port),
+		Codec:     newTestCodec(nil),
+		Logger:    logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	go listener.WaitForPeer(ctx)
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// HELLO from a future protocol version
+	hello, _, _ := newTestCodec(nil).EncodeHello()
+	hello[2] = byte(protocol.ProtocolVersion + 1)
+	conn.Write(hello)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no reply from listener: %v", err)
+	}
+
+	msg, err := newTestCodec(nil).Decode(buf[:n])
+	if err != nil {
+		t.Fatalf("failed to decode reply: %v", err)
+	}
+	if msg.Type != protocol.MsgError || msg.ErrorCode != protocol.ErrorCodeVersionUnsupported {
+		t.Errorf("got %s code %d, want ERROR code %d", protocol.MessageTypeName(msg.Type), msg.ErrorCode, protocol.ErrorCodeVersionUnsupported)
+	}
+	if msg.ErrorMsg == "" {
+		t.Error("expected an explanatory error message")
+	}
+}
+
+func TestPeerRejected(t *testing.T) {
+	emitter := &testutil.MockEmitter{}
+	tr := &Transport{
+		codec:   newTestCodec(nil),
+		logger:  logging.NewLogger(logging.LevelError),
+		emitter: emitter,
+		limiter: newHandshakeLimiter(),
+	}
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415}
+
+	err := tr.peerRejected(addr, &protocol.Message{
+		Type:      protocol.MsgError,
+		ErrorCode: protocol.ErrorCodeRateLimited,
+		ErrorMsg:  "retry in 30s",
+	})
+	if !errors.Is(err, ErrPeerError) {
+		t.Fatalf("peerRejected() = %v, want ErrPeerError", err)
+	}
+	if got := err.Error(); got != "peer reported an error: rate limited (retry in 30s)" {
+		t.Errorf("error = %q", got)
+	}
+
+	errs := emitter.GetEvents(events.EventError)
+	if len(errs) != 1 {
+		t.Fatalf("got %d error events, want 1", len(errs))
+	}
+	if data := errs[0].Data.(events.ErrorData); data.Reason != events.ReasonRateLimited {
+		t.Errorf("reason = %q, want %q", data.Reason, events.ReasonRateLimited)
+	}
+}
+
+func TestBatchReader_ReadsMultipleDatagrams(t *testing.T) {
+	if !BatchSupported() {
+		t.Skip("batched reads not supported on this platform")
+	}
+
+	tr, err := New(Config{
+		Mode:   ModeListen,
+		Codec:  newTestCodec(nil),
+		Logger: logging.NewLogger(logging.LevelError),
+	})
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	defer tr.Close()
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: tr.LocalAddr().(*net.UDPAddr).Port})
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	const count = 5
+	for i := 0; i < count; i++ {
+		conn.Write([]byte{byte(i), 0xAA})
+	}
+
+	reader := tr.NewBatchReader(DefaultBatchSize, 1500)
+	tr.SetReadDeadline(time.Now().Add(time.Second))
+
+	var got []byte
+	for len(got) < count {
+		n, err := reader.Read()
+		if err != nil {
+			t.Fatalf("Read failed after %d datagrams: %v", len(got), err)
+		}
+		for i := 0; i < n; i++ {
+			data, addr := reader.Datagram(i)
+			if len(data) != 2 || data[1] != 0xAA {
+				t.Fatalf("datagram %d = %x, want [%02x aa]", len(got), data, len(got))
+			}
+			if addr == nil || addr.Port != conn.LocalAddr().(*net.UDPAddr).Port {
+				t.Errorf("datagram from %v, want %v", addr, conn.LocalAddr())
+			}
+			got = append(got, data[0])
+		}
+	}
+
+	// Ordering is preserved
+	for i, b := range got {
+		if int(b) != i {
+			t.Errorf("datagram %d carried %d", i, b)
+		}
+	}
+}
+
+func TestBatchWriter_SendsInOrder(t *testing.T) {
+	if !BatchSupported() {
+		t.Skip("batched writes not supported on this platform")
+	}
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer peer.Close()
+
+	tr, err := New(Config{
+		Mode:     ModeConnect,
+		PeerAddr: peer.LocalAddr().String(),
+		Codec:    newTestCodec(nil),
+		Logger:   logging.NewLogger(logging.LevelError),
+	})
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	defer tr.Close()
+
+	writer := tr.NewBatchWriter(4)
+
+	// Not connected yet
+	if _, err := writer.Write([][]byte{{0}}); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Write before connect = %v, want ErrNotConnected", err)
+	}
+	tr.connected = true
+
+	// More datagrams than one batch holds
+	const count = 10
+	datagrams := make([][]byte, count)
+	for i := range datagrams {
+		datagrams[i] = []byte{byte(i)}
+	}
+	sent, err := writer.Write(datagrams)
+	if err != nil || sent != count {
+		t.Fatalf("Write() = %d, %v, want %d, nil", sent, err, count)
+	}
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	for i := 0; i < count; i++ {
+		n, err := peer.Read(buf)
+		if err != nil {
+			t.Fatalf("read %d failed: %v", i, err)
+		}
+		if n != 1 || int(buf[0]) != i {
+			t.Errorf("datagram %d = %x, want [%02x]", i, buf[:n], i)
+		}
+	}
+}
+
+func TestHandshakeFailureReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{protocol.ErrInvalidHMAC, events.ReasonAuthFailed},
+		{fmt.Errorf("%w: expected 1, got 2", protocol.ErrVersionMismatch), events.ReasonVersionMismatch},
+		{protocol.ErrUnknownMsgType, events.ReasonInvalidMessage},
+	}
+	for _, tt := range tests {
+		if got := handshakeFailureReason(tt.err); got != tt.want {
+			t.Errorf("handshakeFailureReason(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// newTestCodec is protocol.NewCodec for keys DeriveKey is known to accept.
+func newTestCodec(key []byte) *protocol.Codec {
+	codec, err := protocol.NewCodec(key)
+	if err != nil {
+		panic(err)
+	}
+	return codec
+}
+
 // Helper function to find a free port
 func freePort() int {
 	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
@@ -422,3 +1648,262 @@ func freePort() int {
 	defer conn.Close()
 	return conn.LocalAddr().(*net.UDPAddr).Port
 }
+
+func TestTCPFraming_Roundtrip(t *testing.T) {
+	messages := [][]byte{
+		{0x03, 1, 2, 3, 4, 5, 6, 7, 8},
+		{},
+		bytes.Repeat([]byte{0xAB}, MaxTCPMessageSize),
+		{0x05},
+	}
+
+	var stream []byte
+	for _, msg := range messages {
+		var err error
+		stream, err = appendFrame(stream, msg)
+		if err != nil {
+			t.Fatalf("appendFrame failed: %v", err)
+		}
+	}
+
+	// Deliver the stream one byte per Read to exercise reassembly
+	reader := newFrameReader(iotest.OneByteReader(bytes.NewReader(stream)))
+	buf := make([]byte, MaxTCPMessageSize)
+	for i, want := range messages {
+		n, err := reader.next(buf)
+		if err != nil {
+			t.Fatalf("message %d: next failed: %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Errorf("message %d: got %d bytes, want %d", i, n, len(want))
+		}
+	}
+	if _, err := reader.next(buf); err != io.EOF {
+		t.Errorf("next at end of stream = %v, want io.EOF", err)
+	}
+}
+
+// chunkReader returns r's bytes in reads of the given sizes, in turn.
+type chunkReader struct {
+	r     io.Reader
+	sizes []int
+	i     int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	size := c.sizes[c.i%len(c.sizes)]
+	c.i++
+	return c.r.Read(p[:min(size, len(p))])
+}
+
+func TestTCPFraming_ChunkBoundaries(t *testing.T) {
+	var messages [][]byte
+	var stream []byte
+	for i := range 50 {
+		msg := bytes.Repeat([]byte{byte(i)}, i*37%300)
+		messages = append(messages, msg)
+		stream, _ = appendFrame(stream, msg)
+	}
+
+	// Reads that split length prefixes, end exactly on message boundaries
+	// and span several messages at once
+	for _, sizes := range [][]int{{1, 2}, {3}, {tcpLengthSize + 5, 1}, {7, 1000, 1}, {len(stream)}} {
+		reader := newFrameReader(&chunkReader{r: bytes.NewReader(stream), sizes: sizes})
+		buf := make([]byte, MaxTCPMessageSize)
+		for i, want := range messages {
+			n, err := reader.next(buf)
+			if err != nil {
+				t.Fatalf("chunks %v, message %d: next failed: %v", sizes, i, err)
+			}
+			if !bytes.Equal(buf[:n], want) {
+				t.Fatalf("chunks %v, message %d: got %d bytes, want %d", sizes, i, n, len(want))
+			}
+		}
+		if _, err := reader.next(buf); err != io.EOF {
+			t.Errorf("chunks %v: next at end of stream = %v, want io.EOF", sizes, err)
+		}
+	}
+}
+
+func TestTCPFraming_PartialReadSurvivesTimeout(t *testing.T) {
+	frame, _ := appendFrame(nil, []byte("hello"))
+
+	// The first read returns half the frame and then a timeout
+	r := iotest.TimeoutReader(bytes.NewReader(frame[:4]))
+	reader := newFrameReader(io.MultiReader(r, bytes.NewReader(frame[4:])))
+	buf := make([]byte, 16)
+
+	if _, err := reader.next(buf); err != iotest.ErrTimeout {
+		t.Fatalf("first next = %v, want timeout", err)
+	}
+	n, err := reader.next(buf)
+	if err != nil {
+		t.Fatalf("second next failed: %v", err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("got %q, want %q", buf[:n], "hello")
+	}
+}
+
+func TestTCPFraming_TooLarge(t *testing.T) {
+	if _, err := appendFrame(nil, make([]byte, MaxTCPMessageSize+1)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("appendFrame() error = %v, want ErrMessageTooLarge", err)
+	}
+
+	// A corrupt length prefix is rejected rather than buffered forever
+	reader := newFrameReader(bytes.NewReader([]byte{0xFF, 0xFF, 0x00}))
+	if _, err := reader.next(make([]byte, 16)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("next() error = %v, want ErrMessageTooLarge", err)
+	}
+
+	// ...even when it arrives a byte at a time after a valid message, and
+	// without waiting for the bytes it announces
+	stream, _ := appendFrame(nil, []byte("ok"))
+	stream = binary.BigEndian.AppendUint16(stream, MaxTCPMessageSize+1)
+	reader = newFrameReader(iotest.OneByteReader(bytes.NewReader(stream)))
+	buf := make([]byte, 16)
+	if n, err := reader.next(buf); err != nil || string(buf[:n]) != "ok" {
+		t.Fatalf("next() = %q, %v, want the valid message first", buf[:n], err)
+	}
+	if _, err := reader.next(buf); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("next() error = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	for input, want := range map[string]Backend{"udp": BackendUDP, " TCP ": BackendTCP} {
+		got, err := ParseBackend(input)
+		if err != nil || got != want {
+			t.Errorf("ParseBackend(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseBackend("quic"); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	if got := MaxMessageSize(BackendTCP); got < protocol.MaxMessageSize || got > 0xFFFF {
+		t.Errorf("MaxMessageSize(tcp) = %d, want room for protocol.MaxMessageSize within the uint16 length prefix", got)
+	}
+	if got := MaxMessageSize(BackendUDP); got != MaxUDPMessageSize {
+		t.Errorf("MaxMessageSize(udp) = %d, want %d", got, MaxUDPMessageSize)
+	}
+}
+
+func TestTCPTransport_SilentClientsDontBlockPeer(t *testing.T) {
+	key := []byte("tcp-test-key")
+	logger := logging.NewLogger(logging.LevelError)
+	listenCodec, connectCodec := newTestCodec(key), newTestCodec(key)
+
+	listener, err := NewTCP(Config{Mode: ModeListen, Codec: listenCodec, Logger: logger})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+	connector, err := NewTCP(Config{Mode: ModeConnect, PeerAddr: listener.LocalAddr().String(), Codec: connectCodec, Logger: logger})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	defer connector.Close()
+
+	// Clients that connect and never send a HELLO
+	var silent []net.Conn
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", listener.LocalAddr().String())
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		defer c.Close()
+		silent = append(silent, c)
+	}
+
+	// The peer connects well within one client's TCPAcceptTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), TCPAcceptTimeout/2)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- listener.WaitForPeer(ctx) }()
+	if err := connector.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("WaitForPeer failed: %v", err)
+	}
+
+	// The silent clients' connections are closed once the peer is in
+	for i, c := range silent {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("silent client %d: Read() = %v, want EOF", i, err)
+		}
+	}
+}
+
+func TestTCPTransport_HandshakeAndExchange(t *testing.T) {
+	key := []byte("tcp-test-key")
+	logger := logging.NewLogger(logging.LevelError)
+
+	listener, err := NewTCP(Config{
+		Mode:   ModeListen,
+		Codec:  newTestCodec(key),
+		Logger: logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	connector, err := NewTCP(Config{
+		Mode:     ModeConnect,
+		PeerAddr: listener.LocalAddr().String(),
+		Codec:    newTestCodec(key),
+		Logger:   logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	defer connector.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- listener.WaitForPeer(ctx) }()
+
+	if err := connector.Connect(ctx); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("WaitForPeer failed: %v", err)
+	}
+
+	want := PeerInfo{Version: protocol.ProtocolVersion, Secure: true}
+	if listener.PeerInfo() != want || connector.PeerInfo() != want {
+		t.Errorf("PeerInfo() = %+v / %+v, want %+v", listener.PeerInfo(), connector.PeerInfo(), want)
+	}
+
+	// Several messages sent back to back arrive intact and in order
+	for i := 0; i < 3; i++ {
+		if err := connector.Send([]byte{byte(i), 0xAA}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	buf := make([]byte, MaxTCPMessageSize)
+	for i := 0; i < 3; i++ {
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := listener.Recv(buf)
+		if err != nil {
+			t.Fatalf("Recv failed: %v", err)
+		}
+		if n != 2 || buf[0] != byte(i) || addr == nil {
+			t.Errorf("message %d = %x from %v", i, buf[:n], addr)
+		}
+	}
+
+	// Closing one side surfaces as ErrPeerClosed on the other
+	connector.Close()
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := listener.Recv(buf); !errors.Is(err, ErrPeerClosed) {
+		t.Errorf("Recv after peer close = %v, want ErrPeerClosed", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Package xbslink is the public API of xbslink-ng for programs outside this
+// module: compatible clients, interop tests and fuzzers.
+//
+// # Wire format stability
+//
+// Codec produces and accepts exactly the messages xbslink-ng itself sends,
+// as described in the README's Wire Protocol section. The wire format is
+// fixed for a given ProtocolVersion: any change to message layout, framing
+// or the handshake responses comes with a new ProtocolVersion, and peers
+// keep accepting every version from MinProtocolVersion up. A message encoded
+// by this package at a given version therefore stays decodable by every
+// xbslink-ng release that still lists that version as supported.
+package xbslink
+
+import "github.com/xbslink/xbslink-ng/internal/protocol"
+
+// Protocol versions; see SupportedVersion. From KDFVersion on, messages are
+// keyed with DeriveKey(passphrase) instead of the passphrase itself.
+const (
+	ProtocolVersion    = protocol.ProtocolVersion
+	MinProtocolVersion = protocol.MinProtocolVersion
+	KDFVersion         = protocol.KDFVersion
+	FlagsVersion       = protocol.FlagsVersion
+)
+
+// Flags offered in a HELLO or HELLO_ACK from FlagsVersion on; see
+// UsePeerFlags.
+const (
+	FlagFrameCRC = protocol.FlagFrameCRC
+)
+
+// Message types, the first byte of every message.
+const (
+	MsgFrame        = protocol.MsgFrame
+	MsgHello        = protocol.MsgHello
+	MsgHelloAck     = protocol.MsgHelloAck
+	MsgPing         = protocol.MsgPing
+	MsgPong         = protocol.MsgPong
+	MsgBye          = protocol.MsgBye
+	MsgError        = protocol.MsgError
+	MsgHelloConfirm = protocol.MsgHelloConfirm
+	MsgFrameCRC     = protocol.MsgFrameCRC
+)
+
+// Error codes carried in MsgError.
+const (
+	ErrorCodeModeMismatch       = protocol.ErrorCodeModeMismatch
+	ErrorCodeVersionUnsupported = protocol.ErrorCodeVersionUnsupported
+	ErrorCodeRateLimited        = protocol.ErrorCodeRateLimited
+)
+
+// Limits on a key for NewCodecFromKey.
+const (
+	MinKeySize = protocol.MinKeySize
+	MaxKeySize = protocol.MaxKeySize
+)
+
+// Frame size limits.
+const (
+	MinEthernetFrame  = protocol.MinEthernetFrame
+	MaxFrameSize      = protocol.MaxFrameSize
+	MaxJumboFrameSize = protocol.MaxJumboFrameSize
+	MaxMessageSize    = protocol.MaxMessageSize
+)
+
+// Errors returned by Codec.Decode.
+var (
+	ErrMessageTooShort   = protocol.ErrMessageTooShort
+	ErrInvalidHMAC       = protocol.ErrInvalidHMAC
+	ErrReplayDetected    = protocol.ErrReplayDetected
+	ErrUnknownMsgType    = protocol.ErrUnknownMsgType
+	ErrInvalidPayload    = protocol.ErrInvalidPayload
+	ErrVersionMismatch   = protocol.ErrVersionMismatch
+	ErrChallengeRequired = protocol.ErrChallengeRequired
+	ErrFrameCorrupt      = protocol.ErrFrameCorrupt
+)
+
+// Message is a decoded message. Which fields are set depends on Type.
+type Message = protocol.Message
+
+// Codec encodes and decodes xbslink-ng messages. With a key it signs every
+// message with HMAC-SHA256 and rejects replays, as with --key; without one
+// it uses insecure framing. A Codec is one side of one session: its nonces
+// must not be shared between connections.
+type Codec struct {
+	codec *protocol.Codec
+}
+
+// NewCodec creates a codec. A nil or empty key selects insecure mode;
+// otherwise key is the passphrase, as given to --key. It fails only if
+// DeriveKey does.
+func NewCodec(key []byte) (*Codec, error) {
+	codec, err := protocol.NewCodec(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Codec{codec: codec}, nil
+}
+
+// NewCodecFromKey creates a codec keyed with key itself, without DeriveKey,
+// for a random key of MinKeySize to MaxKeySize bytes (--key-format hex or
+// base64).
+func NewCodecFromKey(key []byte) *Codec {
+	return &Codec{codec: protocol.NewCodecFromKey(key)}
+}
+
+// DeriveKey returns the HMAC key that protocol versions from KDFVersion on
+// derive from passphrase (PBKDF2-HMAC-SHA256 with a fixed salt). It fails
+// only in FIPS 140-only mode, for passphrases shorter than 14 bytes.
+func DeriveKey(passphrase []byte) ([]byte, error) {
+	return protocol.DeriveKey(passphrase)
+}
+
+// UseVersion keys the session for protocol version v: the raw passphrase
+// below KDFVersion, the derived key from it. A codec starts at
+// ProtocolVersion, and EncodeHelloAck switches to the version it answers
+// in; a client speaking an older version calls it before EncodeHello.
+func (c *Codec) UseVersion(v uint16) {
+	c.codec.UseVersion(v)
+}
+
+// IsSecure reports whether the codec signs and verifies messages.
+func (c *Codec) IsSecure() bool {
+	return c.codec.IsSecure()
+}
+
+// SetMaxFrameSize raises the largest frame the codec encodes or accepts from
+// MaxFrameSize, up to MaxJumboFrameSize. Both peers must agree on it.
+func (c *Codec) SetMaxFrameSize(n int) error {
+	return c.codec.SetMaxFrameSize(n)
+}
+
+// SetFrameCRC offers CRC-32 checked frames (FlagFrameCRC) in the codec's
+// HELLO or HELLO_ACK, as with --frame-crc.
+func (c *Codec) SetFrameCRC(on bool) {
+	c.codec.SetFrameCRC(on)
+}
+
+// UsePeerFlags settles the session's optional features from the Flags of
+// the peer's HELLO or HELLO_ACK: those both sides offer are used.
+func (c *Codec) UsePeerFlags(flags byte) {
+	c.codec.UsePeerFlags(flags)
+}
+
+// EncodeFrame encodes an Ethernet frame as a FRAME message, or FRAME_CRC if
+// the session uses frame CRCs.
+func (c *Codec) EncodeFrame(frame []byte) ([]byte, error) {
+	return c.codec.EncodeFrame(frame)
+}
+
+// EncodeHello encodes a HELLO at ProtocolVersion, returning the message and
+// the challenge it carries, for checking the HELLO_ACK's response.
+func (c *Codec) EncodeHello() (msg, challenge []byte, err error) {
+	return c.codec.EncodeHello()
+}
+
+// EncodeHelloAck answers a HELLO's challenge at the given version. From
+// version 2 it returns the listener's own challenge too, to be answered by
+// a HELLO_CONFIRM.
+func (c *Codec) EncodeHelloAck(challenge []byte, version uint16) (msg, ownChallenge []byte, err error) {
+	return c.codec.EncodeHelloAck(challenge, version)
+}
+
+// EncodeHelloConfirm answers the challenge in a version 2+ HELLO_ACK.
+func (c *Codec) EncodeHelloConfirm(challenge []byte, version uint16) []byte {
+	return c.codec.EncodeHelloConfirm(challenge, version)
+}
+
+// VerifyChallengeResponse reports whether a HELLO_ACK's response answers the
+// challenge sent in our HELLO.
+func (c *Codec) VerifyChallengeResponse(challenge, response []byte, version uint16) bool {
+	return c.codec.VerifyChallengeResponse(challenge, response, version)
+}
+
+// VerifyConfirmResponse reports whether a HELLO_CONFIRM's response answers
+// the challenge sent in our HELLO_ACK.
+func (c *Codec) VerifyConfirmResponse(challenge, response []byte, version uint16) bool {
+	return c.codec.VerifyConfirmResponse(challenge, response, version)
+}
+
+// EncodePing encodes a PING carrying timestamp (Unix nanoseconds).
+func (c *Codec) EncodePing(timestamp int64) []byte {
+	return c.codec.EncodePing(timestamp)
+}
+
+// EncodePong encodes the PONG answering a PING's timestamp.
+func (c *Codec) EncodePong(timestamp int64) []byte {
+	return c.codec.EncodePong(timestamp)
+}
+
+// EncodePongWithClock encodes a PONG that also carries the responder's
+// clock (Unix nanoseconds), decoded as Message.PeerClock.
+func (c *Codec) EncodePongWithClock(timestamp, clock int64) []byte {
+	return c.codec.EncodePongWithClock(timestamp, clock)
+}
+
+// EncodeBye encodes a BYE.
+func (c *Codec) EncodeBye() []byte {
+	return c.codec.EncodeBye()
+}
+
+// Decode parses a message. Frame, Challenge and Response alias data, so
+// copy them before reusing data. A secure codec accepts an unsigned ERROR
+// here, since a peer rejecting a HELLO may not share the key; treat it as
+// advisory.
+func (c *Codec) Decode(data []byte) (*Message, error) {
+	return c.codec.Decode(data)
+}
+
+// ResetRecvNonce forgets the last nonce received, for a codec reused after
+// the peer reconnects.
+func (c *Codec) ResetRecvNonce() {
+	c.codec.ResetRecvNonce()
+}
+
+// EncodeError encodes an ERROR message. ERROR is always unauthenticated, so
+// it needs no codec.
+func EncodeError(code uint16, text string) []byte {
+	return protocol.EncodeError(code, text)
+}
+
+// SupportedVersion reports whether v is a protocol version xbslink-ng speaks.
+func SupportedVersion(v uint16) bool {
+	return protocol.SupportedVersion(v)
+}
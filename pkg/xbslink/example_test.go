@@ -0,0 +1,71 @@
+package xbslink_test
+
+import (
+	"fmt"
+
+	"github.com/xbslink/xbslink-ng/pkg/xbslink"
+)
+
+// Two codecs sharing a key stand in for the connector and the listener.
+func ExampleCodec() {
+	key := []byte("shared secret")
+	connector, err := xbslink.NewCodec(key)
+	if err != nil {
+		panic(err)
+	}
+	listener, err := xbslink.NewCodec(key)
+	if err != nil {
+		panic(err)
+	}
+
+	// Handshake: HELLO, HELLO_ACK, HELLO_CONFIRM
+	hello, challenge, err := connector.EncodeHello()
+	if err != nil {
+		panic(err)
+	}
+	msg, err := listener.Decode(hello)
+	if err != nil {
+		panic(err)
+	}
+	ack, listenerChallenge, err := listener.EncodeHelloAck(msg.Challenge, msg.Version)
+	if err != nil {
+		panic(err)
+	}
+	msg, err = connector.Decode(ack)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("listener proved key:", connector.VerifyChallengeResponse(challenge, msg.Response, msg.Version))
+	confirm, err := listener.Decode(connector.EncodeHelloConfirm(msg.Challenge, msg.Version))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("connector proved key:", listener.VerifyConfirmResponse(listenerChallenge, confirm.Response, msg.Version))
+
+	// A broadcast Ethernet frame from an Xbox
+	frame := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // destination
+		0x00, 0x50, 0xf2, 0x1a, 0x2b, 0x3c, // source
+		0x08, 0x00, // IPv4
+		0x45, 0x00,
+	}
+	wire, err := connector.EncodeFrame(frame)
+	if err != nil {
+		panic(err)
+	}
+	msg, err = listener.Decode(wire)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("type %#02x, %d-byte frame from % x\n", msg.Type, len(msg.Frame), msg.Frame[6:12])
+
+	// Replaying a message is rejected
+	_, err = listener.Decode(wire)
+	fmt.Println(err)
+
+	// Output:
+	// listener proved key: true
+	// connector proved key: true
+	// type 0x00, 16-byte frame from 00 50 f2 1a 2b 3c
+	// replay attack detected: nonce not increasing
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/capture"
+	"github.com/xbslink/xbslink-ng/internal/logging"
+)
+
+// Echo frames carry echoMagic, a random run ID and a sequence number, so a
+// frame is only matched by the run and attempt that sent it.
+const (
+	echoMagic     = "XBOX-SIM-ECHO"
+	echoTagSize   = len(echoMagic) + 8 + 4
+	echoEtherType = 0x88B5 // IEEE local experimental: nothing on the LAN parses it
+	echoMinFrame  = 60     // Ethernet minimum without FCS
+)
+
+// runEcho injects tagged frames on bridge A's Xbox side and waits for each to
+// come out of bridge B on its Xbox side, measuring the end-to-end latency.
+func runEcho() {
+	fs := flag.NewFlagSet("echo", flag.ExitOnError)
+	injectIface := fs.String("inject-interface", "", "Interface on bridge A's Xbox side to inject frames on")
+	observeIface := fs.String("observe-interface", "", "Interface on bridge B's Xbox side to watch for them")
+	xboxMAC := fs.String("xbox-mac", "00:50:F2:AA:AA:AA", "Source MAC of the frames: bridge A's --xbox-mac")
+	count := fs.Int("count", 10, "Number of frames to send")
+	interval := fs.Duration("interval", 100*time.Millisecond, "Pause between frames")
+	timeout := fs.Duration("timeout", 2*time.Second, "How long to wait for each frame at bridge B")
+
+	fs.Parse(os.Args[2:])
+
+	if *injectIface == "" || *observeIface == "" {
+		fmt.Fprintln(os.Stderr, "Error: --inject-interface and --observe-interface are required")
+		os.Exit(1)
+	}
+	if *injectIface == *observeIface {
+		// The frame would be seen straight away, without crossing the bridges
+		fmt.Fprintln(os.Stderr, "Error: --inject-interface and --observe-interface must be on separate networks")
+		os.Exit(1)
+	}
+	if *count < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --count must be at least 1")
+		os.Exit(1)
+	}
+	mac, err := capture.ParseMAC(*xboxMAC)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --xbox-mac: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Bridge B injects A's frames with their original source, so capturing
+	// "from the Xbox" on B's side sees exactly the frames that crossed
+	cap, err := capture.New(capture.Config{
+		Interface:       *observeIface,
+		InjectInterface: *injectIface,
+		XboxMAC:         mac,
+		Logger:          logging.NewLogger(logging.LevelWarn),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening capture: %v\n", err)
+		os.Exit(1)
+	}
+	defer cap.Close()
+
+	runID := make([]byte, 8)
+	rand.Read(runID)
+
+	fmt.Println("=== Xbox System Link Frame Roundtrip ===")
+	fmt.Printf("Inject: %s (as %s) -> bridge A -> bridge B -> observe: %s\n\n", *injectIface, mac, *observeIface)
+
+	var latencies []time.Duration
+	buf := make([]byte, capture.SnapLen)
+	for seq := 0; seq < *count; seq++ {
+		if seq > 0 {
+			time.Sleep(*interval)
+		}
+		frame := buildEchoFrame(mac, runID, uint32(seq))
+		sent := time.Now()
+		if err := cap.WritePacket(frame); err != nil {
+			fmt.Printf("Frame %d: inject failed: %v\n", seq+1, err)
+			continue
+		}
+		seen, err := awaitEcho(cap, buf, frame[14:14+echoTagSize], sent.Add(*timeout))
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Error reading capture: %v\n", err)
+			os.Exit(1)
+		case seen.IsZero():
+			fmt.Printf("Frame %d: LOST (not seen within %v)\n", seq+1, *timeout)
+		default:
+			latency := seen.Sub(sent)
+			latencies = append(latencies, latency)
+			fmt.Printf("Frame %d: %v\n", seq+1, latency.Round(10*time.Microsecond))
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("Results: %d/%d frames arrived", len(latencies), *count)
+	if len(latencies) > 0 {
+		lo, hi, sum := latencies[0], latencies[0], time.Duration(0)
+		for _, l := range latencies {
+			lo, hi, sum = min(lo, l), max(hi, l), sum+l
+		}
+		avg := sum / time.Duration(len(latencies))
+		fmt.Printf(", latency min/avg/max %v/%v/%v",
+			lo.Round(10*time.Microsecond), avg.Round(10*time.Microsecond), hi.Round(10*time.Microsecond))
+	}
+	fmt.Println()
+
+	if len(latencies) < *count {
+		os.Exit(1)
+	}
+}
+
+// buildEchoFrame builds a broadcast frame from mac carrying the echo tag for
+// runID and seq, padded to the Ethernet minimum.
+func buildEchoFrame(mac net.HardwareAddr, runID []byte, seq uint32) []byte {
+	payload := make([]byte, echoMinFrame-14)
+	n := copy(payload, echoMagic)
+	n += copy(payload[n:], runID)
+	binary.BigEndian.PutUint32(payload[n:], seq)
+	broadcast := net.HardwareAddr{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	return buildEthernetFrame(mac, broadcast, echoEtherType, payload)
+}
+
+// awaitEcho reads the capture until a frame carrying tag arrives or the
+// deadline passes, and returns when it arrived (zero if it didn't). Frames
+// from earlier attempts that arrive late are skipped.
+func awaitEcho(cap *capture.Capture, buf, tag []byte, deadline time.Time) (time.Time, error) {
+	for time.Now().Before(deadline) {
+		n, err := cap.ReadPacketInto(buf)
+		if err != nil {
+			if errors.Is(err, capture.ErrFrameTooLarge) || errors.Is(err, capture.ErrFrameTruncated) {
+				continue
+			}
+			return time.Time{}, err
+		}
+		if n >= 14+len(tag) && bytes.Equal(buf[14:14+len(tag)], tag) {
+			return time.Now(), nil
+		}
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ImpairConfig provides thread-safe loss and reorder rates for outgoing
+// frames, to simulate a bad WAN.
+type ImpairConfig struct {
+	mu      sync.Mutex
+	loss    float64 // percent of frames dropped
+	reorder float64 // percent of frames held back behind the next one
+	rng     *rand.Rand
+}
+
+// NewImpairConfig creates a new ImpairConfig. Rates are percentages, 0-100.
+func NewImpairConfig(loss, reorder float64) *ImpairConfig {
+	return newImpairConfig(loss, reorder, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+func newImpairConfig(loss, reorder float64, rng *rand.Rand) *ImpairConfig {
+	return &ImpairConfig{loss: loss, reorder: reorder, rng: rng}
+}
+
+// Drop reports whether to drop the next frame, with probability loss%.
+func (ic *ImpairConfig) Drop() bool {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	return ic.loss > 0 && ic.rng.Float64()*100 < ic.loss
+}
+
+// Reorder reports whether to hold the next frame back, with probability
+// reorder%.
+func (ic *ImpairConfig) Reorder() bool {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	return ic.reorder > 0 && ic.rng.Float64()*100 < ic.reorder
+}
+
+// Active reports whether any impairment is configured.
+func (ic *ImpairConfig) Active() bool {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	return ic.loss > 0 || ic.reorder > 0
+}
+
+// String returns a human-readable summary.
+func (ic *ImpairConfig) String() string {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	return fmt.Sprintf("loss=%g%% reorder=%g%%", ic.loss, ic.reorder)
+}
+
+// impairer applies an ImpairConfig to a stream of frames. A reordered frame
+// is held back and sent right after the next one, swapping the two. Not safe
+// for concurrent use; one sender owns it.
+type impairer struct {
+	cfg  *ImpairConfig
+	held []byte
+
+	sent, dropped, reordered uint64
+}
+
+// next takes the next frame to send and returns the frames to send now, in
+// order: none if it was dropped or held back, two if it releases a held one.
+func (im *impairer) next(frame []byte) [][]byte {
+	if im.cfg.Drop() {
+		im.dropped++
+		return nil
+	}
+	if im.held != nil {
+		out := [][]byte{frame, im.held}
+		im.held = nil
+		im.sent += 2
+		return out
+	}
+	if im.cfg.Reorder() {
+		im.held = frame
+		im.reordered++
+		return nil
+	}
+	im.sent++
+	return [][]byte{frame}
+}
+
+// String summarizes what the impairer did.
+func (im *impairer) String() string {
+	return fmt.Sprintf("%d frames sent, %d dropped, %d reordered", im.sent, im.dropped, im.reordered)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestImpairConfig_Rates(t *testing.T) {
+	const trials = 10000
+	tests := []struct {
+		name          string
+		loss, reorder float64
+	}{
+		{"off", 0, 0},
+		{"always", 100, 100},
+		{"partial", 30, 5},
+	}
+	for _, tt := range tests {
+		ic := newImpairConfig(tt.loss, tt.reorder, rand.New(rand.NewSource(1)))
+		drops, reorders := 0, 0
+		for i := 0; i < trials; i++ {
+			if ic.Drop() {
+				drops++
+			}
+			if ic.Reorder() {
+				reorders++
+			}
+		}
+		for _, c := range []struct {
+			what    string
+			got     int
+			percent float64
+		}{{"Drop", drops, tt.loss}, {"Reorder", reorders, tt.reorder}} {
+			got := float64(c.got) * 100 / trials
+			if got < c.percent-2 || got > c.percent+2 {
+				t.Errorf("%s: %s() true %.1f%% of the time, want about %g%%", tt.name, c.what, got, c.percent)
+			}
+		}
+	}
+}
+
+func TestImpairer_Next(t *testing.T) {
+	frames := [][]byte{{1}, {2}, {3}, {4}, {5}}
+	tests := []struct {
+		name          string
+		loss, reorder float64
+		want          [][]byte // everything sent, in order
+	}{
+		{"clean", 0, 0, [][]byte{{1}, {2}, {3}, {4}, {5}}},
+		{"all lost", 100, 0, nil},
+		// Each held frame goes out after the next; the last stays held
+		{"all reordered", 0, 100, [][]byte{{2}, {1}, {4}, {3}}},
+	}
+	for _, tt := range tests {
+		im := &impairer{cfg: newImpairConfig(tt.loss, tt.reorder, rand.New(rand.NewSource(1)))}
+		var sent [][]byte
+		for _, f := range frames {
+			sent = append(sent, im.next(f)...)
+		}
+		if len(sent) != len(tt.want) {
+			t.Errorf("%s: sent %v, want %v", tt.name, sent, tt.want)
+			continue
+		}
+		for i := range sent {
+			if !bytes.Equal(sent[i], tt.want[i]) {
+				t.Errorf("%s: sent %v, want %v", tt.name, sent, tt.want)
+				break
+			}
+		}
+		if im.sent != uint64(len(tt.want)) {
+			t.Errorf("%s: counted %d sent, want %d", tt.name, im.sent, len(tt.want))
+		}
+	}
+}
@@ -24,6 +24,10 @@ func main() {
 		runGenerate()
 	case "client":
 		runClient()
+	case "server":
+		runServer()
+	case "echo":
+		runEcho()
 	case "help":
 		printUsage()
 	default:
@@ -40,6 +44,8 @@ Commands:
   test      Run E2E tests against xbslink-ng bridges
   generate  Generate traffic for manual testing
   client    Connect to a live server as a simulated peer
+  server    Listen for a bridge in connect mode as a simulated peer
+  echo      Check that frames cross two bridges end to end (needs pcap)
   help      Show this help message
 
 Test flags:
@@ -48,8 +54,17 @@ Test flags:
   --xbox-mac-a   Xbox MAC for bridge A (default: 00:50:F2:AA:AA:AA)
   --xbox-mac-b   Xbox MAC for bridge B (default: 00:50:F2:BB:BB:BB)
 
-Client flags:
-  --address              Server address host:port (required)
+Echo flags:
+  --inject-interface   Interface on bridge A's Xbox side to inject frames on (required)
+  --observe-interface  Interface on bridge B's Xbox side to watch for them (required)
+  --xbox-mac           Source MAC of the frames, bridge A's --xbox-mac (default: 00:50:F2:AA:AA:AA)
+  --count              Number of frames to send (default: 10)
+  --interval           Pause between frames (default: 100ms)
+  --timeout            How long to wait for each frame at bridge B (default: 2s)
+
+Client/server flags:
+  --address              Server address host:port (client only, required)
+  --port                 UDP port to listen on (server only, default: 31415)
   --key                  Encryption key (empty for insecure)
   --log                  Log level: error, warn, info, debug, trace (default: info)
   --send-frames          Send simulated Xbox frames (default: true)
@@ -57,8 +72,12 @@ Client flags:
   --latency-base         Base simulated latency for PONG replies (default: 0)
   --latency-jitter       Jitter range ± for simulated latency (default: 0)
   --latency-step         Step size for interactive +/- adjustment (default: 5ms)
+  --loss                 Percent of sent frames to drop (default: 0)
+  --reorder              Percent of sent frames to hold back behind the next one (default: 0)
+  --rate                 Send frames back to back at this many bits/s (default: 0, every --frame-interval)
+  --recv-rate            Drop received messages beyond this many bits/s (default: 0, no limit)
 
-Interactive keys (client, TTY only):
+Interactive keys (client/server, TTY only):
   +/=  Increase base latency by step
   -    Decrease base latency by step
   q    Quit
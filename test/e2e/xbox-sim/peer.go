@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/xbslink/xbslink-ng/internal/logging"
+	"github.com/xbslink/xbslink-ng/internal/protocol"
+	"github.com/xbslink/xbslink-ng/internal/ratelimit"
+	"github.com/xbslink/xbslink-ng/internal/transport"
+	"github.com/xbslink/xbslink-ng/test/testutil"
+)
+
+// peerOptions are the flags the client and server share: everything about
+// how the simulated peer behaves once connected.
+type peerOptions struct {
+	key           string
+	logLevel      string
+	sendFrames    bool
+	frameInterval time.Duration
+	latencyBase   time.Duration
+	latencyJitter time.Duration
+	latencyStep   time.Duration
+	loss          float64
+	reorder       float64
+	rate          uint64
+	recvRate      uint64
+}
+
+func (o *peerOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.key, "key", "", "Encryption key (empty for insecure)")
+	fs.StringVar(&o.logLevel, "log", "info", "Log level (error, warn, info, debug, trace)")
+	fs.BoolVar(&o.sendFrames, "send-frames", true, "Send simulated Xbox frames")
+	fs.DurationVar(&o.frameInterval, "frame-interval", 50*time.Millisecond, "Interval between sent frames")
+	fs.DurationVar(&o.latencyBase, "latency-base", 0, "Base simulated latency for PONG replies")
+	fs.DurationVar(&o.latencyJitter, "latency-jitter", 0, "Jitter range (±) for simulated latency")
+	fs.DurationVar(&o.latencyStep, "latency-step", 5*time.Millisecond, "Step size for interactive +/- adjustment")
+	fs.Float64Var(&o.loss, "loss", 0, "Percent of sent frames to drop (0-100)")
+	fs.Float64Var(&o.reorder, "reorder", 0, "Percent of sent frames to hold back behind the next one (0-100)")
+	fs.Uint64Var(&o.rate, "rate", 0, "Send frames back to back, paced to this many bits/s (0: every --frame-interval)")
+	fs.Uint64Var(&o.recvRate, "recv-rate", 0, "Drop received messages beyond this many bits/s (0: no limit)")
+}
+
+// peer is a simulated peer configured from peerOptions.
+type peer struct {
+	opts      peerOptions
+	logger    *logging.Logger
+	codec     *protocol.Codec
+	latCfg    *LatencyConfig
+	impairCfg *ImpairConfig
+}
+
+// newPeer validates opts, exiting on an error as the commands do.
+func newPeer(opts peerOptions) *peer {
+	if opts.loss < 0 || opts.loss > 100 || opts.reorder < 0 || opts.reorder > 100 {
+		fmt.Fprintln(os.Stderr, "Error: --loss and --reorder must be between 0 and 100")
+		os.Exit(1)
+	}
+
+	level, err := logging.ParseLevel(opts.logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid log level: %v\n", err)
+		os.Exit(1)
+	}
+
+	var keyBytes []byte
+	if opts.key != "" {
+		keyBytes = []byte(opts.key)
+	}
+
+	codec, err := protocol.NewCodec(keyBytes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --key: %v\n", err)
+		os.Exit(1)
+	}
+
+	return &peer{
+		opts:      opts,
+		logger:    logging.NewLogger(level),
+		codec:     codec,
+		latCfg:    NewLatencyConfig(opts.latencyBase, opts.latencyJitter, opts.latencyStep),
+		impairCfg: NewImpairConfig(opts.loss, opts.reorder),
+	}
+}
+
+// signalContext returns a context cancelled on SIGINT/SIGTERM.
+func (p *peer) signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigCh:
+			p.logger.Info("received signal, shutting down")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// startKeyReader starts the interactive key reader if attached to a TTY;
+// q calls quit.
+func (p *peer) startKeyReader(ctx context.Context, quit context.CancelFunc) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		go peerKeyReader(ctx, p.latCfg, p.logger, quit)
+		p.logger.Info("interactive mode: +/= increase latency, - decrease, q quit")
+	}
+}
+
+// runSession exchanges traffic over a connected trans until the peer says
+// BYE or ctx is done, then says BYE and closes trans.
+func (p *peer) runSession(ctx context.Context, trans *transport.Transport) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p.logger.Info("latency config: %s", p.latCfg)
+	if p.impairCfg.Active() {
+		p.logger.Info("impairment: %s", p.impairCfg)
+	}
+	var recvLimit *ratelimit.Bucket
+	if p.opts.recvRate > 0 {
+		recvLimit = newRateBucket(p.opts.recvRate)
+		p.logger.Info("receive path limited to %d bits/s", p.opts.recvRate)
+	}
+
+	// Start recv loop.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		peerRecvLoop(ctx, trans, p.codec, p.latCfg, recvLimit, p.logger, cancel)
+	}()
+
+	// Start frame sender if requested.
+	if p.opts.sendFrames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peerFrameSender(ctx, trans, p.codec, p.opts.frameInterval, p.opts.rate, p.impairCfg, p.logger)
+		}()
+	}
+
+	<-ctx.Done()
+
+	p.logger.Info("shutting down")
+	wg.Wait() // Let the loops log their summaries
+	_ = trans.SendBye()
+	_ = trans.Close()
+}
+
+func peerRecvLoop(ctx context.Context, trans *transport.Transport, codec *protocol.Codec, latCfg *LatencyConfig, recvLimit *ratelimit.Bucket, logger *logging.Logger, cancel context.CancelFunc) {
+	buf := make([]byte, 65536)
+	var limited uint64
+
+	for {
+		select {
+		case <-ctx.Done():
+			if recvLimit != nil {
+				logger.Info("receive limit: %d messages dropped", limited)
+			}
+			return
+		default:
+		}
+
+		_ = trans.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := trans.Recv(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			logger.Debug("recv error: %v", err)
+			continue
+		}
+		if recvLimit != nil && !recvLimit.Allow(time.Now(), float64(n*8)) {
+			limited++
+			logger.Trace("receive limit: dropped %d bytes", n)
+			continue
+		}
+
+		msg, err := codec.Decode(buf[:n])
+		if err != nil {
+			logger.Debug("decode error: %v", err)
+			continue
+		}
+
+		switch msg.Type {
+		case protocol.MsgPing:
+			ts := msg.Timestamp
+			delay := latCfg.Delay()
+			logger.Debug("PING ts=%d, replying with delay %s", ts, delay)
+			go func() {
+				if delay > 0 {
+					time.Sleep(delay)
+				}
+				pong := codec.EncodePong(ts)
+				if err := trans.Send(pong); err != nil {
+					logger.Debug("send PONG error: %v", err)
+				}
+			}()
+
+		case protocol.MsgBye:
+			logger.Info("received BYE from peer")
+			cancel()
+			return
+
+		case protocol.MsgFrame:
+			logger.Trace("received frame (%d bytes)", len(msg.Frame))
+
+		case protocol.MsgPong:
+			logger.Trace("received PONG ts=%d", msg.Timestamp)
+
+		default:
+			logger.Debug("received unknown message type: 0x%02X", msg.Type)
+		}
+	}
+}
+
+// peerFrameSender sends a frame every interval or, with rate set, back to
+// back at rate bits/s, to saturate a link deterministically.
+func peerFrameSender(ctx context.Context, trans *transport.Transport, codec *protocol.Codec, interval time.Duration, rate uint64, impairCfg *ImpairConfig, logger *logging.Logger) {
+	srcMAC := testutil.RandomXboxMAC()
+	dstMAC := testutil.BroadcastMAC()
+	impair := &impairer{cfg: impairCfg}
+
+	// pace returns when the next frame is due: one interval after the last,
+	// or once the bucket has paid for it
+	var pace func(sent int) <-chan time.Time
+	if rate > 0 {
+		bucket := newRateBucket(rate)
+		pace = func(sent int) <-chan time.Time {
+			return time.After(bucket.Wait(time.Now(), float64(sent*8)))
+		}
+		logger.Info("sending frames at %d bits/s (src=%s)", rate, srcMAC)
+	} else {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		pace = func(int) <-chan time.Time { return ticker.C }
+		logger.Info("sending frames every %s (src=%s)", interval, srcMAC)
+	}
+
+	sent := 0
+	for {
+		select {
+		case <-ctx.Done():
+			if impairCfg.Active() {
+				logger.Info("impairment: %s", impair)
+			}
+			return
+		case <-pace(sent):
+			sent = 0
+			frame := testutil.RandomEthernetFrame(srcMAC, dstMAC, testutil.EtherTypeIPv4, 64)
+			encoded, err := codec.EncodeFrame(frame)
+			if err != nil {
+				logger.Debug("encode frame error: %v", err)
+				continue
+			}
+			// Dropped frames count against the rate too, like on a real link
+			sent = len(encoded)
+			for _, msg := range impair.next(encoded) {
+				if err := trans.Send(msg); err != nil {
+					logger.Debug("send frame error: %v", err)
+				}
+			}
+		}
+	}
+}
+
+func peerKeyReader(ctx context.Context, latCfg *LatencyConfig, logger *logging.Logger, cancel context.CancelFunc) {
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		logger.Warn("failed to set raw terminal mode: %v", err)
+		return
+	}
+	defer func() {
+		_ = term.Restore(fd, oldState)
+	}()
+
+	keyCh := make(chan byte, 1)
+	go func() {
+		b := make([]byte, 1)
+		for {
+			n, err := os.Stdin.Read(b)
+			if n > 0 {
+				keyCh <- b[0]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case k := <-keyCh:
+			switch k {
+			case '+', '=':
+				newBase := latCfg.IncreaseBase()
+				fmt.Fprintf(os.Stderr, "\r\nlatency base: %s\r\n", newBase)
+			case '-':
+				newBase := latCfg.DecreaseBase()
+				fmt.Fprintf(os.Stderr, "\r\nlatency base: %s\r\n", newBase)
+			case 'q', 3: // 'q' or Ctrl-C
+				fmt.Fprintf(os.Stderr, "\r\nquitting...\r\n")
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// newRateBucket returns a bucket for rate bits/s that allows bursts of 10ms
+// worth of traffic, or of one message of any size at low rates.
+func newRateBucket(rate uint64) *ratelimit.Bucket {
+	burst := max(float64(rate)/100, protocol.MaxMessageSize*8)
+	return ratelimit.New(float64(rate), burst, time.Now())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xbslink/xbslink-ng/internal/transport"
+)
+
+// runServer is the mirror of runClient: it listens like a bridge in listen
+// mode, so a bridge's connect mode can be tested without a second instance.
+// After a peer says BYE it waits for the next one, as a bridge would.
+func runServer() {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+
+	port := fs.Uint("port", 31415, "UDP port to listen on")
+	var opts peerOptions
+	opts.register(fs)
+
+	fs.Parse(os.Args[2:])
+
+	if *port == 0 || *port > 65535 {
+		fmt.Fprintln(os.Stderr, "Error: --port must be 1-65535")
+		os.Exit(1)
+	}
+	p := newPeer(opts)
+
+	ctx, cancel := p.signalContext()
+	defer cancel()
+	p.startKeyReader(ctx, cancel)
+
+	for ctx.Err() == nil {
+		// A fresh transport per session, as the bridge does
+		trans, err := transport.New(transport.Config{
+			Mode:      transport.ModeListen,
+			LocalPort: uint16(*port),
+			Codec:     p.codec,
+			Logger:    p.logger,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating transport: %v\n", err)
+			os.Exit(1)
+		}
+
+		p.logger.Info("listening on UDP port %d ...", *port)
+		if err := trans.WaitForPeer(ctx); err != nil {
+			trans.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Error: handshake failed: %v\n", err)
+			os.Exit(1)
+		}
+		p.logger.Info("peer connected from %s (handshake complete)", trans.PeerAddr())
+
+		p.runSession(ctx, trans)
+	}
+}
@@ -4,6 +4,7 @@ package testutil
 import (
 	"crypto/rand"
 	"encoding/binary"
+	mathrand "math/rand"
 	"net"
 	"time"
 )
@@ -50,6 +51,49 @@ func RandomFrame(size int) []byte {
 	return frame
 }
 
+// DeterministicFrame generates a valid Ethernet frame like RandomFrame, but
+// with content drawn from seed: the same seed and size always give the same
+// bytes, so tests can assert exact content or compare against golden files.
+func DeterministicFrame(seed int64, size int) []byte {
+	if size < 14 {
+		size = 14
+	}
+	frame := make([]byte, size)
+	_, _ = mathrand.New(mathrand.NewSource(seed)).Read(frame)
+
+	// Set proper EtherType (IPv4 = 0x0800)
+	frame[12] = 0x08
+	frame[13] = 0x00
+
+	return frame
+}
+
+// SequenceMagic marks the payload of a SequencedFrame.
+const SequenceMagic = "XSEQ"
+
+// SequencedFrame generates a minimum-size broadcast frame from a fixed Xbox
+// MAC whose payload starts with SequenceMagic and n, big-endian. The rest is
+// zero, so frames differ only in n. Use FrameSequence to read n back.
+func SequencedFrame(n uint32) []byte {
+	frame := make([]byte, 60)
+	copy(frame[0:6], BroadcastMAC())
+	copy(frame[6:12], net.HardwareAddr{0x00, 0x50, 0xF2, 0x00, 0x00, 0x01})
+	binary.BigEndian.PutUint16(frame[12:14], EtherTypeIPv4)
+	copy(frame[14:], SequenceMagic)
+	binary.BigEndian.PutUint32(frame[14+len(SequenceMagic):], n)
+	return frame
+}
+
+// FrameSequence returns the sequence number of a frame built by
+// SequencedFrame, or false if frame is not one.
+func FrameSequence(frame []byte) (uint32, bool) {
+	tag := 14 + len(SequenceMagic)
+	if len(frame) < tag+4 || string(frame[14:tag]) != SequenceMagic {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(frame[tag:]), true
+}
+
 // RandomEthernetFrame generates a valid Ethernet frame with specified MACs.
 func RandomEthernetFrame(srcMAC, dstMAC net.HardwareAddr, etherType uint16, payloadSize int) []byte {
 	frame := make([]byte, 14+payloadSize)
@@ -3,6 +3,8 @@ package testutil
 import (
 	"bytes"
 	"sync"
+
+	"github.com/xbslink/xbslink-ng/internal/events"
 )
 
 // MockLogger captures log output for testing.
@@ -59,3 +61,38 @@ func (m *MockLogger) HasMessage(level, substr string) bool {
 	}
 	return false
 }
+
+// MockEmitter records emitted events for testing.
+type MockEmitter struct {
+	mu     sync.Mutex
+	Events []MockEvent
+}
+
+// MockEvent represents a captured event.
+type MockEvent struct {
+	Type events.EventType
+	Data interface{}
+}
+
+// Emit records an event.
+func (m *MockEmitter) Emit(eventType events.EventType, data interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Events = append(m.Events, MockEvent{Type: eventType, Data: data})
+}
+
+// Close does nothing and returns nil.
+func (m *MockEmitter) Close() error { return nil }
+
+// GetEvents returns all captured events of the given type.
+func (m *MockEmitter) GetEvents(eventType events.EventType) []MockEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []MockEvent
+	for _, e := range m.Events {
+		if e.Type == eventType {
+			result = append(result, e)
+		}
+	}
+	return result
+}
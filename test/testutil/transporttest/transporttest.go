@@ -0,0 +1,228 @@
+// Package transporttest provides an in-memory transport.Conn pair for
+// testing the bridge end to end without sockets.
+//
+// It lives outside testutil because the transport package's own tests
+// import testutil, and testutil importing transport would be a cycle.
+package transporttest
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/transport"
+)
+
+// DefaultBuffer is how many messages can be in flight each way before Send
+// drops, like a full socket buffer.
+const DefaultBuffer = 256
+
+// Options configures a Pair. The zero value is a lossless, instant link.
+type Options struct {
+	// Loss is the percentage of sent messages dropped, 0-100.
+	Loss float64
+	// Delay holds each message back this long before Recv returns it.
+	Delay time.Duration
+	// Seed seeds the loss decisions, so a lossy run is reproducible.
+	Seed int64
+	// Buffer is the number of in-flight messages each way. 0 uses
+	// DefaultBuffer.
+	Buffer int
+	// PeerInfo is what both ends report from PeerInfo.
+	PeerInfo transport.PeerInfo
+}
+
+// Pair returns two connected ends: what one sends, the other receives.
+// Connect and WaitForPeer return at once. SendBye and Close hang up, after
+// which the other end's Recv returns transport.ErrPeerClosed once it has
+// drained what was already sent, like the TCP backend.
+func Pair(opts Options) (*Conn, *Conn) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultBuffer
+	}
+	link := &link{opts: opts, rng: rand.New(rand.NewSource(opts.Seed))}
+	aToB := newPipe(opts.Buffer)
+	bToA := newPipe(opts.Buffer)
+	addrA := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 31415}
+	addrB := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 31415}
+	a := &Conn{link: link, out: aToB, in: bToA, peer: addrB, closed: make(chan struct{})}
+	b := &Conn{link: link, out: bToA, in: aToB, peer: addrA, closed: make(chan struct{})}
+	return a, b
+}
+
+// link holds what both ends share.
+type link struct {
+	opts Options
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// drop decides whether to lose the next message.
+func (l *link) drop() bool {
+	if l.opts.Loss <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rng.Float64()*100 < l.opts.Loss
+}
+
+// message is a sent message and when it may be received.
+type message struct {
+	data []byte
+	due  time.Time
+}
+
+// pipe carries messages one way.
+type pipe struct {
+	ch       chan message
+	hangup   chan struct{}
+	hangOnce sync.Once
+}
+
+func newPipe(buffer int) *pipe {
+	return &pipe{ch: make(chan message, buffer), hangup: make(chan struct{})}
+}
+
+// close tells the receiving end that nothing more will be sent.
+func (p *pipe) close() {
+	p.hangOnce.Do(func() { close(p.hangup) })
+}
+
+// Conn is one end of a Pair. It implements transport.Conn.
+type Conn struct {
+	link *link
+	out  *pipe
+	in   *pipe
+	peer net.Addr
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+	sent     uint64
+	dropped  uint64
+}
+
+var _ transport.Conn = (*Conn)(nil)
+
+// WaitForPeer returns at once; the pair is always connected.
+func (c *Conn) WaitForPeer(ctx context.Context) error { return ctx.Err() }
+
+// Connect returns at once; the pair is always connected.
+func (c *Conn) Connect(ctx context.Context) error { return ctx.Err() }
+
+// Send queues a copy of data for the other end. Messages lost to
+// Options.Loss or a full buffer are dropped silently, as UDP would.
+// It fails with net.ErrClosed after SendBye or Close.
+func (c *Conn) Send(data []byte) error {
+	select {
+	case <-c.out.hangup:
+		return net.ErrClosed
+	default:
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.link.drop() {
+		c.dropped++
+		return nil
+	}
+	msg := message{data: append([]byte(nil), data...), due: time.Now().Add(c.link.opts.Delay)}
+	select {
+	case c.out.ch <- msg:
+		c.sent++
+	default:
+		c.dropped++
+	}
+	return nil
+}
+
+// Recv returns the next message from the other end, waiting out its delay.
+// It returns a timeout error once the read deadline passes, and
+// transport.ErrPeerClosed once the other end has hung up and every message
+// it sent has been read, and net.ErrClosed after Close.
+func (c *Conn) Recv(buf []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var msg message
+	select {
+	case msg = <-c.in.ch:
+	case <-c.in.hangup:
+		select {
+		case msg = <-c.in.ch:
+		default:
+			return 0, nil, transport.ErrPeerClosed
+		}
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+
+	// Every message has the same delay, so waiting out this one's never
+	// lets a later one overtake it
+	if wait := time.Until(msg.due); wait > 0 {
+		time.Sleep(wait)
+	}
+	return copy(buf, msg.data), c.peer, nil
+}
+
+// SetReadDeadline sets the deadline for Recv.
+func (c *Conn) SetReadDeadline(deadline time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = deadline
+	return nil
+}
+
+// SendBye hangs up.
+func (c *Conn) SendBye() error {
+	c.out.close()
+	return nil
+}
+
+// PeerAddr returns the other end's address.
+func (c *Conn) PeerAddr() net.Addr { return c.peer }
+
+// PeerInfo returns Options.PeerInfo.
+func (c *Conn) PeerInfo() transport.PeerInfo { return c.link.opts.PeerInfo }
+
+// HandshakeFailures always returns 0.
+func (c *Conn) HandshakeFailures() uint64 { return 0 }
+
+// Close hangs up and unblocks Recv.
+func (c *Conn) Close() error {
+	c.out.close()
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// Sent returns the number of messages queued for the other end.
+func (c *Conn) Sent() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sent
+}
+
+// Dropped returns the number of messages lost to Options.Loss or a full
+// buffer.
+func (c *Conn) Dropped() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
@@ -0,0 +1,117 @@
+package transporttest
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xbslink/xbslink-ng/internal/transport"
+)
+
+func TestPair_Delivers(t *testing.T) {
+	a, b := Pair(Options{})
+	buf := make([]byte, 64)
+
+	for _, tt := range []struct {
+		name     string
+		from, to *Conn
+	}{{"a->b", a, b}, {"b->a", b, a}} {
+		msg := []byte("hello from " + tt.name)
+		if err := tt.from.Send(msg); err != nil {
+			t.Fatalf("%s: Send() failed: %v", tt.name, err)
+		}
+		msg[0] = 'X' // Send must have copied it
+		tt.to.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := tt.to.Recv(buf)
+		if err != nil {
+			t.Fatalf("%s: Recv() failed: %v", tt.name, err)
+		}
+		if got := string(buf[:n]); got != "hello from "+tt.name {
+			t.Errorf("%s: received %q", tt.name, got)
+		}
+		if addr.String() != tt.to.PeerAddr().String() {
+			t.Errorf("%s: received from %v, want %v", tt.name, addr, tt.to.PeerAddr())
+		}
+	}
+	if a.PeerAddr().String() == b.PeerAddr().String() {
+		t.Errorf("both ends have peer address %v", a.PeerAddr())
+	}
+
+	// Nothing more to read: the deadline expires as a net.Error timeout
+	a.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err := a.Recv(buf)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("Recv() with nothing sent = %v, want a timeout", err)
+	}
+}
+
+func TestPair_LossAndDelay(t *testing.T) {
+	const messages = 1000
+	a, _ := Pair(Options{Loss: 20, Seed: 1, Buffer: messages})
+	for i := 0; i < messages; i++ {
+		a.Send([]byte{byte(i)})
+	}
+	if got := a.Sent() + a.Dropped(); got != messages {
+		t.Fatalf("sent + dropped = %d, want %d", got, messages)
+	}
+	if pct := float64(a.Dropped()) * 100 / messages; pct < 16 || pct > 24 {
+		t.Errorf("dropped %.1f%% with Loss 20", pct)
+	}
+
+	// The same seed loses the same messages
+	again, _ := Pair(Options{Loss: 20, Seed: 1, Buffer: messages})
+	for i := 0; i < messages; i++ {
+		again.Send([]byte{byte(i)})
+	}
+	if again.Dropped() != a.Dropped() {
+		t.Errorf("same seed dropped %d, then %d", a.Dropped(), again.Dropped())
+	}
+
+	const delay = 30 * time.Millisecond
+	a, b := Pair(Options{Delay: delay})
+	start := time.Now()
+	a.Send([]byte("late"))
+	b.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := b.Recv(make([]byte, 8)); err != nil {
+		t.Fatalf("Recv() failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("received after %v, want at least %v", elapsed, delay)
+	}
+}
+
+func TestPair_HangUp(t *testing.T) {
+	a, b := Pair(Options{})
+	buf := make([]byte, 8)
+
+	a.Send([]byte("last"))
+	a.SendBye()
+	if err := a.Send([]byte("more")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Send() after SendBye = %v, want net.ErrClosed", err)
+	}
+
+	// What was sent before hanging up is still delivered
+	if n, _, err := b.Recv(buf); err != nil || string(buf[:n]) != "last" {
+		t.Fatalf("Recv() = %q, %v, want \"last\"", buf[:n], err)
+	}
+	if _, _, err := b.Recv(buf); !errors.Is(err, transport.ErrPeerClosed) {
+		t.Errorf("Recv() after hang-up = %v, want ErrPeerClosed", err)
+	}
+
+	// Close unblocks the closing end's own Recv
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := a.Recv(buf)
+		done <- err
+	}()
+	a.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Recv() after Close = %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Recv() still blocked after Close")
+	}
+}